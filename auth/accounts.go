@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// accountLinkTTL is how long a link request waits for the login of the
+// identity to link
+const accountLinkTTL = 10 * time.Minute
+
+var (
+	// ErrLinkRequestNotFound is returned for unknown or expired link requests
+	ErrLinkRequestNotFound = errors.New("unknown or expired link request")
+	// ErrLastIdentity is returned when unlinking the only identity of an
+	// account, which would leave no way to log in to it
+	ErrLastIdentity = errors.New("cannot unlink the only identity of an account")
+)
+
+// AccountService maps provider identities to canonical accounts. The first
+// login of an identity creates its account, unless the identity is linked
+// to an existing one, either explicitly by its owner or automatically by a
+// verified email address.
+type AccountService struct {
+	config *config.Config
+	store  store.AccountStore
+
+	// mu serializes account changes, so concurrent first logins of one
+	// identity cannot create two accounts
+	mu    sync.Mutex
+	links map[string]*accountLinkRequest
+}
+
+type accountLinkRequest struct {
+	accountID string
+	expiresAt time.Time
+}
+
+// NewAccountService creates a new account service
+func NewAccountService(cfg *config.Config, accountStore store.AccountStore) *AccountService {
+	return &AccountService{
+		config: cfg,
+		store:  accountStore,
+		links:  make(map[string]*accountLinkRequest),
+	}
+}
+
+// Resolve returns the user with the ID of the account the identity belongs
+// to, creating the account or auto-linking the identity on its first login
+func (s *AccountService) Resolve(user *models.User) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.store.GetByIdentity(user.Provider, user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		account, err = s.accountForNewIdentity(user)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.recordLogin(account, user)
+}
+
+// accountForNewIdentity finds the account to auto-link a new identity to,
+// or creates one. Both the new identity and the account must have the
+// email verified by an auto-link provider, and the match must be unique.
+func (s *AccountService) accountForNewIdentity(user *models.User) (*models.Account, error) {
+	providers := s.config.AccountAutoLinkProviders
+	if user.EmailVerified && user.Email != "" && containsString(providers, user.Provider) {
+		candidates, err := s.store.ListByEmail(user.Email)
+		if err != nil {
+			return nil, err
+		}
+		var matches []*models.Account
+		for _, candidate := range candidates {
+			if candidate.HasVerifiedEmail(user.Email, providers) {
+				matches = append(matches, candidate)
+			}
+		}
+		switch len(matches) {
+		case 1:
+			log.Printf("Auto-linked %s identity %s to account %s by verified email", user.Provider, user.ID, matches[0].ID)
+			return matches[0], nil
+		case 0:
+		default:
+			log.Printf("Not auto-linking %s identity %s: %d accounts have its email", user.Provider, user.ID, len(matches))
+		}
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Account{ID: "user-" + id, CreatedAt: time.Now()}, nil
+}
+
+// StartLink starts linking another identity to an account. The returned
+// request ID must come back with the login of that identity within
+// accountLinkTTL.
+func (s *AccountService) StartLink(accountID string) (string, error) {
+	if _, err := s.store.Get(accountID); err != nil {
+		return "", err
+	}
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for linkID, link := range s.links {
+		if now.After(link.expiresAt) {
+			delete(s.links, linkID)
+		}
+	}
+	s.links[id] = &accountLinkRequest{accountID: accountID, expiresAt: now.Add(accountLinkTTL)}
+	return id, nil
+}
+
+// CompleteLink links the identity the user just logged in with to the
+// account of the link request, and returns the user with the account ID.
+// Identities linked to another account are refused rather than moved.
+func (s *AccountService) CompleteLink(linkID string, user *models.User) (*models.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, ok := s.links[linkID]
+	delete(s.links, linkID)
+	if !ok || time.Now().After(link.expiresAt) {
+		return nil, ErrLinkRequestNotFound
+	}
+
+	account, err := s.store.GetByIdentity(user.Provider, user.ID)
+	if err == nil && account.ID != link.accountID {
+		return nil, store.ErrIdentityLinked
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+	if account, err = s.store.Get(link.accountID); err != nil {
+		return nil, err
+	}
+	return s.recordLogin(account, user)
+}
+
+// Unlink removes an identity from an account. Its next login creates a new
+// account, or auto-links it again where allowed.
+func (s *AccountService) Unlink(accountID, provider, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, err := s.store.Get(accountID)
+	if err != nil {
+		return err
+	}
+	i := account.Identity(provider, subject)
+	if i < 0 {
+		return store.ErrNotFound
+	}
+	if len(account.Identities) == 1 {
+		return ErrLastIdentity
+	}
+	account.Identities = append(account.Identities[:i], account.Identities[i+1:]...)
+	return s.store.Save(account)
+}
+
+// Account returns an account by ID
+func (s *AccountService) Account(id string) (*models.Account, error) {
+	return s.store.Get(id)
+}
+
+// Subject returns the subject of the account's most recently used identity
+// from the provider, or store.ErrNotFound if none is linked
+func (s *AccountService) Subject(accountID, provider string) (string, error) {
+	account, err := s.store.Get(accountID)
+	if err != nil {
+		return "", err
+	}
+	identity := latestIdentity(account, provider)
+	if identity == nil {
+		return "", store.ErrNotFound
+	}
+	return identity.Subject, nil
+}
+
+// AccountID returns the ID of the account the identity is linked to
+func (s *AccountService) AccountID(provider, subject string) (string, error) {
+	account, err := s.store.GetByIdentity(provider, subject)
+	if err != nil {
+		return "", err
+	}
+	return account.ID, nil
+}
+
+// recordLogin links the identity to the account if it is new, refreshes
+// its email, and returns the user with the account ID. s.mu must be held.
+func (s *AccountService) recordLogin(account *models.Account, user *models.User) (*models.User, error) {
+	now := time.Now()
+	i := account.Identity(user.Provider, user.ID)
+	if i < 0 {
+		account.Identities = append(account.Identities, models.LinkedIdentity{
+			Provider: user.Provider,
+			Subject:  user.ID,
+			LinkedAt: now,
+		})
+		i = len(account.Identities) - 1
+	}
+	account.Identities[i].Email = user.Email
+	account.Identities[i].EmailVerified = user.EmailVerified
+	account.Identities[i].LastLoginAt = now
+	if err := s.store.Save(account); err != nil {
+		return nil, err
+	}
+
+	resolved := *user
+	resolved.ID = account.ID
+	return &resolved, nil
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+func newTestAccountService(autoLinkProviders ...string) *AccountService {
+	return NewAccountService(&config.Config{
+		AccountAutoLinkProviders: autoLinkProviders,
+	}, store.NewMemoryAccountStore())
+}
+
+func testProviderUser(provider, id, email string, verified bool) *models.User {
+	return &models.User{
+		ID:            id,
+		Email:         email,
+		Provider:      provider,
+		Roles:         []string{"user"},
+		EmailVerified: verified,
+	}
+}
+
+func TestAccountService_Resolve(t *testing.T) {
+	s := newTestAccountService()
+
+	first, err := s.Resolve(testProviderUser("google", "g-1", "jane@example.com", true))
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if !strings.HasPrefix(first.ID, "user-") || first.Provider != "google" || first.Email != "jane@example.com" {
+		t.Errorf("Resolve() = %+v, want a new account ID with the provider details", first)
+	}
+
+	again, _ := s.Resolve(testProviderUser("google", "g-1", "jane@example.com", true))
+	if again.ID != first.ID {
+		t.Errorf("Resolve() on the next login = %s, want %s", again.ID, first.ID)
+	}
+
+	// Without auto-linking, the same email at another provider is another account
+	other, _ := s.Resolve(testProviderUser("azure", "a-1", "jane@example.com", true))
+	if other.ID == first.ID {
+		t.Errorf("Resolve() linked azure identity to %s, want a separate account", other.ID)
+	}
+}
+
+func TestAccountService_AutoLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing *models.User
+		login    *models.User
+		wantLink bool
+	}{
+		{
+			"Verified at both providers",
+			testProviderUser("google", "g-1", "jane@example.com", true),
+			testProviderUser("okta", "o-1", "JANE@example.com", true),
+			true,
+		},
+		{
+			"New identity unverified",
+			testProviderUser("google", "g-1", "jane@example.com", true),
+			testProviderUser("okta", "o-1", "jane@example.com", false),
+			false,
+		},
+		{
+			"Existing identity unverified",
+			testProviderUser("google", "g-1", "jane@example.com", false),
+			testProviderUser("okta", "o-1", "jane@example.com", true),
+			false,
+		},
+		{
+			"New provider not allowed",
+			testProviderUser("google", "g-1", "jane@example.com", true),
+			testProviderUser("azure", "a-1", "jane@example.com", true),
+			false,
+		},
+		{
+			"Existing provider not allowed",
+			testProviderUser("azure", "a-1", "jane@example.com", true),
+			testProviderUser("google", "g-1", "jane@example.com", true),
+			false,
+		},
+		{
+			"Other email",
+			testProviderUser("google", "g-1", "jane@example.com", true),
+			testProviderUser("okta", "o-1", "john@example.com", true),
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestAccountService("google", "okta")
+			existing, err := s.Resolve(tt.existing)
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			login, err := s.Resolve(tt.login)
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if linked := login.ID == existing.ID; linked != tt.wantLink {
+				t.Errorf("Resolve() linked = %v, want %v", linked, tt.wantLink)
+			}
+		})
+	}
+}
+
+func TestAccountService_AutoLinkAmbiguous(t *testing.T) {
+	s := newTestAccountService("google", "okta", "email")
+	first, _ := s.Resolve(testProviderUser("google", "g-1", "jane@example.com", true))
+	second, _ := s.Resolve(testProviderUser("okta", "o-1", "jane@example.com", false))
+	// The okta identity verifies the address later, so two accounts have it
+	s.Resolve(testProviderUser("okta", "o-1", "jane@example.com", true))
+
+	third, _ := s.Resolve(testProviderUser("email", "jane@example.com", "jane@example.com", true))
+	if third.ID == first.ID || third.ID == second.ID {
+		t.Errorf("Resolve() auto-linked to %s, want a new account when several accounts match", third.ID)
+	}
+}
+
+func TestAccountService_LinkAndUnlink(t *testing.T) {
+	s := newTestAccountService()
+	jane, _ := s.Resolve(testProviderUser("google", "g-1", "jane@example.com", true))
+	john, _ := s.Resolve(testProviderUser("okta", "o-2", "john@example.com", true))
+
+	linkID, err := s.StartLink(jane.ID)
+	if err != nil {
+		t.Fatalf("StartLink() error = %v", err)
+	}
+	linked, err := s.CompleteLink(linkID, testProviderUser("azure", "a-1", "jane@corp.example.com", false))
+	if err != nil {
+		t.Fatalf("CompleteLink() error = %v", err)
+	}
+	if linked.ID != jane.ID {
+		t.Errorf("CompleteLink() = %s, want %s", linked.ID, jane.ID)
+	}
+	if again, _ := s.Resolve(testProviderUser("azure", "a-1", "jane@corp.example.com", false)); again.ID != jane.ID {
+		t.Errorf("Resolve() after linking = %s, want %s", again.ID, jane.ID)
+	}
+
+	// Link requests work once
+	if _, err := s.CompleteLink(linkID, testProviderUser("azure", "a-1", "", false)); !errors.Is(err, ErrLinkRequestNotFound) {
+		t.Errorf("CompleteLink() reused error = %v, want %v", err, ErrLinkRequestNotFound)
+	}
+
+	// Identities of another account are not moved
+	linkID, _ = s.StartLink(jane.ID)
+	if _, err := s.CompleteLink(linkID, testProviderUser("okta", "o-2", "john@example.com", true)); !errors.Is(err, store.ErrIdentityLinked) {
+		t.Errorf("CompleteLink() with john's identity error = %v, want %v", err, store.ErrIdentityLinked)
+	}
+	if again, _ := s.Resolve(testProviderUser("okta", "o-2", "john@example.com", true)); again.ID != john.ID {
+		t.Errorf("Resolve() john = %s, want %s", again.ID, john.ID)
+	}
+
+	if err := s.Unlink(jane.ID, "azure", "a-1"); err != nil {
+		t.Fatalf("Unlink() error = %v", err)
+	}
+	if again, _ := s.Resolve(testProviderUser("azure", "a-1", "jane@corp.example.com", false)); again.ID == jane.ID {
+		t.Errorf("Resolve() after unlinking = %s, want a new account", again.ID)
+	}
+	if err := s.Unlink(jane.ID, "google", "g-1"); !errors.Is(err, ErrLastIdentity) {
+		t.Errorf("Unlink() of the last identity error = %v, want %v", err, ErrLastIdentity)
+	}
+	if err := s.Unlink(jane.ID, "okta", "o-2"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Unlink() of john's identity error = %v, want %v", err, store.ErrNotFound)
+	}
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Authentication context classes recorded in the acr claim, from weakest to
+// strongest. "phr" is the phishing-resistant class of the OpenID EAP ACR
+// values.
+const (
+	ACRBasic             = "basic" // IdP login only
+	ACRMFA               = "mfa"   // a second factor after the IdP login
+	ACRPhishingResistant = "phr"   // a WebAuthn security key or passkey
+)
+
+var acrLevels = map[string]int{
+	ACRBasic:             1,
+	ACRMFA:               2,
+	ACRPhishingResistant: 3,
+}
+
+// ACRForAMR derives the acr of a login from the methods it used
+func ACRForAMR(amr []string) string {
+	switch {
+	case containsString(amr, AMRHardwareKey):
+		return ACRPhishingResistant
+	case containsString(amr, AMRMFA):
+		return ACRMFA
+	default:
+		return ACRBasic
+	}
+}
+
+// ACRSatisfies reports whether a login at acr meets the required class.
+// Stronger classes satisfy weaker ones.
+func ACRSatisfies(acr, required string) bool {
+	if required == "" {
+		return true
+	}
+	have, ok := acrLevels[acr]
+	if !ok {
+		return false
+	}
+	want, ok := acrLevels[required]
+	return ok && have >= want
+}
+
+// ParseACRValues returns the first class the gateway knows from a
+// space-separated acr_values parameter. Unknown values are ignored, as OIDC
+// treats acr_values as a voluntary request.
+func ParseACRValues(acrValues string) string {
+	for _, acr := range strings.Fields(acrValues) {
+		if _, ok := acrLevels[acr]; ok {
+			return acr
+		}
+	}
+	return ""
+}
+
+// ParseMaxAge parses a max_age parameter in seconds. It returns -1 when the
+// parameter is absent.
+func ParseMaxAge(maxAge string) (int, error) {
+	if maxAge == "" {
+		return -1, nil
+	}
+	seconds, err := strconv.Atoi(maxAge)
+	if err != nil || seconds < 0 {
+		return 0, errors.New("must be a non-negative integer")
+	}
+	return seconds, nil
+}
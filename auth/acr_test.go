@@ -0,0 +1,89 @@
+package auth
+
+import "testing"
+
+func TestACRForAMR(t *testing.T) {
+	tests := []struct {
+		name     string
+		amr      []string
+		expected string
+	}{
+		{"IdP only", []string{AMRFederated}, ACRBasic},
+		{"TOTP", []string{AMRFederated, AMROTP, AMRMFA}, ACRMFA},
+		{"Recovery code", []string{AMRFederated, AMRRecoveryCode, AMRMFA}, ACRMFA},
+		{"Security key", []string{AMRFederated, AMRHardwareKey, AMRMFA}, ACRPhishingResistant},
+		{"Passkey", []string{AMRHardwareKey, AMRMFA}, ACRPhishingResistant},
+		{"None", nil, ACRBasic},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := ACRForAMR(tt.amr); result != tt.expected {
+				t.Errorf("ACRForAMR(%v) = %s, want %s", tt.amr, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestACRSatisfies(t *testing.T) {
+	tests := []struct {
+		acr      string
+		required string
+		expected bool
+	}{
+		{ACRBasic, "", true},
+		{"", "", true},
+		{"", ACRBasic, false},
+		{ACRBasic, ACRMFA, false},
+		{ACRMFA, ACRMFA, true},
+		{ACRPhishingResistant, ACRMFA, true},
+		{ACRMFA, ACRPhishingResistant, false},
+		{"unknown", ACRBasic, false},
+		{ACRPhishingResistant, "unknown", false},
+	}
+
+	for _, tt := range tests {
+		if result := ACRSatisfies(tt.acr, tt.required); result != tt.expected {
+			t.Errorf("ACRSatisfies(%q, %q) = %v, want %v", tt.acr, tt.required, result, tt.expected)
+		}
+	}
+}
+
+func TestParseACRValues(t *testing.T) {
+	tests := []struct {
+		acrValues string
+		expected  string
+	}{
+		{"", ""},
+		{"mfa", ACRMFA},
+		{"urn:example:loa3 phr mfa", ACRPhishingResistant},
+		{"urn:example:loa3", ""},
+	}
+
+	for _, tt := range tests {
+		if result := ParseACRValues(tt.acrValues); result != tt.expected {
+			t.Errorf("ParseACRValues(%q) = %q, want %q", tt.acrValues, result, tt.expected)
+		}
+	}
+}
+
+func TestParseMaxAge(t *testing.T) {
+	tests := []struct {
+		maxAge   string
+		expected int
+		wantErr  bool
+	}{
+		{"", -1, false},
+		{"0", 0, false},
+		{"300", 300, false},
+		{"-5", 0, true},
+		{"five", 0, true},
+	}
+
+	for _, tt := range tests {
+		result, err := ParseMaxAge(tt.maxAge)
+		if (err != nil) != tt.wantErr || result != tt.expected {
+			t.Errorf("ParseMaxAge(%q) = %d, %v, want %d, error %v", tt.maxAge, result, err, tt.expected, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTooManyAttempts is returned when a client or account has made too many
+// attempts recently and must wait before trying again
+var ErrTooManyAttempts = errors.New("too many attempts, try again later")
+
+// attemptLimiter counts attempts per key, such as a client IP or username,
+// over a sliding window. Callers check Allow before the attempt, Record the
+// attempts that count against the limit, and Reset a key after a success.
+type attemptLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu       sync.Mutex
+	attempts map[string][]time.Time
+}
+
+func newAttemptLimiter(limit int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		limit:    limit,
+		window:   window,
+		attempts: make(map[string][]time.Time),
+	}
+}
+
+// Allow reports whether the key is below its limit for the window
+func (l *attemptLimiter) Allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweepLocked(now)
+
+	return len(l.attempts[key]) < l.limit
+}
+
+// Record counts an attempt for the key
+func (l *attemptLimiter) Record(key string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.attempts[key] = append(l.attempts[key], now)
+}
+
+// Take records an attempt for the key unless it has reached its limit, and
+// reports whether the attempt may go ahead
+func (l *attemptLimiter) Take(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweepLocked(now)
+
+	if len(l.attempts[key]) >= l.limit {
+		return false
+	}
+	l.attempts[key] = append(l.attempts[key], now)
+	return true
+}
+
+// Reset forgets the key's attempts
+func (l *attemptLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.attempts, key)
+}
+
+// sweepLocked forgets attempts outside the window; l.mu must be held
+func (l *attemptLimiter) sweepLocked(now time.Time) {
+	cutoff := now.Add(-l.window)
+	for key, times := range l.attempts {
+		i := 0
+		for i < len(times) && !times[i].After(cutoff) {
+			i++
+		}
+		if i == len(times) {
+			delete(l.attempts, key)
+		} else {
+			l.attempts[key] = times[i:]
+		}
+	}
+}
+
+// passwordThrottle limits password logins per username and per client IP,
+// so that neither one account nor one client can keep guessing. Every
+// attempt counts against the username until one succeeds; only failures
+// count against the IP, so users behind a shared address can still log in.
+type passwordThrottle struct {
+	users *attemptLimiter
+	ips   *attemptLimiter
+
+	// perClient counts username attempts per username and client IP, so
+	// clients cannot lock a username out for everyone else
+	perClient bool
+}
+
+func newPasswordThrottle(maxAttempts, ipMaxFailures int, window time.Duration) *passwordThrottle {
+	return &passwordThrottle{
+		users: newAttemptLimiter(maxAttempts, window),
+		ips:   newAttemptLimiter(ipMaxFailures, window),
+	}
+}
+
+// newPerClientPasswordThrottle returns a throttle that limits attempts per
+// username and client IP rather than per username, for accounts that must
+// stay usable while others are guessing their password
+func newPerClientPasswordThrottle(maxAttempts, ipMaxFailures int, window time.Duration) *passwordThrottle {
+	t := newPasswordThrottle(maxAttempts, ipMaxFailures, window)
+	t.perClient = true
+	return t
+}
+
+// Take records an attempt for the username from the IP, and reports
+// whether it may go ahead
+func (t *passwordThrottle) Take(username, ip string, now time.Time) bool {
+	return t.ips.Allow(ip, now) && t.users.Take(t.userKey(username, ip), now)
+}
+
+// Done records the outcome of an attempt taken with Take
+func (t *passwordThrottle) Done(username, ip string, err error, now time.Time) {
+	switch {
+	case err == nil:
+		t.users.Reset(t.userKey(username, ip))
+	case errors.Is(err, ErrInvalidCredentials):
+		t.ips.Record(ip, now)
+	}
+}
+
+// userKey is the key attempts for the username from the IP count against
+func (t *passwordThrottle) userKey(username, ip string) string {
+	if t.perClient {
+		return username + "\x00" + ip
+	}
+	return username
+}
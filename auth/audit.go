@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// Audit records security-relevant actions in the audit trail. Every event
+// is also written to the server log, so it reaches log collection even if
+// the audit store fails.
+type Audit struct {
+	store store.AuditStore
+}
+
+// NewAudit creates an audit trail backed by the store
+func NewAudit(auditStore store.AuditStore) *Audit {
+	return &Audit{store: auditStore}
+}
+
+// Record stamps the event with an ID and the current time and appends it
+// to the audit trail
+func (a *Audit) Record(action, actor, subject string, details map[string]string) error {
+	id, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+	event := &models.AuditEvent{
+		ID:      id,
+		Time:    time.Now().UTC(),
+		Action:  action,
+		Actor:   actor,
+		Subject: subject,
+		Details: details,
+	}
+
+	log.Printf("AUDIT %s actor=%q subject=%q%s", action, actor, subject, formatAuditDetails(details))
+	if err := a.store.Append(event); err != nil {
+		log.Printf("Failed to store audit event %s: %v", id, err)
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+// List returns the audit trail, oldest first. Empty filters match every
+// event; userID matches events the user performed or was affected by.
+func (a *Audit) List(userID, action string) ([]*models.AuditEvent, error) {
+	events, err := a.store.List()
+	if err != nil {
+		return nil, err
+	}
+	matched := events[:0]
+	for _, event := range events {
+		if (userID == "" || event.Involves(userID)) && (action == "" || event.Action == action) {
+			matched = append(matched, event)
+		}
+	}
+	return matched, nil
+}
+
+func formatAuditDetails(details map[string]string) string {
+	keys := make([]string, 0, len(details))
+	for key := range details {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%q", key, details[key])
+	}
+	return b.String()
+}
@@ -0,0 +1,444 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	authorizationCodeTTL    = 1 * time.Minute
+	pendingAuthorizationTTL = 10 * time.Minute
+	idTokenTTL              = 1 * time.Hour
+)
+
+// OAuthError is an RFC 6749 error response
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	Status      int    `json:"-"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+func newOAuthError(status int, code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description, Status: status}
+}
+
+// AuthorizeRequest holds the parameters of an /oauth/authorize request
+type AuthorizeRequest struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	Audience            string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// TokenRequest holds the parameters of an /oauth/token request
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	ClientID     string
+	ClientSecret string
+	CodeVerifier string
+	DeviceCode   string
+
+	// Token exchange parameters
+	SubjectToken       string
+	SubjectTokenType   string
+	ActorToken         string
+	RequestedTokenType string
+	Audience           string
+	Scope              string
+}
+
+// TokenResponse is the successful /oauth/token response
+type TokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int    `json:"expires_in"`
+	IDToken         string `json:"id_token,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+}
+
+type pendingAuthorization struct {
+	request   *AuthorizeRequest
+	expiresAt time.Time
+}
+
+type authorizationCode struct {
+	request   *AuthorizeRequest
+	user      *models.User
+	authTime  time.Time
+	session   *models.Session
+	expiresAt time.Time
+}
+
+// AuthorizationServer lets the gateway act as an OAuth 2.0 / OIDC provider
+// for internal applications. End users still authenticate upstream through
+// the OAuthService; this server only issues codes and tokens to clients.
+type AuthorizationServer struct {
+	config     *config.Config
+	clients    store.ClientStore
+	signingKey *utils.SigningKey
+	sessions   *SessionManager
+
+	mu      sync.Mutex
+	pending map[string]*pendingAuthorization
+	codes   map[string]*authorizationCode
+	devices map[string]*deviceAuthorization
+	// userCodes maps device flow user codes to device codes
+	userCodes map[string]string
+	// userCodeFailures counts wrong user codes per client IP
+	userCodeFailures *attemptLimiter
+}
+
+// NewAuthorizationServer creates a new authorization server. Tokens it is
+// handed are rejected once their session ends; sessions may be nil when
+// session tracking is not used.
+func NewAuthorizationServer(cfg *config.Config, clients store.ClientStore, signingKey *utils.SigningKey, sessions *SessionManager) *AuthorizationServer {
+	return &AuthorizationServer{
+		config:     cfg,
+		clients:    clients,
+		signingKey: signingKey,
+		sessions:   sessions,
+		pending:    make(map[string]*pendingAuthorization),
+		codes:      make(map[string]*authorizationCode),
+		devices:    make(map[string]*deviceAuthorization),
+		userCodes:  make(map[string]string),
+
+		userCodeFailures: newAttemptLimiter(userCodeMaxFailures, deviceCodeTTL),
+	}
+}
+
+// Issuer returns the issuer identifier of the authorization server
+func (s *AuthorizationServer) Issuer() string {
+	return s.config.OIDCIssuer
+}
+
+// SigningKey returns the key used to sign ID tokens
+func (s *AuthorizationServer) SigningKey() *utils.SigningKey {
+	return s.signingKey
+}
+
+// CheckSession verifies that the session a gateway token is bound to is
+// still active, as the gateway middleware does for its own routes
+func (s *AuthorizationServer) CheckSession(claims *utils.Claims) error {
+	if s.sessions == nil || claims.SessionID == "" {
+		return nil
+	}
+	_, err := s.sessions.Check(claims.SessionID)
+	return err
+}
+
+// ValidateClient checks the client ID and redirect URI of an authorization
+// request. Errors returned here must be shown to the user rather than sent
+// to the redirect URI, since the redirect URI cannot be trusted.
+func (s *AuthorizationServer) ValidateClient(req *AuthorizeRequest) (*models.Client, error) {
+	client, err := s.clients.Get(req.ClientID)
+	if err != nil {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_client", "unknown client")
+	}
+	if !client.HasRedirectURI(req.RedirectURI) {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "redirect_uri is not registered for this client")
+	}
+	return client, nil
+}
+
+// ValidateAuthorizeRequest checks the remaining authorization parameters for
+// a client whose redirect URI has already been validated
+func (s *AuthorizationServer) ValidateAuthorizeRequest(client *models.Client, req *AuthorizeRequest) error {
+	if req.ResponseType != "code" {
+		return newOAuthError(http.StatusBadRequest, "unsupported_response_type", "only the code response type is supported")
+	}
+	for _, scope := range models.ParseScopes(req.Scope) {
+		if !client.AllowsScope(scope) {
+			return newOAuthError(http.StatusBadRequest, "invalid_scope", "scope not allowed: "+scope)
+		}
+	}
+	if req.Audience != "" && !client.AllowsAudience(req.Audience) {
+		return newOAuthError(http.StatusBadRequest, "invalid_target", "audience not allowed: "+req.Audience)
+	}
+	if req.CodeChallenge == "" && client.Public() {
+		return newOAuthError(http.StatusBadRequest, "invalid_request", "public clients must use PKCE")
+	}
+	if req.CodeChallengeMethod != "" && req.CodeChallengeMethod != "S256" && req.CodeChallengeMethod != "plain" {
+		return newOAuthError(http.StatusBadRequest, "invalid_request", "unsupported code_challenge_method")
+	}
+	return nil
+}
+
+// StartAuthorization stores a validated request while the user logs in
+// upstream and returns an opaque ID to resume it with
+func (s *AuthorizationServer) StartAuthorization(req *AuthorizeRequest) (string, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepLocked()
+	s.pending[id] = &pendingAuthorization{
+		request:   req,
+		expiresAt: time.Now().Add(pendingAuthorizationTTL),
+	}
+	return id, nil
+}
+
+// CompleteAuthorization issues an authorization code for a pending request
+// once the user has authenticated, returning the client redirect URL. The
+// tokens for the code are bound to the gateway session of the login.
+func (s *AuthorizationServer) CompleteAuthorization(id string, user *models.User, authTime time.Time, session *models.Session) (string, error) {
+	s.mu.Lock()
+	pending, ok := s.pending[id]
+	delete(s.pending, id)
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(pending.expiresAt) {
+		return "", errors.New("authorization request not found or expired")
+	}
+
+	code, err := s.issueCode(pending.request, user, authTime, session)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("code", code)
+	if pending.request.State != "" {
+		params.Set("state", pending.request.State)
+	}
+	return appendQuery(pending.request.RedirectURI, params), nil
+}
+
+// ErrorRedirect builds the client redirect URL carrying an OAuth error
+func (s *AuthorizationServer) ErrorRedirect(req *AuthorizeRequest, oauthErr *OAuthError) string {
+	params := url.Values{}
+	params.Set("error", oauthErr.Code)
+	if oauthErr.Description != "" {
+		params.Set("error_description", oauthErr.Description)
+	}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	return appendQuery(req.RedirectURI, params)
+}
+
+// Exchange handles a token request from a client
+func (s *AuthorizationServer) Exchange(req *TokenRequest) (*TokenResponse, error) {
+	client, err := s.authenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !supportedGrantTypes[req.GrantType] {
+		return nil, newOAuthError(http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+	if !client.AllowsGrantType(req.GrantType) {
+		return nil, newOAuthError(http.StatusBadRequest, "unauthorized_client", "grant type not allowed for this client")
+	}
+
+	switch req.GrantType {
+	case GrantTypeDeviceCode:
+		return s.exchangeDeviceCode(client, req)
+	case GrantTypeTokenExchange:
+		return s.exchangeToken(client, req)
+	default:
+		return s.exchangeAuthorizationCode(client, req)
+	}
+}
+
+func (s *AuthorizationServer) exchangeAuthorizationCode(client *models.Client, req *TokenRequest) (*TokenResponse, error) {
+	s.mu.Lock()
+	code, ok := s.codes[req.Code]
+	delete(s.codes, req.Code)
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(code.expiresAt) {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "authorization code is invalid or expired")
+	}
+	if code.request.ClientID != client.ID {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "authorization code was issued to another client")
+	}
+	if code.request.RedirectURI != req.RedirectURI {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "redirect_uri does not match")
+	}
+	if code.request.CodeChallenge != "" &&
+		!utils.VerifyPKCE(req.CodeVerifier, code.request.CodeChallenge, code.request.CodeChallengeMethod) {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "PKCE verification failed")
+	}
+
+	return s.issueTokens(client, code.user, code.request.Scope, code.request.Audience, code.request.Nonce, code.authTime, code.session)
+}
+
+// issueTokens mints the access token and, for the openid scope, the ID token
+// returned from the token endpoint. The access token carries the granted
+// scope, the client ID and the requested audience, or the client when none
+// was requested, so it is not accepted by the gateway's own routes. It is
+// bound to the gateway session, so it ends with the session, including on
+// provider logout.
+func (s *AuthorizationServer) issueTokens(client *models.Client, user *models.User, scope, audience, nonce string, authTime time.Time, session *models.Session) (*TokenResponse, error) {
+	accessTTL := time.Duration(s.config.JWTExpiration) * time.Hour
+	if client.AccessTokenLifetime > 0 {
+		accessTTL = time.Duration(client.AccessTokenLifetime) * time.Second
+	}
+	claims := utils.NewClaims(user, accessTTL)
+	claims.Scope = scope
+	claims.ClientID = client.ID
+	if !authTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(authTime)
+	}
+	if session != nil {
+		claims.SessionID = session.ID
+		if !session.ExpiresAt.IsZero() && session.ExpiresAt.Before(claims.ExpiresAt.Time) {
+			claims.ExpiresAt = jwt.NewNumericDate(session.ExpiresAt)
+		}
+	}
+	if audience == "" {
+		audience = client.ID
+	}
+	claims.Audience = jwt.ClaimStrings{audience}
+	accessToken, err := utils.SignJWT(claims, s.config.JWTSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &TokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(time.Until(claims.ExpiresAt.Time).Seconds()),
+		Scope:       scope,
+	}
+
+	if hasScope(scope, "openid") {
+		idTTL := idTokenTTL
+		if client.IDTokenLifetime > 0 {
+			idTTL = time.Duration(client.IDTokenLifetime) * time.Second
+		}
+		resp.IDToken, err = utils.GenerateIDToken(s.signingKey, s.Issuer(), user, client.ID, nonce, authTime, idTTL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// UserInfo returns the standard OIDC claims for the token's user
+func (s *AuthorizationServer) UserInfo(claims *utils.Claims) map[string]interface{} {
+	return map[string]interface{}{
+		"sub":   claims.UserID,
+		"email": claims.Email,
+		"name":  claims.Name,
+	}
+}
+
+func (s *AuthorizationServer) authenticateClient(clientID, clientSecret string) (*models.Client, error) {
+	client, err := s.clients.Get(clientID)
+	if err != nil {
+		return nil, newOAuthError(http.StatusUnauthorized, "invalid_client", "unknown client")
+	}
+	if client.Public() {
+		return client, nil
+	}
+	if !client.VerifySecret(clientSecret) {
+		return nil, newOAuthError(http.StatusUnauthorized, "invalid_client", "client authentication failed")
+	}
+	return client, nil
+}
+
+func (s *AuthorizationServer) issueCode(req *AuthorizeRequest, user *models.User, authTime time.Time, session *models.Session) (string, error) {
+	code, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepLocked()
+	s.codes[code] = &authorizationCode{
+		request:   req,
+		user:      user,
+		authTime:  authTime,
+		session:   session,
+		expiresAt: time.Now().Add(authorizationCodeTTL),
+	}
+	return code, nil
+}
+
+// sweepLocked drops expired pending requests and codes; s.mu must be held
+func (s *AuthorizationServer) sweepLocked() {
+	now := time.Now()
+	for id, p := range s.pending {
+		if now.After(p.expiresAt) {
+			delete(s.pending, id)
+		}
+	}
+	for code, c := range s.codes {
+		if now.After(c.expiresAt) {
+			delete(s.codes, code)
+		}
+	}
+	for deviceCode, d := range s.devices {
+		if now.After(d.expiresAt) {
+			delete(s.devices, deviceCode)
+			delete(s.userCodes, d.userCode)
+		}
+	}
+}
+
+func hasScope(scope, want string) bool {
+	for _, s := range models.ParseScopes(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+func appendQuery(rawURL string, params url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	for key, values := range params {
+		for _, v := range values {
+			query.Add(key, v)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
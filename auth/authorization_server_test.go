@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+func newTestAuthorizationServer(t *testing.T) *AuthorizationServer {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	clients := store.NewMemoryClientStore()
+	client := &models.Client{
+		ID:           "app",
+		RedirectURIs: []string{"https://app.example.com/callback"},
+	}
+	client.SetSecret("app-secret")
+	clients.Save(client)
+
+	cfg := &config.Config{
+		JWTSecret:     "test-secret-key",
+		JWTExpiration: 1,
+		OIDCIssuer:    "https://auth.example.com",
+	}
+	return NewAuthorizationServer(cfg, clients, utils.NewSigningKey(privateKey), NewSessionManager(cfg, store.NewMemorySessionStore()))
+}
+
+func TestAuthorizationServer_CodeFlow(t *testing.T) {
+	s := newTestAuthorizationServer(t)
+	req := &AuthorizeRequest{
+		ClientID:     "app",
+		RedirectURI:  "https://app.example.com/callback",
+		ResponseType: "code",
+		Scope:        "openid email",
+		State:        "xyz",
+		Nonce:        "n-1",
+	}
+
+	client, err := s.ValidateClient(req)
+	if err != nil {
+		t.Fatalf("ValidateClient() error = %v", err)
+	}
+	if err := s.ValidateAuthorizeRequest(client, req); err != nil {
+		t.Fatalf("ValidateAuthorizeRequest() error = %v", err)
+	}
+
+	id, err := s.StartAuthorization(req)
+	if err != nil {
+		t.Fatalf("StartAuthorization() error = %v", err)
+	}
+
+	user := &models.User{ID: "123", Email: "test@example.com", Roles: []string{"user"}}
+	session := &models.Session{ID: "session-1", ExpiresAt: time.Now().Add(10 * time.Minute)}
+	redirectURL, err := s.CompleteAuthorization(id, user, time.Now(), session)
+	if err != nil {
+		t.Fatalf("CompleteAuthorization() error = %v", err)
+	}
+
+	u, _ := url.Parse(redirectURL)
+	if u.Query().Get("state") != "xyz" {
+		t.Errorf("state = %s, want xyz", u.Query().Get("state"))
+	}
+	code := u.Query().Get("code")
+
+	resp, err := s.Exchange(&TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  req.RedirectURI,
+		ClientID:     "app",
+		ClientSecret: "app-secret",
+	})
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if resp.IDToken == "" {
+		t.Error("Expected ID token for openid scope")
+	}
+
+	// The access token ends with the gateway session of the login
+	claims, err := utils.ValidateJWT(resp.AccessToken, "test-secret-key")
+	if err != nil {
+		t.Fatalf("ValidateJWT() error = %v", err)
+	}
+	if claims.SessionID != session.ID || claims.ExpiresAt.After(session.ExpiresAt) {
+		t.Errorf("access token sid = %q, exp = %v, want %q and at most %v", claims.SessionID, claims.ExpiresAt, session.ID, session.ExpiresAt)
+	}
+
+	// and names the client, which it is for when no audience was requested
+	if claims.ClientID != "app" || len(claims.Audience) != 1 || !claims.HasAudience("app") {
+		t.Errorf("access token client_id = %q, aud = %v, want app and [app]", claims.ClientID, claims.Audience)
+	}
+
+	var idClaims utils.IDTokenClaims
+	if err := s.SigningKey().Parse(resp.IDToken, &idClaims); err != nil {
+		t.Fatalf("Failed to parse ID token: %v", err)
+	}
+	if idClaims.Nonce != "n-1" || idClaims.Subject != "123" {
+		t.Errorf("ID token nonce/sub = %s/%s, want n-1/123", idClaims.Nonce, idClaims.Subject)
+	}
+
+	// Codes are single use
+	if _, err := s.Exchange(&TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         code,
+		RedirectURI:  req.RedirectURI,
+		ClientID:     "app",
+		ClientSecret: "app-secret",
+	}); err == nil {
+		t.Error("Expected error when reusing authorization code, got nil")
+	}
+}
+
+func TestAuthorizationServer_ValidateClient(t *testing.T) {
+	s := newTestAuthorizationServer(t)
+
+	tests := []struct {
+		name        string
+		clientID    string
+		redirectURI string
+		wantErr     bool
+	}{
+		{"Registered redirect", "app", "https://app.example.com/callback", false},
+		{"Unregistered redirect", "app", "https://evil.example.com/callback", true},
+		{"Redirect prefix only", "app", "https://app.example.com/callback/extra", true},
+		{"Unknown client", "other", "https://app.example.com/callback", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.ValidateClient(&AuthorizeRequest{ClientID: tt.clientID, RedirectURI: tt.redirectURI})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorizationServer_ExchangeWrongSecret(t *testing.T) {
+	s := newTestAuthorizationServer(t)
+	req := &AuthorizeRequest{ClientID: "app", RedirectURI: "https://app.example.com/callback", ResponseType: "code"}
+
+	id, _ := s.StartAuthorization(req)
+	redirectURL, err := s.CompleteAuthorization(id, &models.User{ID: "123"}, time.Now(), nil)
+	if err != nil {
+		t.Fatalf("CompleteAuthorization() error = %v", err)
+	}
+	u, _ := url.Parse(redirectURL)
+
+	_, err = s.Exchange(&TokenRequest{
+		GrantType:    "authorization_code",
+		Code:         u.Query().Get("code"),
+		RedirectURI:  req.RedirectURI,
+		ClientID:     "app",
+		ClientSecret: "wrong",
+	})
+	if err == nil {
+		t.Error("Expected error for wrong client secret, got nil")
+	}
+}
@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+// breakGlassAlertTimeout bounds how long an alert webhook may take
+const breakGlassAlertTimeout = 10 * time.Second
+
+// BreakGlassService logs in pre-provisioned emergency accounts without the
+// identity provider. The accounts are read from a file at startup and only
+// their password hashes are stored. Every attempt, successful or not, is
+// recorded in the audit trail and raised as an alert.
+type BreakGlassService struct {
+	config   *config.Config
+	accounts map[string]*models.BreakGlassAccount
+	hasher   *PasswordHasher
+	audit    *Audit
+	client   *http.Client
+	throttle *passwordThrottle
+
+	// dummyHash is verified for unknown usernames so that they take as long
+	// as wrong passwords
+	dummyHash string
+
+	// lastSteps holds the last TOTP step used per account, so a code
+	// cannot be replayed
+	mu        sync.Mutex
+	lastSteps map[string]int64
+
+	// Failure alerts are posted at most once per alert interval. Failures
+	// until failureAlertsUntil are counted and posted together when it
+	// passes.
+	alertMu            sync.Mutex
+	failureAlertsUntil time.Time
+	missedFailures     int
+}
+
+// NewBreakGlassService loads the emergency accounts from the configured
+// file. Accounts without a valid Argon2id password hash are rejected.
+// Passwords are verified with the shared password hasher.
+func NewBreakGlassService(cfg *config.Config, hasher *PasswordHasher, audit *Audit) (*BreakGlassService, error) {
+	data, err := os.ReadFile(cfg.BreakGlassAccountsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read break-glass accounts: %w", err)
+	}
+	var accounts []*models.BreakGlassAccount
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("failed to decode break-glass accounts: %w", err)
+	}
+
+	s := &BreakGlassService{
+		config:    cfg,
+		accounts:  make(map[string]*models.BreakGlassAccount),
+		hasher:    hasher,
+		audit:     audit,
+		client:    &http.Client{Timeout: breakGlassAlertTimeout},
+		throttle:  newPerClientPasswordThrottle(cfg.BreakGlassMaxAttempts, cfg.BreakGlassIPMaxFailures, cfg.BreakGlassAttemptWindow),
+		lastSteps: make(map[string]int64),
+	}
+	for _, account := range accounts {
+		username := NormalizeUsername(account.Username)
+		if username == "" {
+			return nil, fmt.Errorf("break-glass account without username")
+		}
+		if _, err := hasher.Verify("", account.PasswordHash); err != nil {
+			return nil, fmt.Errorf("break-glass account %s: %w", username, err)
+		}
+		if _, ok := s.accounts[username]; ok {
+			return nil, fmt.Errorf("duplicate break-glass account %s", username)
+		}
+		account.Username = username
+		s.accounts[username] = account
+	}
+	if len(s.accounts) == 0 {
+		return nil, fmt.Errorf("no break-glass accounts in %s", cfg.BreakGlassAccountsFile)
+	}
+
+	if s.dummyHash, err = hasher.Hash("dummy password"); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Authenticate checks an emergency account's password and, if the account
+// has a TOTP secret, its one-time code. It returns the user and the amr of
+// the login. Failures are reported as ErrInvalidCredentials whichever
+// check failed, and too many attempts for the username from the client IP
+// or failures from the client IP as ErrTooManyAttempts. Attempts from other
+// clients never lock the username, so the account stays usable while it is
+// being guessed at.
+func (s *BreakGlassService) Authenticate(username, password, code, ip string) (*models.User, []string, error) {
+	username = NormalizeUsername(username)
+	now := time.Now()
+	if !s.throttle.Take(username, ip, now) {
+		s.reject(username, ip, "too many attempts")
+		return nil, nil, ErrTooManyAttempts
+	}
+	user, amr, err := s.authenticate(username, password, code, ip)
+	s.throttle.Done(username, ip, err, now)
+	return user, amr, err
+}
+
+func (s *BreakGlassService) authenticate(username, password, code, ip string) (*models.User, []string, error) {
+	account, ok := s.accounts[username]
+	if !ok {
+		s.hasher.Verify(password, s.dummyHash)
+		s.reject(username, ip, "unknown account")
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	valid, err := s.hasher.Verify(password, account.PasswordHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !valid {
+		s.reject(username, ip, "wrong password")
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	amr := []string{AMRPassword}
+	if account.TOTPSecret != "" {
+		if !s.useTOTP(account, code) {
+			s.reject(username, ip, "wrong one-time code")
+			return nil, nil, ErrInvalidCredentials
+		}
+		amr = append(amr, AMROTP, AMRMFA)
+	}
+
+	user := account.User()
+	details := map[string]string{
+		"username": username,
+		"ip":       ip,
+		"ttl":      s.config.BreakGlassTokenTTL.String(),
+	}
+	// The login goes ahead even if the audit store fails: the event is in
+	// the server log and the alert, and the gateway is in an emergency
+	s.audit.Record(models.AuditBreakGlassLogin, user.ID, "", details)
+	s.alert(fmt.Sprintf("Break-glass account %s logged in from %s", username, ip), models.AuditBreakGlassLogin, details)
+	return user, amr, nil
+}
+
+// useTOTP checks a one-time code and marks its step used
+func (s *BreakGlassService) useTOTP(account *models.BreakGlassAccount, code string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	step, ok := utils.VerifyTOTP(account.TOTPSecret, code, time.Now())
+	if !ok || step <= s.lastSteps[account.Username] {
+		return false
+	}
+	s.lastSteps[account.Username] = step
+	return true
+}
+
+// reject records and alerts on a failed login attempt. The webhook gets at
+// most one failure alert per interval, so a guessing attack cannot flood
+// the alert channel.
+func (s *BreakGlassService) reject(username, ip, reason string) {
+	details := map[string]string{
+		"username": username,
+		"ip":       ip,
+		"reason":   reason,
+	}
+	s.audit.Record(models.AuditBreakGlassFailed, models.BreakGlassProvider+":"+username, "", details)
+
+	message := fmt.Sprintf("Failed break-glass login for %s from %s", username, ip)
+	log.Printf("BREAK-GLASS ALERT: %s", message)
+	if s.takeFailureAlert(time.Now()) {
+		s.post(message, models.AuditBreakGlassFailed, details)
+	}
+}
+
+// takeFailureAlert reports whether a failure alert may be posted now. If
+// not, the failure is counted, and the count is posted when the interval
+// ends.
+func (s *BreakGlassService) takeFailureAlert(now time.Time) bool {
+	s.alertMu.Lock()
+	defer s.alertMu.Unlock()
+
+	if !now.Before(s.failureAlertsUntil) {
+		s.failureAlertsUntil = now.Add(s.config.BreakGlassAlertInterval)
+		return true
+	}
+	s.missedFailures++
+	if s.missedFailures == 1 {
+		time.AfterFunc(s.failureAlertsUntil.Sub(now), s.postMissedFailures)
+	}
+	return false
+}
+
+// postMissedFailures posts the number of failures not alerted on
+// individually, and starts a new alert interval
+func (s *BreakGlassService) postMissedFailures() {
+	s.alertMu.Lock()
+	count := s.missedFailures
+	s.missedFailures = 0
+	s.failureAlertsUntil = time.Now().Add(s.config.BreakGlassAlertInterval)
+	s.alertMu.Unlock()
+
+	message := fmt.Sprintf("%d more failed break-glass logins in the last %s; see the audit trail", count, s.config.BreakGlassAlertInterval)
+	s.post(message, models.AuditBreakGlassFailed, map[string]string{"count": strconv.Itoa(count)})
+}
+
+// alert writes the event to the server log and posts it to the alert
+// webhook
+func (s *BreakGlassService) alert(message, action string, details map[string]string) {
+	log.Printf("BREAK-GLASS ALERT: %s", message)
+	s.post(message, action, details)
+}
+
+// post sends an alert to the webhook, when one is configured. The webhook
+// is called in the background so a slow or failing alert channel does not
+// block the login.
+func (s *BreakGlassService) post(message, action string, details map[string]string) {
+	if s.config.BreakGlassAlertWebhookURL == "" {
+		return
+	}
+
+	// "text" is what Slack and compatible incoming webhooks display
+	body, err := json.Marshal(map[string]interface{}{
+		"text":    "BREAK-GLASS ALERT: " + message,
+		"action":  action,
+		"details": details,
+		"time":    time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Failed to encode break-glass alert: %v", err)
+		return
+	}
+	go func() {
+		resp, err := s.client.Post(s.config.BreakGlassAlertWebhookURL, "application/json", bytes.NewReader(body))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = errors.New(resp.Status)
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to send break-glass alert: %v", err)
+		}
+	}()
+}
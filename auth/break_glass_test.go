@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+var testBreakGlassParams = utils.Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func newTestBreakGlassService(t *testing.T, webhookURL string, accounts ...*models.BreakGlassAccount) (*BreakGlassService, *Audit) {
+	data, _ := json.Marshal(accounts)
+	path := filepath.Join(t.TempDir(), "break-glass.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	audit := NewAudit(store.NewMemoryAuditStore())
+	s, err := NewBreakGlassService(&config.Config{
+		BreakGlassAccountsFile:    path,
+		BreakGlassTokenTTL:        15 * time.Minute,
+		BreakGlassAlertWebhookURL: webhookURL,
+		BreakGlassAlertInterval:   100 * time.Millisecond,
+		BreakGlassMaxAttempts:     3,
+		BreakGlassIPMaxFailures:   5,
+		BreakGlassAttemptWindow:   15 * time.Minute,
+	}, newTestPasswordHasher(t), audit)
+	if err != nil {
+		t.Fatalf("NewBreakGlassService() error = %v", err)
+	}
+	return s, audit
+}
+
+func newTestPasswordHasher(t *testing.T) *PasswordHasher {
+	hasher, err := NewPasswordHasher(&config.Config{
+		LocalArgon2Memory:         1024,
+		LocalArgon2Iterations:     1,
+		LocalArgon2Parallelism:    1,
+		LocalArgon2MaxConcurrency: 2,
+	})
+	if err != nil {
+		t.Fatalf("NewPasswordHasher() error = %v", err)
+	}
+	return hasher
+}
+
+func testBreakGlassAccount(t *testing.T, username, password, totpSecret string) *models.BreakGlassAccount {
+	hash, err := utils.HashPassword(password, testBreakGlassParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.BreakGlassAccount{
+		Username:     username,
+		Roles:        []string{"admin"},
+		PasswordHash: hash,
+		TOTPSecret:   totpSecret,
+	}
+}
+
+func TestBreakGlassService_Authenticate(t *testing.T) {
+	alerts := make(chan string, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		alerts <- body.Text
+	}))
+	defer webhook.Close()
+
+	s, audit := newTestBreakGlassService(t, webhook.URL,
+		testBreakGlassAccount(t, "emergency-1", "correct horse battery staple", ""))
+
+	user, amr, err := s.Authenticate("Emergency-1", "correct horse battery staple", "", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if user.ID != "break-glass:emergency-1" || user.Provider != models.BreakGlassProvider || !user.HasRole("admin") {
+		t.Errorf("Authenticate() user = %+v, want the emergency admin", user)
+	}
+	if len(amr) != 1 || amr[0] != AMRPassword {
+		t.Errorf("Authenticate() amr = %v, want [%s]", amr, AMRPassword)
+	}
+
+	for _, tc := range []struct{ username, password string }{
+		{"emergency-1", "wrong password"},
+		{"nobody", "correct horse battery staple"},
+	} {
+		if _, _, err := s.Authenticate(tc.username, tc.password, "", "192.0.2.1"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q, %q) error = %v, want %v", tc.username, tc.password, err, ErrInvalidCredentials)
+		}
+	}
+
+	var actions []string
+	events, _ := audit.List("", "")
+	for _, event := range events {
+		actions = append(actions, event.Action)
+	}
+	want := []string{models.AuditBreakGlassLogin, models.AuditBreakGlassFailed, models.AuditBreakGlassFailed}
+	if strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Errorf("audit actions = %v, want %v", actions, want)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case text := <-alerts:
+			if !strings.HasPrefix(text, "BREAK-GLASS ALERT: ") {
+				t.Errorf("alert text = %q, want the BREAK-GLASS ALERT prefix", text)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d alerts, want 3", i)
+		}
+	}
+}
+
+func TestBreakGlassService_AttemptLimit(t *testing.T) {
+	s, _ := newTestBreakGlassService(t, "",
+		testBreakGlassAccount(t, "emergency-1", "correct horse battery staple", ""))
+
+	// The username is locked for an IP after 3 attempts from it, even with
+	// the right password
+	for i := 0; i < 3; i++ {
+		s.Authenticate("emergency-1", "wrong password", "", "192.0.2.1")
+	}
+	if _, _, err := s.Authenticate("emergency-1", "correct horse battery staple", "", "192.0.2.1"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("Authenticate() of a locked username error = %v, want %v", err, ErrTooManyAttempts)
+	}
+
+	// but the operator can still log in from another IP
+	if _, _, err := s.Authenticate("emergency-1", "correct horse battery staple", "", "192.0.2.2"); err != nil {
+		t.Errorf("Authenticate() from another IP error = %v, want none", err)
+	}
+
+	// The IP is locked after 5 failures, whichever usernames it tries
+	s.Authenticate("nobody-1", "wrong password", "", "192.0.2.1")
+	s.Authenticate("nobody-2", "wrong password", "", "192.0.2.1")
+	if _, _, err := s.Authenticate("nobody-3", "wrong password", "", "192.0.2.1"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("Authenticate() from a locked IP error = %v, want %v", err, ErrTooManyAttempts)
+	}
+}
+
+func TestBreakGlassService_FailureAlertsCoalesced(t *testing.T) {
+	alerts := make(chan string, 20)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Text string `json:"text"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		alerts <- body.Text
+	}))
+	defer webhook.Close()
+
+	s, _ := newTestBreakGlassService(t, webhook.URL,
+		testBreakGlassAccount(t, "emergency-1", "correct horse battery staple", ""),
+		testBreakGlassAccount(t, "emergency-2", "correct horse battery staple", ""))
+	s.config.BreakGlassAlertInterval = 500 * time.Millisecond
+
+	for i := 0; i < 4; i++ {
+		s.Authenticate("emergency-1", "wrong password", "", fmt.Sprintf("192.0.2.%d", i))
+	}
+	// Every success is still posted at once
+	if _, _, err := s.Authenticate("emergency-2", "correct horse battery staple", "", "192.0.2.10"); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	var texts []string
+	timeout := time.After(5 * time.Second)
+	for len(texts) < 3 {
+		select {
+		case text := <-alerts:
+			texts = append(texts, text)
+		case <-timeout:
+			t.Fatalf("got alerts %q, want 3", texts)
+		}
+	}
+	select {
+	case text := <-alerts:
+		t.Errorf("got an extra alert %q", text)
+	case <-time.After(700 * time.Millisecond):
+	}
+
+	joined := strings.Join(texts, "\n")
+	for _, want := range []string{
+		"Failed break-glass login for emergency-1 from 192.0.2.0",
+		"Break-glass account emergency-2 logged in",
+		"3 more failed break-glass logins",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("alerts %q do not include %q", texts, want)
+		}
+	}
+}
+
+func TestBreakGlassService_TOTP(t *testing.T) {
+	secret, _ := utils.GenerateTOTPSecret()
+	s, _ := newTestBreakGlassService(t, "",
+		testBreakGlassAccount(t, "emergency-2", "correct horse battery staple", secret))
+
+	if _, _, err := s.Authenticate("emergency-2", "correct horse battery staple", "", ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() without code error = %v, want %v", err, ErrInvalidCredentials)
+	}
+
+	code, _ := utils.TOTPCode(secret, utils.TOTPStep(time.Now()))
+	_, amr, err := s.Authenticate("emergency-2", "correct horse battery staple", code, "")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if ACRForAMR(amr) != ACRMFA {
+		t.Errorf("Authenticate() amr = %v, want an %s login", amr, ACRMFA)
+	}
+
+	if _, _, err := s.Authenticate("emergency-2", "correct horse battery staple", code, ""); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() with a replayed code error = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
+
+func TestNewBreakGlassService_InvalidHash(t *testing.T) {
+	data, _ := json.Marshal([]*models.BreakGlassAccount{{Username: "emergency-1", PasswordHash: "plaintext"}})
+	path := filepath.Join(t.TempDir(), "break-glass.json")
+	os.WriteFile(path, data, 0o600)
+
+	_, err := NewBreakGlassService(&config.Config{BreakGlassAccountsFile: path}, newTestPasswordHasher(t), NewAudit(store.NewMemoryAuditStore()))
+	if !errors.Is(err, utils.ErrInvalidPasswordHash) {
+		t.Errorf("NewBreakGlassService() error = %v, want %v", err, utils.ErrInvalidPasswordHash)
+	}
+}
+
+func TestBreakGlassService_SharesHashSlots(t *testing.T) {
+	s, _ := newTestBreakGlassService(t, "", testBreakGlassAccount(t, "emergency-1", "correct horse battery staple", ""))
+
+	// With every slot of the shared hasher taken, the check waits
+	for i := 0; i < cap(s.hasher.slots); i++ {
+		s.hasher.slots <- struct{}{}
+	}
+	done := make(chan error)
+	go func() {
+		_, _, err := s.Authenticate("emergency-1", "correct horse battery staple", "", "192.0.2.1")
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("Authenticate() ran with no free hash slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for i := 0; i < cap(s.hasher.slots); i++ {
+		<-s.hasher.slots
+	}
+	if err := <-done; err != nil {
+		t.Errorf("Authenticate() error = %v", err)
+	}
+}
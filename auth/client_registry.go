@@ -0,0 +1,237 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// supportedGrantTypes lists the grant types clients may register for
+var supportedGrantTypes = map[string]bool{
+	"authorization_code":   true,
+	GrantTypeDeviceCode:    true,
+	GrantTypeTokenExchange: true,
+}
+
+// ClientMetadata is the RFC 7591 client metadata accepted at registration
+type ClientMetadata struct {
+	RedirectURIs            []string `json:"redirect_uris"`
+	ClientName              string   `json:"client_name,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	Audiences               []string `json:"audiences,omitempty"`
+	DelegatedRoles          []string `json:"delegated_roles,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	AccessTokenLifetime     int      `json:"access_token_lifetime,omitempty"`
+	IDTokenLifetime         int      `json:"id_token_lifetime,omitempty"`
+}
+
+// ClientInformation is the RFC 7591 registration response. The secret is
+// only ever returned on creation and rotation.
+type ClientInformation struct {
+	ClientID              string `json:"client_id"`
+	ClientSecret          string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt      int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt int64  `json:"client_secret_expires_at"`
+	ClientMetadata
+}
+
+// ClientRegistry manages the OAuth clients of the authorization server
+type ClientRegistry struct {
+	config  *config.Config
+	clients store.ClientStore
+}
+
+// NewClientRegistry creates a new client registry
+func NewClientRegistry(cfg *config.Config, clients store.ClientStore) *ClientRegistry {
+	return &ClientRegistry{
+		config:  cfg,
+		clients: clients,
+	}
+}
+
+// Register validates the metadata and creates a new client
+func (r *ClientRegistry) Register(meta *ClientMetadata) (*ClientInformation, error) {
+	if err := r.validateMetadata(meta); err != nil {
+		return nil, err
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &models.Client{
+		ID:                  id,
+		Name:                meta.ClientName,
+		RedirectURIs:        meta.RedirectURIs,
+		GrantTypes:          meta.GrantTypes,
+		Scopes:              models.ParseScopes(meta.Scope),
+		Audiences:           meta.Audiences,
+		DelegatedRoles:      meta.DelegatedRoles,
+		AccessTokenLifetime: meta.AccessTokenLifetime,
+		IDTokenLifetime:     meta.IDTokenLifetime,
+		CreatedAt:           time.Now(),
+	}
+
+	var secret string
+	if meta.TokenEndpointAuthMethod != "none" {
+		if secret, err = randomHex(32); err != nil {
+			return nil, err
+		}
+		client.SetSecret(secret)
+	}
+
+	if err := r.clients.Save(client); err != nil {
+		return nil, err
+	}
+
+	info := clientInformation(client)
+	info.ClientSecret = secret
+	return info, nil
+}
+
+// RotateSecret replaces the secret of a confidential client
+func (r *ClientRegistry) RotateSecret(id string) (*ClientInformation, error) {
+	client, err := r.clients.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if client.Public() {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_client_metadata", "public clients have no secret")
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	client.SetSecret(secret)
+
+	if err := r.clients.Save(client); err != nil {
+		return nil, err
+	}
+
+	info := clientInformation(client)
+	info.ClientSecret = secret
+	return info, nil
+}
+
+// Get returns the registration information of a client
+func (r *ClientRegistry) Get(id string) (*ClientInformation, error) {
+	client, err := r.clients.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return clientInformation(client), nil
+}
+
+// List returns the registration information of all clients
+func (r *ClientRegistry) List() ([]*ClientInformation, error) {
+	clients, err := r.clients.List()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*ClientInformation, 0, len(clients))
+	for _, client := range clients {
+		infos = append(infos, clientInformation(client))
+	}
+	return infos, nil
+}
+
+// Delete removes a client
+func (r *ClientRegistry) Delete(id string) error {
+	return r.clients.Delete(id)
+}
+
+func (r *ClientRegistry) validateMetadata(meta *ClientMetadata) error {
+	for _, grantType := range meta.GrantTypes {
+		if !supportedGrantTypes[grantType] {
+			return newOAuthError(http.StatusBadRequest, "invalid_client_metadata", "unsupported grant type: "+grantType)
+		}
+	}
+
+	// Only redirect-based grants need redirect URIs
+	probe := &models.Client{GrantTypes: meta.GrantTypes}
+	if probe.AllowsGrantType("authorization_code") && len(meta.RedirectURIs) == 0 {
+		return newOAuthError(http.StatusBadRequest, "invalid_redirect_uri", "at least one redirect_uri is required")
+	}
+	for _, uri := range meta.RedirectURIs {
+		if err := validateRedirectURI(uri); err != nil {
+			return newOAuthError(http.StatusBadRequest, "invalid_redirect_uri", err.Error())
+		}
+	}
+
+	switch meta.TokenEndpointAuthMethod {
+	case "", "client_secret_basic", "client_secret_post", "none":
+	default:
+		return newOAuthError(http.StatusBadRequest, "invalid_client_metadata", "unsupported token_endpoint_auth_method")
+	}
+
+	maxLifetime := r.config.JWTExpiration * 3600
+	if meta.AccessTokenLifetime < 0 || meta.AccessTokenLifetime > maxLifetime {
+		return newOAuthError(http.StatusBadRequest, "invalid_client_metadata", "access_token_lifetime out of range")
+	}
+	if meta.IDTokenLifetime < 0 || meta.IDTokenLifetime > maxLifetime {
+		return newOAuthError(http.StatusBadRequest, "invalid_client_metadata", "id_token_lifetime out of range")
+	}
+	return nil
+}
+
+// validateRedirectURI requires absolute HTTPS URIs without fragments,
+// allowing plain HTTP only for loopback addresses used by native apps
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return errors.New("redirect_uri must be an absolute URL")
+	}
+	if u.Fragment != "" {
+		return errors.New("redirect_uri must not contain a fragment")
+	}
+	if u.Scheme == "https" {
+		return nil
+	}
+	host := u.Hostname()
+	if u.Scheme == "http" && (host == "localhost" || host == "127.0.0.1" || host == "::1") {
+		return nil
+	}
+	return errors.New("redirect_uri must use https")
+}
+
+func clientInformation(client *models.Client) *ClientInformation {
+	authMethod := "client_secret_basic"
+	if client.Public() {
+		authMethod = "none"
+	}
+	return &ClientInformation{
+		ClientID:         client.ID,
+		ClientIDIssuedAt: client.CreatedAt.Unix(),
+		ClientMetadata: ClientMetadata{
+			RedirectURIs:            client.RedirectURIs,
+			ClientName:              client.Name,
+			GrantTypes:              client.GrantTypes,
+			Scope:                   strings.Join(client.Scopes, " "),
+			Audiences:               client.Audiences,
+			DelegatedRoles:          client.DelegatedRoles,
+			TokenEndpointAuthMethod: authMethod,
+			AccessTokenLifetime:     client.AccessTokenLifetime,
+			IDTokenLifetime:         client.IDTokenLifetime,
+		},
+	}
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+func TestClientRegistry_RegisterAndRotate(t *testing.T) {
+	clients := store.NewMemoryClientStore()
+	registry := NewClientRegistry(&config.Config{JWTExpiration: 1}, clients)
+
+	info, err := registry.Register(&ClientMetadata{
+		RedirectURIs:        []string{"https://app.example.com/callback"},
+		ClientName:          "App",
+		Scope:               "openid email",
+		AccessTokenLifetime: 600,
+	})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if info.ClientSecret == "" {
+		t.Fatal("Expected a client secret for a confidential client")
+	}
+
+	client, err := clients.Get(info.ClientID)
+	if err != nil {
+		t.Fatalf("Failed to get registered client: %v", err)
+	}
+	if !client.VerifySecret(info.ClientSecret) {
+		t.Error("Registered secret does not verify")
+	}
+	if client.AccessTokenLifetime != 600 {
+		t.Errorf("AccessTokenLifetime = %d, want 600", client.AccessTokenLifetime)
+	}
+
+	rotated, err := registry.RotateSecret(info.ClientID)
+	if err != nil {
+		t.Fatalf("RotateSecret() error = %v", err)
+	}
+	client, _ = clients.Get(info.ClientID)
+	if client.VerifySecret(info.ClientSecret) {
+		t.Error("Old secret still verifies after rotation")
+	}
+	if !client.VerifySecret(rotated.ClientSecret) {
+		t.Error("Rotated secret does not verify")
+	}
+
+	if err := registry.Delete(info.ClientID); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := registry.Get(info.ClientID); err == nil {
+		t.Error("Expected error getting deleted client, got nil")
+	}
+}
+
+func TestClientRegistry_InvalidMetadata(t *testing.T) {
+	registry := NewClientRegistry(&config.Config{JWTExpiration: 1}, store.NewMemoryClientStore())
+
+	tests := []struct {
+		name string
+		meta ClientMetadata
+	}{
+		{"No redirect URIs", ClientMetadata{}},
+		{"Relative redirect URI", ClientMetadata{RedirectURIs: []string{"/callback"}}},
+		{"Plain HTTP redirect URI", ClientMetadata{RedirectURIs: []string{"http://app.example.com/cb"}}},
+		{"Fragment in redirect URI", ClientMetadata{RedirectURIs: []string{"https://app.example.com/cb#x"}}},
+		{"Unsupported grant type", ClientMetadata{RedirectURIs: []string{"https://app.example.com/cb"}, GrantTypes: []string{"password"}}},
+		{"Lifetime above maximum", ClientMetadata{RedirectURIs: []string{"https://app.example.com/cb"}, AccessTokenLifetime: 7200}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := registry.Register(&tt.meta); err == nil {
+				t.Error("Expected error for invalid metadata, got nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+// GrantTypeDeviceCode is the RFC 8628 device authorization grant type
+const GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+const (
+	deviceCodeTTL        = 10 * time.Minute
+	devicePollInterval   = 5 * time.Second
+	userCodeAlphabet     = "BCDFGHJKLMNPQRSTVWXZ" // no vowels, so codes never spell words
+	userCodeLength       = 8
+	slowDownIntervalStep = 5 * time.Second
+
+	// userCodeMaxFailures is how many wrong user codes a client IP may enter
+	// per deviceCodeTTL, so codes cannot be guessed
+	userCodeMaxFailures = 10
+	// userCodeMaxEntries is how many times a user code may be entered before
+	// its device authorization is dropped
+	userCodeMaxEntries = 3
+)
+
+// ErrDeviceCode is returned for user codes that are unknown, expired or
+// already used, and for consent that does not match a pending login
+var ErrDeviceCode = errors.New("unknown or expired code")
+
+// DeviceAuthorizationResponse is the RFC 8628 device authorization response
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type deviceAuthorization struct {
+	clientID   string
+	clientName string
+	scope      string
+	userCode   string
+	expiresAt  time.Time
+	interval   time.Duration
+	lastPoll   time.Time
+	entries    int
+
+	// Set once the user has logged in, until they approve or deny the
+	// device on the consent page
+	consentToken    string
+	consentUser     *models.User
+	consentAuthTime time.Time
+	consentSession  *models.Session
+
+	// Set once the user has approved the device, or denied it
+	user     *models.User
+	authTime time.Time
+	session  *models.Session
+	denied   bool
+}
+
+// DeviceConsent is what the user is shown before approving a device: the
+// client that asked and the scopes it gets. Token binds the approval to the
+// login that produced it.
+type DeviceConsent struct {
+	UserCode   string
+	ClientID   string
+	ClientName string
+	Scopes     []string
+	Token      string
+}
+
+// StartDeviceAuthorization issues a device code and user code for a client
+// that cannot receive browser redirects
+func (s *AuthorizationServer) StartDeviceAuthorization(clientID, clientSecret, scope string) (*DeviceAuthorizationResponse, error) {
+	client, err := s.authenticateClient(clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsGrantType(GrantTypeDeviceCode) {
+		return nil, newOAuthError(http.StatusBadRequest, "unauthorized_client", "device authorization not allowed for this client")
+	}
+	for _, sc := range models.ParseScopes(scope) {
+		if !client.AllowsScope(sc) {
+			return nil, newOAuthError(http.StatusBadRequest, "invalid_scope", "scope not allowed: "+sc)
+		}
+	}
+
+	deviceCode, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepLocked()
+
+	userCode, err := s.newUserCodeLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	s.devices[deviceCode] = &deviceAuthorization{
+		clientID:   client.ID,
+		clientName: client.Name,
+		scope:      scope,
+		userCode:   userCode,
+		expiresAt:  time.Now().Add(deviceCodeTTL),
+		interval:   devicePollInterval,
+	}
+	s.userCodes[userCode] = deviceCode
+
+	verificationURI := s.Issuer() + "/oauth/device"
+	return &DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                FormatUserCode(userCode),
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + FormatUserCode(userCode),
+		ExpiresIn:               int(deviceCodeTTL.Seconds()),
+		Interval:                int(devicePollInterval.Seconds()),
+	}, nil
+}
+
+// LookupUserCode checks that a user code entered in the browser from ip
+// belongs to a pending device authorization and returns it in normalized
+// form. Wrong codes count against the IP, and each code may only be entered
+// a few times.
+func (s *AuthorizationServer) LookupUserCode(userCode, ip string) (string, error) {
+	userCode = NormalizeUserCode(userCode)
+	now := time.Now()
+	if !s.userCodeFailures.Allow(ip, now) {
+		return "", ErrTooManyAttempts
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.deviceByUserCodeLocked(userCode)
+	if !ok || device.user != nil || device.denied {
+		s.userCodeFailures.Record(ip, now)
+		return "", ErrDeviceCode
+	}
+	device.entries++
+	if device.entries > userCodeMaxEntries {
+		// Someone keeps entering this code; deny rather than risk a user
+		// approving a device that is not theirs
+		device.denied = true
+		return "", ErrDeviceCode
+	}
+	return userCode, nil
+}
+
+// RequestDeviceConsent records the user who logged in for a pending device
+// authorization, and the gateway session of that login, and returns what to
+// show them before they approve it. Nothing is approved until ApproveDevice
+// is called with the token.
+func (s *AuthorizationServer) RequestDeviceConsent(userCode string, user *models.User, authTime time.Time, session *models.Session) (*DeviceConsent, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.deviceByUserCodeLocked(NormalizeUserCode(userCode))
+	if !ok || device.user != nil || device.denied {
+		return nil, ErrDeviceCode
+	}
+	device.consentToken = token
+	device.consentUser = user
+	device.consentAuthTime = authTime
+	device.consentSession = session
+
+	name := device.clientName
+	if name == "" {
+		name = device.clientID
+	}
+	return &DeviceConsent{
+		UserCode:   FormatUserCode(device.userCode),
+		ClientID:   device.clientID,
+		ClientName: name,
+		Scopes:     models.ParseScopes(device.scope),
+		Token:      token,
+	}, nil
+}
+
+// ApproveDevice approves a device authorization for the user who logged in
+// for it, allowing the device's next poll to receive tokens. The consent
+// token must be the one returned by RequestDeviceConsent.
+func (s *AuthorizationServer) ApproveDevice(userCode, consentToken string) error {
+	return s.decideDevice(userCode, consentToken, true)
+}
+
+// DenyDevice rejects a device authorization; the device's next poll gets
+// access_denied
+func (s *AuthorizationServer) DenyDevice(userCode, consentToken string) error {
+	return s.decideDevice(userCode, consentToken, false)
+}
+
+func (s *AuthorizationServer) decideDevice(userCode, consentToken string, approve bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.deviceByUserCodeLocked(NormalizeUserCode(userCode))
+	if !ok || device.user != nil || device.denied || device.consentToken == "" ||
+		subtle.ConstantTimeCompare([]byte(device.consentToken), []byte(consentToken)) != 1 {
+		return ErrDeviceCode
+	}
+	if approve {
+		device.user = device.consentUser
+		device.authTime = device.consentAuthTime
+		device.session = device.consentSession
+	} else {
+		device.denied = true
+	}
+	device.consentToken = ""
+	device.consentUser = nil
+	device.consentSession = nil
+	return nil
+}
+
+func (s *AuthorizationServer) exchangeDeviceCode(client *models.Client, req *TokenRequest) (*TokenResponse, error) {
+	s.mu.Lock()
+	device, ok := s.devices[req.DeviceCode]
+	if !ok || device.clientID != client.ID {
+		s.mu.Unlock()
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "device code is invalid")
+	}
+
+	now := time.Now()
+	if now.After(device.expiresAt) {
+		delete(s.devices, req.DeviceCode)
+		delete(s.userCodes, device.userCode)
+		s.mu.Unlock()
+		return nil, newOAuthError(http.StatusBadRequest, "expired_token", "device code has expired")
+	}
+
+	if device.denied {
+		delete(s.devices, req.DeviceCode)
+		delete(s.userCodes, device.userCode)
+		s.mu.Unlock()
+		return nil, newOAuthError(http.StatusBadRequest, "access_denied", "the user denied the request")
+	}
+
+	if device.user == nil {
+		tooFast := !device.lastPoll.IsZero() && now.Sub(device.lastPoll) < device.interval
+		device.lastPoll = now
+		if tooFast {
+			device.interval += slowDownIntervalStep
+			s.mu.Unlock()
+			return nil, newOAuthError(http.StatusBadRequest, "slow_down", "")
+		}
+		s.mu.Unlock()
+		return nil, newOAuthError(http.StatusBadRequest, "authorization_pending", "")
+	}
+
+	// Approved: the device code is single use
+	delete(s.devices, req.DeviceCode)
+	delete(s.userCodes, device.userCode)
+	s.mu.Unlock()
+
+	return s.issueTokens(client, device.user, device.scope, "", "", device.authTime, device.session)
+}
+
+func (s *AuthorizationServer) deviceByUserCodeLocked(userCode string) (*deviceAuthorization, bool) {
+	deviceCode, ok := s.userCodes[userCode]
+	if !ok {
+		return nil, false
+	}
+	device, ok := s.devices[deviceCode]
+	if !ok || time.Now().After(device.expiresAt) {
+		return nil, false
+	}
+	return device, true
+}
+
+func (s *AuthorizationServer) newUserCodeLocked() (string, error) {
+	alphabetSize := big.NewInt(int64(len(userCodeAlphabet)))
+	for attempt := 0; attempt < 10; attempt++ {
+		code := make([]byte, userCodeLength)
+		for i := range code {
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return "", err
+			}
+			code[i] = userCodeAlphabet[n.Int64()]
+		}
+		if _, exists := s.userCodes[string(code)]; !exists {
+			return string(code), nil
+		}
+	}
+	return "", errors.New("failed to generate a unique user code")
+}
+
+// NormalizeUserCode uppercases a user code and strips separators so codes
+// typed by users match regardless of formatting
+func NormalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, userCode)
+}
+
+// FormatUserCode renders a normalized user code as XXXX-XXXX for display
+func FormatUserCode(userCode string) string {
+	if len(userCode) != userCodeLength {
+		return userCode
+	}
+	return userCode[:4] + "-" + userCode[4:]
+}
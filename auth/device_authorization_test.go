@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+func TestAuthorizationServer_DeviceFlow(t *testing.T) {
+	s := newTestAuthorizationServer(t)
+	s.clients.Save(&models.Client{
+		ID:         "cli",
+		Name:       "Deploy CLI",
+		GrantTypes: []string{GrantTypeDeviceCode},
+	})
+
+	resp, err := s.StartDeviceAuthorization("cli", "", "openid")
+	if err != nil {
+		t.Fatalf("StartDeviceAuthorization() error = %v", err)
+	}
+
+	poll := func() error {
+		_, err := s.Exchange(&TokenRequest{
+			GrantType:  GrantTypeDeviceCode,
+			ClientID:   "cli",
+			DeviceCode: resp.DeviceCode,
+		})
+		return err
+	}
+
+	assertOAuthError := func(err error, code string) {
+		t.Helper()
+		var oauthErr *OAuthError
+		if !errors.As(err, &oauthErr) || oauthErr.Code != code {
+			t.Errorf("error = %v, want %s", err, code)
+		}
+	}
+
+	assertOAuthError(poll(), "authorization_pending")
+	assertOAuthError(poll(), "slow_down")
+
+	// Users may type the code in lower case and without the separator
+	userCode, err := s.LookupUserCode(strings.ToLower(resp.UserCode), "192.0.2.1")
+	if err != nil {
+		t.Fatalf("LookupUserCode() error = %v", err)
+	}
+
+	// Logging in only asks for consent; the device is still pending
+	consent, err := s.RequestDeviceConsent(userCode, &models.User{ID: "123"}, time.Now(), &models.Session{ID: "session-1"})
+	if err != nil {
+		t.Fatalf("RequestDeviceConsent() error = %v", err)
+	}
+	if consent.ClientName != "Deploy CLI" || len(consent.Scopes) != 1 || consent.Scopes[0] != "openid" {
+		t.Errorf("RequestDeviceConsent() = %+v, want the client name and scopes", consent)
+	}
+	s.devices[resp.DeviceCode].lastPoll = time.Time{} // poll again without slow_down
+	assertOAuthError(poll(), "authorization_pending")
+
+	if err := s.ApproveDevice(userCode, "forged"); !errors.Is(err, ErrDeviceCode) {
+		t.Errorf("ApproveDevice() with a wrong consent token error = %v, want %v", err, ErrDeviceCode)
+	}
+	if err := s.ApproveDevice(userCode, consent.Token); err != nil {
+		t.Fatalf("ApproveDevice() error = %v", err)
+	}
+
+	tokens, err := s.Exchange(&TokenRequest{
+		GrantType:  GrantTypeDeviceCode,
+		ClientID:   "cli",
+		DeviceCode: resp.DeviceCode,
+	})
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if tokens.AccessToken == "" || tokens.IDToken == "" {
+		t.Error("Expected access token and ID token")
+	}
+	if claims, err := utils.ValidateJWT(tokens.AccessToken, s.config.JWTSecret); err != nil || claims.SessionID != "session-1" {
+		t.Errorf("device access token sid = %v (%v), want the session of the approving login", claims, err)
+	}
+
+	assertOAuthError(poll(), "invalid_grant")
+}
+
+func TestAuthorizationServer_DeviceDenied(t *testing.T) {
+	s := newTestAuthorizationServer(t)
+	s.clients.Save(&models.Client{ID: "cli", GrantTypes: []string{GrantTypeDeviceCode}})
+	resp, _ := s.StartDeviceAuthorization("cli", "", "openid")
+
+	consent, err := s.RequestDeviceConsent(resp.UserCode, &models.User{ID: "123"}, time.Now(), nil)
+	if err != nil {
+		t.Fatalf("RequestDeviceConsent() error = %v", err)
+	}
+	if err := s.DenyDevice(resp.UserCode, consent.Token); err != nil {
+		t.Fatalf("DenyDevice() error = %v", err)
+	}
+
+	_, err = s.Exchange(&TokenRequest{GrantType: GrantTypeDeviceCode, ClientID: "cli", DeviceCode: resp.DeviceCode})
+	var oauthErr *OAuthError
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "access_denied" {
+		t.Errorf("Exchange() after denial error = %v, want access_denied", err)
+	}
+}
+
+func TestAuthorizationServer_UserCodeLimits(t *testing.T) {
+	s := newTestAuthorizationServer(t)
+	s.clients.Save(&models.Client{ID: "cli", GrantTypes: []string{GrantTypeDeviceCode}})
+	resp, _ := s.StartDeviceAuthorization("cli", "", "openid")
+
+	// Wrong codes lock out the guessing IP, but not others
+	for i := 0; i < userCodeMaxFailures; i++ {
+		if _, err := s.LookupUserCode("BBBB-BBBB", "192.0.2.1"); !errors.Is(err, ErrDeviceCode) {
+			t.Fatalf("LookupUserCode() of a wrong code error = %v, want %v", err, ErrDeviceCode)
+		}
+	}
+	if _, err := s.LookupUserCode(resp.UserCode, "192.0.2.1"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("LookupUserCode() after %d wrong codes error = %v, want %v", userCodeMaxFailures, err, ErrTooManyAttempts)
+	}
+
+	// A code entered over and over is dropped
+	for i := 0; i < userCodeMaxEntries; i++ {
+		if _, err := s.LookupUserCode(resp.UserCode, "192.0.2.2"); err != nil {
+			t.Fatalf("LookupUserCode() error = %v", err)
+		}
+	}
+	if _, err := s.LookupUserCode(resp.UserCode, "192.0.2.2"); !errors.Is(err, ErrDeviceCode) {
+		t.Errorf("LookupUserCode() entry %d error = %v, want %v", userCodeMaxEntries+1, err, ErrDeviceCode)
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"BCDF-GHJK", "BCDFGHJK"},
+		{"bcdf-ghjk", "BCDFGHJK"},
+		{" bcdf ghjk ", "BCDFGHJK"},
+	}
+
+	for _, tt := range tests {
+		if result := NormalizeUserCode(tt.input); result != tt.expected {
+			t.Errorf("NormalizeUserCode(%q) = %s, want %s", tt.input, result, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// ErrUnknownUser is returned for users the gateway has no record of
+var ErrUnknownUser = errors.New("unknown user")
+
+// UserDirectory looks up users for logins that never reach their provider,
+// such as passkeys. Profiles come from the account and local account
+// stores, or from the profile the provider returned at the user's last
+// login, and roles are the baseline before grants and elevations.
+type UserDirectory struct {
+	config   *config.Config
+	accounts *AccountService
+	local    *LocalAccountService
+}
+
+// NewUserDirectory creates a directory over the account and local account
+// services, either of which may be nil when disabled
+func NewUserDirectory(cfg *config.Config, accounts *AccountService, local *LocalAccountService) *UserDirectory {
+	return &UserDirectory{
+		config:   cfg,
+		accounts: accounts,
+		local:    local,
+	}
+}
+
+// Lookup returns the user with the gateway user ID who logs in with the
+// provider. With account linking the ID is an account ID, and an identity
+// from the provider must still be linked to it. Local accounts must still
+// exist and keep their current roles.
+func (d *UserDirectory) Lookup(userID, provider string) (*models.User, error) {
+	if provider == "" {
+		return nil, ErrUnknownUser
+	}
+
+	user := &models.User{ID: userID, Provider: provider}
+	subject := userID
+	if d.accounts != nil {
+		account, err := d.accounts.Account(userID)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrUnknownUser
+		}
+		if err != nil {
+			return nil, err
+		}
+		identity := latestIdentity(account, provider)
+		if identity == nil {
+			return nil, ErrUnknownUser
+		}
+		subject = identity.Subject
+		user.Email = identity.Email
+		user.EmailVerified = identity.EmailVerified
+	}
+
+	switch provider {
+	case "local":
+		if d.local == nil {
+			return nil, ErrUnknownUser
+		}
+		account, err := d.local.Account(subject)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, ErrUnknownUser
+		}
+		if err != nil {
+			return nil, err
+		}
+		local := account.User()
+		local.ID = userID
+		return local, nil
+	case MagicLinkProvider:
+		// The address is the identity, and a link to it proved control
+		user.Email = subject
+		user.EmailVerified = true
+		user.Roles = magicLinkRoles(d.config)
+	default:
+		user.Roles = []string{string(models.RoleUser)}
+	}
+	return user, nil
+}
+
+// LookupProfile is Lookup given the profile the provider returned at the
+// user's last login, if any. Users the gateway keeps no record of, such as
+// IdP, LDAP and SAML users, take their email, name and roles from it, so a
+// passkey login carries the same ones as their provider login. Local and
+// magic link users keep their current ones.
+func (d *UserDirectory) LookupProfile(userID, provider string, profile *models.User) (*models.User, error) {
+	user, err := d.Lookup(userID, provider)
+	if err != nil || profile == nil || profile.Provider != provider {
+		return user, err
+	}
+	switch provider {
+	case "local", MagicLinkProvider:
+		return user, nil
+	}
+
+	user.Email = profile.Email
+	user.EmailVerified = profile.EmailVerified
+	user.Name = profile.Name
+	user.Picture = profile.Picture
+	user.Roles = append([]string(nil), profile.Roles...)
+	return user, nil
+}
+
+// Find returns the user with the gateway user ID, whichever provider they
+// log in with. With account linking the account must exist, and its most
+// recently used identity gives the email. Without it only local accounts can
+// be found, because provider users are not stored.
+func (d *UserDirectory) Find(userID string) (*models.User, error) {
+	if d.accounts == nil {
+		return d.Lookup(userID, "local")
+	}
+
+	account, err := d.accounts.Account(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrUnknownUser
+	}
+	if err != nil {
+		return nil, err
+	}
+	var latest *models.LinkedIdentity
+	for i := range account.Identities {
+		if latest == nil || account.Identities[i].LastLoginAt.After(latest.LastLoginAt) {
+			latest = &account.Identities[i]
+		}
+	}
+	if latest == nil {
+		return nil, ErrUnknownUser
+	}
+	return d.Lookup(userID, latest.Provider)
+}
+
+// latestIdentity returns the account's most recently used identity from
+// the provider, or nil if none is linked
+func latestIdentity(account *models.Account, provider string) *models.LinkedIdentity {
+	var latest *models.LinkedIdentity
+	for i := range account.Identities {
+		identity := &account.Identities[i]
+		if identity.Provider != provider {
+			continue
+		}
+		if latest == nil || identity.LastLoginAt.After(latest.LastLoginAt) {
+			latest = identity
+		}
+	}
+	return latest
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+func TestUserDirectory_Lookup(t *testing.T) {
+	local, localStore := newTestLocalAccountService(t)
+	localStore.Save(&models.LocalAccount{ID: "local-1", Username: "vendor", Email: "vendor@example.com", Name: "Vendor", Roles: []string{"viewer"}})
+
+	cfg := &config.Config{MagicLinkRoles: []string{"viewer"}}
+	d := NewUserDirectory(cfg, nil, local)
+
+	tests := []struct {
+		name      string
+		userID    string
+		provider  string
+		wantEmail string
+		wantRoles []string
+		wantErr   error
+	}{
+		{"Provider user gets the default role", "123", "google", "", []string{"user"}, nil},
+		{"Local account keeps its current roles", "local-1", "local", "vendor@example.com", []string{"viewer"}, nil},
+		{"Deleted local account", "local-2", "local", "", nil, ErrUnknownUser},
+		{"Magic link address", "jane@example.com", MagicLinkProvider, "jane@example.com", []string{"viewer"}, nil},
+		{"No provider recorded", "123", "", "", nil, ErrUnknownUser},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := d.Lookup(tt.userID, tt.provider)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Lookup() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if user.ID != tt.userID || user.Provider != tt.provider || user.Email != tt.wantEmail || !slices.Equal(user.Roles, tt.wantRoles) {
+				t.Errorf("Lookup() = %+v, want %s/%s with email %q and roles %v", user, tt.provider, tt.userID, tt.wantEmail, tt.wantRoles)
+			}
+		})
+	}
+}
+
+func TestUserDirectory_Find(t *testing.T) {
+	local, localStore := newTestLocalAccountService(t)
+	localStore.Save(&models.LocalAccount{ID: "local-1", Username: "vendor", Email: "vendor@example.com", Name: "Vendor", Roles: []string{"viewer"}})
+
+	// Without account linking only local accounts are known
+	d := NewUserDirectory(&config.Config{}, nil, local)
+	user, err := d.Find("local-1")
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if user.Email != "vendor@example.com" || user.Name != "Vendor" || user.Provider != "local" {
+		t.Errorf("Find() = %+v, want the local account's profile", user)
+	}
+	if _, err := d.Find("123"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("Find() of a provider user error = %v, want %v", err, ErrUnknownUser)
+	}
+
+	accounts := newTestAccountService()
+	d = NewUserDirectory(&config.Config{}, accounts, local)
+	resolved, err := accounts.Resolve(testProviderUser("google", "g-1", "jane@example.com", true))
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	user, err = d.Find(resolved.ID)
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if user.ID != resolved.ID || user.Email != "jane@example.com" || user.Provider != "google" {
+		t.Errorf("Find() = %+v, want the account with its Google identity", user)
+	}
+	if _, err := d.Find("no-such-account"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("Find() of a missing account error = %v, want %v", err, ErrUnknownUser)
+	}
+}
+
+func TestUserDirectory_LookupAccount(t *testing.T) {
+	accounts := newTestAccountService()
+	d := NewUserDirectory(&config.Config{}, accounts, nil)
+
+	resolved, err := accounts.Resolve(testProviderUser("google", "g-1", "jane@example.com", true))
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	user, err := d.Lookup(resolved.ID, "google")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if user.ID != resolved.ID || user.Email != "jane@example.com" || !user.EmailVerified || !slices.Equal(user.Roles, []string{"user"}) {
+		t.Errorf("Lookup() = %+v, want the account with the identity's verified email and the default role", user)
+	}
+
+	// The identity must still be linked to the account
+	if _, err := d.Lookup(resolved.ID, "okta"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("Lookup() with an unlinked provider error = %v, want %v", err, ErrUnknownUser)
+	}
+	if _, err := d.Lookup("no-such-account", "google"); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("Lookup() of a missing account error = %v, want %v", err, ErrUnknownUser)
+	}
+}
+
+func TestUserDirectory_LookupProfile(t *testing.T) {
+	local, localStore := newTestLocalAccountService(t)
+	localStore.Save(&models.LocalAccount{ID: "local-1", Username: "vendor", Email: "vendor@example.com", Roles: []string{"viewer"}})
+	d := NewUserDirectory(&config.Config{}, nil, local)
+
+	tests := []struct {
+		name      string
+		userID    string
+		provider  string
+		profile   *models.User
+		wantEmail string
+		wantRoles []string
+	}{
+		{"SAML user keeps the recorded roles", "jane", "saml", &models.User{Provider: "saml", Email: "jane@example.com", Roles: []string{"admin"}}, "jane@example.com", []string{"admin"}},
+		{"No recorded profile", "jane", "saml", nil, "", []string{"user"}},
+		{"Profile from another provider", "jane", "saml", &models.User{Provider: "google", Roles: []string{"admin"}}, "", []string{"user"}},
+		{"Local account keeps its current roles", "local-1", "local", &models.User{Provider: "local", Roles: []string{"admin"}}, "vendor@example.com", []string{"viewer"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := d.LookupProfile(tt.userID, tt.provider, tt.profile)
+			if err != nil {
+				t.Fatalf("LookupProfile() error = %v", err)
+			}
+			if user.Email != tt.wantEmail || !slices.Equal(user.Roles, tt.wantRoles) {
+				t.Errorf("LookupProfile() = %+v, want email %q and roles %v", user, tt.wantEmail, tt.wantRoles)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// maxJustificationLength bounds the justification stored with a request
+const maxJustificationLength = 1000
+
+var (
+	// ErrInvalidElevation is wrapped by errors for requests that are not
+	// allowed, such as for a role that cannot be elevated to
+	ErrInvalidElevation = errors.New("invalid elevation request")
+	// ErrNotApprover is returned when the user may not decide requests
+	ErrNotApprover = errors.New("not an elevation approver")
+	// ErrSelfApproval is returned when approvers decide their own request
+	ErrSelfApproval = errors.New("cannot decide your own elevation request")
+	// ErrElevationDecided is returned when deciding a request that was
+	// already decided or has lapsed
+	ErrElevationDecided = errors.New("elevation request is no longer pending")
+)
+
+// ElevationService grants roles for a limited time. A user requests a role
+// with a justification, an approver approves or denies it, and while the
+// approval lasts the role is added to tokens issued to the user. Every
+// step is recorded in the audit trail.
+type ElevationService struct {
+	config *config.Config
+	store  store.ElevationStore
+	audit  *Audit
+
+	// mu serializes decisions, so a request is decided only once
+	mu sync.Mutex
+}
+
+// NewElevationService creates a new elevation service
+func NewElevationService(cfg *config.Config, elevationStore store.ElevationStore, audit *Audit) *ElevationService {
+	return &ElevationService{
+		config: cfg,
+		store:  elevationStore,
+		audit:  audit,
+	}
+}
+
+// Request files a request by the user to hold the role for the duration
+func (s *ElevationService) Request(user *models.User, role string, duration time.Duration, justification string) (*models.Elevation, error) {
+	justification = strings.TrimSpace(justification)
+	switch {
+	case !containsString(s.config.ElevationRoles, role):
+		return nil, fmt.Errorf("%w: role %q cannot be requested", ErrInvalidElevation, role)
+	case duration <= 0 || duration > s.config.ElevationMaxDuration:
+		return nil, fmt.Errorf("%w: duration must be positive and at most %s", ErrInvalidElevation, s.config.ElevationMaxDuration)
+	case justification == "":
+		return nil, fmt.Errorf("%w: a justification is required", ErrInvalidElevation)
+	case len(justification) > maxJustificationLength:
+		return nil, fmt.Errorf("%w: justification is longer than %d characters", ErrInvalidElevation, maxJustificationLength)
+	}
+
+	existing, err := s.store.ListByUser(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, elevation := range existing {
+		if elevation.Role == role && s.pending(elevation, now) {
+			return nil, fmt.Errorf("%w: a request for %s is already pending", ErrInvalidElevation, role)
+		}
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	elevation := &models.Elevation{
+		ID:              id,
+		UserID:          user.ID,
+		Email:           user.Email,
+		Role:            role,
+		DurationSeconds: int(duration.Seconds()),
+		Justification:   justification,
+		Status:          models.ElevationPending,
+		RequestedAt:     now,
+	}
+	if err := s.store.Save(elevation); err != nil {
+		return nil, err
+	}
+
+	err = s.audit.Record(models.AuditElevationRequested, user.ID, "", map[string]string{
+		"elevation_id":  elevation.ID,
+		"role":          role,
+		"duration":      duration.String(),
+		"justification": justification,
+	})
+	return elevation, err
+}
+
+// Approve grants the request. The role is held from now for the requested
+// duration.
+func (s *ElevationService) Approve(approver *models.User, id, reason string) (*models.Elevation, error) {
+	return s.decide(approver, id, reason, models.ElevationApproved)
+}
+
+// Deny rejects the request
+func (s *ElevationService) Deny(approver *models.User, id, reason string) (*models.Elevation, error) {
+	return s.decide(approver, id, reason, models.ElevationDenied)
+}
+
+func (s *ElevationService) decide(approver *models.User, id, reason string, status models.ElevationStatus) (*models.Elevation, error) {
+	allowed, err := s.CanApprove(approver)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrNotApprover
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elevation, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if elevation.UserID == approver.ID {
+		return nil, ErrSelfApproval
+	}
+	now := time.Now()
+	if !s.pending(elevation, now) {
+		return nil, ErrElevationDecided
+	}
+
+	elevation.Status = status
+	elevation.DecidedBy = approver.ID
+	elevation.DecidedAt = now
+	elevation.DecisionReason = strings.TrimSpace(reason)
+	action := models.AuditElevationDenied
+	details := map[string]string{
+		"elevation_id": elevation.ID,
+		"role":         elevation.Role,
+	}
+	if status == models.ElevationApproved {
+		elevation.ExpiresAt = now.Add(time.Duration(elevation.DurationSeconds) * time.Second)
+		action = models.AuditElevationApproved
+		details["expires_at"] = elevation.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if elevation.DecisionReason != "" {
+		details["reason"] = elevation.DecisionReason
+	}
+	if err := s.store.Save(elevation); err != nil {
+		return nil, err
+	}
+
+	return elevation, s.audit.Record(action, approver.ID, elevation.UserID, details)
+}
+
+// CanApprove reports whether the user may decide elevation requests, either
+// as a listed approver or through an approver role. A role the user has an
+// active elevation for does not count, so elevated users cannot approve
+// each other.
+func (s *ElevationService) CanApprove(user *models.User) (bool, error) {
+	if containsString(s.config.ElevationApprovers, user.ID) {
+		return true, nil
+	}
+	active, err := s.active(user.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range user.Roles {
+		if !containsString(s.config.ElevationApproverRoles, role) {
+			continue
+		}
+		elevated := false
+		for _, elevation := range active {
+			elevated = elevated || elevation.Role == role
+		}
+		if !elevated {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ListByUser returns the user's requests, newest first
+func (s *ElevationService) ListByUser(userID string) ([]*models.Elevation, error) {
+	return s.store.ListByUser(userID)
+}
+
+// ListPending returns the requests awaiting a decision, oldest first
+func (s *ElevationService) ListPending() ([]*models.Elevation, error) {
+	elevations, err := s.store.ListByStatus(models.ElevationPending)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	pending := elevations[:0]
+	for _, elevation := range elevations {
+		if s.pending(elevation, now) {
+			pending = append(pending, elevation)
+		}
+	}
+	return pending, nil
+}
+
+// Elevate returns the user with the roles of their active elevations added
+// as time-bound roles, for a token about to be issued. Elevations for roles
+// the user already holds are skipped, so they do not cut tokens short.
+func (s *ElevationService) Elevate(user *models.User) (*models.User, error) {
+	active, err := s.active(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	elevated := *user
+	for _, elevation := range active {
+		if !elevated.AddTimedRole(elevation.Role, elevation.ExpiresAt) {
+			continue
+		}
+		err := s.audit.Record(models.AuditElevationUsed, user.ID, "", map[string]string{
+			"elevation_id": elevation.ID,
+			"role":         elevation.Role,
+			"expires_at":   elevation.ExpiresAt.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &elevated, nil
+}
+
+// active returns the user's approved, unexpired elevations
+func (s *ElevationService) active(userID string) ([]*models.Elevation, error) {
+	elevations, err := s.store.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var active []*models.Elevation
+	for _, elevation := range elevations {
+		if elevation.Active(now) {
+			active = append(active, elevation)
+		}
+	}
+	return active, nil
+}
+
+// pending reports whether the request awaits a decision and has not lapsed
+func (s *ElevationService) pending(elevation *models.Elevation, now time.Time) bool {
+	return elevation.Status == models.ElevationPending &&
+		now.Before(elevation.RequestedAt.Add(s.config.ElevationRequestTTL))
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+func newTestElevationService() (*ElevationService, *Audit) {
+	audit := NewAudit(store.NewMemoryAuditStore())
+	return NewElevationService(&config.Config{
+		ElevationRoles:         []string{"admin"},
+		ElevationMaxDuration:   4 * time.Hour,
+		ElevationRequestTTL:    24 * time.Hour,
+		ElevationApproverRoles: []string{"approver"},
+		ElevationApprovers:     []string{"security-lead"},
+	}, store.NewMemoryElevationStore(), audit), audit
+}
+
+func TestElevationService_Request(t *testing.T) {
+	s, _ := newTestElevationService()
+	jane := &models.User{ID: "jane", Roles: []string{"user"}}
+
+	tests := []struct {
+		name          string
+		role          string
+		duration      time.Duration
+		justification string
+		wantErr       bool
+	}{
+		{"Valid", "admin", time.Hour, "INC-1234 database failover", false},
+		{"Role not elevatable", "superuser", time.Hour, "INC-1234", true},
+		{"Too long", "admin", 5 * time.Hour, "INC-1234", true},
+		{"No duration", "admin", 0, "INC-1234", true},
+		{"No justification", "admin", time.Hour, "  ", true},
+		{"Already pending", "admin", time.Hour, "INC-1234 again", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elevation, err := s.Request(jane, tt.role, tt.duration, tt.justification)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidElevation) {
+					t.Errorf("Request() error = %v, want %v", err, ErrInvalidElevation)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Request() error = %v", err)
+			}
+			if elevation.Status != models.ElevationPending || elevation.DurationSeconds != 3600 {
+				t.Errorf("Request() = %+v, want a pending request for 3600 seconds", elevation)
+			}
+		})
+	}
+}
+
+func TestElevationService_Approve(t *testing.T) {
+	s, audit := newTestElevationService()
+	jane := &models.User{ID: "jane", Roles: []string{"user"}}
+	approver := &models.User{ID: "bob", Roles: []string{"approver"}}
+
+	// Nothing is elevated before approval
+	elevated, _ := s.Elevate(jane)
+	if elevated.HasRole("admin") || !elevated.RolesExpireAt.IsZero() {
+		t.Errorf("Elevate() before approval = %v until %v, want no elevation", elevated.Roles, elevated.RolesExpireAt)
+	}
+
+	elevation, _ := s.Request(jane, "admin", time.Hour, "INC-1234")
+	if _, err := s.Approve(jane, elevation.ID, ""); !errors.Is(err, ErrNotApprover) {
+		t.Errorf("Approve() by requester error = %v, want %v", err, ErrNotApprover)
+	}
+	if _, err := s.Approve(&models.User{ID: "jane", Roles: []string{"approver"}}, elevation.ID, ""); !errors.Is(err, ErrSelfApproval) {
+		t.Errorf("Approve() of own request error = %v, want %v", err, ErrSelfApproval)
+	}
+
+	approved, err := s.Approve(approver, elevation.ID, "on call")
+	if err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	if approved.Status != models.ElevationApproved || approved.DecidedBy != "bob" {
+		t.Errorf("Approve() = %+v, want approved by bob", approved)
+	}
+	if _, err := s.Deny(&models.User{ID: "security-lead"}, elevation.ID, ""); !errors.Is(err, ErrElevationDecided) {
+		t.Errorf("Deny() after approval error = %v, want %v", err, ErrElevationDecided)
+	}
+
+	elevated, err = s.Elevate(jane)
+	if err != nil {
+		t.Fatalf("Elevate() error = %v", err)
+	}
+	if !elevated.HasRole("admin") || !elevated.HasRole("user") || !elevated.RolesExpireAt.Equal(approved.ExpiresAt) {
+		t.Errorf("Elevate() = %v until %v, want admin added until %v", elevated.Roles, elevated.RolesExpireAt, approved.ExpiresAt)
+	}
+	if jane.HasRole("admin") {
+		t.Error("Elevate() modified the user passed in")
+	}
+
+	// A role held through an elevation does not make an approver
+	if ok, _ := s.CanApprove(&models.User{ID: "jane", Roles: []string{"admin"}}); ok {
+		t.Error("CanApprove() = true for an elevated role, want false")
+	}
+
+	events, _ := audit.List("jane", "")
+	var actions []string
+	for _, event := range events {
+		actions = append(actions, event.Action)
+	}
+	want := []string{models.AuditElevationRequested, models.AuditElevationApproved, models.AuditElevationUsed}
+	if len(actions) != len(want) {
+		t.Fatalf("audit actions = %v, want %v", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Errorf("audit actions = %v, want %v", actions, want)
+			break
+		}
+	}
+}
+
+func TestElevationService_Expiry(t *testing.T) {
+	s, _ := newTestElevationService()
+	jane := &models.User{ID: "jane", Roles: []string{"user"}}
+
+	// An approval that has run out no longer elevates
+	expired := &models.Elevation{
+		ID:          "expired",
+		UserID:      "jane",
+		Role:        "admin",
+		Status:      models.ElevationApproved,
+		RequestedAt: time.Now().Add(-3 * time.Hour),
+		ExpiresAt:   time.Now().Add(-time.Hour),
+	}
+	s.store.Save(expired)
+	if elevated, _ := s.Elevate(jane); elevated.HasRole("admin") {
+		t.Error("Elevate() with an expired approval added admin")
+	}
+
+	// Requests left undecided lapse
+	lapsed := &models.Elevation{
+		ID:              "lapsed",
+		UserID:          "jane",
+		Role:            "admin",
+		DurationSeconds: 3600,
+		Status:          models.ElevationPending,
+		RequestedAt:     time.Now().Add(-25 * time.Hour),
+	}
+	s.store.Save(lapsed)
+	if _, err := s.Approve(&models.User{ID: "bob", Roles: []string{"approver"}}, "lapsed", ""); !errors.Is(err, ErrElevationDecided) {
+		t.Errorf("Approve() of a lapsed request error = %v, want %v", err, ErrElevationDecided)
+	}
+	if pending, _ := s.ListPending(); len(pending) != 0 {
+		t.Errorf("ListPending() = %d requests, want 0", len(pending))
+	}
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrInvalidIDToken is wrapped by errors for provider ID tokens that are
+	// missing or fail verification
+	ErrInvalidIDToken = errors.New("invalid ID token")
+	// ErrIDTokenNonce is returned when the ID token's nonce is not the one
+	// sent with the login, as with a replayed or injected token
+	ErrIDTokenNonce = errors.New("ID token nonce does not match the login")
+	// ErrUserInfoSubject is returned when the userinfo response describes
+	// another user than the ID token
+	ErrUserInfoSubject = errors.New("userinfo subject does not match the ID token")
+)
+
+// UpstreamIDToken holds the verified claims of the provider's ID token
+type UpstreamIDToken struct {
+	Nonce     string `json:"nonce,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	AuthTime  int64  `json:"auth_time,omitempty"`
+	ObjectID  string `json:"oid,omitempty"` // Azure AD object ID
+	UpstreamClaims
+	jwt.RegisteredClaims
+}
+
+// VerifyIDToken checks the provider's ID token from the token response: its
+// signature against the provider's JWKS, issuer, audience and expiry, and
+// that its nonce is the one sent with this login
+func (s *OAuthService) VerifyIDToken(rawIDToken, nonce string) (*UpstreamIDToken, error) {
+	if rawIDToken == "" {
+		return nil, fmt.Errorf("%w: the provider returned no ID token", ErrInvalidIDToken)
+	}
+
+	claims := &UpstreamIDToken{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.keys.Key(kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithAudience(s.config.OAuthClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if issuer := expectedIssuer(s.config, claims.TenantID); issuer == "" || claims.Issuer != issuer {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.Issuer)
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrIDTokenNonce
+	}
+	return claims, nil
+}
+
+// CheckUserInfo checks that the user read from the userinfo endpoint is the
+// subject of the ID token before the two are used together (OIDC Core
+// 5.3.2). Microsoft Graph reports the object ID rather than the pairwise
+// sub, so Azure users are matched on the oid claim.
+func (t *UpstreamIDToken) CheckUserInfo(provider string, user *models.User) error {
+	subject := t.Subject
+	if provider == "azure" {
+		subject = t.ObjectID
+	}
+	if subject == "" || subtle.ConstantTimeCompare([]byte(user.ID), []byte(subject)) != 1 {
+		return fmt.Errorf("%w: %q is not %q", ErrUserInfoSubject, user.ID, subject)
+	}
+	return nil
+}
+
+// expectedIssuer returns the issuer a provider token with the tenant ID
+// tid must have. Azure's multi-tenant endpoints issue tokens from the
+// user's own tenant, so there the tenant in the configured issuer is
+// replaced by the token's tid.
+func expectedIssuer(cfg *config.Config, tenantID string) string {
+	issuer := cfg.OAuthIssuer
+	if cfg.OAuthProvider != "azure" || tenantID == "" {
+		return issuer
+	}
+	for _, tenant := range []string{"common", "organizations", "consumers"} {
+		if prefix, ok := strings.CutSuffix(issuer, "/"+tenant+"/v2.0"); ok {
+			return prefix + "/" + tenantID + "/v2.0"
+		}
+	}
+	return issuer
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newTestIDTokenService serves a JWKS for a fresh provider key and returns
+// an OAuthService trusting it, along with the key for signing ID tokens
+func newTestIDTokenService(t *testing.T, cfg *config.Config) (*OAuthService, *utils.SigningKey) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	key := utils.NewSigningKey(privateKey)
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []utils.JWK{key.JWK()}})
+	}))
+	t.Cleanup(jwks.Close)
+
+	cfg.OAuthClientID = "gateway"
+	cfg.OAuthJWKSURL = jwks.URL
+	return NewOAuthService(cfg), key
+}
+
+func idTokenClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   testProviderIssuer,
+		"aud":   "gateway",
+		"sub":   "123",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"nonce": nonce,
+	}
+}
+
+func TestOAuthService_VerifyIDToken(t *testing.T) {
+	s, key := newTestIDTokenService(t, &config.Config{OAuthProvider: "google", OAuthIssuer: testProviderIssuer})
+
+	forged, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name    string
+		modify  func(jwt.MapClaims)
+		nonce   string
+		sign    func(jwt.MapClaims) string
+		wantErr error
+	}{
+		{name: "Valid", nonce: "n-1"},
+		{name: "Wrong nonce", nonce: "n-2", wantErr: ErrIDTokenNonce},
+		{name: "Missing nonce", nonce: "n-1", modify: func(c jwt.MapClaims) { delete(c, "nonce") }, wantErr: ErrIDTokenNonce},
+		{name: "Empty login nonce", nonce: "", modify: func(c jwt.MapClaims) { c["nonce"] = "" }, wantErr: ErrIDTokenNonce},
+		{name: "Wrong issuer", nonce: "n-1", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, wantErr: ErrInvalidIDToken},
+		{name: "Wrong audience", nonce: "n-1", modify: func(c jwt.MapClaims) { c["aud"] = "other" }, wantErr: ErrInvalidIDToken},
+		{name: "Expired", nonce: "n-1", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: ErrInvalidIDToken},
+		{name: "No expiry", nonce: "n-1", modify: func(c jwt.MapClaims) { delete(c, "exp") }, wantErr: ErrInvalidIDToken},
+		{name: "Unknown key", nonce: "n-1", sign: func(c jwt.MapClaims) string {
+			raw, _ := utils.NewSigningKey(forged).Sign(c)
+			return raw
+		}, wantErr: ErrInvalidIDToken},
+		{name: "HMAC", nonce: "n-1", sign: func(c jwt.MapClaims) string {
+			raw, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte("gateway"))
+			return raw
+		}, wantErr: ErrInvalidIDToken},
+		{name: "Empty", nonce: "n-1", sign: func(jwt.MapClaims) string { return "" }, wantErr: ErrInvalidIDToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := idTokenClaims("n-1")
+			if tt.modify != nil {
+				tt.modify(claims)
+			}
+			var raw string
+			if tt.sign != nil {
+				raw = tt.sign(claims)
+			} else {
+				var err error
+				if raw, err = key.Sign(claims); err != nil {
+					t.Fatalf("Sign() error = %v", err)
+				}
+			}
+
+			idToken, err := s.VerifyIDToken(raw, tt.nonce)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyIDToken() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && idToken.Subject != "123" {
+				t.Errorf("VerifyIDToken() sub = %q, want %q", idToken.Subject, "123")
+			}
+		})
+	}
+}
+
+func TestOAuthService_VerifyIDTokenAzureTenant(t *testing.T) {
+	s, key := newTestIDTokenService(t, &config.Config{
+		OAuthProvider: "azure",
+		OAuthIssuer:   "https://login.microsoftonline.com/common/v2.0",
+	})
+
+	claims := idTokenClaims("n-1")
+	claims["iss"] = "https://login.microsoftonline.com/tenant-a/v2.0"
+	claims["tid"] = "tenant-a"
+	raw, _ := key.Sign(claims)
+	idToken, err := s.VerifyIDToken(raw, "n-1")
+	if err != nil {
+		t.Fatalf("VerifyIDToken() error = %v", err)
+	}
+	if idToken.TenantID != "tenant-a" {
+		t.Errorf("VerifyIDToken() tid = %q, want %q", idToken.TenantID, "tenant-a")
+	}
+
+	// The issuer must name the tenant the token claims to be from
+	claims["tid"] = "tenant-b"
+	raw, _ = key.Sign(claims)
+	if _, err := s.VerifyIDToken(raw, "n-1"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("VerifyIDToken() with mismatched tid error = %v, want %v", err, ErrInvalidIDToken)
+	}
+}
+
+func TestUpstreamIDToken_CheckUserInfo(t *testing.T) {
+	idToken := &UpstreamIDToken{ObjectID: "oid-1", RegisteredClaims: jwt.RegisteredClaims{Subject: "123"}}
+
+	tests := []struct {
+		name     string
+		provider string
+		userID   string
+		wantErr  error
+	}{
+		{"Same subject", "okta", "123", nil},
+		{"Other subject", "okta", "456", ErrUserInfoSubject},
+		{"Missing subject", "google", "", ErrUserInfoSubject},
+		{"Azure object ID", "azure", "oid-1", nil},
+		{"Azure pairwise sub", "azure", "123", ErrUserInfoSubject},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := idToken.CheckUserInfo(tt.provider, &models.User{ID: tt.userID})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckUserInfo() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
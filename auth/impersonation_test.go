@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+func newTestImpersonationService() (*ImpersonationService, *Audit) {
+	audit := NewAudit(store.NewMemoryAuditStore())
+	return NewImpersonationService(&config.Config{
+		JWTSecret:             "test-secret",
+		ImpersonationRoles:    []string{"viewer"},
+		ImpersonationTokenTTL: 15 * time.Minute,
+	}, audit), audit
+}
+
+func testAdminClaims(ttl time.Duration) *utils.Claims {
+	claims := utils.NewClaims(&models.User{ID: "admin-1", Roles: []string{"admin"}}, ttl)
+	claims.SessionID = "session-1"
+	claims.AMR = []string{"fed", "otp", "mfa"}
+	claims.ACR = ACRMFA
+	return claims
+}
+
+func TestImpersonationService_Impersonate(t *testing.T) {
+	s, audit := newTestImpersonationService()
+	target := &models.User{ID: "jane", Email: "jane@example.com", Roles: []string{"admin"}}
+
+	token, _, err := s.Impersonate(testAdminClaims(time.Hour), target, "SUP-42 reproduce checkout error")
+	if err != nil {
+		t.Fatalf("Impersonate() error = %v", err)
+	}
+	claims, err := utils.ValidateJWT(token, "test-secret")
+	if err != nil {
+		t.Fatalf("ValidateJWT() error = %v", err)
+	}
+	if claims.UserID != "jane" || claims.Act == nil || claims.Act.Subject != "admin-1" {
+		t.Errorf("Impersonate() sub = %s, act = %+v, want jane acted on by admin-1", claims.UserID, claims.Act)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != "viewer" {
+		t.Errorf("Impersonate() roles = %v, want only the impersonation roles", claims.Roles)
+	}
+	if claims.SessionID != "session-1" || claims.ACR != ACRMFA {
+		t.Errorf("Impersonate() sid = %s, acr = %s, want the admin's session and login", claims.SessionID, claims.ACR)
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time); ttl > 15*time.Minute {
+		t.Errorf("Impersonate() lifetime = %v, want at most 15m", ttl)
+	}
+
+	events, _ := audit.List("jane", models.AuditImpersonationStarted)
+	if len(events) != 1 || events[0].Actor != "admin-1" || events[0].Details["reason"] != "SUP-42 reproduce checkout error" {
+		t.Errorf("audit events = %+v, want one impersonation by admin-1 with the reason", events)
+	}
+}
+
+func TestImpersonationService_CappedByAdminToken(t *testing.T) {
+	s, _ := newTestImpersonationService()
+	admin := testAdminClaims(5 * time.Minute)
+
+	_, claims, err := s.Impersonate(admin, &models.User{ID: "jane"}, "SUP-42")
+	if err != nil {
+		t.Fatalf("Impersonate() error = %v", err)
+	}
+	if claims.ExpiresAt.After(admin.ExpiresAt.Time) {
+		t.Errorf("Impersonate() expires at %v, want no later than the admin token at %v", claims.ExpiresAt, admin.ExpiresAt)
+	}
+}
+
+func TestImpersonationService_Invalid(t *testing.T) {
+	s, _ := newTestImpersonationService()
+	impersonating := testAdminClaims(time.Hour)
+	impersonating.Act = &utils.Actor{Subject: "admin-2"}
+
+	tests := []struct {
+		name   string
+		admin  *utils.Claims
+		target string
+		reason string
+	}{
+		{"No reason", testAdminClaims(time.Hour), "jane", " "},
+		{"No user", testAdminClaims(time.Hour), "", "SUP-42"},
+		{"Self", testAdminClaims(time.Hour), "admin-1", "SUP-42"},
+		{"Already impersonating", impersonating, "jane", "SUP-42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := s.Impersonate(tt.admin, &models.User{ID: tt.target}, tt.reason)
+			if !errors.Is(err, ErrInvalidImpersonation) {
+				t.Errorf("Impersonate() error = %v, want %v", err, ErrInvalidImpersonation)
+			}
+		})
+	}
+}
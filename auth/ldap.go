@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPService authenticates users against an LDAP directory or Active
+// Directory by search-then-bind: a service account looks up the user's
+// entry, and the password is checked by binding as that entry
+type LDAPService struct {
+	config   *config.Config
+	throttle *passwordThrottle
+
+	// groupRoles are the configured group DNs, parsed for comparison
+	groupRoles []ldapGroupRole
+}
+
+type ldapGroupRole struct {
+	role string
+	dn   *ldap.DN
+}
+
+// NewLDAPService creates an LDAP service from the LDAP_* settings
+func NewLDAPService(cfg *config.Config) (*LDAPService, error) {
+	if !strings.Contains(cfg.LDAPUserFilter, "{username}") {
+		return nil, errors.New("LDAP user filter must contain {username}")
+	}
+
+	groupRoles := make([]ldapGroupRole, 0, len(cfg.LDAPGroupRoles))
+	for _, mapping := range cfg.LDAPGroupRoles {
+		dn, err := ldap.ParseDN(mapping.GroupDN)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LDAP group DN for role %s: %w", mapping.Role, err)
+		}
+		groupRoles = append(groupRoles, ldapGroupRole{role: mapping.Role, dn: dn})
+	}
+
+	return &LDAPService{
+		config:     cfg,
+		throttle:   newPasswordThrottle(cfg.PasswordMaxAttempts, cfg.PasswordIPMaxFailures, cfg.PasswordAttemptWindow),
+		groupRoles: groupRoles,
+	}, nil
+}
+
+// Authenticate checks a username and password against the directory, tried
+// from the client IP, and returns the user with roles mapped from their
+// groups. Too many attempts for the username or failures from the IP return
+// ErrTooManyAttempts without contacting the directory.
+func (s *LDAPService) Authenticate(username, password, ip string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	// Directories match usernames case-insensitively
+	key := strings.ToLower(username)
+	now := time.Now()
+	if !s.throttle.Take(key, ip, now) {
+		return nil, ErrTooManyAttempts
+	}
+	user, err := s.authenticate(username, password)
+	s.throttle.Done(key, ip, err, now)
+	return user, err
+}
+
+func (s *LDAPService) authenticate(username, password string) (*models.User, error) {
+	// An empty password would be an unauthenticated bind, which many
+	// directories accept for any DN
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	entry, err := s.findUser(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP bind failed: %w", err)
+	}
+
+	return s.userFromEntry(entry)
+}
+
+func (s *LDAPService) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(s.config.LDAPURL, ldap.DialWithDialer(&net.Dialer{Timeout: s.config.LDAPTimeout}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+	conn.SetTimeout(s.config.LDAPTimeout)
+
+	if s.config.LDAPStartTLS {
+		serverURL, err := url.Parse(s.config.LDAPURL)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("invalid LDAP URL: %w", err)
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: serverURL.Hostname()}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("LDAP StartTLS failed: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// findUser binds as the service account and searches for the user's entry
+func (s *LDAPService) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	if s.config.LDAPBindDN != "" {
+		if err := conn.Bind(s.config.LDAPBindDN, s.config.LDAPBindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP service account bind failed: %w", err)
+		}
+	}
+
+	filter := strings.ReplaceAll(s.config.LDAPUserFilter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		s.config.LDAPBaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, // one more than needed, to detect ambiguous filters
+		int(s.config.LDAPTimeout/time.Second),
+		false,
+		filter,
+		[]string{
+			s.config.LDAPIDAttribute,
+			s.config.LDAPEmailAttribute,
+			s.config.LDAPNameAttribute,
+			s.config.LDAPGroupAttribute,
+		},
+		nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("LDAP user filter matched more than one entry for %q", username)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LDAP search failed: %w", err)
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return nil, ErrInvalidCredentials
+	case 1:
+		return result.Entries[0], nil
+	default:
+		return nil, fmt.Errorf("LDAP user filter matched more than one entry for %q", username)
+	}
+}
+
+// userFromEntry maps directory attributes and group memberships to a user
+func (s *LDAPService) userFromEntry(entry *ldap.Entry) (*models.User, error) {
+	id := ldapAttributeString(entry.GetRawAttributeValue(s.config.LDAPIDAttribute))
+	if id == "" {
+		return nil, fmt.Errorf("LDAP entry %s has no %s attribute", entry.DN, s.config.LDAPIDAttribute)
+	}
+
+	user := &models.User{
+		ID:       id,
+		Email:    entry.GetAttributeValue(s.config.LDAPEmailAttribute),
+		Name:     entry.GetAttributeValue(s.config.LDAPNameAttribute),
+		Provider: "ldap",
+		Created:  time.Now(),
+	}
+
+	for _, group := range entry.GetAttributeValues(s.config.LDAPGroupAttribute) {
+		groupDN, err := ldap.ParseDN(group)
+		if err != nil {
+			continue
+		}
+		for _, mapping := range s.groupRoles {
+			if groupDN.EqualFold(mapping.dn) && !containsString(user.Roles, mapping.role) {
+				user.Roles = append(user.Roles, mapping.role)
+			}
+		}
+	}
+
+	// Assign default role if no groups mapped to a role
+	if len(user.Roles) == 0 {
+		user.Roles = []string{string(models.RoleUser)}
+	}
+	return user, nil
+}
+
+// ldapAttributeString returns a text attribute as is and a binary one, such
+// as objectGUID, hex-encoded
+func ldapAttributeString(value []byte) string {
+	if !utf8.Valid(value) {
+		return hex.EncodeToString(value)
+	}
+	for _, r := range string(value) {
+		if !unicode.IsPrint(r) {
+			return hex.EncodeToString(value)
+		}
+	}
+	return string(value)
+}
@@ -0,0 +1,284 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testLDAPServiceDN       = "CN=svc-iag,OU=Service Accounts,DC=corp,DC=example,DC=com"
+	testLDAPServicePassword = "service-secret"
+)
+
+type testLDAPEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// testLDAPServer is an in-process LDAP server answering simple binds and
+// searches by sAMAccountName, including the (sAMAccountName=*) presence
+// filter an unescaped "*" would produce
+type testLDAPServer struct {
+	listener net.Listener
+	entries  []testLDAPEntry
+
+	mu    sync.Mutex
+	binds []string
+}
+
+func newTestLDAPServer(t *testing.T, entries ...testLDAPEntry) *testLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen() error = %v", err)
+	}
+	s := &testLDAPServer{listener: listener, entries: entries}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *testLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+// Binds returns the DNs of all bind attempts
+func (s *testLDAPServer) Binds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.binds)
+}
+
+func (s *testLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	bound := ""
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			s.mu.Lock()
+			s.binds = append(s.binds, dn)
+			s.mu.Unlock()
+
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if s.checkPassword(dn, password) {
+				code, bound = ldap.LDAPResultSuccess, dn
+			}
+			s.reply(conn, messageID, testLDAPResult(ldap.ApplicationBindResponse, code))
+
+		case ldap.ApplicationSearchRequest:
+			if bound != testLDAPServiceDN {
+				s.reply(conn, messageID, testLDAPResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				continue
+			}
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			matchAll := strings.Contains(filter, "(sAMAccountName=*)")
+			for _, entry := range s.entries {
+				if matchAll || strings.Contains(filter, "(sAMAccountName="+ldap.EscapeFilter(entry.attributes["sAMAccountName"][0])+")") {
+					s.reply(conn, messageID, testLDAPSearchEntry(entry))
+				}
+			}
+			s.reply(conn, messageID, testLDAPResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *testLDAPServer) checkPassword(dn, password string) bool {
+	if password == "" {
+		return false
+	}
+	if dn == testLDAPServiceDN {
+		return password == testLDAPServicePassword
+	}
+	for _, entry := range s.entries {
+		if entry.dn == dn {
+			return entry.password == password
+		}
+	}
+	return false
+}
+
+func (s *testLDAPServer) reply(conn net.Conn, messageID int64, op *ber.Packet) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	envelope.AppendChild(op)
+	conn.Write(envelope.Bytes())
+}
+
+func testLDAPResult(tag ber.Tag, code uint16) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "LDAPResult")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return result
+}
+
+func testLDAPSearchEntry(entry testLDAPEntry) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "SearchResultEntry")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "objectName"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, values := range entry.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	packet.AppendChild(attributes)
+	return packet
+}
+
+func newTestLDAPService(t *testing.T, server *testLDAPServer, servicePassword string) *LDAPService {
+	s, err := NewLDAPService(&config.Config{
+		LDAPURL:            server.URL(),
+		LDAPBindDN:         testLDAPServiceDN,
+		LDAPBindPassword:   servicePassword,
+		LDAPBaseDN:         "DC=corp,DC=example,DC=com",
+		LDAPUserFilter:     "(&(objectClass=user)(sAMAccountName={username}))",
+		LDAPIDAttribute:    "objectGUID",
+		LDAPEmailAttribute: "mail",
+		LDAPNameAttribute:  "displayName",
+		LDAPGroupAttribute: "memberOf",
+		LDAPGroupRoles: []config.LDAPGroupRole{
+			{Role: "admin", GroupDN: "CN=IAG Admins,OU=Groups,DC=corp,DC=example,DC=com"},
+			{Role: "viewer", GroupDN: "CN=Auditors,OU=Groups,DC=corp,DC=example,DC=com"},
+		},
+		LDAPTimeout: 5 * time.Second,
+
+		PasswordMaxAttempts:   10,
+		PasswordIPMaxFailures: 20,
+		PasswordAttemptWindow: 15 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewLDAPService() error = %v", err)
+	}
+	return s
+}
+
+func TestLDAPService_Authenticate(t *testing.T) {
+	server := newTestLDAPServer(t,
+		testLDAPEntry{
+			dn:       "CN=Jane Doe,OU=Staff,DC=corp,DC=example,DC=com",
+			password: "jane-password",
+			attributes: map[string][]string{
+				"sAMAccountName": {"jdoe"},
+				"objectGUID":     {"\x01\x02\x03\xff"},
+				"mail":           {"jane.doe@corp.example.com"},
+				"displayName":    {"Jane Doe"},
+				"memberOf": {
+					"cn=iag admins,ou=groups,dc=corp,dc=example,dc=com",
+					"CN=Auditors, OU=Groups, DC=corp, DC=example, DC=com",
+					"CN=Everyone,OU=Groups,DC=corp,DC=example,DC=com",
+				},
+			},
+		},
+		testLDAPEntry{
+			dn:       "CN=Sam Roe,OU=Staff,DC=corp,DC=example,DC=com",
+			password: "sam-password",
+			attributes: map[string][]string{
+				"sAMAccountName": {"sroe"},
+				"objectGUID":     {"sam-guid"},
+				"memberOf":       {"CN=Everyone,OU=Groups,DC=corp,DC=example,DC=com"},
+			},
+		},
+	)
+	s := newTestLDAPService(t, server, testLDAPServicePassword)
+
+	tests := []struct {
+		name      string
+		username  string
+		password  string
+		wantErr   error
+		wantID    string
+		wantRoles []string
+	}{
+		{"Groups map to roles", "jdoe", "jane-password", nil, "010203ff", []string{"admin", "viewer"}},
+		{"No mapped groups", " sroe ", "sam-password", nil, "sam-guid", []string{"user"}},
+		{"Wrong password", "jdoe", "sam-password", ErrInvalidCredentials, "", nil},
+		{"Unknown user", "nobody", "jane-password", ErrInvalidCredentials, "", nil},
+		{"Filter injection", "*", "jane-password", ErrInvalidCredentials, "", nil},
+		{"Empty password", "jdoe", "", ErrInvalidCredentials, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := s.Authenticate(tt.username, tt.password, "192.0.2.1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if user.ID != tt.wantID || user.Provider != "ldap" || !slices.Equal(user.Roles, tt.wantRoles) {
+				t.Errorf("Authenticate() = %s %s %v, want %s ldap %v", user.ID, user.Provider, user.Roles, tt.wantID, tt.wantRoles)
+			}
+		})
+	}
+
+	user, _ := s.Authenticate("jdoe", "jane-password", "192.0.2.1")
+	if user.Email != "jane.doe@corp.example.com" || user.Name != "Jane Doe" {
+		t.Errorf("Authenticate() = %s %q, want the mail and displayName attributes", user.Email, user.Name)
+	}
+}
+
+func TestLDAPService_EmptyPasswordNeverBinds(t *testing.T) {
+	server := newTestLDAPServer(t)
+	s := newTestLDAPService(t, server, testLDAPServicePassword)
+
+	if _, err := s.Authenticate("jdoe", "", "192.0.2.1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("Authenticate() error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if binds := server.Binds(); len(binds) != 0 {
+		t.Errorf("Binds() = %v, want none", binds)
+	}
+}
+
+func TestLDAPService_ServiceAccountFailure(t *testing.T) {
+	server := newTestLDAPServer(t)
+	s := newTestLDAPService(t, server, "wrong-secret")
+
+	// A broken service account is a server error, not the user's fault
+	_, err := s.Authenticate("jdoe", "jane-password", "192.0.2.1")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() error = %v, want a service account error", err)
+	}
+}
+
+func TestNewLDAPService_RequiresUsernamePlaceholder(t *testing.T) {
+	if _, err := NewLDAPService(&config.Config{LDAPUserFilter: "(objectClass=user)"}); err == nil {
+		t.Error("NewLDAPService() error = nil, want an error for a filter without {username}")
+	}
+}
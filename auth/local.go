@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// AMRPassword is the RFC 8176 amr value for a password login
+const AMRPassword = "pwd"
+
+// passwordMaxLength bounds the work an attacker can make Argon2 do
+const passwordMaxLength = 256
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._@-]{2,63}$`)
+
+var (
+	// ErrInvalidCredentials is returned for unknown usernames and wrong
+	// passwords alike
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrInvalidLocalToken is returned for unknown, used, expired or
+	// mismatched invite and reset tokens
+	ErrInvalidLocalToken = errors.New("invalid or expired token")
+	// ErrInvalidUsername is returned for usernames outside the allowed
+	// characters and length
+	ErrInvalidUsername = errors.New("username must be 3-64 characters of a-z, 0-9, '.', '_', '@' or '-'")
+	// ErrPasswordPolicy is wrapped by errors for passwords that do not meet
+	// the password policy
+	ErrPasswordPolicy = errors.New("password does not meet the policy")
+)
+
+// LocalAccountService manages local username/password accounts: admin
+// invites, registration, login and password resets
+type LocalAccountService struct {
+	config   *config.Config
+	store    store.LocalAccountStore
+	hasher   *PasswordHasher
+	sessions *SessionManager
+	accounts *AccountService
+	throttle *passwordThrottle
+
+	// dummyHash is verified for unknown usernames so that they take as long
+	// as wrong passwords
+	dummyHash string
+}
+
+// NewLocalAccountService creates a local account service hashing with the
+// shared password hasher. Password changes, resets and deletions end the
+// account's sessions in sessions. accounts maps local accounts to the
+// canonical accounts that sessions belong to, and is nil when account
+// linking is disabled.
+func NewLocalAccountService(cfg *config.Config, accountStore store.LocalAccountStore, hasher *PasswordHasher, sessions *SessionManager, accounts *AccountService) (*LocalAccountService, error) {
+	dummyHash, err := hasher.Hash("dummy password")
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalAccountService{
+		config:    cfg,
+		store:     accountStore,
+		hasher:    hasher,
+		sessions:  sessions,
+		accounts:  accounts,
+		throttle:  newPasswordThrottle(cfg.PasswordMaxAttempts, cfg.PasswordIPMaxFailures, cfg.PasswordAttemptWindow),
+		dummyHash: dummyHash,
+	}, nil
+}
+
+// NormalizeUsername lower-cases and trims a username
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// CheckPasswordPolicy checks a new password: it must have the configured
+// minimum length, must not be a single repeated character, and must not
+// contain the username or the local part of the email address
+func (s *LocalAccountService) CheckPasswordPolicy(password, username, email string) error {
+	length := utf8.RuneCountInString(password)
+	if length < s.config.LocalPasswordMinLength {
+		return fmt.Errorf("%w: must be at least %d characters", ErrPasswordPolicy, s.config.LocalPasswordMinLength)
+	}
+	if length > passwordMaxLength {
+		return fmt.Errorf("%w: must be at most %d characters", ErrPasswordPolicy, passwordMaxLength)
+	}
+	if length > 0 && strings.Count(password, string([]rune(password)[:1])) == length {
+		return fmt.Errorf("%w: must not repeat a single character", ErrPasswordPolicy)
+	}
+
+	lower := strings.ToLower(password)
+	if username != "" && strings.Contains(lower, NormalizeUsername(username)) {
+		return fmt.Errorf("%w: must not contain the username", ErrPasswordPolicy)
+	}
+	if local, _, _ := strings.Cut(strings.ToLower(email), "@"); len(local) >= 3 && strings.Contains(lower, local) {
+		return fmt.Errorf("%w: must not contain the email address", ErrPasswordPolicy)
+	}
+	return nil
+}
+
+// Invite creates a registration invite for the email with the given roles
+// and returns the one-time token and its expiry
+func (s *LocalAccountService) Invite(email string, roles []string, createdBy string) (string, time.Time, error) {
+	if email == "" {
+		return "", time.Time{}, errors.New("email is required")
+	}
+	if len(roles) == 0 {
+		roles = []string{string(models.RoleUser)}
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(s.config.LocalInviteTTL)
+	if err := s.store.SaveToken(&models.LocalToken{
+		Hash:      hashLocalToken(token),
+		Kind:      models.LocalTokenInvite,
+		Email:     email,
+		Roles:     roles,
+		CreatedBy: createdBy,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// Register creates an account from an invite. The invite is used up only
+// if the account is created.
+func (s *LocalAccountService) Register(inviteToken, username, name, password string) (*models.LocalAccount, error) {
+	username = NormalizeUsername(username)
+	if !usernamePattern.MatchString(username) {
+		return nil, ErrInvalidUsername
+	}
+	if _, err := s.store.GetByUsername(username); err == nil {
+		return nil, store.ErrUsernameTaken
+	}
+
+	invite, err := s.takeToken(inviteToken, models.LocalTokenInvite)
+	if err != nil {
+		return nil, err
+	}
+	// Put the invite back if the account cannot be created from it
+	restore := func(err error) (*models.LocalAccount, error) {
+		s.store.SaveToken(invite)
+		return nil, err
+	}
+
+	if err := s.CheckPasswordPolicy(password, username, invite.Email); err != nil {
+		return restore(err)
+	}
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return restore(err)
+	}
+	id, err := randomHex(16)
+	if err != nil {
+		return restore(err)
+	}
+
+	now := time.Now()
+	account := &models.LocalAccount{
+		ID:                "local-" + id,
+		Username:          username,
+		Email:             invite.Email,
+		Name:              name,
+		Roles:             invite.Roles,
+		PasswordHash:      hash,
+		PasswordChangedAt: now,
+		CreatedAt:         now,
+		CreatedBy:         invite.CreatedBy,
+	}
+	if err := s.store.Save(account); err != nil {
+		return restore(err)
+	}
+	return account, nil
+}
+
+// Authenticate checks a username and password, tried from the client IP,
+// and returns the user. Too many attempts for the username or failures from
+// the IP return ErrTooManyAttempts without checking the password. Hashes
+// made with older parameters are upgraded on success.
+func (s *LocalAccountService) Authenticate(username, password, ip string) (*models.User, error) {
+	username = NormalizeUsername(username)
+	now := time.Now()
+	if !s.throttle.Take(username, ip, now) {
+		return nil, ErrTooManyAttempts
+	}
+	user, err := s.authenticate(username, password)
+	s.throttle.Done(username, ip, err, now)
+	return user, err
+}
+
+func (s *LocalAccountService) authenticate(username, password string) (*models.User, error) {
+	account, err := s.store.GetByUsername(username)
+	if errors.Is(err, store.ErrNotFound) {
+		s.hasher.Verify(password, s.dummyHash)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := s.hasher.Verify(password, account.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	if s.hasher.NeedsRehash(account.PasswordHash) {
+		if hash, err := s.hasher.Hash(password); err == nil {
+			account.PasswordHash = hash
+			s.store.Save(account)
+		}
+	}
+	return account.User(), nil
+}
+
+// ChangePassword sets a new password for the gateway user after checking
+// the current one, tried from the client IP, and ends the user's sessions
+// other than sessionID. The current password is throttled like a login,
+// sharing its attempts.
+func (s *LocalAccountService) ChangePassword(userID, current, password, ip, sessionID string) error {
+	accountID, err := s.localID(userID)
+	if err != nil {
+		return err
+	}
+	account, err := s.store.Get(accountID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if !s.throttle.Take(account.Username, ip, now) {
+		return ErrTooManyAttempts
+	}
+	ok, err := s.hasher.Verify(current, account.PasswordHash)
+	if err == nil && !ok {
+		err = ErrInvalidCredentials
+	}
+	s.throttle.Done(account.Username, ip, err, now)
+	if err != nil {
+		return err
+	}
+
+	if err := s.setPassword(account, password); err != nil {
+		return err
+	}
+	return s.sessions.RevokeAll(userID, sessionID)
+}
+
+// IssuePasswordReset creates a one-time password reset token for an
+// account and returns it with its expiry
+func (s *LocalAccountService) IssuePasswordReset(accountID, createdBy string) (string, time.Time, error) {
+	if _, err := s.store.Get(accountID); err != nil {
+		return "", time.Time{}, err
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(s.config.LocalResetTTL)
+	if err := s.store.SaveToken(&models.LocalToken{
+		Hash:      hashLocalToken(token),
+		Kind:      models.LocalTokenPasswordReset,
+		AccountID: accountID,
+		CreatedBy: createdBy,
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ResetPassword sets a new password with a reset token and ends all of the
+// account's sessions. The token is used up only if the password is changed.
+func (s *LocalAccountService) ResetPassword(resetToken, password string) error {
+	reset, err := s.takeToken(resetToken, models.LocalTokenPasswordReset)
+	if err != nil {
+		return err
+	}
+	account, err := s.store.Get(reset.AccountID)
+	if err != nil {
+		return ErrInvalidLocalToken
+	}
+	if err := s.setPassword(account, password); err != nil {
+		s.store.SaveToken(reset)
+		return err
+	}
+	return s.endSessions(account.ID)
+}
+
+// Accounts lists all local accounts
+func (s *LocalAccountService) Accounts() ([]*models.LocalAccount, error) {
+	return s.store.List()
+}
+
+// Account returns a local account by ID
+func (s *LocalAccountService) Account(id string) (*models.LocalAccount, error) {
+	return s.store.Get(id)
+}
+
+// DeleteAccount removes a local account and ends its sessions
+func (s *LocalAccountService) DeleteAccount(id string) error {
+	if err := s.store.Delete(id); err != nil {
+		return err
+	}
+	return s.endSessions(id)
+}
+
+// localID returns the local account ID of a gateway user ID, which is the
+// canonical account ID when account linking is enabled
+func (s *LocalAccountService) localID(userID string) (string, error) {
+	if s.accounts == nil {
+		return userID, nil
+	}
+	return s.accounts.Subject(userID, "local")
+}
+
+// endSessions ends all sessions of the local account, which are kept under
+// its canonical account ID when account linking is enabled
+func (s *LocalAccountService) endSessions(accountID string) error {
+	userID := accountID
+	if s.accounts != nil {
+		var err error
+		userID, err = s.accounts.AccountID("local", accountID)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil // never logged in
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.sessions.RevokeAll(userID, "")
+}
+
+func (s *LocalAccountService) setPassword(account *models.LocalAccount, password string) error {
+	if err := s.CheckPasswordPolicy(password, account.Username, account.Email); err != nil {
+		return err
+	}
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	account.PasswordHash = hash
+	account.PasswordChangedAt = time.Now()
+	return s.store.Save(account)
+}
+
+// takeToken consumes a one-time token of the given kind
+func (s *LocalAccountService) takeToken(token, kind string) (*models.LocalToken, error) {
+	stored, err := s.store.TakeToken(hashLocalToken(token))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrInvalidLocalToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored.Kind != kind {
+		s.store.SaveToken(stored)
+		return nil, ErrInvalidLocalToken
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrInvalidLocalToken
+	}
+	return stored, nil
+}
+
+func hashLocalToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+func newTestLocalAccountService(t *testing.T) (*LocalAccountService, store.LocalAccountStore) {
+	accountStore := store.NewMemoryLocalAccountStore()
+	cfg := &config.Config{
+		LocalArgon2Memory:      1024,
+		LocalArgon2Iterations:  1,
+		LocalArgon2Parallelism: 1,
+		LocalPasswordMinLength: 12,
+		LocalInviteTTL:         time.Hour,
+		LocalResetTTL:          time.Hour,
+
+		LocalArgon2MaxConcurrency: 2,
+		PasswordMaxAttempts:       5,
+		PasswordIPMaxFailures:     10,
+		PasswordAttemptWindow:     15 * time.Minute,
+	}
+	hasher, err := NewPasswordHasher(cfg)
+	if err != nil {
+		t.Fatalf("NewPasswordHasher() error = %v", err)
+	}
+	sessions := NewSessionManager(cfg, store.NewMemorySessionStore())
+	s, err := NewLocalAccountService(cfg, accountStore, hasher, sessions, nil)
+	if err != nil {
+		t.Fatalf("NewLocalAccountService() error = %v", err)
+	}
+	return s, accountStore
+}
+
+func TestLocalAccountService_CheckPasswordPolicy(t *testing.T) {
+	s, _ := newTestLocalAccountService(t)
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{"Long passphrase", "correct horse battery staple", false},
+		{"Too short", "Sh0rt!pass", true},
+		{"Repeated character", "aaaaaaaaaaaaaaaa", true},
+		{"Contains username", "my-VENDOR1-password", true},
+		{"Contains email local part", "jane.doe.password!", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.CheckPasswordPolicy(tt.password, "vendor1", "jane.doe@example.com")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckPasswordPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrPasswordPolicy) {
+				t.Errorf("CheckPasswordPolicy() error = %v, want %v", err, ErrPasswordPolicy)
+			}
+		})
+	}
+}
+
+func TestLocalAccountService_RegisterAndAuthenticate(t *testing.T) {
+	s, _ := newTestLocalAccountService(t)
+
+	invite, _, err := s.Invite("vendor@example.com", []string{"viewer"}, "admin-1")
+	if err != nil {
+		t.Fatalf("Invite() error = %v", err)
+	}
+
+	// A rejected password leaves the invite usable
+	if _, err := s.Register(invite, "Vendor1", "Vendor One", "short"); !errors.Is(err, ErrPasswordPolicy) {
+		t.Fatalf("Register() with weak password error = %v, want %v", err, ErrPasswordPolicy)
+	}
+	account, err := s.Register(invite, "Vendor1", "Vendor One", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if account.Username != "vendor1" || account.Email != "vendor@example.com" || account.Roles[0] != "viewer" {
+		t.Errorf("Register() = %+v, want vendor1 with the invite's email and roles", account)
+	}
+	if _, err := s.Register(invite, "vendor2", "", "correct horse battery staple"); !errors.Is(err, ErrInvalidLocalToken) {
+		t.Errorf("Register() with used invite error = %v, want %v", err, ErrInvalidLocalToken)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{"Correct", "vendor1", "correct horse battery staple", nil},
+		{"Username is case-insensitive", " VENDOR1 ", "correct horse battery staple", nil},
+		{"Wrong password", "vendor1", "wrong horse battery staple", ErrInvalidCredentials},
+		{"Unknown user", "nobody", "correct horse battery staple", ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := s.Authenticate(tt.username, tt.password, "192.0.2.1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && (user.ID != account.ID || user.Provider != "local") {
+				t.Errorf("Authenticate() = %+v, want local user %s", user, account.ID)
+			}
+		})
+	}
+}
+
+func TestLocalAccountService_AttemptLimit(t *testing.T) {
+	s, _ := newTestLocalAccountService(t)
+	invite, _, _ := s.Invite("vendor@example.com", nil, "admin-1")
+	if _, err := s.Register(invite, "vendor1", "", "correct horse battery staple"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	// A success clears the username's attempts
+	for i := 0; i < 4; i++ {
+		s.Authenticate("vendor1", "wrong horse battery staple", "192.0.2.1")
+	}
+	if _, err := s.Authenticate("vendor1", "correct horse battery staple", "192.0.2.1"); err != nil {
+		t.Fatalf("Authenticate() after 4 failures error = %v", err)
+	}
+
+	// The username is locked after 5 attempts, even from another IP and
+	// with the right password
+	for i := 0; i < 5; i++ {
+		s.Authenticate("Vendor1", "wrong horse battery staple", "192.0.2.1")
+	}
+	if _, err := s.Authenticate("vendor1", "correct horse battery staple", "192.0.2.2"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("Authenticate() of a locked username error = %v, want %v", err, ErrTooManyAttempts)
+	}
+
+	// The IP is locked after 10 failures, whichever usernames it tries
+	for i := 0; i < 5; i++ {
+		s.Authenticate(fmt.Sprintf("nobody%d", i), "wrong horse battery staple", "192.0.2.1")
+	}
+	if _, err := s.Authenticate("someone", "wrong horse battery staple", "192.0.2.1"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("Authenticate() from a locked IP error = %v, want %v", err, ErrTooManyAttempts)
+	}
+}
+
+func TestLocalAccountService_PasswordReset(t *testing.T) {
+	s, accountStore := newTestLocalAccountService(t)
+	invite, _, _ := s.Invite("vendor@example.com", nil, "admin-1")
+	account, err := s.Register(invite, "vendor1", "", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	// Invites cannot be used as reset tokens
+	invite, _, _ = s.Invite("other@example.com", nil, "admin-1")
+	if err := s.ResetPassword(invite, "a brand new passphrase"); !errors.Is(err, ErrInvalidLocalToken) {
+		t.Fatalf("ResetPassword() with invite error = %v, want %v", err, ErrInvalidLocalToken)
+	}
+
+	reset, _, err := s.IssuePasswordReset(account.ID, "admin-1")
+	if err != nil {
+		t.Fatalf("IssuePasswordReset() error = %v", err)
+	}
+	session, err := s.sessions.Create(account.User(), "192.0.2.1", "test", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if err := s.ResetPassword(reset, "a brand new passphrase"); err != nil {
+		t.Fatalf("ResetPassword() error = %v", err)
+	}
+	if _, err := s.sessions.Check(session.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Check() after reset error = %v, want %v", err, ErrSessionRevoked)
+	}
+	if err := s.ResetPassword(reset, "another new passphrase"); !errors.Is(err, ErrInvalidLocalToken) {
+		t.Errorf("ResetPassword() with used token error = %v, want %v", err, ErrInvalidLocalToken)
+	}
+	if _, err := s.Authenticate("vendor1", "correct horse battery staple", "192.0.2.1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() with old password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+	if _, err := s.Authenticate("vendor1", "a brand new passphrase", "192.0.2.1"); err != nil {
+		t.Errorf("Authenticate() with new password error = %v", err)
+	}
+
+	// Logins upgrade hashes made with older parameters
+	s.hasher.params.Iterations = 2
+	if _, err := s.Authenticate("vendor1", "a brand new passphrase", "192.0.2.1"); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	stored, _ := accountStore.Get(account.ID)
+	if s.hasher.NeedsRehash(stored.PasswordHash) {
+		t.Error("PasswordNeedsRehash() after login = true, want the hash upgraded")
+	}
+}
+
+func TestLocalAccountService_ChangePassword(t *testing.T) {
+	s, _ := newTestLocalAccountService(t)
+	invite, _, _ := s.Invite("vendor@example.com", nil, "admin-1")
+	account, err := s.Register(invite, "vendor1", "", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	current, _ := s.sessions.Create(account.User(), "192.0.2.1", "test", "")
+	other, _ := s.sessions.Create(account.User(), "192.0.2.9", "test", "")
+
+	if err := s.ChangePassword(account.ID, "correct horse battery staple", "a brand new passphrase", "192.0.2.1", current.ID); err != nil {
+		t.Fatalf("ChangePassword() error = %v", err)
+	}
+	if _, err := s.sessions.Check(current.ID); err != nil {
+		t.Errorf("Check() of the changing session error = %v", err)
+	}
+	if _, err := s.sessions.Check(other.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Check() of another session error = %v, want %v", err, ErrSessionRevoked)
+	}
+
+	// Wrong current passwords are throttled like logins, so a stolen token
+	// cannot be used to guess the password
+	for i := 0; i < 5; i++ {
+		err := s.ChangePassword(account.ID, "wrong horse battery staple", "another new passphrase", "192.0.2.1", current.ID)
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("ChangePassword() attempt %d error = %v, want %v", i+1, err, ErrInvalidCredentials)
+		}
+	}
+	err = s.ChangePassword(account.ID, "a brand new passphrase", "another new passphrase", "192.0.2.1", current.ID)
+	if !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("ChangePassword() after too many attempts error = %v, want %v", err, ErrTooManyAttempts)
+	}
+	if _, err := s.Authenticate("vendor1", "a brand new passphrase", "192.0.2.2"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("Authenticate() after too many attempts error = %v, want %v", err, ErrTooManyAttempts)
+	}
+}
+
+func TestLocalAccountService_DeleteAccount(t *testing.T) {
+	s, _ := newTestLocalAccountService(t)
+	invite, _, _ := s.Invite("vendor@example.com", nil, "admin-1")
+	account, err := s.Register(invite, "vendor1", "", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	session, _ := s.sessions.Create(account.User(), "192.0.2.1", "test", "")
+
+	if err := s.DeleteAccount(account.ID); err != nil {
+		t.Fatalf("DeleteAccount() error = %v", err)
+	}
+	if _, err := s.sessions.Check(session.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Check() after delete error = %v, want %v", err, ErrSessionRevoked)
+	}
+}
+
+func TestLocalAccountService_AccountLinking(t *testing.T) {
+	s, _ := newTestLocalAccountService(t)
+	s.accounts = newTestAccountService()
+	invite, _, _ := s.Invite("vendor@example.com", nil, "admin-1")
+	account, err := s.Register(invite, "vendor1", "", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	// Sessions and tokens carry the canonical account ID, not the local one
+	user, err := s.accounts.Resolve(account.User())
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	current, _ := s.sessions.Create(user, "192.0.2.1", "test", "")
+	other, _ := s.sessions.Create(user, "192.0.2.9", "test", "")
+
+	if err := s.ChangePassword(user.ID, "correct horse battery staple", "a brand new passphrase", "192.0.2.1", current.ID); err != nil {
+		t.Fatalf("ChangePassword() error = %v", err)
+	}
+	if _, err := s.sessions.Check(current.ID); err != nil {
+		t.Errorf("Check() of the changing session error = %v", err)
+	}
+	if _, err := s.sessions.Check(other.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Check() of another session after change error = %v, want %v", err, ErrSessionRevoked)
+	}
+
+	reset, _, _ := s.IssuePasswordReset(account.ID, "admin-1")
+	if err := s.ResetPassword(reset, "another new passphrase"); err != nil {
+		t.Fatalf("ResetPassword() error = %v", err)
+	}
+	if _, err := s.sessions.Check(current.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Check() after reset error = %v, want %v", err, ErrSessionRevoked)
+	}
+
+	session, _ := s.sessions.Create(user, "192.0.2.1", "test", "")
+	if err := s.DeleteAccount(account.ID); err != nil {
+		t.Fatalf("DeleteAccount() error = %v", err)
+	}
+	if _, err := s.sessions.Check(session.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Check() after delete error = %v, want %v", err, ErrSessionRevoked)
+	}
+}
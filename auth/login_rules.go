@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ErrLoginNotAllowed is wrapped by errors for users the login rules reject
+var ErrLoginNotAllowed = errors.New("login not allowed")
+
+// UpstreamClaims are the claims of the provider's ID token that login rules
+// look at
+type UpstreamClaims struct {
+	HostedDomain string `json:"hd"`  // Google Workspace domain
+	TenantID     string `json:"tid"` // Azure AD tenant
+}
+
+// ParseUpstreamClaims reads the login rule claims from a provider ID token
+// that VerifyIDToken has already checked, such as one kept with a pending
+// login. The signature is not checked again here.
+func ParseUpstreamClaims(idToken string) UpstreamClaims {
+	var claims struct {
+		UpstreamClaims
+		jwt.RegisteredClaims
+	}
+	if idToken != "" {
+		jwt.NewParser().ParseUnverified(idToken, &claims)
+	}
+	return claims.UpstreamClaims
+}
+
+// CheckLoginAllowed applies the LOGIN_* rules to a user who logged in
+// through any provider. Denied emails are rejected and allowed emails and
+// providers admitted first. Then, if any allow rule is configured, the user
+// must pass every rule that applies to the provider, and at least one must
+// apply.
+func CheckLoginAllowed(cfg *config.Config, user *models.User, upstream UpstreamClaims) error {
+	if user.Email != "" && containsFold(cfg.LoginDeniedEmails, user.Email) {
+		return fmt.Errorf("%w: %s is on the deny list", ErrLoginNotAllowed, user.Email)
+	}
+	// An unverified address may belong to someone else, as with Azure,
+	// where tenant admins can set any mail value
+	if user.EmailVerified && containsFold(cfg.LoginAllowedEmails, user.Email) {
+		return nil
+	}
+
+	// Local, LDAP and SAML users are vetted by the admins of those systems
+	if containsString(cfg.LoginAllowedProviders, user.Provider) {
+		return nil
+	}
+	// Without an email, as for passkey users whose provider email is not
+	// kept, the deny list cannot be checked
+	if user.Email == "" && len(cfg.LoginDeniedEmails) > 0 {
+		return fmt.Errorf("%w: no email to check against the deny list", ErrLoginNotAllowed)
+	}
+
+	if len(cfg.LoginAllowedEmails) == 0 && len(cfg.LoginAllowedDomains) == 0 &&
+		len(cfg.LoginAllowedGoogleHD) == 0 && len(cfg.LoginAllowedAzureTenants) == 0 &&
+		len(cfg.LoginAllowedProviders) == 0 {
+		return nil
+	}
+
+	applied := false
+	// Azure never vouches for emails, so its users are restricted by tenant
+	if len(cfg.LoginAllowedDomains) > 0 && user.Provider != "azure" {
+		applied = true
+		_, domain, _ := strings.Cut(user.Email, "@")
+		if !user.EmailVerified || !containsFold(cfg.LoginAllowedDomains, domain) {
+			return fmt.Errorf("%w: %q is not a verified email in an allowed domain", ErrLoginNotAllowed, user.Email)
+		}
+	}
+	if len(cfg.LoginAllowedGoogleHD) > 0 && user.Provider == "google" {
+		applied = true
+		if upstream.HostedDomain == "" || !containsFold(cfg.LoginAllowedGoogleHD, upstream.HostedDomain) {
+			return fmt.Errorf("%w: Google hosted domain %q is not allowed", ErrLoginNotAllowed, upstream.HostedDomain)
+		}
+	}
+	if len(cfg.LoginAllowedAzureTenants) > 0 && user.Provider == "azure" {
+		applied = true
+		if upstream.TenantID == "" || !containsFold(cfg.LoginAllowedAzureTenants, upstream.TenantID) {
+			return fmt.Errorf("%w: Azure tenant %q is not allowed", ErrLoginNotAllowed, upstream.TenantID)
+		}
+	}
+	if !applied {
+		return fmt.Errorf("%w: %s is not on the allow list", ErrLoginNotAllowed, user.Email)
+	}
+	return nil
+}
+
+func containsFold(values []string, want string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, want) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestCheckLoginAllowed(t *testing.T) {
+	google := func(email string, verified bool) *models.User {
+		return &models.User{Provider: "google", Email: email, EmailVerified: verified}
+	}
+	azure := func(email string) *models.User {
+		return &models.User{Provider: "azure", Email: email}
+	}
+
+	tests := []struct {
+		name      string
+		cfg       config.Config
+		user      *models.User
+		upstream  UpstreamClaims
+		wantAllow bool
+	}{
+		{"No rules", config.Config{}, google("anyone@gmail.com", true), UpstreamClaims{}, true},
+		{"Denied email", config.Config{LoginDeniedEmails: []string{"Mallory@Example.com"}},
+			google("mallory@example.com", true), UpstreamClaims{}, false},
+		{"Deny beats allow", config.Config{
+			LoginDeniedEmails:  []string{"mallory@example.com"},
+			LoginAllowedEmails: []string{"mallory@example.com"},
+		}, google("mallory@example.com", true), UpstreamClaims{}, false},
+
+		{"Allowed domain", config.Config{LoginAllowedDomains: []string{"example.com"}},
+			google("Jane@EXAMPLE.com", true), UpstreamClaims{}, true},
+		{"Other domain", config.Config{LoginAllowedDomains: []string{"example.com"}},
+			google("jane@gmail.com", true), UpstreamClaims{}, false},
+		{"Subdomain", config.Config{LoginAllowedDomains: []string{"example.com"}},
+			google("jane@evil.example.com", true), UpstreamClaims{}, false},
+		{"Unverified email in allowed domain", config.Config{LoginAllowedDomains: []string{"example.com"}},
+			google("jane@example.com", false), UpstreamClaims{}, false},
+
+		{"Allowed email outside rules", config.Config{
+			LoginAllowedDomains: []string{"example.com"},
+			LoginAllowedEmails:  []string{"contractor@gmail.com"},
+		}, google("contractor@gmail.com", true), UpstreamClaims{}, true},
+		{"Allowed email only", config.Config{LoginAllowedEmails: []string{"contractor@gmail.com"}},
+			google("other@gmail.com", true), UpstreamClaims{}, false},
+		{"Allowed email unverified", config.Config{LoginAllowedEmails: []string{"ceo@example.com"}},
+			azure("ceo@example.com"), UpstreamClaims{TenantID: "attacker-tenant"}, false},
+
+		{"Google hosted domain", config.Config{LoginAllowedGoogleHD: []string{"example.com"}},
+			google("jane@example.com", true), UpstreamClaims{HostedDomain: "example.com"}, true},
+		{"Consumer Google account", config.Config{LoginAllowedGoogleHD: []string{"example.com"}},
+			google("jane@gmail.com", true), UpstreamClaims{}, false},
+		{"Hosted domain and email domain", config.Config{
+			LoginAllowedGoogleHD: []string{"example.com"},
+			LoginAllowedDomains:  []string{"example.com"},
+		}, google("jane@other.com", true), UpstreamClaims{HostedDomain: "example.com"}, false},
+
+		{"Azure tenant", config.Config{LoginAllowedAzureTenants: []string{"11111111-aaaa"}},
+			azure("jane@example.com"), UpstreamClaims{TenantID: "11111111-AAAA"}, true},
+		{"Other Azure tenant", config.Config{LoginAllowedAzureTenants: []string{"11111111-aaaa"}},
+			azure("jane@example.com"), UpstreamClaims{TenantID: "22222222-bbbb"}, false},
+		{"No rule for the provider", config.Config{LoginAllowedAzureTenants: []string{"11111111-aaaa"}},
+			google("jane@example.com", true), UpstreamClaims{}, false},
+		{"Azure tenant with a domain rule", config.Config{
+			LoginAllowedDomains:      []string{"example.com"},
+			LoginAllowedAzureTenants: []string{"11111111-aaaa"},
+		}, azure("jane@example.com"), UpstreamClaims{TenantID: "11111111-aaaa"}, true},
+		{"Azure with only a domain rule", config.Config{LoginAllowedDomains: []string{"example.com"}},
+			azure("jane@example.com"), UpstreamClaims{TenantID: "11111111-aaaa"}, false},
+
+		{"Allowed provider", config.Config{
+			LoginAllowedDomains:   []string{"example.com"},
+			LoginAllowedProviders: []string{"ldap"},
+		}, &models.User{Provider: "ldap", Email: "jane@other.com"}, UpstreamClaims{}, true},
+		{"Allowed provider, denied email", config.Config{
+			LoginDeniedEmails:     []string{"jane@example.com"},
+			LoginAllowedProviders: []string{"ldap"},
+		}, &models.User{Provider: "ldap", Email: "jane@example.com"}, UpstreamClaims{}, false},
+		{"Local user without a provider rule", config.Config{LoginAllowedDomains: []string{"example.com"}},
+			&models.User{Provider: "local", Email: "jane@example.com"}, UpstreamClaims{}, false},
+		{"Only other providers allowed", config.Config{LoginAllowedProviders: []string{"ldap"}},
+			google("jane@example.com", true), UpstreamClaims{}, false},
+		{"No email with a deny list", config.Config{LoginDeniedEmails: []string{"mallory@example.com"}},
+			&models.User{Provider: "google"}, UpstreamClaims{}, false},
+		{"Magic link user in allowed domain", config.Config{LoginAllowedDomains: []string{"example.com"}},
+			&models.User{Provider: MagicLinkProvider, Email: "jane@example.com", EmailVerified: true}, UpstreamClaims{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckLoginAllowed(&tt.cfg, tt.user, tt.upstream)
+			if tt.wantAllow && err != nil {
+				t.Errorf("CheckLoginAllowed() error = %v, want nil", err)
+			}
+			if !tt.wantAllow && !errors.Is(err, ErrLoginNotAllowed) {
+				t.Errorf("CheckLoginAllowed() error = %v, want %v", err, ErrLoginNotAllowed)
+			}
+		})
+	}
+}
+
+func TestParseUpstreamClaims(t *testing.T) {
+	idToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "1234",
+		"hd":  "example.com",
+		"tid": "11111111-aaaa",
+	}).SignedString([]byte("provider-key"))
+
+	claims := ParseUpstreamClaims(idToken)
+	if claims.HostedDomain != "example.com" || claims.TenantID != "11111111-aaaa" {
+		t.Errorf("ParseUpstreamClaims() = %+v, want the hd and tid claims", claims)
+	}
+	if claims := ParseUpstreamClaims(""); claims != (UpstreamClaims{}) {
+		t.Errorf("ParseUpstreamClaims(\"\") = %+v, want no claims", claims)
+	}
+}
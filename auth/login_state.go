@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	loginStateTTL = 10 * time.Minute
+
+	// authTimeLeeway allows for clock skew between the gateway and the IdP
+	// when checking a login's age against max_age
+	authTimeLeeway = time.Minute
+)
+
+var (
+	// ErrInvalidLoginState is returned for state values that fail to decrypt
+	// or authenticate, including any that were tampered with
+	ErrInvalidLoginState = errors.New("invalid login state")
+	// ErrLoginStateExpired is returned for state values past their expiry
+	ErrLoginStateExpired = errors.New("login state expired")
+	// ErrAuthTimeMissing is returned when max_age was requested but the IdP
+	// did not say when the user authenticated
+	ErrAuthTimeMissing = errors.New("the provider did not report auth_time for a max_age login")
+	// ErrAuthTooOld is returned when the user's authentication at the IdP is
+	// older than the requested max_age
+	ErrAuthTooOld = errors.New("the provider authentication is older than max_age")
+)
+
+// LoginState is everything the gateway needs to finish an upstream login.
+// It travels through the provider as the OAuth state parameter, so each
+// in-flight login carries its own data and parallel logins never collide.
+type LoginState struct {
+	Nonce        string      `json:"n"`
+	CodeVerifier string      `json:"v"`
+	ReturnTo     string      `json:"r,omitempty"`
+	Provider     string      `json:"p"`
+	Params       TokenParams `json:"t"`
+	ExpiresAt    int64       `json:"e"`
+
+	// ACR and MaxAge are a step-up request: the login must reach the acr
+	// class, and the IdP is asked to re-authenticate users whose last login
+	// is older than MaxAge seconds (-1 when not requested)
+	ACR    string `json:"a,omitempty"`
+	MaxAge int    `json:"m"`
+
+	// AuthTime is when the user authenticated, in Unix seconds, once known.
+	// It is 0 when the IdP did not report it.
+	AuthTime int64 `json:"at,omitempty"`
+
+	// Binding is a hash of the browser's binding cookie, which ties the
+	// state to the browser that started the login
+	Binding string `json:"b"`
+}
+
+// NewLoginState starts a login with a fresh nonce and PKCE verifier, bound
+// to the given browser binding value
+func NewLoginState(provider, returnTo string, params TokenParams, binding string) (*LoginState, error) {
+	nonce, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	return &LoginState{
+		Nonce:        nonce,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		ReturnTo:     returnTo,
+		Provider:     provider,
+		Params:       params,
+		ExpiresAt:    time.Now().Add(loginStateTTL).Unix(),
+		MaxAge:       -1,
+		Binding:      hashBinding(binding),
+	}, nil
+}
+
+// BoundTo reports whether the state was started by the browser holding the
+// given binding value
+func (s *LoginState) BoundTo(binding string) bool {
+	return subtle.ConstantTimeCompare([]byte(s.Binding), []byte(hashBinding(binding))) == 1
+}
+
+// SetAuthTime records when the user authenticated at the IdP. When the
+// login asked for max_age, the time must be reported and recent enough.
+func (s *LoginState) SetAuthTime(authTime int64, now time.Time) error {
+	if s.MaxAge >= 0 {
+		if authTime <= 0 {
+			return ErrAuthTimeMissing
+		}
+		if now.Sub(time.Unix(authTime, 0)) > time.Duration(s.MaxAge)*time.Second+authTimeLeeway {
+			return ErrAuthTooOld
+		}
+	}
+	if authTime > 0 {
+		s.AuthTime = authTime
+	}
+	return nil
+}
+
+// AuthenticatedAt returns when the user authenticated, or the zero time
+// when that is not known
+func (s *LoginState) AuthenticatedAt() time.Time {
+	if s.AuthTime <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(s.AuthTime, 0)
+}
+
+// LoginStateCodec seals login state with AES-256-GCM, so state values are
+// both confidential and tamper-evident without any server-side storage
+type LoginStateCodec struct {
+	aead cipher.AEAD
+}
+
+// NewLoginStateCodec derives the state key from the given secret
+func NewLoginStateCodec(secret string) (*LoginStateCodec, error) {
+	key := sha256.Sum256([]byte("oauth-state:" + secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginStateCodec{aead: aead}, nil
+}
+
+// Encode encrypts the state into a URL-safe string
+func (c *LoginStateCodec) Encode(state *LoginState) (string, error) {
+	plaintext, err := json.Marshal(state)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode login state: %w", err)
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, plaintext, nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decode authenticates and decrypts a state string and checks its expiry
+func (c *LoginStateCodec) Decode(encoded string) (*LoginState, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return nil, ErrInvalidLoginState
+	}
+
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, ErrInvalidLoginState
+	}
+
+	var state LoginState
+	if err := json.Unmarshal(plaintext, &state); err != nil {
+		return nil, ErrInvalidLoginState
+	}
+	if time.Now().Unix() > state.ExpiresAt {
+		return nil, ErrLoginStateExpired
+	}
+	return &state, nil
+}
+
+func hashBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return hex.EncodeToString(sum[:])
+}
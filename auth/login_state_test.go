@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestLoginStateRoundTrip(t *testing.T) {
+	codec, err := NewLoginStateCodec("test-secret")
+	if err != nil {
+		t.Fatalf("NewLoginStateCodec() error = %v", err)
+	}
+
+	params := TokenParams{Audience: "orders-api", Scope: "orders:read"}
+	state, err := NewLoginState("google", "/dashboard", params, "binding")
+	if err != nil {
+		t.Fatalf("NewLoginState() error = %v", err)
+	}
+
+	encoded, err := codec.Encode(state)
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+
+	decoded, err := codec.Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if *decoded != *state {
+		t.Errorf("Decode() = %+v, want %+v", decoded, state)
+	}
+	if !decoded.BoundTo("binding") {
+		t.Error("BoundTo() = false for the original binding, want true")
+	}
+	if decoded.BoundTo("other") {
+		t.Error("BoundTo() = true for another binding, want false")
+	}
+}
+
+func TestLoginStateParallelLogins(t *testing.T) {
+	codec, _ := NewLoginStateCodec("test-secret")
+
+	first, _ := NewLoginState("google", "/a", TokenParams{}, "binding")
+	second, _ := NewLoginState("google", "/b", TokenParams{}, "binding")
+	firstEncoded, _ := codec.Encode(first)
+	secondEncoded, _ := codec.Encode(second)
+
+	// Starting a second login must not invalidate the first
+	for _, tt := range []struct {
+		encoded  string
+		returnTo string
+	}{{firstEncoded, "/a"}, {secondEncoded, "/b"}} {
+		decoded, err := codec.Decode(tt.encoded)
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if decoded.ReturnTo != tt.returnTo {
+			t.Errorf("Decode().ReturnTo = %q, want %q", decoded.ReturnTo, tt.returnTo)
+		}
+	}
+	if first.Nonce == second.Nonce || first.CodeVerifier == second.CodeVerifier {
+		t.Error("parallel logins share a nonce or PKCE verifier")
+	}
+}
+
+func TestLoginStateDecodeRejects(t *testing.T) {
+	codec, _ := NewLoginStateCodec("test-secret")
+	otherCodec, _ := NewLoginStateCodec("other-secret")
+
+	state, _ := NewLoginState("google", "/dashboard", TokenParams{}, "binding")
+	valid, _ := codec.Encode(state)
+
+	raw, _ := base64.RawURLEncoding.DecodeString(valid)
+	raw[len(raw)-1] ^= 0x01
+	tampered := base64.RawURLEncoding.EncodeToString(raw)
+
+	expiredState, _ := NewLoginState("google", "", TokenParams{}, "binding")
+	expiredState.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	expired, _ := codec.Encode(expiredState)
+
+	foreign, _ := otherCodec.Encode(state)
+
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr error
+	}{
+		{"Tampered", tampered, ErrInvalidLoginState},
+		{"Other key", foreign, ErrInvalidLoginState},
+		{"Expired", expired, ErrLoginStateExpired},
+		{"Not base64", "not base64!", ErrInvalidLoginState},
+		{"Too short", "AAAA", ErrInvalidLoginState},
+		{"Empty", "", ErrInvalidLoginState},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := codec.Decode(tt.encoded); err != tt.wantErr {
+				t.Errorf("Decode() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoginStateSetAuthTime(t *testing.T) {
+	now := time.Now()
+	ago := func(d time.Duration) int64 { return now.Add(-d).Unix() }
+
+	tests := []struct {
+		name     string
+		maxAge   int
+		authTime int64
+		wantErr  error
+		wantTime int64
+	}{
+		{"No max_age, reported", -1, ago(time.Hour), nil, ago(time.Hour)},
+		{"No max_age, unknown", -1, 0, nil, 0},
+		{"Within max_age", 300, ago(2 * time.Minute), nil, ago(2 * time.Minute)},
+		{"Within leeway", 0, ago(30 * time.Second), nil, ago(30 * time.Second)},
+		{"Older than max_age", 300, ago(10 * time.Minute), ErrAuthTooOld, 0},
+		{"Missing with max_age", 300, 0, ErrAuthTimeMissing, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &LoginState{MaxAge: tt.maxAge}
+			if err := state.SetAuthTime(tt.authTime, now); err != tt.wantErr {
+				t.Fatalf("SetAuthTime() error = %v, want %v", err, tt.wantErr)
+			}
+			if state.AuthTime != tt.wantTime {
+				t.Errorf("SetAuthTime() AuthTime = %d, want %d", state.AuthTime, tt.wantTime)
+			}
+			if state.AuthenticatedAt().IsZero() != (tt.wantTime == 0) {
+				t.Errorf("AuthenticatedAt() = %v, want zero only when unknown", state.AuthenticatedAt())
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// backChannelLogoutEvent is the events member that marks a logout token
+	backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+	// logoutTokenMaxAge bounds how old a logout token may be, which also
+	// bounds how long token IDs are remembered for replay detection
+	logoutTokenMaxAge = 5 * time.Minute
+)
+
+// LogoutTokenClaims are the claims of an OIDC back-channel logout token
+type LogoutTokenClaims struct {
+	SessionID string                     `json:"sid,omitempty"`
+	Events    map[string]json.RawMessage `json:"events"`
+	Nonce     string                     `json:"nonce,omitempty"`
+	TenantID  string                     `json:"tid,omitempty"` // Azure AD tenant
+	jwt.RegisteredClaims
+}
+
+// LogoutReceiver handles logout notifications from the upstream provider,
+// ending the gateway sessions that came from the logged-out provider session
+type LogoutReceiver struct {
+	config   *config.Config
+	keys     *ProviderKeys
+	sessions *SessionManager
+
+	mu   sync.Mutex
+	seen map[string]time.Time // jti -> expiry of the replay window
+}
+
+// NewLogoutReceiver creates a receiver that verifies logout tokens with the
+// provider's keys
+func NewLogoutReceiver(cfg *config.Config, keys *ProviderKeys, sessions *SessionManager) *LogoutReceiver {
+	return &LogoutReceiver{
+		config:   cfg,
+		keys:     keys,
+		sessions: sessions,
+		seen:     make(map[string]time.Time),
+	}
+}
+
+// BackChannel verifies a logout token (OIDC Back-Channel Logout 1.0) and
+// revokes the matching sessions, returning how many were revoked
+func (l *LogoutReceiver) BackChannel(logoutToken string) (int, error) {
+	if logoutToken == "" {
+		return 0, newOAuthError(http.StatusBadRequest, "invalid_request", "logout_token is required")
+	}
+
+	claims := &LogoutTokenClaims{}
+	_, err := jwt.ParseWithClaims(logoutToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return l.keys.Key(kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}),
+		jwt.WithAudience(l.config.OAuthClientID),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return 0, newOAuthError(http.StatusBadRequest, "invalid_request", "invalid logout token: "+err.Error())
+	}
+	// The issuer is checked as for ID tokens, so logouts from Azure
+	// multi-tenant logins are matched against the user's tenant
+	if issuer := expectedIssuer(l.config, claims.TenantID); issuer == "" || claims.Issuer != issuer {
+		return 0, newOAuthError(http.StatusBadRequest, "invalid_request", "invalid logout token: unexpected issuer")
+	}
+
+	if err := l.validateLogoutClaims(claims); err != nil {
+		return 0, err
+	}
+
+	return l.sessions.RevokeUpstream(claims.Subject, claims.SessionID)
+}
+
+// FrontChannel revokes the gateway session of the browser that loaded an
+// OIDC front-channel logout request. These requests are unsigned and anyone
+// can make a browser load one, so only the browser's own session is ended,
+// and only if it came from the provider session named in the request.
+func (l *LogoutReceiver) FrontChannel(issuer, sessionID, browserSessionID string) (int, error) {
+	if l.config.OAuthIssuer == "" || issuer != l.config.OAuthIssuer {
+		return 0, newOAuthError(http.StatusBadRequest, "invalid_request", "issuer mismatch")
+	}
+	if sessionID == "" {
+		return 0, newOAuthError(http.StatusBadRequest, "invalid_request", "sid is required")
+	}
+	if browserSessionID == "" {
+		return 0, newOAuthError(http.StatusBadRequest, "invalid_request", "no gateway session in this browser")
+	}
+	revoked, err := l.sessions.RevokeFromUpstream(browserSessionID, sessionID)
+	if errors.Is(err, ErrUpstreamSessionMismatch) {
+		return 0, newOAuthError(http.StatusBadRequest, "invalid_request", "sid does not match this browser's session")
+	}
+	return revoked, err
+}
+
+// validateLogoutClaims applies the logout token rules beyond signature,
+// issuer, audience and time checks, including replay detection
+func (l *LogoutReceiver) validateLogoutClaims(claims *LogoutTokenClaims) error {
+	if claims.IssuedAt == nil || time.Since(claims.IssuedAt.Time) > logoutTokenMaxAge {
+		return newOAuthError(http.StatusBadRequest, "invalid_request", "logout token is too old")
+	}
+	if _, ok := claims.Events[backChannelLogoutEvent]; !ok {
+		return newOAuthError(http.StatusBadRequest, "invalid_request", "logout token lacks the back-channel logout event")
+	}
+	if claims.Nonce != "" {
+		return newOAuthError(http.StatusBadRequest, "invalid_request", "logout token must not contain a nonce")
+	}
+	if claims.Subject == "" && claims.SessionID == "" {
+		return newOAuthError(http.StatusBadRequest, "invalid_request", "logout token must contain sub or sid")
+	}
+	if claims.ID == "" {
+		return newOAuthError(http.StatusBadRequest, "invalid_request", "logout token must contain jti")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for jti, expiry := range l.seen {
+		if now.After(expiry) {
+			delete(l.seen, jti)
+		}
+	}
+	if _, ok := l.seen[claims.ID]; ok {
+		return newOAuthError(http.StatusBadRequest, "invalid_request", fmt.Sprintf("logout token %s was already used", claims.ID))
+	}
+	l.seen[claims.ID] = claims.IssuedAt.Add(logoutTokenMaxAge + time.Minute)
+	return nil
+}
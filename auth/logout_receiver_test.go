@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testProviderIssuer = "https://idp.example.com"
+
+// newTestLogoutReceiver serves a JWKS for a fresh provider key and returns
+// a receiver trusting it, along with the key for signing logout tokens
+func newTestLogoutReceiver(t *testing.T) (*LogoutReceiver, *SessionManager, *utils.SigningKey) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	key := utils.NewSigningKey(privateKey)
+
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []utils.JWK{key.JWK()}})
+	}))
+	t.Cleanup(jwks.Close)
+
+	cfg := &config.Config{
+		OAuthClientID: "gateway",
+		OAuthIssuer:   testProviderIssuer,
+		OAuthJWKSURL:  jwks.URL,
+	}
+	sessions := NewSessionManager(cfg, store.NewMemorySessionStore())
+	return NewLogoutReceiver(cfg, NewProviderKeys(cfg.OAuthJWKSURL), sessions), sessions, key
+}
+
+// createUpstreamSession logs a user in with a provider ID token naming sub and sid
+func createUpstreamSession(t *testing.T, sessions *SessionManager, sub, sid string) *models.Session {
+	t.Helper()
+
+	idToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": sub, "sid": sid}).SignedString([]byte("unused"))
+	if err != nil {
+		t.Fatalf("SignedString() error = %v", err)
+	}
+	session, err := sessions.Create(&models.User{ID: sub}, "127.0.0.1", "test", idToken)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	return session
+}
+
+func logoutClaims(sub, sid, jti string) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"iss":    testProviderIssuer,
+		"aud":    "gateway",
+		"iat":    time.Now().Unix(),
+		"exp":    time.Now().Add(2 * time.Minute).Unix(),
+		"jti":    jti,
+		"events": map[string]interface{}{backChannelLogoutEvent: map[string]interface{}{}},
+	}
+	if sub != "" {
+		claims["sub"] = sub
+	}
+	if sid != "" {
+		claims["sid"] = sid
+	}
+	return claims
+}
+
+func TestLogoutReceiver_BackChannel(t *testing.T) {
+	receiver, sessions, key := newTestLogoutReceiver(t)
+
+	first := createUpstreamSession(t, sessions, "alice", "idp-sid-1")
+	second := createUpstreamSession(t, sessions, "alice", "idp-sid-2")
+	other := createUpstreamSession(t, sessions, "bob", "idp-sid-3")
+
+	// sid alone ends only that provider session
+	token, _ := key.Sign(logoutClaims("", "idp-sid-1", "jti-1"))
+	if revoked, err := receiver.BackChannel(token); err != nil || revoked != 1 {
+		t.Fatalf("BackChannel(sid) = %d, %v, want 1, nil", revoked, err)
+	}
+	if _, err := sessions.Check(first.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Check() after sid logout error = %v, want %v", err, ErrSessionRevoked)
+	}
+	if _, err := sessions.Check(second.ID); err != nil {
+		t.Errorf("Check() on other provider session error = %v", err)
+	}
+
+	// sub alone ends all of the user's sessions
+	token, _ = key.Sign(logoutClaims("alice", "", "jti-2"))
+	if revoked, err := receiver.BackChannel(token); err != nil || revoked != 1 {
+		t.Fatalf("BackChannel(sub) = %d, %v, want 1, nil", revoked, err)
+	}
+	if _, err := sessions.Check(second.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Check() after sub logout error = %v, want %v", err, ErrSessionRevoked)
+	}
+	if _, err := sessions.Check(other.ID); err != nil {
+		t.Errorf("Check() on other user's session error = %v", err)
+	}
+}
+
+func TestLogoutReceiver_BackChannelRejects(t *testing.T) {
+	receiver, _, key := newTestLogoutReceiver(t)
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged, _ := utils.NewSigningKey(otherKey).Sign(logoutClaims("alice", "", "forged"))
+
+	sign := func(mutate func(jwt.MapClaims)) string {
+		claims := logoutClaims("alice", "idp-sid", "jti")
+		mutate(claims)
+		token, err := key.Sign(claims)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return token
+	}
+
+	replayed := sign(func(c jwt.MapClaims) { c["jti"] = "replayed" })
+	if _, err := receiver.BackChannel(replayed); err != nil {
+		t.Fatalf("BackChannel() first use error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"Empty", ""},
+		{"Unknown key", forged},
+		{"Wrong issuer", sign(func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" })},
+		{"Wrong audience", sign(func(c jwt.MapClaims) { c["aud"] = "other-client" })},
+		{"Missing event", sign(func(c jwt.MapClaims) { c["events"] = map[string]interface{}{} })},
+		{"Has nonce", sign(func(c jwt.MapClaims) { c["nonce"] = "n" })},
+		{"No sub or sid", sign(func(c jwt.MapClaims) { delete(c, "sub"); delete(c, "sid") })},
+		{"No jti", sign(func(c jwt.MapClaims) { delete(c, "jti") })},
+		{"Too old", sign(func(c jwt.MapClaims) { c["iat"] = time.Now().Add(-time.Hour).Unix(); delete(c, "exp") })},
+		{"Replayed", replayed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := receiver.BackChannel(tt.token); err == nil {
+				t.Error("BackChannel() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestLogoutReceiver_FrontChannel(t *testing.T) {
+	receiver, sessions, _ := newTestLogoutReceiver(t)
+	session := createUpstreamSession(t, sessions, "alice", "idp-sid-1")
+	other := createUpstreamSession(t, sessions, "bob", "idp-sid-2")
+
+	tests := []struct {
+		name             string
+		issuer           string
+		sid              string
+		browserSessionID string
+	}{
+		{"Wrong issuer", "https://evil.example.com", "idp-sid-1", session.ID},
+		{"No sid", testProviderIssuer, "", session.ID},
+		{"No session in the browser", testProviderIssuer, "idp-sid-1", ""},
+		// A page that knows alice's sid cannot log her out from bob's browser
+		{"Another provider session's browser", testProviderIssuer, "idp-sid-1", other.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := receiver.FrontChannel(tt.issuer, tt.sid, tt.browserSessionID); err == nil {
+				t.Error("FrontChannel() error = nil, want error")
+			}
+		})
+	}
+	for _, s := range []*models.Session{session, other} {
+		if _, err := sessions.Check(s.ID); err != nil {
+			t.Fatalf("Check() after rejected logouts error = %v", err)
+		}
+	}
+
+	if revoked, err := receiver.FrontChannel(testProviderIssuer, "idp-sid-1", session.ID); err != nil || revoked != 1 {
+		t.Fatalf("FrontChannel() = %d, %v, want 1, nil", revoked, err)
+	}
+	if _, err := sessions.Check(session.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Check() after logout error = %v, want %v", err, ErrSessionRevoked)
+	}
+	if _, err := sessions.Check(other.ID); err != nil {
+		t.Errorf("Check() of another browser's session error = %v", err)
+	}
+}
+
+func TestLogoutReceiver_BackChannelAzureTenant(t *testing.T) {
+	receiver, sessions, key := newTestLogoutReceiver(t)
+	receiver.config.OAuthProvider = "azure"
+	receiver.config.OAuthIssuer = "https://login.microsoftonline.com/common/v2.0"
+	session := createUpstreamSession(t, sessions, "alice", "idp-sid-1")
+
+	sign := func(issuer, tid, jti string) string {
+		claims := logoutClaims("", "idp-sid-1", jti)
+		claims["iss"] = issuer
+		claims["tid"] = tid
+		token, err := key.Sign(claims)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return token
+	}
+
+	// The issuer must be the tenant the token names
+	if _, err := receiver.BackChannel(sign("https://login.microsoftonline.com/tenant-2/v2.0", "tenant-1", "jti-1")); err == nil {
+		t.Error("BackChannel() with another tenant's issuer error = nil, want error")
+	}
+	if revoked, err := receiver.BackChannel(sign("https://login.microsoftonline.com/tenant-1/v2.0", "tenant-1", "jti-2")); err != nil || revoked != 1 {
+		t.Fatalf("BackChannel() = %d, %v, want 1, nil", revoked, err)
+	}
+	if _, err := sessions.Check(session.ID); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Check() after logout error = %v, want %v", err, ErrSessionRevoked)
+	}
+}
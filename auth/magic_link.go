@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+const (
+	// MagicLinkProvider is the provider name of users who log in with an
+	// emailed link
+	MagicLinkProvider = "email"
+	// AMREmail is the amr value of a login proven by access to a mailbox.
+	// RFC 8176 has no value for it.
+	AMREmail = "email"
+)
+
+var (
+	// ErrInvalidEmail is returned for values that are not a bare email address
+	ErrInvalidEmail = errors.New("invalid email address")
+	// ErrInvalidMagicLink is returned for links that are malformed, tampered
+	// with, expired or already used
+	ErrInvalidMagicLink = errors.New("invalid or expired sign-in link")
+	// ErrMagicLinkRateLimited is returned when an address has requested too
+	// many links recently
+	ErrMagicLinkRateLimited = errors.New("too many sign-in links requested for this address")
+	// ErrMagicLinkNotAllowed is returned for addresses that may not sign in
+	// with a link, because they are not on the allow list or the login rules
+	// reject them
+	ErrMagicLinkNotAllowed = errors.New("address may not sign in with a link")
+)
+
+// magicLinkClaims is the signed payload of a link token
+type magicLinkClaims struct {
+	Email     string `json:"e"`
+	ID        string `json:"j"`
+	ExpiresAt int64  `json:"x"`
+}
+
+// MagicLinkService issues and redeems single-use sign-in links. Links are
+// signed, so nothing is stored until they are used; used link IDs are then
+// kept in memory until the link would have expired.
+type MagicLinkService struct {
+	config  *config.Config
+	mail    MailSender
+	key     []byte
+	ipSends *attemptLimiter
+
+	mu   sync.Mutex
+	used map[string]time.Time   // link ID -> expiry
+	sent map[string][]time.Time // address -> recent send times
+}
+
+// NewMagicLinkService creates a magic link service sending through mail.
+// The signing key is derived from the JWT secret.
+func NewMagicLinkService(cfg *config.Config, mail MailSender) *MagicLinkService {
+	key := sha256.Sum256([]byte("magic-link:" + cfg.JWTSecret))
+	return &MagicLinkService{
+		config:  cfg,
+		mail:    mail,
+		key:     key[:],
+		ipSends: newAttemptLimiter(cfg.MagicLinkIPRateLimit, cfg.MagicLinkRateWindow),
+		used:    make(map[string]time.Time),
+		sent:    make(map[string][]time.Time),
+	}
+}
+
+// Send emails a sign-in link to the address, requested from the client IP,
+// and returns the link's ID and expiry. Nothing is sent to addresses that
+// could not sign in anyway.
+func (s *MagicLinkService) Send(email, ip string) (string, time.Time, error) {
+	if !s.ipSends.Take(ip, time.Now()) {
+		return "", time.Time{}, ErrTooManyAttempts
+	}
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	// Addresses that may not sign in are limited too, so the limit does not
+	// tell them apart from allowed ones
+	if !s.allowSend(email, time.Now()) {
+		return "", time.Time{}, ErrMagicLinkRateLimited
+	}
+	if err := s.checkAllowed(email); err != nil {
+		return "", time.Time{}, err
+	}
+
+	id, token, expiresAt, err := s.issue(email, time.Now())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	link := s.config.MagicLinkURL + "?" + url.Values{"token": {token}}.Encode()
+	body := fmt.Sprintf("Use this link to sign in. It works once and expires in %d minutes.\n\n%s\n\n"+
+		"If you did not ask to sign in, you can ignore this email.\n", int(s.config.MagicLinkTTL.Minutes()), link)
+	if err := s.mail.Send(email, "Your sign-in link", body); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to send sign-in link: %w", err)
+	}
+	return id, expiresAt, nil
+}
+
+// Check verifies a link token without using it up and returns the address
+// it was sent to and its ID
+func (s *MagicLinkService) Check(token string) (string, string, error) {
+	claims, err := s.verify(token, time.Now())
+	if err != nil {
+		return "", "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.used[claims.ID]; ok {
+		return "", "", ErrInvalidMagicLink
+	}
+	return claims.Email, claims.ID, nil
+}
+
+// Consume verifies a link token, uses it up and returns the user it signs in
+func (s *MagicLinkService) Consume(token string) (*models.User, error) {
+	now := time.Now()
+	claims, err := s.verify(token, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// The allow list may have changed since the link was sent
+	if !s.onAllowList(claims.Email) {
+		return nil, ErrMagicLinkNotAllowed
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+	if _, ok := s.used[claims.ID]; ok {
+		return nil, ErrInvalidMagicLink
+	}
+	s.used[claims.ID] = time.Unix(claims.ExpiresAt, 0)
+
+	return &models.User{
+		ID:       claims.Email,
+		Email:    claims.Email,
+		Provider: MagicLinkProvider,
+		Roles:    magicLinkRoles(s.config),
+		Created:  now,
+
+		// Receiving the link proves control of the address
+		EmailVerified: true,
+	}, nil
+}
+
+// magicLinkRoles returns the roles of magic link users
+func magicLinkRoles(cfg *config.Config) []string {
+	if len(cfg.MagicLinkRoles) == 0 {
+		return []string{string(models.RoleUser)}
+	}
+	return append([]string(nil), cfg.MagicLinkRoles...)
+}
+
+// checkAllowed returns ErrMagicLinkNotAllowed unless the address is on the
+// magic link allow list and the login rules admit it
+func (s *MagicLinkService) checkAllowed(email string) error {
+	if !s.onAllowList(email) {
+		return fmt.Errorf("%w: %s is not on the allow list", ErrMagicLinkNotAllowed, email)
+	}
+	user := &models.User{ID: email, Email: email, EmailVerified: true, Provider: MagicLinkProvider}
+	if err := CheckLoginAllowed(s.config, user, UpstreamClaims{}); err != nil {
+		return fmt.Errorf("%w: %v", ErrMagicLinkNotAllowed, err)
+	}
+	return nil
+}
+
+// onAllowList reports whether the address or its domain is allowed to sign
+// in with a link
+func (s *MagicLinkService) onAllowList(email string) bool {
+	_, domain, _ := strings.Cut(email, "@")
+	return containsFold(s.config.MagicLinkAllowedEmails, email) ||
+		containsFold(s.config.MagicLinkAllowedDomains, domain)
+}
+
+// allowSend records a send to the address unless it has reached its limit
+// for the rate window
+func (s *MagicLinkService) allowSend(email string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	if len(s.sent[email]) >= s.config.MagicLinkRateLimit {
+		return false
+	}
+	s.sent[email] = append(s.sent[email], now)
+	return true
+}
+
+// sweepLocked forgets expired link IDs and sends outside the rate window
+func (s *MagicLinkService) sweepLocked(now time.Time) {
+	for id, expiresAt := range s.used {
+		if now.After(expiresAt) {
+			delete(s.used, id)
+		}
+	}
+	cutoff := now.Add(-s.config.MagicLinkRateWindow)
+	for email, times := range s.sent {
+		i := 0
+		for i < len(times) && !times[i].After(cutoff) {
+			i++
+		}
+		if i == len(times) {
+			delete(s.sent, email)
+		} else {
+			s.sent[email] = times[i:]
+		}
+	}
+}
+
+// issue creates a signed link token for the address
+func (s *MagicLinkService) issue(email string, now time.Time) (string, string, time.Time, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	expiresAt := now.Add(s.config.MagicLinkTTL)
+	payload, err := json.Marshal(magicLinkClaims{Email: email, ID: id, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return id, encoded + "." + base64.RawURLEncoding.EncodeToString(s.sign(encoded)), expiresAt, nil
+}
+
+// verify checks a link token's signature and expiry
+func (s *MagicLinkService) verify(token string, now time.Time) (*magicLinkClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrInvalidMagicLink
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, s.sign(encoded)) {
+		return nil, ErrInvalidMagicLink
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidMagicLink
+	}
+	var claims magicLinkClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Email == "" || claims.ID == "" {
+		return nil, ErrInvalidMagicLink
+	}
+	if !now.Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, ErrInvalidMagicLink
+	}
+	return &claims, nil
+}
+
+func (s *MagicLinkService) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// normalizeEmail checks that value is a bare address, without a display
+// name, and lower-cases it
+func normalizeEmail(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Address != value {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(value), nil
+}
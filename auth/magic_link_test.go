@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+)
+
+var testMagicLinkPattern = regexp.MustCompile(`https://gateway\.example\.com/auth/magic-link/verify\?\S+`)
+
+func newTestMagicLinkService(t *testing.T) (*MagicLinkService, string) {
+	dir := t.TempDir()
+	sender, err := NewFileMailSender(dir, "iag@example.com")
+	if err != nil {
+		t.Fatalf("NewFileMailSender() error = %v", err)
+	}
+	return NewMagicLinkService(&config.Config{
+		JWTSecret:           "test-secret",
+		MagicLinkURL:        "https://gateway.example.com/auth/magic-link/verify",
+		MagicLinkTTL:        15 * time.Minute,
+		MagicLinkRoles:      []string{"viewer"},
+		MagicLinkRateLimit:  3,
+		MagicLinkRateWindow: time.Hour,
+
+		MagicLinkAllowedEmails:  []string{"guest@example.net"},
+		MagicLinkAllowedDomains: []string{"partner.example.org"},
+		MagicLinkIPRateLimit:    6,
+		LoginDeniedEmails:       []string{"former@partner.example.org"},
+	}, sender), dir
+}
+
+// sentTokens returns the link tokens in the messages written to dir, in
+// the order they were sent
+func sentTokens(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatalf("Glob() error = %v", err)
+	}
+	slices.Sort(names)
+
+	var tokens []string
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		link, err := url.Parse(testMagicLinkPattern.FindString(string(data)))
+		if err != nil {
+			t.Fatalf("url.Parse() error = %v", err)
+		}
+		tokens = append(tokens, link.Query().Get("token"))
+	}
+	return tokens
+}
+
+func TestMagicLinkService_SendAndConsume(t *testing.T) {
+	s, dir := newTestMagicLinkService(t)
+
+	id, expiresAt, err := s.Send(" Reviewer@Partner.example.org ", "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if time.Until(expiresAt) > 15*time.Minute || time.Until(expiresAt) < 14*time.Minute {
+		t.Errorf("Send() expiry = %v, want 15 minutes from now", expiresAt)
+	}
+	tokens := sentTokens(t, dir)
+	if len(tokens) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(tokens))
+	}
+
+	email, checkedID, err := s.Check(tokens[0])
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if email != "reviewer@partner.example.org" || checkedID != id {
+		t.Errorf("Check() = %s %s, want reviewer@partner.example.org %s", email, checkedID, id)
+	}
+
+	user, err := s.Consume(tokens[0])
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	if user.ID != "reviewer@partner.example.org" || user.Provider != MagicLinkProvider || !slices.Equal(user.Roles, []string{"viewer"}) {
+		t.Errorf("Consume() = %+v, want the reviewer with the viewer role", user)
+	}
+
+	// Links work once
+	if _, err := s.Consume(tokens[0]); !errors.Is(err, ErrInvalidMagicLink) {
+		t.Errorf("second Consume() error = %v, want %v", err, ErrInvalidMagicLink)
+	}
+	if _, _, err := s.Check(tokens[0]); !errors.Is(err, ErrInvalidMagicLink) {
+		t.Errorf("Check() after use error = %v, want %v", err, ErrInvalidMagicLink)
+	}
+}
+
+func TestMagicLinkService_RejectsInvalidLinks(t *testing.T) {
+	s, _ := newTestMagicLinkService(t)
+	other, _ := newTestMagicLinkService(t)
+	other.key = []byte("another-key")
+
+	_, valid, _, _ := s.issue("reviewer@partner.example.org", time.Now())
+	_, expired, _, _ := s.issue("reviewer@partner.example.org", time.Now().Add(-time.Hour))
+	_, foreign, _, _ := other.issue("reviewer@partner.example.org", time.Now())
+	payload, signature, _ := strings.Cut(valid, ".")
+	_, otherPayload, _, _ := s.issue("attacker@example.org", time.Now())
+	otherPayload, _, _ = strings.Cut(otherPayload, ".")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"Expired", expired},
+		{"Signed with another key", foreign},
+		{"Payload swapped", otherPayload + "." + signature},
+		{"No signature", payload},
+		{"Empty", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Consume(tt.token); !errors.Is(err, ErrInvalidMagicLink) {
+				t.Errorf("Consume() error = %v, want %v", err, ErrInvalidMagicLink)
+			}
+		})
+	}
+}
+
+func TestMagicLinkService_RateLimit(t *testing.T) {
+	s, dir := newTestMagicLinkService(t)
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := s.Send("reviewer@partner.example.org", "192.0.2.1"); err != nil {
+			t.Fatalf("Send() #%d error = %v", i+1, err)
+		}
+	}
+	// The limit is per address, however it is written
+	if _, _, err := s.Send("REVIEWER@partner.example.org", "192.0.2.1"); !errors.Is(err, ErrMagicLinkRateLimited) {
+		t.Errorf("Send() #4 error = %v, want %v", err, ErrMagicLinkRateLimited)
+	}
+	if _, _, err := s.Send("other@partner.example.org", "192.0.2.1"); err != nil {
+		t.Errorf("Send() to another address error = %v", err)
+	}
+	if n := len(sentTokens(t, dir)); n != 4 {
+		t.Errorf("sent %d messages, want 4", n)
+	}
+
+	// Addresses that may not sign in hit the same limit, so the response
+	// does not reveal the allow list
+	for i := 0; i < 3; i++ {
+		if _, _, err := s.Send("attacker@example.org", "192.0.2.2"); !errors.Is(err, ErrMagicLinkNotAllowed) {
+			t.Fatalf("Send() #%d to a not allowed address error = %v, want %v", i+1, err, ErrMagicLinkNotAllowed)
+		}
+	}
+	if _, _, err := s.Send("attacker@example.org", "192.0.2.2"); !errors.Is(err, ErrMagicLinkRateLimited) {
+		t.Errorf("Send() #4 to a not allowed address error = %v, want %v", err, ErrMagicLinkRateLimited)
+	}
+
+	// Sends older than the window no longer count
+	s.mu.Lock()
+	for i := range s.sent["reviewer@partner.example.org"] {
+		s.sent["reviewer@partner.example.org"][i] = time.Now().Add(-2 * time.Hour)
+	}
+	s.mu.Unlock()
+	if _, _, err := s.Send("reviewer@partner.example.org", "192.0.2.1"); err != nil {
+		t.Errorf("Send() after the window error = %v", err)
+	}
+}
+
+func TestMagicLinkService_InvalidEmail(t *testing.T) {
+	s, dir := newTestMagicLinkService(t)
+
+	for _, email := range []string{"", "not-an-address", "Jane <jane@example.com>", "jane@example.com\r\nBcc: all@example.com"} {
+		if _, _, err := s.Send(email, "192.0.2.1"); !errors.Is(err, ErrInvalidEmail) {
+			t.Errorf("Send(%q) error = %v, want %v", email, err, ErrInvalidEmail)
+		}
+	}
+	if n := len(sentTokens(t, dir)); n != 0 {
+		t.Errorf("sent %d messages, want 0", n)
+	}
+}
+
+func TestMagicLinkService_NotAllowed(t *testing.T) {
+	s, dir := newTestMagicLinkService(t)
+
+	tests := []struct {
+		name    string
+		email   string
+		wantErr error
+	}{
+		{"Allowed domain", "reviewer@partner.example.org", nil},
+		{"Allowed address", "Guest@example.net", nil},
+		{"Other address in the domain", "someone@example.net", ErrMagicLinkNotAllowed},
+		{"Other domain", "attacker@example.org", ErrMagicLinkNotAllowed},
+		{"Denied by the login rules", "former@partner.example.org", ErrMagicLinkNotAllowed},
+	}
+
+	sent := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := s.Send(tt.email, "192.0.2.1"); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Send() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil {
+				sent++
+			}
+			if n := len(sentTokens(t, dir)); n != sent {
+				t.Errorf("sent %d messages, want %d", n, sent)
+			}
+		})
+	}
+
+	// Links already sent stop working once the address is taken off the list
+	_, token, _, _ := s.issue("reviewer@partner.example.org", time.Now())
+	s.config.MagicLinkAllowedDomains = nil
+	if _, err := s.Consume(token); !errors.Is(err, ErrMagicLinkNotAllowed) {
+		t.Errorf("Consume() error = %v, want %v", err, ErrMagicLinkNotAllowed)
+	}
+}
+
+func TestMagicLinkService_IPRateLimit(t *testing.T) {
+	s, _ := newTestMagicLinkService(t)
+
+	// Spreading requests over addresses does not get past the IP limit
+	for i := 0; i < 6; i++ {
+		if _, _, err := s.Send(fmt.Sprintf("user%d@partner.example.org", i), "192.0.2.1"); err != nil {
+			t.Fatalf("Send() #%d error = %v", i+1, err)
+		}
+	}
+	if _, _, err := s.Send("user6@partner.example.org", "192.0.2.1"); !errors.Is(err, ErrTooManyAttempts) {
+		t.Errorf("Send() #7 error = %v, want %v", err, ErrTooManyAttempts)
+	}
+	if _, _, err := s.Send("user6@partner.example.org", "192.0.2.2"); err != nil {
+		t.Errorf("Send() from another IP error = %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+)
+
+// MailSender delivers plain text email
+type MailSender interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailSender creates the sender selected by MAIL_SENDER
+func NewMailSender(cfg *config.Config) (MailSender, error) {
+	switch cfg.MailSender {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, errors.New("SMTP_HOST is required for the smtp mail sender")
+		}
+		return NewSMTPMailSender(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	case "file":
+		if cfg.MailFileDir == "" {
+			return nil, errors.New("MAIL_FILE_DIR is required for the file mail sender")
+		}
+		return NewFileMailSender(cfg.MailFileDir, cfg.MailFrom)
+	case "log":
+		return &LogMailSender{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported mail sender: %s", cfg.MailSender)
+	}
+}
+
+// SMTPMailSender sends mail through an SMTP relay. The connection is
+// upgraded with STARTTLS when the server offers it, and credentials are
+// only sent over TLS or to localhost.
+type SMTPMailSender struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailSender creates an SMTP sender. Authentication is skipped when
+// username is empty.
+func NewSMTPMailSender(host, port, username, password, from string) *SMTPMailSender {
+	sender := &SMTPMailSender{
+		addr: net.JoinHostPort(host, port),
+		from: from,
+	}
+	if username != "" {
+		sender.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return sender
+}
+
+// Send sends the message to a single recipient
+func (s *SMTPMailSender) Send(to, subject, body string) error {
+	message, err := formatMail(s.from, to, subject, body)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{to}, message)
+}
+
+// FileMailSender writes each message to its own .eml file instead of
+// sending it
+type FileMailSender struct {
+	dir  string
+	from string
+}
+
+// NewFileMailSender creates a file sender writing to dir, creating it if
+// needed
+func NewFileMailSender(dir, from string) (*FileMailSender, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create mail directory: %w", err)
+	}
+	return &FileMailSender{dir: dir, from: from}, nil
+}
+
+// Send writes the message to a new file named after the time it was sent
+func (s *FileMailSender) Send(to, subject, body string) error {
+	message, err := formatMail(s.from, to, subject, body)
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(suffix) + ".eml"
+	return os.WriteFile(filepath.Join(s.dir, name), message, 0o600)
+}
+
+// LogMailSender writes messages to the server log. Anyone with access to
+// the log can use the links in them, so it is for development only.
+type LogMailSender struct{}
+
+// Send logs the message
+func (s *LogMailSender) Send(to, subject, body string) error {
+	log.Printf("Mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// formatMail builds an RFC 5322 message, refusing header values that could
+// inject headers of their own
+func formatMail(from, to, subject, body string) ([]byte, error) {
+	for _, value := range []string{from, to, subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errors.New("mail header contains a line break")
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
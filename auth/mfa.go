@@ -0,0 +1,444 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	mfaMaxAttempts    = 5
+	recoveryCodeCount = 10
+)
+
+// Authentication method references recorded in the amr claim. Values are
+// from RFC 8176 where one fits; "recovery" marks a recovery code.
+const (
+	AMRFederated    = "fed"
+	AMROTP          = "otp"
+	AMRMFA          = "mfa"
+	AMRRecoveryCode = "recovery"
+)
+
+var (
+	// ErrMFANotEnrolled is returned when the user has no TOTP enrollment
+	ErrMFANotEnrolled = errors.New("MFA is not enrolled")
+	// ErrMFAAlreadyEnrolled is returned when starting an enrollment for a
+	// user who already has a confirmed one
+	ErrMFAAlreadyEnrolled = errors.New("MFA is already enrolled")
+	// ErrMFAInvalidCode is returned for wrong, reused or malformed codes
+	ErrMFAInvalidCode = errors.New("invalid MFA code")
+	// ErrMFAChallengeNotFound is returned for unknown or expired challenges
+	// and for challenges that ran out of attempts
+	ErrMFAChallengeNotFound = errors.New("MFA challenge not found or expired")
+	// ErrMFATooManyAttempts is returned for the last wrong code a challenge
+	// accepts; the challenge is gone and the login must start again
+	ErrMFATooManyAttempts = errors.New("too many invalid MFA codes")
+)
+
+// TOTPEnrollment is what a user needs to add the gateway to an
+// authenticator app
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// PendingLogin is a first-factor login waiting for its second factor
+type PendingLogin struct {
+	User            *models.User
+	State           *LoginState
+	UpstreamIDToken string
+
+	// AMR lists the methods of the first factor, such as fed for an IdP
+	// login or pwd for a local password
+	AMR []string
+
+	// Enrollment is set when MFA is required but the user has not enrolled
+	// yet; the first code they enter confirms it
+	Enrollment *TOTPEnrollment
+
+	// WebAuthn is set when the user can answer with a registered security
+	// key instead of a TOTP code
+	WebAuthn bool
+
+	// RecoveryCodes is set once an enrollment started at login is
+	// confirmed. The challenge stays open until the user has seen them and
+	// continues with FinishChallenge.
+	RecoveryCodes []string
+
+	expiresAt time.Time
+	attempts  int
+	verified  []string // amr values once the second factor was passed
+}
+
+// MFAService manages TOTP enrollment and the challenge step between the
+// provider login and token issuance
+type MFAService struct {
+	config *config.Config
+	store  store.MFAStore
+
+	mu         sync.Mutex
+	challenges map[string]*PendingLogin
+
+	// enrollMu serializes changes to enrollments, so concurrent requests
+	// cannot both accept the same TOTP step or recovery code
+	enrollMu sync.Mutex
+}
+
+// NewMFAService creates a new MFA service
+func NewMFAService(cfg *config.Config, mfaStore store.MFAStore) *MFAService {
+	return &MFAService{
+		config:     cfg,
+		store:      mfaStore,
+		challenges: make(map[string]*PendingLogin),
+	}
+}
+
+// Required reports whether the user must pass a second factor at login:
+// always once enrolled, and otherwise when their roles or account are on
+// the MFA policy lists
+func (s *MFAService) Required(user *models.User) (bool, error) {
+	enrolled, err := s.Enrolled(user.ID)
+	if err != nil || enrolled {
+		return enrolled, err
+	}
+	for _, role := range user.Roles {
+		if containsString(s.config.MFARequiredRoles, role) {
+			return true, nil
+		}
+	}
+	return containsString(s.config.MFARequiredUsers, user.ID) ||
+		(user.Email != "" && containsString(s.config.MFARequiredUsers, user.Email)), nil
+}
+
+// Enrolled reports whether the user has a confirmed TOTP enrollment
+func (s *MFAService) Enrolled(userID string) (bool, error) {
+	enrollment, err := s.store.Get(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return enrollment.Confirmed, nil
+}
+
+// StartEnrollment creates a new TOTP secret for the user, replacing any
+// unconfirmed one. It takes effect once confirmed with a code.
+func (s *MFAService) StartEnrollment(user *models.User) (*TOTPEnrollment, error) {
+	s.enrollMu.Lock()
+	defer s.enrollMu.Unlock()
+
+	enrolled, err := s.Enrolled(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if enrolled {
+		return nil, ErrMFAAlreadyEnrolled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Save(&models.MFAEnrollment{
+		UserID:    user.ID,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		return nil, err
+	}
+	return s.totpEnrollment(user, secret), nil
+}
+
+// ConfirmEnrollment activates a pending enrollment with a code from the
+// authenticator app and returns the user's recovery codes
+func (s *MFAService) ConfirmEnrollment(userID, code string) ([]string, error) {
+	s.enrollMu.Lock()
+	defer s.enrollMu.Unlock()
+
+	enrollment, err := s.store.Get(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if enrollment.Confirmed {
+		return nil, ErrMFAAlreadyEnrolled
+	}
+
+	step, ok := utils.VerifyTOTP(enrollment.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrMFAInvalidCode
+	}
+	enrollment.Confirmed = true
+	enrollment.LastUsedStep = step
+
+	codes, err := setRecoveryCodes(enrollment)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Save(enrollment); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking
+// a current code
+func (s *MFAService) RegenerateRecoveryCodes(userID, code string) ([]string, error) {
+	s.enrollMu.Lock()
+	defer s.enrollMu.Unlock()
+
+	enrollment, _, err := s.verifyLocked(userID, code)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := setRecoveryCodes(enrollment)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.store.Save(enrollment); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable removes the user's enrollment after checking a current code
+func (s *MFAService) Disable(userID, code string) error {
+	s.enrollMu.Lock()
+	defer s.enrollMu.Unlock()
+
+	if _, _, err := s.verifyLocked(userID, code); err != nil {
+		return err
+	}
+	return s.store.Delete(userID)
+}
+
+// StartChallenge parks a provider login until the second factor is passed
+// and returns the challenge ID. Users who must use MFA but have neither a
+// TOTP enrollment nor a security key get a fresh enrollment to confirm
+// during the challenge.
+func (s *MFAService) StartChallenge(pending *PendingLogin) (string, error) {
+	enrolled, err := s.Enrolled(pending.User.ID)
+	if err != nil {
+		return "", err
+	}
+	if !enrolled && !pending.WebAuthn {
+		if pending.Enrollment, err = s.StartEnrollment(pending.User); err != nil {
+			return "", err
+		}
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	pending.expiresAt = time.Now().Add(mfaChallengeTTL)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweepLocked()
+	s.challenges[id] = pending
+	return id, nil
+}
+
+// Challenge returns a pending login by challenge ID
+func (s *MFAService) Challenge(id string) (*PendingLogin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, ok := s.challenges[id]
+	if !ok || time.Now().After(pending.expiresAt) {
+		return nil, ErrMFAChallengeNotFound
+	}
+	return pending, nil
+}
+
+// TakeChallenge consumes a challenge whose second factor was checked
+// elsewhere, such as by a WebAuthn assertion
+func (s *MFAService) TakeChallenge(id string) (*PendingLogin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, ok := s.challenges[id]
+	delete(s.challenges, id)
+	if !ok || time.Now().After(pending.expiresAt) {
+		return nil, ErrMFAChallengeNotFound
+	}
+	return pending, nil
+}
+
+// CompleteChallenge checks the code for a challenge. On success the
+// challenge is consumed and the pending login is returned with the amr
+// values for the token. A challenge that confirmed an enrollment is kept
+// instead, with the new recovery codes in RecoveryCodes, until
+// FinishChallenge is called.
+func (s *MFAService) CompleteChallenge(id, code string) (*PendingLogin, []string, error) {
+	// Take the challenge out while the code is checked, so concurrent
+	// requests cannot both complete it
+	s.mu.Lock()
+	pending, ok := s.challenges[id]
+	delete(s.challenges, id)
+	s.mu.Unlock()
+	if !ok || time.Now().After(pending.expiresAt) {
+		return nil, nil, ErrMFAChallengeNotFound
+	}
+	if pending.verified != nil {
+		// Already passed; the user still has to acknowledge the codes
+		s.putChallenge(id, pending)
+		return pending, pending.verified, nil
+	}
+	pending.attempts++
+
+	method := AMROTP
+	var recoveryCodes []string
+	var err error
+	if pending.Enrollment != nil {
+		recoveryCodes, err = s.ConfirmEnrollment(pending.User.ID, code)
+	} else {
+		s.enrollMu.Lock()
+		_, method, err = s.verifyLocked(pending.User.ID, code)
+		s.enrollMu.Unlock()
+	}
+	if err != nil {
+		// Put the challenge back for another try, unless that was the last
+		if errors.Is(err, ErrMFAInvalidCode) && pending.attempts >= mfaMaxAttempts {
+			return nil, nil, ErrMFATooManyAttempts
+		}
+		s.putChallenge(id, pending)
+		return nil, nil, err
+	}
+
+	amr := append(slices.Clone(pending.AMR), method, AMRMFA)
+	if recoveryCodes != nil {
+		// The codes are shown before the login finishes
+		pending.Enrollment = nil
+		pending.RecoveryCodes = recoveryCodes
+		pending.verified = amr
+		pending.expiresAt = time.Now().Add(mfaChallengeTTL)
+		s.putChallenge(id, pending)
+	}
+	return pending, amr, nil
+}
+
+// FinishChallenge consumes a challenge whose code was accepted by
+// CompleteChallenge but kept open to show recovery codes, and returns the
+// pending login with the amr values for the token
+func (s *MFAService) FinishChallenge(id string) (*PendingLogin, []string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending, ok := s.challenges[id]
+	if !ok || pending.verified == nil || time.Now().After(pending.expiresAt) {
+		return nil, nil, ErrMFAChallengeNotFound
+	}
+	delete(s.challenges, id)
+	return pending, pending.verified, nil
+}
+
+func (s *MFAService) putChallenge(id string, pending *PendingLogin) {
+	s.mu.Lock()
+	s.challenges[id] = pending
+	s.mu.Unlock()
+}
+
+// verifyLocked checks a TOTP or recovery code against a confirmed
+// enrollment, consuming it, and returns the updated enrollment and the
+// method used; s.enrollMu must be held
+func (s *MFAService) verifyLocked(userID, code string) (*models.MFAEnrollment, string, error) {
+	enrollment, err := s.store.Get(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, "", ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if !enrollment.Confirmed {
+		return nil, "", ErrMFANotEnrolled
+	}
+
+	method := ""
+	if step, ok := utils.VerifyTOTP(enrollment.Secret, code, time.Now()); ok && step > enrollment.LastUsedStep {
+		enrollment.LastUsedStep = step
+		method = AMROTP
+	} else if i := matchRecoveryCode(enrollment.RecoveryCodeHashes, code); i >= 0 {
+		enrollment.RecoveryCodeHashes = append(enrollment.RecoveryCodeHashes[:i], enrollment.RecoveryCodeHashes[i+1:]...)
+		method = AMRRecoveryCode
+	} else {
+		return nil, "", ErrMFAInvalidCode
+	}
+
+	if err := s.store.Save(enrollment); err != nil {
+		return nil, "", err
+	}
+	return enrollment, method, nil
+}
+
+func (s *MFAService) totpEnrollment(user *models.User, secret string) *TOTPEnrollment {
+	account := user.Email
+	if account == "" {
+		account = user.ID
+	}
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(s.config.MFAIssuer, account, secret),
+	}
+}
+
+// sweepLocked drops expired challenges; s.mu must be held
+func (s *MFAService) sweepLocked() {
+	now := time.Now()
+	for id, pending := range s.challenges {
+		if now.After(pending.expiresAt) {
+			delete(s.challenges, id)
+		}
+	}
+}
+
+// setRecoveryCodes replaces the enrollment's recovery codes with new ones,
+// storing only their hashes, and returns the codes
+func setRecoveryCodes(enrollment *models.MFAEnrollment) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := randomHex(5)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	enrollment.RecoveryCodeHashes = hashes
+	return codes, nil
+}
+
+// matchRecoveryCode returns the index of the hash matching code, or -1
+func matchRecoveryCode(hashes []string, code string) int {
+	hash := hashRecoveryCode(code)
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			return i
+		}
+	}
+	return -1
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
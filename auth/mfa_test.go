@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+func newTestMFAService() (*MFAService, store.MFAStore) {
+	mfaStore := store.NewMemoryMFAStore()
+	return NewMFAService(&config.Config{
+		MFAIssuer:        "IAG",
+		MFARequiredRoles: []string{"admin"},
+		MFARequiredUsers: []string{"contractor@example.com"},
+	}, mfaStore), mfaStore
+}
+
+// codeAt returns the TOTP code for the step offset from now
+func codeAt(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := utils.TOTPCode(secret, utils.TOTPStep(time.Now())+offset)
+	if err != nil {
+		t.Fatalf("TOTPCode() error = %v", err)
+	}
+	return code
+}
+
+func TestMFAService_Required(t *testing.T) {
+	m, mfaStore := newTestMFAService()
+	mfaStore.Save(&models.MFAEnrollment{UserID: "enrolled", Secret: "ABCDEF", Confirmed: true})
+	mfaStore.Save(&models.MFAEnrollment{UserID: "unconfirmed", Secret: "ABCDEF"})
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		expected bool
+	}{
+		{"Plain user", &models.User{ID: "u1", Roles: []string{"user"}}, false},
+		{"Required role", &models.User{ID: "u2", Roles: []string{"user", "admin"}}, true},
+		{"Required email", &models.User{ID: "u3", Email: "contractor@example.com"}, true},
+		{"Enrolled", &models.User{ID: "enrolled"}, true},
+		{"Unconfirmed enrollment", &models.User{ID: "unconfirmed"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := m.Required(tt.user)
+			if err != nil {
+				t.Fatalf("Required() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Required() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMFAService_EnrollAndChallenge(t *testing.T) {
+	m, _ := newTestMFAService()
+	user := &models.User{ID: "123", Email: "user@example.com"}
+
+	enrollment, err := m.StartEnrollment(user)
+	if err != nil {
+		t.Fatalf("StartEnrollment() error = %v", err)
+	}
+	if _, err := m.ConfirmEnrollment(user.ID, "000000"); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Fatalf("ConfirmEnrollment() with wrong code error = %v, want %v", err, ErrMFAInvalidCode)
+	}
+	recoveryCodes, err := m.ConfirmEnrollment(user.ID, codeAt(t, enrollment.Secret, -1))
+	if err != nil {
+		t.Fatalf("ConfirmEnrollment() error = %v", err)
+	}
+	if len(recoveryCodes) != recoveryCodeCount {
+		t.Fatalf("ConfirmEnrollment() returned %d recovery codes, want %d", len(recoveryCodes), recoveryCodeCount)
+	}
+	if _, err := m.StartEnrollment(user); !errors.Is(err, ErrMFAAlreadyEnrolled) {
+		t.Errorf("StartEnrollment() when enrolled error = %v, want %v", err, ErrMFAAlreadyEnrolled)
+	}
+
+	// The code used to confirm cannot be replayed, a later one works
+	id, err := m.StartChallenge(&PendingLogin{User: user, AMR: []string{AMRFederated}})
+	if err != nil {
+		t.Fatalf("StartChallenge() error = %v", err)
+	}
+	if _, _, err := m.CompleteChallenge(id, codeAt(t, enrollment.Secret, -1)); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Fatalf("CompleteChallenge() with replayed code error = %v, want %v", err, ErrMFAInvalidCode)
+	}
+	pending, amr, err := m.CompleteChallenge(id, codeAt(t, enrollment.Secret, 0))
+	if err != nil {
+		t.Fatalf("CompleteChallenge() error = %v", err)
+	}
+	if pending.User.ID != user.ID || !slices.Equal(amr, []string{AMRFederated, AMROTP, AMRMFA}) {
+		t.Errorf("CompleteChallenge() = %s, %v, want %s, [fed otp mfa]", pending.User.ID, amr, user.ID)
+	}
+	if _, err := m.Challenge(id); !errors.Is(err, ErrMFAChallengeNotFound) {
+		t.Errorf("Challenge() after completion error = %v, want %v", err, ErrMFAChallengeNotFound)
+	}
+
+	// Recovery codes work once
+	id, _ = m.StartChallenge(&PendingLogin{User: user, AMR: []string{AMRFederated}})
+	if _, amr, err = m.CompleteChallenge(id, recoveryCodes[0]); err != nil || amr[1] != AMRRecoveryCode {
+		t.Fatalf("CompleteChallenge() with recovery code = %v, %v", amr, err)
+	}
+	id, _ = m.StartChallenge(&PendingLogin{User: user, AMR: []string{AMRFederated}})
+	if _, _, err := m.CompleteChallenge(id, recoveryCodes[0]); !errors.Is(err, ErrMFAInvalidCode) {
+		t.Errorf("CompleteChallenge() with used recovery code error = %v, want %v", err, ErrMFAInvalidCode)
+	}
+}
+
+func TestMFAService_ChallengeEnrollsRequiredUser(t *testing.T) {
+	m, _ := newTestMFAService()
+	user := &models.User{ID: "admin-1", Roles: []string{"admin"}}
+
+	id, err := m.StartChallenge(&PendingLogin{User: user, AMR: []string{AMRFederated}})
+	if err != nil {
+		t.Fatalf("StartChallenge() error = %v", err)
+	}
+	pending, err := m.Challenge(id)
+	if err != nil {
+		t.Fatalf("Challenge() error = %v", err)
+	}
+	if pending.Enrollment == nil {
+		t.Fatal("Challenge().Enrollment = nil, want an enrollment for an unenrolled user")
+	}
+
+	completed, _, err := m.CompleteChallenge(id, codeAt(t, pending.Enrollment.Secret, 0))
+	if err != nil {
+		t.Fatalf("CompleteChallenge() error = %v", err)
+	}
+	if enrolled, _ := m.Enrolled(user.ID); !enrolled {
+		t.Error("Enrolled() = false after completing the enrollment challenge, want true")
+	}
+	if len(completed.RecoveryCodes) != recoveryCodeCount || completed.Enrollment != nil {
+		t.Fatalf("CompleteChallenge() returned %d recovery codes, want %d", len(completed.RecoveryCodes), recoveryCodeCount)
+	}
+
+	// The challenge stays open until the user has seen the codes
+	if _, err := m.Challenge(id); err != nil {
+		t.Fatalf("Challenge() before FinishChallenge error = %v", err)
+	}
+	finished, amr, err := m.FinishChallenge(id)
+	if err != nil {
+		t.Fatalf("FinishChallenge() error = %v", err)
+	}
+	if finished.User.ID != user.ID || !slices.Equal(amr, []string{AMRFederated, AMROTP, AMRMFA}) {
+		t.Errorf("FinishChallenge() = %s, %v, want %s, [fed otp mfa]", finished.User.ID, amr, user.ID)
+	}
+	if _, _, err := m.FinishChallenge(id); !errors.Is(err, ErrMFAChallengeNotFound) {
+		t.Errorf("FinishChallenge() twice error = %v, want %v", err, ErrMFAChallengeNotFound)
+	}
+
+	// Ordinary challenges cannot skip the code
+	id, _ = m.StartChallenge(&PendingLogin{User: user, AMR: []string{AMRFederated}})
+	if _, _, err := m.FinishChallenge(id); !errors.Is(err, ErrMFAChallengeNotFound) {
+		t.Errorf("FinishChallenge() without a code error = %v, want %v", err, ErrMFAChallengeNotFound)
+	}
+}
+
+func TestMFAService_ChallengeAttemptLimit(t *testing.T) {
+	m, _ := newTestMFAService()
+	user := &models.User{ID: "123"}
+	enrollment, _ := m.StartEnrollment(user)
+	m.ConfirmEnrollment(user.ID, codeAt(t, enrollment.Secret, -1))
+
+	id, _ := m.StartChallenge(&PendingLogin{User: user, AMR: []string{AMRFederated}})
+	for i := 1; i < mfaMaxAttempts; i++ {
+		if _, _, err := m.CompleteChallenge(id, "000000"); !errors.Is(err, ErrMFAInvalidCode) {
+			t.Fatalf("CompleteChallenge() attempt %d error = %v, want %v", i, err, ErrMFAInvalidCode)
+		}
+	}
+	if _, _, err := m.CompleteChallenge(id, "000000"); !errors.Is(err, ErrMFATooManyAttempts) {
+		t.Fatalf("CompleteChallenge() last attempt error = %v, want %v", err, ErrMFATooManyAttempts)
+	}
+	if _, _, err := m.CompleteChallenge(id, codeAt(t, enrollment.Secret, 0)); !errors.Is(err, ErrMFAChallengeNotFound) {
+		t.Errorf("CompleteChallenge() after too many attempts error = %v, want %v", err, ErrMFAChallengeNotFound)
+	}
+}
+
+// slowMFAStore delays the return of reads, so concurrent code checks
+// overlap and act on the same enrollment
+type slowMFAStore struct {
+	store.MFAStore
+}
+
+func (s slowMFAStore) Get(userID string) (*models.MFAEnrollment, error) {
+	enrollment, err := s.MFAStore.Get(userID)
+	time.Sleep(20 * time.Millisecond)
+	return enrollment, err
+}
+
+func TestMFAService_ChallengeCompletesOnce(t *testing.T) {
+	m, mfaStore := newTestMFAService()
+	user := &models.User{ID: "123"}
+	enrollment, _ := m.StartEnrollment(user)
+	recoveryCodes, _ := m.ConfirmEnrollment(user.ID, codeAt(t, enrollment.Secret, -1))
+	m.store = slowMFAStore{mfaStore}
+
+	// Each request has a valid code of its own, but only one may complete
+	// the challenge
+	id, _ := m.StartChallenge(&PendingLogin{User: user, AMR: []string{AMRFederated}})
+	var wg sync.WaitGroup
+	var completed atomic.Int32
+	for _, code := range recoveryCodes {
+		wg.Add(1)
+		go func(code string) {
+			defer wg.Done()
+			if _, _, err := m.CompleteChallenge(id, code); err == nil {
+				completed.Add(1)
+			}
+		}(code)
+	}
+	wg.Wait()
+	if n := completed.Load(); n != 1 {
+		t.Errorf("CompleteChallenge() succeeded %d times concurrently, want 1", n)
+	}
+}
+
+func TestMFAService_CodeAcceptedOnceAcrossChallenges(t *testing.T) {
+	m, mfaStore := newTestMFAService()
+	user := &models.User{ID: "123"}
+	enrollment, _ := m.StartEnrollment(user)
+	recoveryCodes, _ := m.ConfirmEnrollment(user.ID, codeAt(t, enrollment.Secret, -1))
+	m.store = slowMFAStore{mfaStore}
+
+	// Separate logins racing with the same TOTP code or recovery code may
+	// not both be let in
+	for _, code := range []string{codeAt(t, enrollment.Secret, 0), recoveryCodes[0]} {
+		ids := make([]string, 5)
+		for i := range ids {
+			ids[i], _ = m.StartChallenge(&PendingLogin{User: user, AMR: []string{AMRFederated}})
+		}
+		var wg sync.WaitGroup
+		var completed atomic.Int32
+		for _, id := range ids {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, _, err := m.CompleteChallenge(id, code); err == nil {
+					completed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := completed.Load(); n != 1 {
+			t.Errorf("CompleteChallenge() with code %s succeeded %d times concurrently, want 1", code, n)
+		}
+	}
+}
+
+func TestMFAService_SecurityKeyChallenge(t *testing.T) {
+	m, _ := newTestMFAService()
+	user := &models.User{ID: "admin-1", Roles: []string{"admin"}}
+
+	// A registered security key satisfies the MFA policy without TOTP
+	id, err := m.StartChallenge(&PendingLogin{User: user, WebAuthn: true})
+	if err != nil {
+		t.Fatalf("StartChallenge() error = %v", err)
+	}
+	pending, err := m.TakeChallenge(id)
+	if err != nil {
+		t.Fatalf("TakeChallenge() error = %v", err)
+	}
+	if pending.Enrollment != nil {
+		t.Error("TakeChallenge().Enrollment != nil, want no TOTP enrollment for a security key user")
+	}
+	if _, err := m.TakeChallenge(id); !errors.Is(err, ErrMFAChallengeNotFound) {
+		t.Errorf("TakeChallenge() twice error = %v, want %v", err, ErrMFAChallengeNotFound)
+	}
+}
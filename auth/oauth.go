@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/Hilina-t/microservice-authenticator/config"
@@ -17,6 +19,7 @@ import (
 type OAuthService struct {
 	config      *config.Config
 	oauthConfig *oauth2.Config
+	keys        *ProviderKeys
 }
 
 // NewOAuthService creates a new OAuth service
@@ -35,23 +38,76 @@ func NewOAuthService(cfg *config.Config) *OAuthService {
 	return &OAuthService{
 		config:      cfg,
 		oauthConfig: oauthConfig,
+		keys:        NewProviderKeys(cfg.OAuthJWKSURL),
 	}
 }
 
-// GetAuthURL returns the authorization URL for OAuth flow
-func (s *OAuthService) GetAuthURL(state string) string {
-	return s.oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline)
+// ProviderKeys returns the cache of the provider's signing keys, which
+// verifies ID tokens and can be shared with other verifiers
+func (s *OAuthService) ProviderKeys() *ProviderKeys {
+	return s.keys
 }
 
-// ExchangeCode exchanges the authorization code for tokens
-func (s *OAuthService) ExchangeCode(ctx context.Context, code string) (*oauth2.Token, error) {
-	token, err := s.oauthConfig.Exchange(ctx, code)
+// GetAuthURL returns the authorization URL for OAuth flow, with the login's
+// OIDC nonce, the S256 PKCE challenge for its verifier and, for step-up
+// logins, its max_age
+func (s *OAuthService) GetAuthURL(state string, login *LoginState) string {
+	opts := []oauth2.AuthCodeOption{
+		oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("nonce", login.Nonce),
+		oauth2.S256ChallengeOption(login.CodeVerifier),
+	}
+	// Google preselects the account of a single allowed Workspace domain.
+	// It is only a hint; the callback checks the hd claim.
+	if s.config.OAuthProvider == "google" && len(s.config.LoginAllowedGoogleHD) == 1 {
+		opts = append(opts, oauth2.SetAuthURLParam("hd", s.config.LoginAllowedGoogleHD[0]))
+	}
+	if login.MaxAge >= 0 {
+		opts = append(opts, oauth2.SetAuthURLParam("max_age", strconv.Itoa(login.MaxAge)))
+		if login.MaxAge == 0 {
+			opts = append(opts, oauth2.SetAuthURLParam("prompt", "login"))
+		}
+	}
+	return s.oauthConfig.AuthCodeURL(state, opts...)
+}
+
+// ExchangeCode exchanges the authorization code for tokens, proving
+// possession of the PKCE verifier
+func (s *OAuthService) ExchangeCode(ctx context.Context, code, verifier string) (*oauth2.Token, error) {
+	token, err := s.oauthConfig.Exchange(ctx, code, oauth2.VerifierOption(verifier))
 	if err != nil {
 		return nil, fmt.Errorf("failed to exchange code: %w", err)
 	}
 	return token, nil
 }
 
+// IDToken returns the provider's ID token from a token response, if any
+func (s *OAuthService) IDToken(token *oauth2.Token) string {
+	idToken, _ := token.Extra("id_token").(string)
+	return idToken
+}
+
+// EndSessionURL returns the provider URL that ends the user's provider
+// session (OIDC RP-initiated logout), or "" if the provider has none
+func (s *OAuthService) EndSessionURL(idTokenHint string) string {
+	if s.config.OAuthEndSessionURL == "" {
+		return ""
+	}
+
+	query := url.Values{}
+	if idTokenHint != "" {
+		query.Set("id_token_hint", idTokenHint)
+	}
+	if s.config.PostLogoutRedirectURL != "" {
+		query.Set("post_logout_redirect_uri", s.config.PostLogoutRedirectURL)
+		query.Set("client_id", s.config.OAuthClientID)
+	}
+	if len(query) == 0 {
+		return s.config.OAuthEndSessionURL
+	}
+	return s.config.OAuthEndSessionURL + "?" + query.Encode()
+}
+
 // GetUserInfo fetches user information from the provider
 func (s *OAuthService) GetUserInfo(ctx context.Context, token *oauth2.Token) (*models.User, error) {
 	client := s.oauthConfig.Client(ctx, token)
@@ -81,14 +137,18 @@ func (s *OAuthService) GetUserInfo(ctx context.Context, token *oauth2.Token) (*m
 	case "google":
 		user.ID = getStringField(userInfo, "id")
 		user.Email = getStringField(userInfo, "email")
+		user.EmailVerified = getBoolField(userInfo, "verified_email")
 		user.Name = getStringField(userInfo, "name")
 		user.Picture = getStringField(userInfo, "picture")
 	case "okta":
 		user.ID = getStringField(userInfo, "sub")
 		user.Email = getStringField(userInfo, "email")
+		user.EmailVerified = getBoolField(userInfo, "email_verified")
 		user.Name = getStringField(userInfo, "name")
 		user.Picture = getStringField(userInfo, "picture")
 	case "azure":
+		// Graph does not say whether mail was verified, and tenant admins
+		// can set it to any address, so it is never taken as verified
 		user.ID = getStringField(userInfo, "id")
 		user.Email = getStringField(userInfo, "mail")
 		if user.Email == "" {
@@ -113,3 +173,12 @@ func getStringField(data map[string]interface{}, field string) string {
 	}
 	return ""
 }
+
+func getBoolField(data map[string]interface{}, field string) bool {
+	if val, ok := data[field]; ok {
+		if b, ok := val.(bool); ok {
+			return b
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+)
+
+func TestEndSessionURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		endpoint    string
+		postLogout  string
+		idTokenHint string
+		want        string
+	}{
+		{"No endpoint", "", "https://app.example.com/", "id-token", ""},
+		{"Endpoint only", "https://idp.example.com/logout", "", "", "https://idp.example.com/logout"},
+		{"With hint", "https://idp.example.com/logout", "", "id-token", "https://idp.example.com/logout?id_token_hint=id-token"},
+		{
+			"With hint and redirect",
+			"https://idp.example.com/logout",
+			"https://app.example.com/",
+			"id-token",
+			"https://idp.example.com/logout?client_id=client&id_token_hint=id-token&post_logout_redirect_uri=https%3A%2F%2Fapp.example.com%2F",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewOAuthService(&config.Config{
+				OAuthClientID:         "client",
+				OAuthEndSessionURL:    tt.endpoint,
+				PostLogoutRedirectURL: tt.postLogout,
+			})
+			if got := s.EndSessionURL(tt.idTokenHint); got != tt.want {
+				t.Errorf("EndSessionURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+// PasswordHasher hashes and verifies passwords with Argon2id. Local and
+// break-glass accounts share one hasher, so that at most the configured
+// number of hashes run at once and a burst of logins queues instead of
+// using their memory many times over.
+type PasswordHasher struct {
+	params utils.Argon2Params
+	slots  chan struct{}
+}
+
+// NewPasswordHasher creates a hasher with the configured Argon2id
+// parameters and concurrency
+func NewPasswordHasher(cfg *config.Config) (*PasswordHasher, error) {
+	params := utils.DefaultArgon2Params
+	params.Memory = uint32(cfg.LocalArgon2Memory)
+	params.Iterations = uint32(cfg.LocalArgon2Iterations)
+	params.Parallelism = uint8(cfg.LocalArgon2Parallelism)
+	if params.Memory < 8*uint32(params.Parallelism) || params.Iterations == 0 || params.Parallelism == 0 {
+		return nil, fmt.Errorf("invalid Argon2 parameters: memory=%d iterations=%d parallelism=%d",
+			params.Memory, params.Iterations, params.Parallelism)
+	}
+	if cfg.LocalArgon2MaxConcurrency < 1 {
+		return nil, fmt.Errorf("invalid Argon2 concurrency: %d", cfg.LocalArgon2MaxConcurrency)
+	}
+
+	return &PasswordHasher{
+		params: params,
+		slots:  make(chan struct{}, cfg.LocalArgon2MaxConcurrency),
+	}, nil
+}
+
+// Hash hashes a password with the configured parameters
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+	return utils.HashPassword(password, h.params)
+}
+
+// Verify checks a password against a hash made with any parameters
+func (h *PasswordHasher) Verify(password, hash string) (bool, error) {
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+	return utils.VerifyPassword(password, hash)
+}
+
+// NeedsRehash reports whether a hash was made with other parameters than
+// the configured ones
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	return utils.PasswordNeedsRehash(hash, h.params)
+}
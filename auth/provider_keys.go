@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+// providerKeysMinRefresh limits how often an unknown kid triggers a refetch,
+// so forged tokens cannot make the gateway hammer the provider
+const providerKeysMinRefresh = time.Minute
+
+// ProviderKeys caches the upstream provider's signing keys from its JWKS
+// endpoint, refetching when a token names a key it has not seen
+type ProviderKeys struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewProviderKeys creates a key cache for the JWKS document at url
+func NewProviderKeys(url string) *ProviderKeys {
+	return &ProviderKeys{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+}
+
+// Key returns the provider key with the given ID
+func (p *ProviderKeys) Key(kid string) (*rsa.PublicKey, error) {
+	if p.url == "" {
+		return nil, errors.New("provider JWKS URL is not configured")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.fetchedAt) < providerKeysMinRefresh {
+		return nil, fmt.Errorf("unknown key ID: %s", kid)
+	}
+	if err := p.fetchLocked(); err != nil {
+		return nil, err
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key ID: %s", kid)
+}
+
+// fetchLocked replaces the cached keys with the current JWKS; p.mu must be held
+func (p *ProviderKeys) fetchLocked() error {
+	p.fetchedAt = time.Now()
+
+	resp, err := p.client.Get(p.url)
+	if err != nil {
+		return fmt.Errorf("failed to fetch provider keys: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch provider keys: status %d", resp.StatusCode)
+	}
+
+	var jwks struct {
+		Keys []utils.JWK `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return fmt.Errorf("failed to decode provider keys: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.RSAPublicKey()
+		if err != nil {
+			// Skip key types we do not verify with, such as EC keys
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	p.keys = keys
+	return nil
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ValidateReturnTo checks a post-login redirect target against the
+// allowlist to prevent open redirects. Local paths on the gateway are always
+// allowed; absolute URLs must match an allowlist entry of the form
+// "https://app.example.com/path", where the host may start with "*." to
+// match subdomains and the path is a prefix matched on segment boundaries.
+// Targets must parse as URLs and have a clean path, and may not contain
+// control characters or backslashes, which browsers strip or treat as
+// slashes, so the target cannot resolve to another host or path.
+func ValidateReturnTo(allowlist []string, target string) error {
+	if strings.ContainsFunc(target, func(r rune) bool { return r < 0x20 || r == 0x7f || r == '\\' }) {
+		return errors.New("return_to must not contain control characters or backslashes")
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return errors.New("return_to is not a valid URL")
+	}
+	if !isCleanPath(u.Path) {
+		return errors.New("return_to path must not contain dot segments or repeated slashes")
+	}
+
+	if isLocalPath(target) {
+		return nil
+	}
+
+	if !u.IsAbs() || u.Host == "" {
+		return errors.New("return_to must be a local path or an absolute URL")
+	}
+	if u.User != nil {
+		return errors.New("return_to must not contain credentials")
+	}
+
+	for _, entry := range allowlist {
+		if matchesReturnToEntry(entry, u) {
+			return nil
+		}
+	}
+	return errors.New("return_to is not in the allowlist")
+}
+
+func matchesReturnToEntry(entry string, target *url.URL) bool {
+	allowed, err := url.Parse(entry)
+	if err != nil || allowed.Scheme != target.Scheme {
+		return false
+	}
+
+	host := strings.ToLower(target.Host)
+	allowedHost := strings.ToLower(allowed.Host)
+	if suffix, ok := strings.CutPrefix(allowedHost, "*."); ok {
+		if !strings.HasSuffix(host, "."+suffix) {
+			return false
+		}
+	} else if host != allowedHost {
+		return false
+	}
+
+	prefix := allowed.Path
+	if prefix == "" || prefix == "/" {
+		return true
+	}
+	path := target.Path
+	if path == "" {
+		path = "/"
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// isLocalPath reports whether target is a path on this origin, rejecting
+// the scheme-relative form browsers treat as another host. Backslashes are
+// rejected by the caller.
+func isLocalPath(target string) bool {
+	return strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//")
+}
+
+// isCleanPath reports whether a decoded URL path is already in canonical
+// form, so a prefix match on it cannot be escaped with ".." segments. A
+// trailing slash is allowed.
+func isCleanPath(p string) bool {
+	if p == "" {
+		return true
+	}
+	clean := path.Clean(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	return clean == p
+}
@@ -0,0 +1,50 @@
+package auth
+
+import "testing"
+
+func TestValidateReturnTo(t *testing.T) {
+	allowlist := []string{
+		"https://app.example.com/",
+		"https://*.internal.example.com/portal",
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		wantErr bool
+	}{
+		{"Local path", "/dashboard", false},
+		{"Allowed host", "https://app.example.com/settings?tab=1", false},
+		{"Allowed subdomain and path", "https://hr.internal.example.com/portal/me", false},
+		{"Exact path prefix", "https://hr.internal.example.com/portal", false},
+		{"Path prefix without boundary", "https://hr.internal.example.com/portal-evil", true},
+		{"Path outside prefix", "https://hr.internal.example.com/other", true},
+		{"Wildcard does not match apex", "https://internal.example.com/portal", true},
+		{"Other host", "https://evil.example.com/", true},
+		{"Suffix trick", "https://app.example.com.evil.com/", true},
+		{"Scheme mismatch", "http://app.example.com/", true},
+		{"Scheme relative", "//evil.example.com/", true},
+		{"Backslash", "/\\evil.example.com", true},
+		{"Credentials", "https://user@app.example.com/", true},
+		{"Javascript", "javascript:alert(1)", true},
+		{"Tab before slash", "/\t/evil.com", true},
+		{"Newline", "/dashboard\n", true},
+		{"Backslash in path", "/a\\b", true},
+		{"Invalid escape", "/%zz", true},
+		{"Dot segments escape prefix", "https://hr.internal.example.com/portal/../admin", true},
+		{"Encoded dot segments", "https://hr.internal.example.com/portal/%2e%2e/admin", true},
+		{"Repeated slash", "https://hr.internal.example.com/portal//x", true},
+		{"Local dot segments", "/portal/../admin", true},
+		{"Trailing slash", "https://hr.internal.example.com/portal/", false},
+		{"Local path with query", "/dashboard?tab=../x", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateReturnTo(allowlist, tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateReturnTo(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+		})
+	}
+}
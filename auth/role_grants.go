@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// roleGrantSystemActor is the audit actor of grants removed on expiry
+const roleGrantSystemActor = "system"
+
+// ErrInvalidRoleGrant is wrapped by errors for grants that are not allowed
+var ErrInvalidRoleGrant = errors.New("invalid role grant")
+
+// ErrNoActiveRoleGrant is returned by Apply when the user's roles are
+// replaced by grants and none of them is in force
+var ErrNoActiveRoleGrant = errors.New("no active role grant")
+
+// RoleGrantService manages roles granted to users for a validity window.
+// While a grant is in force its role is added to tokens issued to the user,
+// and the tokens expire when the first such role ends. Grants are removed
+// once they expire, so temporary access needs no manual cleanup, except
+// grants that replace the user's roles: those are kept until revoked, so
+// the user cannot log in once they have all ended.
+type RoleGrantService struct {
+	config *config.Config
+	store  store.RoleGrantStore
+	audit  *Audit
+
+	// mu serializes removal of expired grants, so each is audited once
+	mu sync.Mutex
+}
+
+// NewRoleGrantService creates a new role grant service
+func NewRoleGrantService(cfg *config.Config, grantStore store.RoleGrantStore, audit *Audit) *RoleGrantService {
+	return &RoleGrantService{
+		config: cfg,
+		store:  grantStore,
+		audit:  audit,
+	}
+}
+
+// Grant gives the user in the grant its role for the grant's window
+func (s *RoleGrantService) Grant(granter *models.User, grant *models.RoleGrant) (*models.RoleGrant, error) {
+	now := time.Now()
+	grant.Role = strings.TrimSpace(grant.Role)
+	grant.Reason = strings.TrimSpace(grant.Reason)
+	switch {
+	case grant.UserID == "":
+		return nil, fmt.Errorf("%w: user_id is required", ErrInvalidRoleGrant)
+	case grant.Role == "":
+		return nil, fmt.Errorf("%w: role is required", ErrInvalidRoleGrant)
+	case !grant.NotAfter.IsZero() && !grant.NotAfter.After(now):
+		return nil, fmt.Errorf("%w: not_after is in the past", ErrInvalidRoleGrant)
+	case !grant.NotAfter.IsZero() && !grant.NotAfter.After(grant.NotBefore):
+		return nil, fmt.Errorf("%w: not_after must be after not_before", ErrInvalidRoleGrant)
+	case len(grant.Reason) > maxJustificationLength:
+		return nil, fmt.Errorf("%w: reason is longer than %d characters", ErrInvalidRoleGrant, maxJustificationLength)
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	grant.ID = id
+	grant.GrantedBy = granter.ID
+	grant.GrantedAt = now
+	if err := s.store.Save(grant); err != nil {
+		return nil, err
+	}
+
+	details := roleGrantDetails(grant)
+	if grant.Reason != "" {
+		details["reason"] = grant.Reason
+	}
+	return grant, s.audit.Record(models.AuditRoleGrantCreated, granter.ID, grant.UserID, details)
+}
+
+// Revoke removes a grant before it expires. Tokens already issued keep
+// the role until they expire.
+func (s *RoleGrantService) Revoke(revoker *models.User, id string) error {
+	grant, err := s.store.Get(id)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Delete(id); err != nil {
+		return err
+	}
+	return s.audit.Record(models.AuditRoleGrantRevoked, revoker.ID, grant.UserID, roleGrantDetails(grant))
+}
+
+// List returns the grants that have not expired and the expired grants
+// that replace roles, of all users or of the user when userID is not
+// empty, oldest first
+func (s *RoleGrantService) List(userID string) ([]*models.RoleGrant, error) {
+	grants, err := s.store.List(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.removeExpired(grants, time.Now())
+}
+
+// Apply returns the user with the roles of their grants in force added as
+// time-bound roles, for a token about to be issued. Grants for roles the
+// user already holds are skipped, so they do not cut tokens short. When a
+// grant replaces roles, only the roles of grants in force are kept, and
+// ErrNoActiveRoleGrant is returned if there are none.
+func (s *RoleGrantService) Apply(user *models.User) (*models.User, error) {
+	now := time.Now()
+	grants, err := s.store.List(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if grants, err = s.removeExpired(grants, now); err != nil {
+		return nil, err
+	}
+
+	granted := *user
+	replaced := false
+	for _, grant := range grants {
+		replaced = replaced || grant.ReplaceRoles
+	}
+	if replaced {
+		granted.Roles = nil
+	}
+	for _, grant := range grants {
+		if grant.ActiveAt(now) {
+			granted.AddTimedRole(grant.Role, grant.NotAfter)
+		}
+	}
+	if replaced && len(granted.Roles) == 0 {
+		return nil, ErrNoActiveRoleGrant
+	}
+	return &granted, nil
+}
+
+// removeExpired deletes and audits the expired grants, and returns the rest.
+// Grants that replace roles are kept, as they still deny the user's own
+// roles.
+func (s *RoleGrantService) removeExpired(grants []*models.RoleGrant, now time.Time) ([]*models.RoleGrant, error) {
+	kept := grants[:0]
+	for _, grant := range grants {
+		if !grant.ExpiredAt(now) || grant.ReplaceRoles {
+			kept = append(kept, grant)
+			continue
+		}
+
+		s.mu.Lock()
+		err := s.store.Delete(grant.ID)
+		if err == nil {
+			err = s.audit.Record(models.AuditRoleGrantExpired, roleGrantSystemActor, grant.UserID, roleGrantDetails(grant))
+		} else if errors.Is(err, store.ErrNotFound) {
+			// Removed concurrently, and audited there
+			err = nil
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return kept, nil
+}
+
+func roleGrantDetails(grant *models.RoleGrant) map[string]string {
+	details := map[string]string{
+		"grant_id": grant.ID,
+		"role":     grant.Role,
+	}
+	if !grant.NotBefore.IsZero() {
+		details["not_before"] = grant.NotBefore.UTC().Format(time.RFC3339)
+	}
+	if !grant.NotAfter.IsZero() {
+		details["not_after"] = grant.NotAfter.UTC().Format(time.RFC3339)
+	}
+	return details
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+func newTestRoleGrantService() (*RoleGrantService, *Audit) {
+	audit := NewAudit(store.NewMemoryAuditStore())
+	return NewRoleGrantService(&config.Config{}, store.NewMemoryRoleGrantStore(), audit), audit
+}
+
+func TestRoleGrantService_Grant(t *testing.T) {
+	s, _ := newTestRoleGrantService()
+	admin := &models.User{ID: "admin-1", Roles: []string{"admin"}}
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		userID    string
+		role      string
+		notBefore time.Time
+		notAfter  time.Time
+		wantErr   bool
+	}{
+		{"Valid", "jane", "editor", time.Time{}, now.Add(time.Hour), false},
+		{"No end", "jane", "viewer", time.Time{}, time.Time{}, false},
+		{"No user", "", "editor", time.Time{}, now.Add(time.Hour), true},
+		{"No role", "jane", " ", time.Time{}, now.Add(time.Hour), true},
+		{"Ended", "jane", "editor", time.Time{}, now.Add(-time.Hour), true},
+		{"Ends before start", "jane", "editor", now.Add(2 * time.Hour), now.Add(time.Hour), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grant, err := s.Grant(admin, &models.RoleGrant{
+				UserID:    tt.userID,
+				Role:      tt.role,
+				NotBefore: tt.notBefore,
+				NotAfter:  tt.notAfter,
+			})
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRoleGrant) {
+					t.Errorf("Grant() error = %v, want %v", err, ErrInvalidRoleGrant)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Grant() error = %v", err)
+			}
+			if grant.ID == "" || grant.GrantedBy != "admin-1" {
+				t.Errorf("Grant() = %+v, want an ID and granted by admin-1", grant)
+			}
+		})
+	}
+}
+
+func TestRoleGrantService_Apply(t *testing.T) {
+	s, _ := newTestRoleGrantService()
+	admin := &models.User{ID: "admin-1", Roles: []string{"admin"}}
+	jane := &models.User{ID: "jane", Roles: []string{"user"}}
+	now := time.Now()
+
+	s.Grant(admin, &models.RoleGrant{UserID: "jane", Role: "editor", NotAfter: now.Add(2 * time.Hour)})
+	billing, _ := s.Grant(admin, &models.RoleGrant{UserID: "jane", Role: "billing", NotAfter: now.Add(time.Hour)})
+	s.Grant(admin, &models.RoleGrant{UserID: "jane", Role: "viewer"})
+	s.Grant(admin, &models.RoleGrant{UserID: "jane", Role: "auditor", NotBefore: now.Add(time.Hour), NotAfter: now.Add(2 * time.Hour)})
+	// A grant of a role already held does not cut tokens short
+	s.Grant(admin, &models.RoleGrant{UserID: "jane", Role: "user", NotAfter: now.Add(time.Minute)})
+
+	granted, err := s.Apply(jane)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	for _, role := range []string{"user", "editor", "billing", "viewer"} {
+		if !granted.HasRole(role) {
+			t.Errorf("Apply() roles = %v, want %s", granted.Roles, role)
+		}
+	}
+	if granted.HasRole("auditor") {
+		t.Errorf("Apply() roles = %v, want no auditor before not_before", granted.Roles)
+	}
+	if !granted.RolesExpireAt.Equal(billing.NotAfter) {
+		t.Errorf("Apply() roles expire at %v, want the earliest not_after %v", granted.RolesExpireAt, billing.NotAfter)
+	}
+	if len(jane.Roles) != 1 {
+		t.Errorf("Apply() modified the user passed in: %v", jane.Roles)
+	}
+}
+
+func TestRoleGrantService_Expiry(t *testing.T) {
+	s, audit := newTestRoleGrantService()
+	admin := &models.User{ID: "admin-1", Roles: []string{"admin"}}
+
+	// Grants that have ended are removed without manual cleanup
+	s.store.Save(&models.RoleGrant{
+		ID:        "expired",
+		UserID:    "jane",
+		Role:      "editor",
+		NotAfter:  time.Now().Add(-time.Minute),
+		GrantedAt: time.Now().Add(-time.Hour),
+	})
+	granted, err := s.Apply(&models.User{ID: "jane"})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if granted.HasRole("editor") {
+		t.Error("Apply() with an expired grant added editor")
+	}
+	if _, err := s.store.Get("expired"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("store.Get() of an expired grant error = %v, want %v", err, store.ErrNotFound)
+	}
+	events, _ := audit.List("jane", models.AuditRoleGrantExpired)
+	if len(events) != 1 || events[0].Actor != roleGrantSystemActor {
+		t.Errorf("audit events = %+v, want one expiry by %s", events, roleGrantSystemActor)
+	}
+
+	// Revoked grants stop applying at once
+	grant, _ := s.Grant(admin, &models.RoleGrant{UserID: "jane", Role: "editor", NotAfter: time.Now().Add(time.Hour)})
+	if err := s.Revoke(admin, grant.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if err := s.Revoke(admin, grant.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Revoke() twice error = %v, want %v", err, store.ErrNotFound)
+	}
+	if grants, _ := s.List("jane"); len(grants) != 0 {
+		t.Errorf("List() = %d grants, want 0", len(grants))
+	}
+}
+
+func TestRoleGrantService_ReplaceRoles(t *testing.T) {
+	s, _ := newTestRoleGrantService()
+	admin := &models.User{ID: "admin-1", Roles: []string{"admin"}}
+	contractor := &models.User{ID: "contractor", Roles: []string{"user", "editor"}}
+	now := time.Now()
+
+	grant, _ := s.Grant(admin, &models.RoleGrant{UserID: "contractor", Role: "user", NotAfter: now.Add(time.Hour), ReplaceRoles: true})
+	granted, err := s.Apply(contractor)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if len(granted.Roles) != 1 || !granted.HasRole("user") {
+		t.Errorf("Apply() roles = %v, want only the granted user role", granted.Roles)
+	}
+	if !granted.RolesExpireAt.Equal(grant.NotAfter) {
+		t.Errorf("Apply() roles expire at %v, want %v", granted.RolesExpireAt, grant.NotAfter)
+	}
+	s.Revoke(admin, grant.ID)
+
+	// Once every grant has ended no token is issued, and the grant is kept
+	// so the login's own roles do not come back
+	s.store.Save(&models.RoleGrant{
+		ID:           "ended",
+		UserID:       "contractor",
+		Role:         "user",
+		NotAfter:     now.Add(-time.Minute),
+		ReplaceRoles: true,
+		GrantedAt:    now.Add(-time.Hour),
+	})
+	if _, err := s.Apply(contractor); !errors.Is(err, ErrNoActiveRoleGrant) {
+		t.Errorf("Apply() after the grants ended error = %v, want %v", err, ErrNoActiveRoleGrant)
+	}
+	if _, err := s.Apply(contractor); !errors.Is(err, ErrNoActiveRoleGrant) {
+		t.Errorf("Apply() again error = %v, want %v", err, ErrNoActiveRoleGrant)
+	}
+	if grants, _ := s.List("contractor"); len(grants) != 1 {
+		t.Errorf("List() = %d grants, want the ended grant kept", len(grants))
+	}
+
+	// Nor before a grant starts
+	s.Revoke(admin, "ended")
+	s.Grant(admin, &models.RoleGrant{UserID: "contractor", Role: "user", NotBefore: now.Add(time.Hour), ReplaceRoles: true})
+	if _, err := s.Apply(contractor); !errors.Is(err, ErrNoActiveRoleGrant) {
+		t.Errorf("Apply() before not_before error = %v, want %v", err, ErrNoActiveRoleGrant)
+	}
+}
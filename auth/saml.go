@@ -0,0 +1,243 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/crewjam/saml"
+)
+
+// SAMLProvider is the provider name of users who log in through the SAML IdP
+const SAMLProvider = "saml"
+
+// ErrInvalidSAMLResponse is wrapped by errors for SAML responses that fail
+// validation: bad signatures, unexpected audiences, expired conditions, or
+// responses to requests this browser did not make
+var ErrInvalidSAMLResponse = errors.New("invalid SAML response")
+
+// SAMLService is a SAML 2.0 service provider. It sends AuthnRequests with
+// the HTTP-Redirect binding and accepts signed assertions at the ACS with
+// the HTTP-POST binding.
+type SAMLService struct {
+	config *config.Config
+	sp     *saml.ServiceProvider
+}
+
+// NewSAMLService creates a SAML service provider from the SAML_* settings.
+// The IdP metadata is read from its file or fetched from its URL.
+func NewSAMLService(cfg *config.Config) (*SAMLService, error) {
+	metadataURL, err := url.Parse(cfg.SAMLEntityID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAML entity ID: %w", err)
+	}
+	acsURL, err := url.Parse(cfg.SAMLACSURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAML ACS URL: %w", err)
+	}
+	idpMetadata, err := loadSAMLIDPMetadata(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &saml.ServiceProvider{
+		EntityID:          cfg.SAMLEntityID,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       idpMetadata,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+	}
+	if cfg.SAMLCertFile != "" || cfg.SAMLKeyFile != "" {
+		keyPair, err := tls.LoadX509KeyPair(cfg.SAMLCertFile, cfg.SAMLKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load SAML key pair: %w", err)
+		}
+		key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("SAML key must be an RSA key")
+		}
+		sp.Key = key
+		sp.Certificate = keyPair.Leaf
+	}
+
+	if sp.GetSSOBindingLocation(saml.HTTPRedirectBinding) == "" {
+		return nil, errors.New("SAML IdP metadata has no HTTP-Redirect single sign-on service")
+	}
+
+	return &SAMLService{config: cfg, sp: sp}, nil
+}
+
+// loadSAMLIDPMetadata reads the IdP's EntityDescriptor from the metadata
+// file, or fetches it from the metadata URL
+func loadSAMLIDPMetadata(cfg *config.Config) (*saml.EntityDescriptor, error) {
+	var data []byte
+	var err error
+	if cfg.SAMLIDPMetadataFile != "" {
+		data, err = os.ReadFile(cfg.SAMLIDPMetadataFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SAML IdP metadata: %w", err)
+		}
+	} else {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Get(cfg.SAMLIDPMetadataURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch SAML IdP metadata: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch SAML IdP metadata: status %d", resp.StatusCode)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20)); err != nil {
+			return nil, fmt.Errorf("failed to fetch SAML IdP metadata: %w", err)
+		}
+	}
+
+	var metadata saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode SAML IdP metadata: %w", err)
+	}
+	if len(metadata.IDPSSODescriptors) == 0 {
+		return nil, errors.New("SAML IdP metadata has no IDPSSODescriptor")
+	}
+	return &metadata, nil
+}
+
+// Metadata returns the SP metadata document to register with the IdP
+func (s *SAMLService) Metadata() ([]byte, error) {
+	data, err := xml.MarshalIndent(s.sp.Metadata(), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SAML metadata: %w", err)
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// AuthnRequestURL creates an AuthnRequest and returns the IdP URL carrying
+// it with the HTTP-Redirect binding, along with the request ID the response
+// must answer. With forceAuthn the IdP must authenticate the user again
+// rather than rely on its session.
+func (s *SAMLService) AuthnRequestURL(relayState string, forceAuthn bool) (string, string, error) {
+	request, err := s.sp.MakeAuthenticationRequest(
+		s.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding),
+		saml.HTTPRedirectBinding,
+		saml.HTTPPostBinding,
+	)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create SAML AuthnRequest: %w", err)
+	}
+	if forceAuthn {
+		request.ForceAuthn = &forceAuthn
+	}
+	redirectURL, err := request.Redirect(relayState, s.sp)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create SAML AuthnRequest: %w", err)
+	}
+	return redirectURL.String(), request.ID, nil
+}
+
+// ParseResponse validates a base64-encoded SAMLResponse to the request with
+// the given ID and returns the user it asserts, along with when the IdP
+// authenticated them (the zero time when the assertion does not say). The
+// response or assertion must be signed by the IdP, be addressed to this SP,
+// carry an audience restriction naming it, and be within its validity
+// window.
+func (s *SAMLService) ParseResponse(samlResponse, requestID string) (*models.User, time.Time, error) {
+	data, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: invalid base64", ErrInvalidSAMLResponse)
+	}
+
+	assertion, err := s.sp.ParseXMLResponse(data, []string{requestID})
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		return nil, time.Time{}, fmt.Errorf("%w: %v", ErrInvalidSAMLResponse, err)
+	}
+
+	// The library accepts assertions without an audience restriction; an
+	// assertion meant for any SP could then be replayed here
+	if assertion.Conditions == nil || len(assertion.Conditions.AudienceRestrictions) == 0 {
+		return nil, time.Time{}, fmt.Errorf("%w: assertion has no audience restriction", ErrInvalidSAMLResponse)
+	}
+
+	user, err := s.userFromAssertion(assertion)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var authnInstant time.Time
+	for _, statement := range assertion.AuthnStatements {
+		if statement.AuthnInstant.After(authnInstant) {
+			authnInstant = statement.AuthnInstant
+		}
+	}
+	return user, authnInstant, nil
+}
+
+// userFromAssertion maps the NameID and attributes of an assertion to a
+// user. Group values only give the roles they are mapped to, so the IdP
+// cannot assert gateway roles directly.
+func (s *SAMLService) userFromAssertion(assertion *saml.Assertion) (*models.User, error) {
+	attributes := make(map[string][]string)
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			values := make([]string, 0, len(attribute.Values))
+			for _, value := range attribute.Values {
+				values = append(values, value.Value)
+			}
+			attributes[attribute.Name] = append(attributes[attribute.Name], values...)
+			if attribute.FriendlyName != "" && attribute.FriendlyName != attribute.Name {
+				attributes[attribute.FriendlyName] = append(attributes[attribute.FriendlyName], values...)
+			}
+		}
+	}
+	first := func(names []string) string {
+		for _, name := range names {
+			if values := attributes[name]; len(values) > 0 && values[0] != "" {
+				return values[0]
+			}
+		}
+		return ""
+	}
+
+	user := &models.User{
+		Email:    first(s.config.SAMLEmailAttributes),
+		Name:     first(s.config.SAMLNameAttributes),
+		Provider: SAMLProvider,
+		Created:  time.Now(),
+	}
+	if s.config.SAMLIDAttribute != "" {
+		user.ID = first([]string{s.config.SAMLIDAttribute})
+	} else if assertion.Subject != nil && assertion.Subject.NameID != nil {
+		user.ID = assertion.Subject.NameID.Value
+	}
+	if user.ID == "" {
+		return nil, fmt.Errorf("%w: assertion has no user ID", ErrInvalidSAMLResponse)
+	}
+
+	for _, name := range s.config.SAMLGroupAttributes {
+		for _, group := range attributes[name] {
+			for _, mapping := range s.config.SAMLGroupRoles {
+				if strings.EqualFold(group, mapping.Group) && !containsString(user.Roles, mapping.Role) {
+					user.Roles = append(user.Roles, mapping.Role)
+				}
+			}
+		}
+	}
+	// Assign default role if no groups mapped to a role
+	if len(user.Roles) == 0 {
+		user.Roles = []string{string(models.RoleUser)}
+	}
+	return user, nil
+}
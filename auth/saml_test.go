@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/crewjam/saml"
+)
+
+const testSAMLEntityID = "https://gateway.example.com/saml/metadata"
+
+func newTestSAMLKeyPair(t *testing.T, commonName string) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return key, cert
+}
+
+// testSAMLIdP is an identity provider that answers the service's
+// AuthnRequests with signed responses
+type testSAMLIdP struct {
+	t          *testing.T
+	idp        *saml.IdentityProvider
+	spMetadata *saml.EntityDescriptor
+}
+
+func newTestSAMLIdP(t *testing.T) *testSAMLIdP {
+	key, cert := newTestSAMLKeyPair(t, "idp.example.com")
+	metadataURL, _ := url.Parse("https://idp.example.com/metadata")
+	ssoURL, _ := url.Parse("https://idp.example.com/sso")
+	p := &testSAMLIdP{t: t}
+	p.idp = &saml.IdentityProvider{
+		Key:                     key,
+		Certificate:             cert,
+		MetadataURL:             *metadataURL,
+		SSOURL:                  *ssoURL,
+		ServiceProviderProvider: p,
+	}
+	return p
+}
+
+func (p *testSAMLIdP) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	if p.spMetadata == nil || serviceProviderID != p.spMetadata.EntityID {
+		return nil, os.ErrNotExist
+	}
+	return p.spMetadata, nil
+}
+
+// metadataFile writes the IdP metadata to a file for SAML_IDP_METADATA_FILE
+func (p *testSAMLIdP) metadataFile() string {
+	data, err := xml.Marshal(p.idp.Metadata())
+	if err != nil {
+		p.t.Fatalf("xml.Marshal() error = %v", err)
+	}
+	path := filepath.Join(p.t.TempDir(), "idp-metadata.xml")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		p.t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+// respond answers the AuthnRequest in redirectURL for the session. edit may
+// change the assertion before it is signed.
+func (p *testSAMLIdP) respond(redirectURL string, session *saml.Session, edit func(*saml.Assertion)) string {
+	request, err := saml.NewIdpAuthnRequest(p.idp, httptest.NewRequest(http.MethodGet, redirectURL, nil))
+	if err != nil {
+		p.t.Fatalf("NewIdpAuthnRequest() error = %v", err)
+	}
+	if err := request.Validate(); err != nil {
+		p.t.Fatalf("Validate() error = %v", err)
+	}
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(request, session); err != nil {
+		p.t.Fatalf("MakeAssertion() error = %v", err)
+	}
+	if edit != nil {
+		edit(request.Assertion)
+	}
+	form, err := request.PostBinding()
+	if err != nil {
+		p.t.Fatalf("PostBinding() error = %v", err)
+	}
+	return form.SAMLResponse
+}
+
+func newTestSAMLService(t *testing.T, idp *testSAMLIdP, modify func(*config.Config)) *SAMLService {
+	cfg := &config.Config{
+		SAMLEntityID:        testSAMLEntityID,
+		SAMLACSURL:          "https://gateway.example.com/saml/acs",
+		SAMLIDPMetadataFile: idp.metadataFile(),
+		SAMLEmailAttributes: []string{"email"},
+		SAMLNameAttributes:  []string{"displayName"},
+		SAMLGroupAttributes: []string{"groups"},
+		SAMLGroupRoles: []config.SAMLGroupRole{
+			{Role: "admin", Group: "IAG Admins"},
+			{Role: "viewer", Group: "Auditors"},
+		},
+	}
+	if modify != nil {
+		modify(cfg)
+	}
+	s, err := NewSAMLService(cfg)
+	if err != nil {
+		t.Fatalf("NewSAMLService() error = %v", err)
+	}
+	idp.spMetadata = s.sp.Metadata()
+	return s
+}
+
+func testSAMLSession() *saml.Session {
+	attribute := func(name string, values ...string) saml.Attribute {
+		attribute := saml.Attribute{Name: name}
+		for _, value := range values {
+			attribute.Values = append(attribute.Values, saml.AttributeValue{Type: "xs:string", Value: value})
+		}
+		return attribute
+	}
+	return &saml.Session{
+		NameID:     "jane@corp.example.com",
+		CreateTime: time.Now(),
+		CustomAttributes: []saml.Attribute{
+			attribute("email", "jane@corp.example.com"),
+			attribute("displayName", "Jane Doe"),
+			attribute("groups", "IAG Admins", "auditors", "Finance"),
+		},
+	}
+}
+
+func TestSAMLService_ParseResponse(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	s := newTestSAMLService(t, idp, nil)
+
+	redirectURL, requestID, err := s.AuthnRequestURL("relay-1", false)
+	if err != nil {
+		t.Fatalf("AuthnRequestURL() error = %v", err)
+	}
+	if !strings.HasPrefix(redirectURL, "https://idp.example.com/sso?") || !strings.Contains(redirectURL, "RelayState=relay-1") {
+		t.Errorf("AuthnRequestURL() = %s, want the IdP SSO URL with the relay state", redirectURL)
+	}
+
+	session := testSAMLSession()
+	user, authnInstant, err := s.ParseResponse(idp.respond(redirectURL, session, nil), requestID)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if authnInstant.Unix() != session.CreateTime.Unix() {
+		t.Errorf("ParseResponse() authn instant = %v, want %v", authnInstant, session.CreateTime)
+	}
+	if user.ID != "jane@corp.example.com" || user.Email != "jane@corp.example.com" || user.Name != "Jane Doe" || user.Provider != SAMLProvider {
+		t.Errorf("ParseResponse() = %+v, want Jane from the NameID and attributes", user)
+	}
+	if !slices.Equal(user.Roles, []string{"admin", "viewer"}) {
+		t.Errorf("ParseResponse() roles = %v, want [admin viewer]", user.Roles)
+	}
+}
+
+func TestSAMLService_GroupRoles(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	s := newTestSAMLService(t, idp, nil)
+
+	// Values that name gateway roles are not taken as roles
+	session := testSAMLSession()
+	session.CustomAttributes[2].Values = []saml.AttributeValue{
+		{Type: "xs:string", Value: "admin"},
+		{Type: "xs:string", Value: "Finance"},
+	}
+	redirectURL, requestID, _ := s.AuthnRequestURL("", false)
+	user, _, err := s.ParseResponse(idp.respond(redirectURL, session, nil), requestID)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if !slices.Equal(user.Roles, []string{"user"}) {
+		t.Errorf("ParseResponse() roles = %v, want [user] for unmapped groups", user.Roles)
+	}
+}
+
+func TestSAMLService_ForceAuthn(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	s := newTestSAMLService(t, idp, nil)
+
+	for _, forceAuthn := range []bool{false, true} {
+		redirectURL, _, err := s.AuthnRequestURL("", forceAuthn)
+		if err != nil {
+			t.Fatalf("AuthnRequestURL() error = %v", err)
+		}
+		request, err := saml.NewIdpAuthnRequest(idp.idp, httptest.NewRequest(http.MethodGet, redirectURL, nil))
+		if err != nil {
+			t.Fatalf("NewIdpAuthnRequest() error = %v", err)
+		}
+		if err := request.Validate(); err != nil {
+			t.Fatalf("Validate() error = %v", err)
+		}
+		got := request.Request.ForceAuthn != nil && *request.Request.ForceAuthn
+		if got != forceAuthn {
+			t.Errorf("AuthnRequestURL(%v) ForceAuthn = %v, want %v", forceAuthn, got, forceAuthn)
+		}
+	}
+}
+
+func TestSAMLService_RejectsInvalidResponses(t *testing.T) {
+	idp := newTestSAMLIdP(t)
+	s := newTestSAMLService(t, idp, nil)
+
+	impostor := newTestSAMLIdP(t)
+	impostor.idp.MetadataURL = idp.idp.MetadataURL
+	impostor.spMetadata = s.sp.Metadata()
+
+	tests := []struct {
+		name    string
+		respond func(redirectURL, requestID string) (string, string)
+	}{
+		{"Other request", func(redirectURL, requestID string) (string, string) {
+			return idp.respond(redirectURL, testSAMLSession(), nil), "id-other"
+		}},
+		{"Other audience", func(redirectURL, requestID string) (string, string) {
+			return idp.respond(redirectURL, testSAMLSession(), func(assertion *saml.Assertion) {
+				assertion.Conditions.AudienceRestrictions[0].Audience.Value = "https://other.example.com/saml"
+			}), requestID
+		}},
+		{"No audience restriction", func(redirectURL, requestID string) (string, string) {
+			return idp.respond(redirectURL, testSAMLSession(), func(assertion *saml.Assertion) {
+				assertion.Conditions.AudienceRestrictions = nil
+			}), requestID
+		}},
+		{"Expired", func(redirectURL, requestID string) (string, string) {
+			return idp.respond(redirectURL, testSAMLSession(), func(assertion *saml.Assertion) {
+				assertion.Conditions.NotBefore = time.Now().Add(-time.Hour)
+				assertion.Conditions.NotOnOrAfter = time.Now().Add(-30 * time.Minute)
+			}), requestID
+		}},
+		{"Signed by another key", func(redirectURL, requestID string) (string, string) {
+			return impostor.respond(redirectURL, testSAMLSession(), nil), requestID
+		}},
+		{"Tampered", func(redirectURL, requestID string) (string, string) {
+			session := testSAMLSession()
+			session.CustomAttributes[2].Values[0].Value = "Auditors"
+			data, _ := base64.StdEncoding.DecodeString(idp.respond(redirectURL, session, nil))
+			data = []byte(strings.Replace(string(data), ">Auditors<", ">IAG Admins<", 1))
+			return base64.StdEncoding.EncodeToString(data), requestID
+		}},
+		{"Not base64", func(redirectURL, requestID string) (string, string) {
+			return "<Response/>", requestID
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redirectURL, requestID, err := s.AuthnRequestURL("", false)
+			if err != nil {
+				t.Fatalf("AuthnRequestURL() error = %v", err)
+			}
+			response, requestID := tt.respond(redirectURL, requestID)
+			if _, _, err := s.ParseResponse(response, requestID); !errors.Is(err, ErrInvalidSAMLResponse) {
+				t.Errorf("ParseResponse() error = %v, want %v", err, ErrInvalidSAMLResponse)
+			}
+		})
+	}
+}
+
+func TestSAMLService_EncryptedAssertion(t *testing.T) {
+	key, cert := newTestSAMLKeyPair(t, "gateway.example.com")
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "sp.crt"), filepath.Join(dir, "sp.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600)
+
+	idp := newTestSAMLIdP(t)
+	s := newTestSAMLService(t, idp, func(cfg *config.Config) {
+		cfg.SAMLCertFile, cfg.SAMLKeyFile = certFile, keyFile
+		cfg.SAMLIDAttribute = "email"
+	})
+
+	redirectURL, requestID, _ := s.AuthnRequestURL("", false)
+	response := idp.respond(redirectURL, testSAMLSession(), nil)
+	if data, _ := base64.StdEncoding.DecodeString(response); !strings.Contains(string(data), "EncryptedAssertion") {
+		t.Fatal("respond() sent a plaintext assertion, want it encrypted to the SP certificate")
+	}
+
+	user, _, err := s.ParseResponse(response, requestID)
+	if err != nil {
+		t.Fatalf("ParseResponse() error = %v", err)
+	}
+	if user.ID != "jane@corp.example.com" {
+		t.Errorf("ParseResponse() ID = %s, want the email attribute", user.ID)
+	}
+}
@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// sessionTouchInterval limits how often last-seen times are written back
+const sessionTouchInterval = time.Minute
+
+var (
+	// ErrSessionRevoked is returned for tokens whose session no longer exists
+	ErrSessionRevoked = errors.New("session has been revoked")
+	// ErrSessionExpired is returned for sessions past their idle timeout or
+	// absolute lifetime
+	ErrSessionExpired = errors.New("session has expired")
+	// ErrUpstreamSessionMismatch is returned when a gateway session was not
+	// started from the provider session named in a logout request
+	ErrUpstreamSessionMismatch = errors.New("session was not started from this provider session")
+)
+
+// SessionManager tracks server-side sessions backing issued tokens, so users
+// can list where they are logged in and revoke individual sessions
+type SessionManager struct {
+	config *config.Config
+	store  store.SessionStore
+}
+
+// NewSessionManager creates a new session manager
+func NewSessionManager(cfg *config.Config, sessionStore store.SessionStore) *SessionManager {
+	return &SessionManager{
+		config: cfg,
+		store:  sessionStore,
+	}
+}
+
+// Create starts a session for a user who just logged in, applying the
+// session policy for the user's roles. If the user then holds more sessions
+// than allowed, the oldest ones are evicted. upstreamIDToken is the provider's
+// ID token, if any, kept for ending the provider session on logout.
+func (m *SessionManager) Create(user *models.User, ipAddress, userAgent, upstreamIDToken string) (*models.Session, error) {
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	policy := m.PolicyFor(user.Roles)
+	now := time.Now()
+	session := &models.Session{
+		ID:                 id,
+		UserID:             user.ID,
+		Provider:           user.Provider,
+		IPAddress:          ipAddress,
+		UserAgent:          userAgent,
+		CreatedAt:          now,
+		LastSeenAt:         now,
+		IdleTimeoutSeconds: int(policy.IdleTimeout.Seconds()),
+		UpstreamIDToken:    upstreamIDToken,
+	}
+	session.UpstreamSubject, session.UpstreamSessionID = upstreamIdentity(upstreamIDToken)
+	if policy.MaxLifetime > 0 {
+		session.ExpiresAt = now.Add(policy.MaxLifetime)
+	}
+
+	if err := m.store.Create(session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	if policy.MaxConcurrent > 0 {
+		if err := m.evictOldest(user.ID, policy.MaxConcurrent); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// PolicyFor resolves the session policy for a set of roles. Role overrides
+// can only tighten the default policy: each limit is the strictest of the
+// default and the overrides of all the roles.
+func (m *SessionManager) PolicyFor(roles []string) config.SessionPolicy {
+	effective := m.config.SessionPolicy
+	for _, role := range roles {
+		override, ok := m.config.SessionRolePolicies[role]
+		if !ok {
+			continue
+		}
+		effective.IdleTimeout = minNonZero(effective.IdleTimeout, override.IdleTimeout)
+		effective.MaxLifetime = minNonZero(effective.MaxLifetime, override.MaxLifetime)
+		effective.MaxConcurrent = minNonZero(effective.MaxConcurrent, override.MaxConcurrent)
+	}
+	return effective
+}
+
+// Check verifies that a session is still active and records activity on it
+func (m *SessionManager) Check(sessionID string) (*models.Session, error) {
+	session, err := m.store.Get(sessionID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrSessionRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if session.Expired(now) {
+		m.store.Delete(session.ID)
+		return nil, ErrSessionExpired
+	}
+
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+		session.LastSeenAt = now
+		if err := m.store.Touch(session.ID, now); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// List returns a user's sessions
+func (m *SessionManager) List(userID string) ([]*models.Session, error) {
+	return m.store.ListByUser(userID)
+}
+
+// Get returns one of the user's sessions. Sessions of other users are
+// reported as not found.
+func (m *SessionManager) Get(userID, sessionID string) (*models.Session, error) {
+	session, err := m.store.Get(sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != userID {
+		return nil, store.ErrNotFound
+	}
+	return session, nil
+}
+
+// Revoke ends one of the user's sessions. Sessions of other users are
+// reported as not found.
+func (m *SessionManager) Revoke(userID, sessionID string) error {
+	if _, err := m.Get(userID, sessionID); err != nil {
+		return err
+	}
+	return m.store.Delete(sessionID)
+}
+
+// RevokeAll ends all of the user's sessions except keepSessionID, which may
+// be empty
+func (m *SessionManager) RevokeAll(userID, keepSessionID string) error {
+	sessions, err := m.store.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.ID == keepSessionID {
+			continue
+		}
+		if err := m.store.Delete(session.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeUpstream ends the sessions started from a provider login, matched
+// by provider subject and/or session ID, and returns how many were ended
+func (m *SessionManager) RevokeUpstream(subject, sessionID string) (int, error) {
+	sessions, err := m.store.ListByUpstream(subject, sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		err := m.store.Delete(session.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// RevokeFromUpstream ends one gateway session if it was started from the
+// provider session, and returns how many were ended. A session started from
+// another provider session is not ended.
+func (m *SessionManager) RevokeFromUpstream(id, upstreamSessionID string) (int, error) {
+	session, err := m.store.Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if session.UpstreamSessionID == "" || session.UpstreamSessionID != upstreamSessionID {
+		return 0, ErrUpstreamSessionMismatch
+	}
+	if err := m.store.Delete(id); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// evictOldest deletes the user's oldest sessions until at most max remain
+func (m *SessionManager) evictOldest(userID string, max int) error {
+	sessions, err := m.store.ListByUser(userID)
+	if err != nil {
+		return err
+	}
+
+	// ListByUser returns the newest sessions first
+	for _, session := range sessions[min(max, len(sessions)):] {
+		if err := m.store.Delete(session.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// upstreamIdentity reads the subject and session ID from a provider ID token.
+// The token came straight from the provider's token endpoint over TLS, so
+// its signature is not checked again here.
+func upstreamIdentity(idToken string) (subject, sessionID string) {
+	if idToken == "" {
+		return "", ""
+	}
+	var claims struct {
+		SessionID string `json:"sid"`
+		jwt.RegisteredClaims
+	}
+	if _, _, err := jwt.NewParser().ParseUnverified(idToken, &claims); err != nil {
+		return "", ""
+	}
+	return claims.Subject, claims.SessionID
+}
+
+func minNonZero[T int | time.Duration](a, b T) T {
+	if a == 0 {
+		return b
+	}
+	if b == 0 {
+		return a
+	}
+	return min(a, b)
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+func TestSessionManager_PolicyFor(t *testing.T) {
+	m := NewSessionManager(&config.Config{
+		SessionPolicy: config.SessionPolicy{
+			IdleTimeout:   30 * time.Minute,
+			MaxLifetime:   24 * time.Hour,
+			MaxConcurrent: 5,
+		},
+		SessionRolePolicies: map[string]config.SessionPolicy{
+			"admin":  {MaxLifetime: 8 * time.Hour, MaxConcurrent: 2},
+			"viewer": {IdleTimeout: 2 * time.Hour},
+		},
+	}, store.NewMemorySessionStore())
+
+	tests := []struct {
+		name     string
+		roles    []string
+		expected config.SessionPolicy
+	}{
+		{"No override", []string{"user"}, config.SessionPolicy{IdleTimeout: 30 * time.Minute, MaxLifetime: 24 * time.Hour, MaxConcurrent: 5}},
+		{"Admin override inherits idle", []string{"admin"}, config.SessionPolicy{IdleTimeout: 30 * time.Minute, MaxLifetime: 8 * time.Hour, MaxConcurrent: 2}},
+		{"Override cannot loosen the defaults", []string{"viewer"}, config.SessionPolicy{IdleTimeout: 30 * time.Minute, MaxLifetime: 24 * time.Hour, MaxConcurrent: 5}},
+		{"Strictest of several", []string{"viewer", "admin"}, config.SessionPolicy{IdleTimeout: 30 * time.Minute, MaxLifetime: 8 * time.Hour, MaxConcurrent: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := m.PolicyFor(tt.roles); result != tt.expected {
+				t.Errorf("PolicyFor(%v) = %+v, want %+v", tt.roles, result, tt.expected)
+			}
+		})
+	}
+
+	// An override sets a limit the defaults leave off
+	m.config.SessionPolicy.IdleTimeout = 0
+	if result := m.PolicyFor([]string{"viewer"}); result.IdleTimeout != 2*time.Hour {
+		t.Errorf("PolicyFor(viewer) idle timeout = %v, want 2h", result.IdleTimeout)
+	}
+}
+
+func TestSessionManager_EvictsOldest(t *testing.T) {
+	sessionStore := store.NewMemorySessionStore()
+	m := NewSessionManager(&config.Config{
+		SessionPolicy: config.SessionPolicy{MaxConcurrent: 2},
+	}, sessionStore)
+
+	user := &models.User{ID: "123", Roles: []string{"user"}}
+	var ids []string
+	for i := 0; i < 3; i++ {
+		session, err := m.Create(user, "127.0.0.1", "test", "")
+		if err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+		ids = append(ids, session.ID)
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := m.Check(ids[0]); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Check() oldest session error = %v, want ErrSessionRevoked", err)
+	}
+	for _, id := range ids[1:] {
+		if _, err := m.Check(id); err != nil {
+			t.Errorf("Check() error = %v, want nil", err)
+		}
+	}
+}
+
+func TestSessionManager_IdleTimeout(t *testing.T) {
+	sessionStore := store.NewMemorySessionStore()
+	m := NewSessionManager(&config.Config{
+		SessionPolicy: config.SessionPolicy{IdleTimeout: 30 * time.Minute},
+	}, sessionStore)
+
+	session, err := m.Create(&models.User{ID: "123"}, "127.0.0.1", "test", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	sessionStore.Touch(session.ID, time.Now().Add(-31*time.Minute))
+
+	if _, err := m.Check(session.ID); !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Check() idle session error = %v, want ErrSessionExpired", err)
+	}
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// GrantTypeTokenExchange is the RFC 8693 token exchange grant type
+const GrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+const (
+	// TokenTypeAccessToken identifies gateway access tokens in token exchange
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	// TokenTypeJWT is accepted as an alias, since gateway tokens are JWTs
+	TokenTypeJWT = "urn:ietf:params:oauth:token-type:jwt"
+
+	tokenExchangeTTL = 15 * time.Minute
+)
+
+// exchangeToken lets a calling service trade a user's gateway token for a
+// token restricted to one downstream audience, a subset of scopes and the
+// client's delegated roles, with an act claim naming the calling service.
+// The subject token must be unrestricted or issued for the gateway or the
+// calling client.
+func (s *AuthorizationServer) exchangeToken(client *models.Client, req *TokenRequest) (*TokenResponse, error) {
+	// The client becomes the act claim, so it must prove its identity
+	if client.Public() {
+		return nil, newOAuthError(http.StatusUnauthorized, "invalid_client", "token exchange requires client authentication")
+	}
+
+	switch req.SubjectTokenType {
+	case TokenTypeAccessToken, TokenTypeJWT:
+	default:
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "unsupported subject_token_type")
+	}
+	if req.RequestedTokenType != "" && req.RequestedTokenType != TokenTypeAccessToken {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "unsupported requested_token_type")
+	}
+	if req.ActorToken != "" {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_request", "actor tokens are not supported; the authenticated client is the actor")
+	}
+
+	subject, err := utils.ValidateJWT(req.SubjectToken, s.config.JWTSecret)
+	if err != nil {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "subject_token is invalid")
+	}
+	// The exchanged token carries the session on, so it must still be active
+	if err := s.CheckSession(subject); errors.Is(err, ErrSessionRevoked) || errors.Is(err, ErrSessionExpired) {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "subject_token session has ended")
+	} else if err != nil {
+		return nil, err
+	}
+	// A token meant for another service cannot be exchanged by this client
+	if len(subject.Audience) > 0 && !subject.HasAudience(s.Issuer()) && !subject.HasAudience(client.ID) {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_grant", "subject_token was not issued for the gateway or this client")
+	}
+
+	if req.Audience == "" {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_target", "audience is required")
+	}
+	if !client.AllowsAudience(req.Audience) {
+		return nil, newOAuthError(http.StatusBadRequest, "invalid_target", "client may not request tokens for audience "+req.Audience)
+	}
+
+	scope, err := downScope(client, subject, req.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// The exchanged token never outlives the subject token
+	ttl := tokenExchangeTTL
+	if client.AccessTokenLifetime > 0 {
+		ttl = time.Duration(client.AccessTokenLifetime) * time.Second
+	}
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	if subject.ExpiresAt != nil && subject.ExpiresAt.Time.Before(expiresAt) {
+		expiresAt = subject.ExpiresAt.Time
+	}
+
+	claims := &utils.Claims{
+		UserID:     subject.UserID,
+		Email:      subject.Email,
+		Name:       subject.Name,
+		Roles:      client.DelegatableRoles(subject.Roles),
+		Provider:   subject.Provider,
+		Scope:      scope,
+		SessionID:  subject.SessionID,
+		AMR:        subject.AMR,
+		ACR:        subject.ACR,
+		AuthTime:   subject.AuthTime,
+		BreakGlass: subject.BreakGlass,
+		Act: &utils.Actor{
+			Subject: client.ID,
+			Act:     subject.Act,
+		},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			Issuer:    subject.Issuer,
+			Subject:   subject.Subject,
+			Audience:  jwt.ClaimStrings{req.Audience},
+		},
+	}
+
+	accessToken, err := utils.SignJWT(claims, s.config.JWTSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenResponse{
+		AccessToken:     accessToken,
+		IssuedTokenType: TokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int(time.Until(expiresAt).Seconds()),
+		Scope:           scope,
+	}, nil
+}
+
+// downScope resolves the scope of an exchanged token. Requested scopes must
+// be allowed for the client and, if the subject token is itself scoped,
+// present in the subject token. Without a requested scope the token keeps
+// the subject's scope, or gets the client's allowed scopes if the subject
+// token is unscoped, so exchanged tokens are never broader than the client.
+func downScope(client *models.Client, subject *utils.Claims, requested string) (string, error) {
+	if requested == "" {
+		if subject.Scope != "" {
+			return subject.Scope, nil
+		}
+		return strings.Join(client.Scopes, " "), nil
+	}
+
+	var subjectScopes []string
+	if subject.Scope != "" {
+		subjectScopes = models.ParseScopes(subject.Scope)
+	}
+
+	scopes := models.ParseScopes(requested)
+	for _, scope := range scopes {
+		if !client.AllowsScope(scope) {
+			return "", newOAuthError(http.StatusBadRequest, "invalid_scope", "scope not allowed: "+scope)
+		}
+		if subjectScopes != nil && !containsString(subjectScopes, scope) {
+			return "", newOAuthError(http.StatusBadRequest, "invalid_scope", "scope exceeds subject token: "+scope)
+		}
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
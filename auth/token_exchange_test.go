@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+func TestAuthorizationServer_TokenExchange(t *testing.T) {
+	s := newTestAuthorizationServer(t)
+
+	service := &models.Client{
+		ID:         "service-a",
+		GrantTypes: []string{GrantTypeTokenExchange},
+		Scopes:     []string{"orders:read", "orders:write"},
+		Audiences:  []string{"service-b"},
+
+		DelegatedRoles: []string{"user"},
+	}
+	service.SetSecret("service-a-secret")
+	s.clients.Save(service)
+
+	user := &models.User{ID: "123", Email: "test@example.com", Roles: []string{"user", "admin"}}
+	subjectToken, err := utils.GenerateJWT(user, s.config.JWTSecret, 1)
+	if err != nil {
+		t.Fatalf("Failed to generate JWT: %v", err)
+	}
+
+	exchange := func(audience, scope string) (*TokenResponse, error) {
+		return s.Exchange(&TokenRequest{
+			GrantType:        GrantTypeTokenExchange,
+			ClientID:         "service-a",
+			ClientSecret:     "service-a-secret",
+			SubjectToken:     subjectToken,
+			SubjectTokenType: TokenTypeAccessToken,
+			Audience:         audience,
+			Scope:            scope,
+		})
+	}
+
+	resp, err := exchange("service-b", "orders:read")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	claims, err := utils.ValidateJWT(resp.AccessToken, s.config.JWTSecret)
+	if err != nil {
+		t.Fatalf("Exchanged token does not validate: %v", err)
+	}
+	if claims.Subject != user.ID {
+		t.Errorf("Subject = %s, want %s", claims.Subject, user.ID)
+	}
+	if !claims.HasAudience("service-b") || len(claims.Audience) != 1 {
+		t.Errorf("Audience = %v, want [service-b]", claims.Audience)
+	}
+	if claims.Scope != "orders:read" {
+		t.Errorf("Scope = %s, want orders:read", claims.Scope)
+	}
+	if claims.Act == nil || claims.Act.Subject != "service-a" {
+		t.Errorf("Act = %+v, want service-a", claims.Act)
+	}
+	if len(claims.Roles) != 1 || claims.Roles[0] != "user" {
+		t.Errorf("Roles = %v, want only the delegated role user", claims.Roles)
+	}
+
+	// A second hop cannot widen the scope and records the delegation chain
+	s.clients.Save(&models.Client{ID: "service-b", SecretHash: service.SecretHash,
+		GrantTypes: []string{GrantTypeTokenExchange}, Scopes: service.Scopes, Audiences: []string{"service-c"}})
+	subjectToken = resp.AccessToken
+	if _, err := s.Exchange(&TokenRequest{
+		GrantType: GrantTypeTokenExchange, ClientID: "service-b", ClientSecret: "service-a-secret",
+		SubjectToken: subjectToken, SubjectTokenType: TokenTypeAccessToken,
+		Audience: "service-c", Scope: "orders:write",
+	}); err == nil {
+		t.Error("Expected error widening scope, got nil")
+	}
+	resp, err = s.Exchange(&TokenRequest{
+		GrantType: GrantTypeTokenExchange, ClientID: "service-b", ClientSecret: "service-a-secret",
+		SubjectToken: subjectToken, SubjectTokenType: TokenTypeAccessToken,
+		Audience: "service-c",
+	})
+	if err != nil {
+		t.Fatalf("Exchange() second hop error = %v", err)
+	}
+	claims, _ = utils.ValidateJWT(resp.AccessToken, s.config.JWTSecret)
+	if claims.Act == nil || claims.Act.Subject != "service-b" || claims.Act.Act == nil || claims.Act.Act.Subject != "service-a" {
+		t.Errorf("Act chain = %+v, want service-b <- service-a", claims.Act)
+	}
+
+	// A token for service-c cannot be exchanged by service-a
+	if _, err := s.Exchange(&TokenRequest{
+		GrantType: GrantTypeTokenExchange, ClientID: "service-a", ClientSecret: "service-a-secret",
+		SubjectToken: resp.AccessToken, SubjectTokenType: TokenTypeAccessToken,
+		Audience: "service-b",
+	}); err == nil {
+		t.Error("Expected error exchanging a token issued for another audience, got nil")
+	}
+
+	tests := []struct {
+		name     string
+		audience string
+		scope    string
+	}{
+		{"Audience not allowed", "service-x", ""},
+		{"Missing audience", "", ""},
+		{"Scope not allowed", "service-b", "admin"},
+	}
+
+	subjectToken, _ = utils.GenerateJWT(user, s.config.JWTSecret, 1)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := exchange(tt.audience, tt.scope); err == nil {
+				t.Error("Expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestAuthorizationServer_TokenExchangeEndedSession(t *testing.T) {
+	s := newTestAuthorizationServer(t)
+
+	service := &models.Client{
+		ID:         "service-a",
+		GrantTypes: []string{GrantTypeTokenExchange},
+		Audiences:  []string{"service-b"},
+	}
+	service.SetSecret("service-a-secret")
+	s.clients.Save(service)
+
+	user := &models.User{ID: "123", Email: "test@example.com", Roles: []string{"user"}}
+	session, err := s.sessions.Create(user, "192.0.2.1", "test", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	claims := utils.NewClaims(user, time.Hour)
+	claims.SessionID = session.ID
+	subjectToken, err := utils.SignJWT(claims, s.config.JWTSecret)
+	if err != nil {
+		t.Fatalf("Failed to sign JWT: %v", err)
+	}
+
+	exchange := func() error {
+		_, err := s.Exchange(&TokenRequest{
+			GrantType:        GrantTypeTokenExchange,
+			ClientID:         "service-a",
+			ClientSecret:     "service-a-secret",
+			SubjectToken:     subjectToken,
+			SubjectTokenType: TokenTypeAccessToken,
+			Audience:         "service-b",
+		})
+		return err
+	}
+
+	if err := exchange(); err != nil {
+		t.Fatalf("Exchange() with an active session error = %v", err)
+	}
+
+	// Once the user logs out, the token cannot be traded for a fresh one
+	if err := s.sessions.Revoke(user.ID, session.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	var oauthErr *OAuthError
+	if err := exchange(); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_grant" {
+		t.Errorf("Exchange() with a revoked session error = %v, want invalid_grant", err)
+	}
+}
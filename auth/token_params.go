@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TokenParams holds the audience and scopes requested for a gateway token
+// at login time
+type TokenParams struct {
+	Audience string `json:"audience,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// ParseTokenParams reads the audience and scope query parameters
+func ParseTokenParams(query url.Values) TokenParams {
+	return TokenParams{
+		Audience: query.Get("audience"),
+		Scope:    strings.Join(models.ParseScopes(query.Get("scope")), " "),
+	}
+}
+
+// Validate checks the requested audience and scopes against the ones the
+// gateway is configured to issue
+func (p TokenParams) Validate(cfg *config.Config) error {
+	if p.Audience != "" && !containsString(cfg.TokenAudiences, p.Audience) {
+		return fmt.Errorf("audience not allowed: %s", p.Audience)
+	}
+	for _, scope := range models.ParseScopes(p.Scope) {
+		if !containsString(cfg.TokenScopes, scope) {
+			return fmt.Errorf("scope not allowed: %s", scope)
+		}
+	}
+	return nil
+}
+
+// Apply stamps the audience and scope into the claims
+func (p TokenParams) Apply(claims *utils.Claims) {
+	if p.Audience != "" {
+		claims.Audience = jwt.ClaimStrings{p.Audience}
+	}
+	claims.Scope = p.Scope
+}
@@ -0,0 +1,467 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const webauthnCeremonyTTL = 5 * time.Minute
+
+// AMRHardwareKey is the RFC 8176 amr value for proof of possession of a
+// hardware-secured key, recorded for WebAuthn logins
+const AMRHardwareKey = "hwk"
+
+var (
+	// ErrWebAuthnCeremonyNotFound is returned for unknown or expired
+	// registration and login ceremonies
+	ErrWebAuthnCeremonyNotFound = errors.New("WebAuthn ceremony not found or expired")
+	// ErrWebAuthnNotRegistered is returned when the user has no credentials
+	ErrWebAuthnNotRegistered = errors.New("no WebAuthn credentials registered")
+	// ErrWebAuthnVerificationFailed is returned when an authenticator
+	// response does not verify
+	ErrWebAuthnVerificationFailed = errors.New("WebAuthn verification failed")
+	// ErrWebAuthnCloned is returned for credentials whose signature counter
+	// went backwards, a sign the key was cloned. They stay refused until the
+	// user removes them.
+	ErrWebAuthnCloned = errors.New("WebAuthn credential may have been cloned")
+)
+
+// webauthnCeremony is the server side of an in-flight registration or login
+type webauthnCeremony struct {
+	session   webauthn.SessionData
+	userID    string // empty for passkey logins, where the user is not known yet
+	expiresAt time.Time
+}
+
+// WebAuthnService runs WebAuthn registration and login ceremonies, for
+// security keys as a second factor and for passkeys as a standalone login
+type WebAuthnService struct {
+	config   *config.Config
+	webAuthn *webauthn.WebAuthn
+	store    store.WebAuthnStore
+
+	mu         sync.Mutex
+	ceremonies map[string]*webauthnCeremony
+}
+
+// NewWebAuthnService creates a WebAuthn relying party for the configured
+// RP ID and origins
+func NewWebAuthnService(cfg *config.Config, webAuthnStore store.WebAuthnStore) (*WebAuthnService, error) {
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: cfg.WebAuthnRPName,
+		RPOrigins:     cfg.WebAuthnOrigins,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure WebAuthn: %w", err)
+	}
+
+	return &WebAuthnService{
+		config:     cfg,
+		webAuthn:   webAuthn,
+		store:      webAuthnStore,
+		ceremonies: make(map[string]*webauthnCeremony),
+	}, nil
+}
+
+// HasCredentials reports whether the user registered any credential
+func (s *WebAuthnService) HasCredentials(userID string) (bool, error) {
+	credentials, err := s.Credentials(userID)
+	return len(credentials) > 0, err
+}
+
+// Credentials returns the user's registered credentials
+func (s *WebAuthnService) Credentials(userID string) ([]models.WebAuthnCredential, error) {
+	account, err := s.store.Get(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return account.Credentials, nil
+}
+
+// DeleteCredential removes one of the user's credentials by its base64url ID
+func (s *WebAuthnService) DeleteCredential(userID, credentialID string) error {
+	id, err := base64.RawURLEncoding.DecodeString(credentialID)
+	if err != nil {
+		return store.ErrNotFound
+	}
+
+	account, err := s.store.Get(userID)
+	if err != nil {
+		return err
+	}
+	for i, credential := range account.Credentials {
+		if bytes.Equal(credential.ID, id) {
+			account.Credentials = append(account.Credentials[:i], account.Credentials[i+1:]...)
+			return s.store.Save(account)
+		}
+	}
+	return store.ErrNotFound
+}
+
+// RecordProfile keeps the profile the user's provider returned at login,
+// before role grants and elevations are added, for later passkey logins.
+// The account is written only when the profile changed.
+func (s *WebAuthnService) RecordProfile(user *models.User) error {
+	profile := &models.User{
+		Email:         user.Email,
+		Name:          user.Name,
+		Picture:       user.Picture,
+		Provider:      user.Provider,
+		Roles:         append([]string(nil), user.Roles...),
+		EmailVerified: user.EmailVerified,
+	}
+
+	account, err := s.store.Get(user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		handle := make([]byte, 32)
+		if _, err := rand.Read(handle); err != nil {
+			return err
+		}
+		account, err = &models.WebAuthnAccount{UserID: user.ID, Handle: handle}, nil
+	}
+	if err != nil {
+		return err
+	}
+	if account.Provider == user.Provider && reflect.DeepEqual(account.Profile, profile) {
+		return nil
+	}
+	account.Provider = user.Provider
+	account.Profile = profile
+	return s.store.Save(account)
+}
+
+// BeginRegistration starts registering a new credential for the user and
+// returns the ceremony ID and the options for navigator.credentials.create
+func (s *WebAuthnService) BeginRegistration(user *models.User) (string, *protocol.CredentialCreation, error) {
+	account, err := s.store.Get(user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		handle := make([]byte, 32)
+		if _, err := rand.Read(handle); err != nil {
+			return "", nil, err
+		}
+		account, err = &models.WebAuthnAccount{UserID: user.ID, Handle: handle}, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	account.Provider = user.Provider
+
+	waUser := webauthnUser{account: account, user: user}
+	creation, session, err := s.webAuthn.BeginRegistration(waUser,
+		webauthn.WithExclusions(webauthn.Credentials(waUser.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin registration: %w", err)
+	}
+
+	id, err := s.startCeremony(session, user.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, creation, nil
+}
+
+// FinishRegistration verifies the authenticator's attestation response and
+// stores the new credential under the given name
+func (s *WebAuthnService) FinishRegistration(ceremonyID string, user *models.User, name string, response io.Reader) (*models.WebAuthnCredential, error) {
+	ceremony, err := s.takeCeremony(ceremonyID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.store.Get(user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		account, err = &models.WebAuthnAccount{UserID: user.ID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(account.Credentials) == 0 {
+		// No authenticator holds the old handle yet, and this one was given
+		// the ceremony's; an account made by RecordProfile in between has
+		// a handle of its own
+		account.Handle = ceremony.session.UserID
+	}
+	account.Provider = user.Provider
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+	}
+	credential, err := s.webAuthn.CreateCredential(webauthnUser{account: account, user: user}, ceremony.session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+	}
+
+	if name == "" {
+		name = fmt.Sprintf("Security key %d", len(account.Credentials)+1)
+	}
+	stored := credentialToModel(credential, name)
+	account.Credentials = append(account.Credentials, stored)
+	if err := s.store.Save(account); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// BeginLogin starts a second-factor assertion for a known user
+func (s *WebAuthnService) BeginLogin(userID string) (string, *protocol.CredentialAssertion, error) {
+	account, err := s.store.Get(userID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && len(account.Credentials) == 0) {
+		return "", nil, ErrWebAuthnNotRegistered
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	assertion, session, err := s.webAuthn.BeginLogin(webauthnUser{account: account})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin login: %w", err)
+	}
+
+	id, err := s.startCeremony(session, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, assertion, nil
+}
+
+// FinishLogin verifies a second-factor assertion for the user who passed
+// the first factor and returns the amr values it adds. The user's provider
+// is kept for later passkey logins.
+func (s *WebAuthnService) FinishLogin(ceremonyID string, user *models.User, response io.Reader) ([]string, error) {
+	ceremony, err := s.takeCeremony(ceremonyID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.store.Get(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	account.Provider = user.Provider
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+	}
+	credential, err := s.webAuthn.ValidateLogin(webauthnUser{account: account}, ceremony.session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+	}
+
+	if err := s.recordUse(account, credential); err != nil {
+		return nil, err
+	}
+	return []string{AMRHardwareKey, AMRMFA}, nil
+}
+
+// BeginPasskeyLogin starts a passwordless login in which the authenticator
+// picks the account. User verification is required, so a passkey login
+// counts as multi-factor.
+func (s *WebAuthnService) BeginPasskeyLogin() (string, *protocol.CredentialAssertion, error) {
+	assertion, session, err := s.webAuthn.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to begin passkey login: %w", err)
+	}
+
+	id, err := s.startCeremony(session, "")
+	if err != nil {
+		return "", nil, err
+	}
+	return id, assertion, nil
+}
+
+// FinishPasskeyLogin verifies a passkey assertion and returns the account
+// it belongs to, with the amr values. Callers look up the user from the
+// account's user ID, provider and recorded profile.
+func (s *WebAuthnService) FinishPasskeyLogin(ceremonyID string, response io.Reader) (*models.WebAuthnAccount, []string, error) {
+	ceremony, err := s.takeCeremony(ceremonyID, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(response)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+	}
+
+	var account *models.WebAuthnAccount
+	_, credential, err := s.webAuthn.ValidatePasskeyLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		account, err = s.store.GetByHandle(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		return webauthnUser{account: account}, nil
+	}, ceremony.session, parsed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrWebAuthnVerificationFailed, err)
+	}
+
+	if err := s.recordUse(account, credential); err != nil {
+		return nil, nil, err
+	}
+	return account, []string{AMRHardwareKey, AMRMFA}, nil
+}
+
+// recordUse stores the new signature counter and last-used time of a
+// credential after a successful assertion. A credential the authenticator
+// reports as cloned is flagged instead, keeping the highest counter seen,
+// and it and every later assertion with it fail with ErrWebAuthnCloned.
+func (s *WebAuthnService) recordUse(account *models.WebAuthnAccount, credential *webauthn.Credential) error {
+	for i := range account.Credentials {
+		stored := &account.Credentials[i]
+		if !bytes.Equal(stored.ID, credential.ID) {
+			continue
+		}
+		if stored.CloneWarning || credential.Authenticator.CloneWarning {
+			stored.CloneWarning = true
+			if err := s.store.Save(account); err != nil {
+				return err
+			}
+			return ErrWebAuthnCloned
+		}
+		stored.SignCount = credential.Authenticator.SignCount
+		stored.BackupState = credential.Flags.BackupState
+		stored.LastUsedAt = time.Now()
+		return s.store.Save(account)
+	}
+	return nil
+}
+
+func (s *WebAuthnService) startCeremony(session *webauthn.SessionData, userID string) (string, error) {
+	id, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for ceremonyID, ceremony := range s.ceremonies {
+		if now.After(ceremony.expiresAt) {
+			delete(s.ceremonies, ceremonyID)
+		}
+	}
+	s.ceremonies[id] = &webauthnCeremony{
+		session:   *session,
+		userID:    userID,
+		expiresAt: now.Add(webauthnCeremonyTTL),
+	}
+	return id, nil
+}
+
+// takeCeremony consumes a ceremony, which must belong to userID
+func (s *WebAuthnService) takeCeremony(id, userID string) (*webauthnCeremony, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ceremony, ok := s.ceremonies[id]
+	if !ok || ceremony.userID != userID {
+		return nil, ErrWebAuthnCeremonyNotFound
+	}
+	delete(s.ceremonies, id)
+	if time.Now().After(ceremony.expiresAt) {
+		return nil, ErrWebAuthnCeremonyNotFound
+	}
+	return ceremony, nil
+}
+
+// webauthnUser adapts a stored account to the WebAuthn library. The user
+// is set while registering, for the names authenticators show.
+type webauthnUser struct {
+	account *models.WebAuthnAccount
+	user    *models.User
+}
+
+func (u webauthnUser) WebAuthnID() []byte {
+	return u.account.Handle
+}
+
+func (u webauthnUser) WebAuthnName() string {
+	if u.user != nil && u.user.Email != "" {
+		return u.user.Email
+	}
+	return u.account.UserID
+}
+
+func (u webauthnUser) WebAuthnDisplayName() string {
+	if u.user != nil && u.user.Name != "" {
+		return u.user.Name
+	}
+	return u.WebAuthnName()
+}
+
+func (u webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.account.Credentials))
+	for _, stored := range u.account.Credentials {
+		credentials = append(credentials, credentialFromModel(stored))
+	}
+	return credentials
+}
+
+func credentialToModel(credential *webauthn.Credential, name string) models.WebAuthnCredential {
+	transports := make([]string, 0, len(credential.Transport))
+	for _, t := range credential.Transport {
+		transports = append(transports, string(t))
+	}
+	return models.WebAuthnCredential{
+		ID:              credential.ID,
+		Name:            name,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		UserPresent:     credential.Flags.UserPresent,
+		UserVerified:    credential.Flags.UserVerified,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	}
+}
+
+func credentialFromModel(stored models.WebAuthnCredential) webauthn.Credential {
+	transports := make([]protocol.AuthenticatorTransport, 0, len(stored.Transports))
+	for _, t := range stored.Transports {
+		transports = append(transports, protocol.AuthenticatorTransport(t))
+	}
+	return webauthn.Credential{
+		ID:              stored.ID,
+		PublicKey:       stored.PublicKey,
+		AttestationType: stored.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			UserPresent:    stored.UserPresent,
+			UserVerified:   stored.UserVerified,
+			BackupEligible: stored.BackupEligible,
+			BackupState:    stored.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:       stored.AAGUID,
+			SignCount:    stored.SignCount,
+			CloneWarning: stored.CloneWarning,
+		},
+	}
+}
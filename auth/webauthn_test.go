@@ -0,0 +1,314 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+const testWebAuthnOrigin = "https://login.example.com"
+
+// softAuthenticator is a software security key with a single ES256
+// credential and "none" attestation
+type softAuthenticator struct {
+	t         *testing.T
+	key       *ecdsa.PrivateKey
+	id        []byte
+	handle    []byte
+	signCount uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{t: t, key: key, id: id}
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte("login.example.com"))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) clientData(ceremonyType string, challenge protocol.URLEncodedBase64) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremonyType,
+		"challenge": challenge.String(),
+		"origin":    testWebAuthnOrigin,
+	})
+	return data
+}
+
+// create answers navigator.credentials.create
+func (a *softAuthenticator) create(options *protocol.CredentialCreation) []byte {
+	a.handle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatalf("cbor.Marshal() error = %v", err)
+	}
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(attested, a.id...)
+	attested = append(attested, coseKey...)
+
+	attestationObject, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(0x45, attested), // UP, UV, AT
+	})
+	if err != nil {
+		a.t.Fatalf("cbor.Marshal() error = %v", err)
+	}
+
+	return a.response(map[string]interface{}{
+		"clientDataJSON":    a.clientData("webauthn.create", options.Response.Challenge),
+		"attestationObject": attestationObject,
+	})
+}
+
+// get answers navigator.credentials.get
+func (a *softAuthenticator) get(options *protocol.CredentialAssertion) []byte {
+	a.signCount++
+	authData := a.authData(0x05, nil) // UP, UV
+	clientData := a.clientData("webauthn.get", options.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(slices.Clone(authData), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatalf("SignASN1() error = %v", err)
+	}
+
+	return a.response(map[string]interface{}{
+		"clientDataJSON":    clientData,
+		"authenticatorData": authData,
+		"signature":         signature,
+		"userHandle":        a.handle,
+	})
+}
+
+func (a *softAuthenticator) response(fields map[string]interface{}) []byte {
+	encoded := make(map[string]string, len(fields))
+	for name, value := range fields {
+		encoded[name] = base64.RawURLEncoding.EncodeToString(value.([]byte))
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":       base64.RawURLEncoding.EncodeToString(a.id),
+		"rawId":    base64.RawURLEncoding.EncodeToString(a.id),
+		"type":     "public-key",
+		"response": encoded,
+	})
+	return body
+}
+
+func newTestWebAuthnService(t *testing.T) *WebAuthnService {
+	s, err := NewWebAuthnService(&config.Config{
+		WebAuthnRPID:    "login.example.com",
+		WebAuthnRPName:  "IAG",
+		WebAuthnOrigins: []string{testWebAuthnOrigin},
+	}, store.NewMemoryWebAuthnStore())
+	if err != nil {
+		t.Fatalf("NewWebAuthnService() error = %v", err)
+	}
+	return s
+}
+
+func TestWebAuthnService_RegisterAndLogin(t *testing.T) {
+	s := newTestWebAuthnService(t)
+	authenticator := newSoftAuthenticator(t)
+	user := &models.User{ID: "123", Email: "user@example.com", Provider: "google", Roles: []string{"user"}}
+
+	if _, _, err := s.BeginLogin(user.ID); !errors.Is(err, ErrWebAuthnNotRegistered) {
+		t.Fatalf("BeginLogin() before registration error = %v, want %v", err, ErrWebAuthnNotRegistered)
+	}
+
+	ceremonyID, creation, err := s.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+	credential, err := s.FinishRegistration(ceremonyID, user, "YubiKey", bytes.NewReader(authenticator.create(creation)))
+	if err != nil {
+		t.Fatalf("FinishRegistration() error = %v", err)
+	}
+	if credential.Name != "YubiKey" || !bytes.Equal(credential.ID, authenticator.id) {
+		t.Errorf("FinishRegistration() = %s %x, want YubiKey %x", credential.Name, credential.ID, authenticator.id)
+	}
+	if has, _ := s.HasCredentials(user.ID); !has {
+		t.Error("HasCredentials() = false after registration, want true")
+	}
+
+	// Second factor after a first-factor login
+	ceremonyID, assertion, err := s.BeginLogin(user.ID)
+	if err != nil {
+		t.Fatalf("BeginLogin() error = %v", err)
+	}
+	response := authenticator.get(assertion)
+	amr, err := s.FinishLogin(ceremonyID, user, bytes.NewReader(response))
+	if err != nil {
+		t.Fatalf("FinishLogin() error = %v", err)
+	}
+	if !slices.Equal(amr, []string{AMRHardwareKey, AMRMFA}) {
+		t.Errorf("FinishLogin() amr = %v, want [hwk mfa]", amr)
+	}
+	if _, err := s.FinishLogin(ceremonyID, user, bytes.NewReader(response)); !errors.Is(err, ErrWebAuthnCeremonyNotFound) {
+		t.Errorf("FinishLogin() replayed error = %v, want %v", err, ErrWebAuthnCeremonyNotFound)
+	}
+
+	// Passwordless login
+	ceremonyID, assertion, err = s.BeginPasskeyLogin()
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin() error = %v", err)
+	}
+	loggedIn, amr, err := s.FinishPasskeyLogin(ceremonyID, bytes.NewReader(authenticator.get(assertion)))
+	if err != nil {
+		t.Fatalf("FinishPasskeyLogin() error = %v", err)
+	}
+	if loggedIn.UserID != user.ID || loggedIn.Provider != "google" || !slices.Equal(amr, []string{AMRHardwareKey, AMRMFA}) {
+		t.Errorf("FinishPasskeyLogin() = %s/%s, %v, want google/%s, [hwk mfa]", loggedIn.Provider, loggedIn.UserID, amr, user.ID)
+	}
+
+	credentials, _ := s.Credentials(user.ID)
+	if len(credentials) != 1 || credentials[0].SignCount != 2 || credentials[0].LastUsedAt.IsZero() {
+		t.Errorf("Credentials() = %+v, want one credential with sign count 2 and a last use", credentials)
+	}
+}
+
+func TestWebAuthnService_RejectsBadAssertions(t *testing.T) {
+	s := newTestWebAuthnService(t)
+	authenticator := newSoftAuthenticator(t)
+	user := &models.User{ID: "123"}
+
+	ceremonyID, creation, _ := s.BeginRegistration(user)
+	if _, err := s.FinishRegistration(ceremonyID, user, "", bytes.NewReader(authenticator.create(creation))); err != nil {
+		t.Fatalf("FinishRegistration() error = %v", err)
+	}
+
+	// A different key cannot answer for the registered credential
+	impostor := newSoftAuthenticator(t)
+	impostor.id, impostor.handle = authenticator.id, authenticator.handle
+	ceremonyID, assertion, _ := s.BeginLogin(user.ID)
+	if _, err := s.FinishLogin(ceremonyID, user, bytes.NewReader(impostor.get(assertion))); !errors.Is(err, ErrWebAuthnVerificationFailed) {
+		t.Errorf("FinishLogin() with wrong key error = %v, want %v", err, ErrWebAuthnVerificationFailed)
+	}
+
+	// Ceremonies belong to the user they were started for
+	ceremonyID, assertion, _ = s.BeginLogin(user.ID)
+	other := &models.User{ID: "456"}
+	if _, err := s.FinishLogin(ceremonyID, other, bytes.NewReader(authenticator.get(assertion))); !errors.Is(err, ErrWebAuthnCeremonyNotFound) {
+		t.Errorf("FinishLogin() for another user error = %v, want %v", err, ErrWebAuthnCeremonyNotFound)
+	}
+
+	if err := s.DeleteCredential(user.ID, base64.RawURLEncoding.EncodeToString(authenticator.id)); err != nil {
+		t.Fatalf("DeleteCredential() error = %v", err)
+	}
+	if has, _ := s.HasCredentials(user.ID); has {
+		t.Error("HasCredentials() = true after deleting the only credential, want false")
+	}
+}
+
+func TestWebAuthnService_RejectsClonedCredentials(t *testing.T) {
+	s := newTestWebAuthnService(t)
+	authenticator := newSoftAuthenticator(t)
+	user := &models.User{ID: "123", Provider: "google"}
+
+	ceremonyID, creation, _ := s.BeginRegistration(user)
+	if _, err := s.FinishRegistration(ceremonyID, user, "", bytes.NewReader(authenticator.create(creation))); err != nil {
+		t.Fatalf("FinishRegistration() error = %v", err)
+	}
+	ceremonyID, assertion, _ := s.BeginPasskeyLogin()
+	if _, _, err := s.FinishPasskeyLogin(ceremonyID, bytes.NewReader(authenticator.get(assertion))); err != nil {
+		t.Fatalf("FinishPasskeyLogin() error = %v", err)
+	}
+
+	// A copy of the key signs with a counter the original already used
+	clone := *authenticator
+	clone.signCount = 0
+	ceremonyID, assertion, _ = s.BeginPasskeyLogin()
+	if _, _, err := s.FinishPasskeyLogin(ceremonyID, bytes.NewReader(clone.get(assertion))); !errors.Is(err, ErrWebAuthnCloned) {
+		t.Fatalf("FinishPasskeyLogin() with a cloned key error = %v, want %v", err, ErrWebAuthnCloned)
+	}
+
+	// The credential stays refused, even with a fresh counter
+	ceremonyID, assertion, _ = s.BeginPasskeyLogin()
+	if _, _, err := s.FinishPasskeyLogin(ceremonyID, bytes.NewReader(authenticator.get(assertion))); !errors.Is(err, ErrWebAuthnCloned) {
+		t.Errorf("FinishPasskeyLogin() after a clone error = %v, want %v", err, ErrWebAuthnCloned)
+	}
+	ceremonyID, assertion, _ = s.BeginLogin(user.ID)
+	if _, err := s.FinishLogin(ceremonyID, user, bytes.NewReader(authenticator.get(assertion))); !errors.Is(err, ErrWebAuthnCloned) {
+		t.Errorf("FinishLogin() after a clone error = %v, want %v", err, ErrWebAuthnCloned)
+	}
+	if credentials, _ := s.Credentials(user.ID); len(credentials) != 1 || !credentials[0].CloneWarning || credentials[0].SignCount != 1 {
+		t.Errorf("Credentials() = %+v, want the credential flagged with its highest counter", credentials)
+	}
+}
+
+func TestWebAuthnService_PasskeyLoginKeepsProfile(t *testing.T) {
+	s := newTestWebAuthnService(t)
+	authenticator := newSoftAuthenticator(t)
+	directory := NewUserDirectory(&config.Config{}, nil, nil)
+	user := &models.User{ID: "cn=jane", Email: "jane@corp.example.com", Name: "Jane", Provider: "ldap", Roles: []string{"admin", "viewer"}}
+
+	// The provider login records the profile before the passkey exists
+	if err := s.RecordProfile(user); err != nil {
+		t.Fatalf("RecordProfile() error = %v", err)
+	}
+	ceremonyID, creation, err := s.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("BeginRegistration() error = %v", err)
+	}
+	if _, err := s.FinishRegistration(ceremonyID, user, "Laptop", bytes.NewReader(authenticator.create(creation))); err != nil {
+		t.Fatalf("FinishRegistration() error = %v", err)
+	}
+
+	ceremonyID, assertion, _ := s.BeginPasskeyLogin()
+	account, _, err := s.FinishPasskeyLogin(ceremonyID, bytes.NewReader(authenticator.get(assertion)))
+	if err != nil {
+		t.Fatalf("FinishPasskeyLogin() error = %v", err)
+	}
+	loggedIn, err := directory.LookupProfile(account.UserID, account.Provider, account.Profile)
+	if err != nil {
+		t.Fatalf("LookupProfile() error = %v", err)
+	}
+	if loggedIn.ID != user.ID || loggedIn.Email != user.Email || loggedIn.Name != user.Name || !slices.Equal(loggedIn.Roles, user.Roles) {
+		t.Errorf("LookupProfile() = %+v, want %s <%s> with roles %v", loggedIn, user.ID, user.Email, user.Roles)
+	}
+
+	// The next provider login updates the profile
+	user.Roles = []string{"viewer"}
+	if err := s.RecordProfile(user); err != nil {
+		t.Fatalf("RecordProfile() error = %v", err)
+	}
+	ceremonyID, assertion, _ = s.BeginPasskeyLogin()
+	account, _, err = s.FinishPasskeyLogin(ceremonyID, bytes.NewReader(authenticator.get(assertion)))
+	if err != nil {
+		t.Fatalf("FinishPasskeyLogin() after a new profile error = %v", err)
+	}
+	if loggedIn, _ := directory.LookupProfile(account.UserID, account.Provider, account.Profile); !slices.Equal(loggedIn.Roles, []string{"viewer"}) {
+		t.Errorf("LookupProfile() roles = %v, want [viewer]", loggedIn.Roles)
+	}
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 )
 
 // Config holds the application configuration
@@ -24,8 +26,215 @@ type Config struct {
 	JWTSecret     string
 	JWTExpiration int // in hours
 
+	// Audiences and scopes clients may request for gateway tokens at login
+	TokenAudiences []string
+	TokenScopes    []string
+
 	// RBAC settings
 	EnableRBAC bool
+
+	// Step-up requirements for admin endpoints: the acr class the login
+	// must have reached (basic, mfa or phr) and the maximum time since it
+	AdminRequiredACR string
+	AdminMaxAuthAge  time.Duration
+
+	// Browser session settings. When enabled, the callback stores the JWT in
+	// an HttpOnly cookie and redirects instead of returning JSON.
+	EnableSessionCookies bool
+	SessionCookieName    string
+	CSRFCookieName       string
+	CookieSecure         bool
+
+	// Absolute URLs users may be sent back to after login, e.g.
+	// "https://app.example.com/" or "https://*.internal.example.com/portal"
+	ReturnToAllowlist []string
+
+	// Provider end_session_endpoint for RP-initiated logout, and where the
+	// provider sends the browser afterwards
+	OAuthEndSessionURL    string
+	PostLogoutRedirectURL string
+
+	// Provider issuer and JWKS endpoint, used to verify back-channel logout
+	// tokens and front-channel logout requests
+	OAuthIssuer  string
+	OAuthJWKSURL string
+
+	// TOTP second factor. Enrolled users are always challenged; users with
+	// these roles, or these user IDs or emails, must enroll at login.
+	MFARequiredRoles []string
+	MFARequiredUsers []string
+	MFAStoreFile     string
+	MFAIssuer        string // shown in authenticator apps
+
+	// WebAuthn security keys (as a second factor) and passkeys (as a
+	// passwordless login). The RP ID is the gateway's registrable domain and
+	// the origins are the exact origins the login pages are served from.
+	EnableWebAuthn    bool
+	WebAuthnRPID      string
+	WebAuthnRPName    string
+	WebAuthnOrigins   []string
+	WebAuthnStoreFile string
+
+	// Local username/password accounts, created from admin invites.
+	// Passwords are hashed with Argon2id; memory is in KiB. At most
+	// LocalArgon2MaxConcurrency hashes run at once, bounding their memory;
+	// break-glass password checks count against the same bound.
+	EnableLocalAccounts       bool
+	LocalAccountsFile         string
+	LocalArgon2Memory         int
+	LocalArgon2Iterations     int
+	LocalArgon2Parallelism    int
+	LocalArgon2MaxConcurrency int
+	LocalPasswordMinLength    int
+	LocalInviteTTL            time.Duration
+	LocalResetTTL             time.Duration
+
+	// LDAP / Active Directory login by search-then-bind: the service account
+	// finds the user's entry with LDAPUserFilter, then the password is checked
+	// by binding as that entry. Groups in LDAPGroupAttribute map to roles.
+	EnableLDAP         bool
+	LDAPURL            string // ldap://dc.example.com:389 or ldaps://dc.example.com:636
+	LDAPStartTLS       bool
+	LDAPBindDN         string
+	LDAPBindPassword   string
+	LDAPBaseDN         string
+	LDAPUserFilter     string // {username} is replaced with the escaped username
+	LDAPIDAttribute    string
+	LDAPEmailAttribute string
+	LDAPNameAttribute  string
+	LDAPGroupAttribute string
+	LDAPGroupRoles     []LDAPGroupRole
+	LDAPTimeout        time.Duration
+
+	// Password logins, local or LDAP, are refused for a username after
+	// PasswordMaxAttempts attempts, and for a client IP after
+	// PasswordIPMaxFailures failures, within PasswordAttemptWindow. A
+	// successful login clears the username's attempts.
+	PasswordMaxAttempts   int
+	PasswordIPMaxFailures int
+	PasswordAttemptWindow time.Duration
+
+	// SAML 2.0 service provider. The IdP is described by its metadata, read
+	// from a file or fetched at startup. The SP key pair is optional and
+	// lets the IdP encrypt assertions.
+	EnableSAML          bool
+	SAMLEntityID        string
+	SAMLACSURL          string
+	SAMLIDPMetadataFile string
+	SAMLIDPMetadataURL  string
+	SAMLCertFile        string
+	SAMLKeyFile         string
+	SAMLIDAttribute     string   // empty uses the NameID
+	SAMLEmailAttributes []string // the first one present is used
+	SAMLNameAttributes  []string
+	SAMLGroupAttributes []string // values map to roles through SAMLGroupRoles
+	SAMLGroupRoles      []SAMLGroupRole
+
+	// Passwordless login with a single-use link sent by email, for occasional
+	// external users. Links are only sent to allowed addresses and domains.
+	// Each address may request MagicLinkRateLimit links per
+	// MagicLinkRateWindow, and each client IP MagicLinkIPRateLimit.
+	EnableMagicLink         bool
+	MagicLinkURL            string // where links point: the gateway's /auth/magic-link/verify
+	MagicLinkTTL            time.Duration
+	MagicLinkRoles          []string
+	MagicLinkAllowedEmails  []string
+	MagicLinkAllowedDomains []string
+	MagicLinkRateLimit      int
+	MagicLinkIPRateLimit    int
+	MagicLinkRateWindow     time.Duration
+
+	// Outgoing mail. MailSender is smtp, file (one .eml per message in
+	// MailFileDir, for development and tests) or log.
+	MailSender   string
+	MailFrom     string
+	MailFileDir  string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// Account linking. Each provider identity is linked to a canonical
+	// account whose ID is the token subject. Identities from the auto-link
+	// providers join the account of the same verified email on first login.
+	EnableAccountLinking     bool
+	AccountsFile             string
+	AccountAutoLinkProviders []string
+
+	// Who may log in through the OAuth provider. Denied emails are always
+	// rejected and allowed emails always admitted. Otherwise, once any allow
+	// rule is set, users must pass every rule that applies to the provider:
+	// a verified email in an allowed domain, a Google Workspace hd claim, or
+	// an Azure tenant ID.
+	LoginAllowedEmails       []string
+	LoginDeniedEmails        []string
+	LoginAllowedDomains      []string
+	LoginAllowedGoogleHD     []string
+	LoginAllowedAzureTenants []string
+	LoginAllowedProviders    []string
+
+	// Just-in-time role elevation. Users request one of the elevation roles
+	// for up to the maximum duration, and an approver, by role or user ID,
+	// decides. Undecided requests lapse after the request TTL.
+	EnableElevation        bool
+	ElevationRoles         []string
+	ElevationMaxDuration   time.Duration
+	ElevationRequestTTL    time.Duration
+	ElevationApproverRoles []string
+	ElevationApprovers     []string
+	ElevationStoreFile     string
+
+	// Time-bound role grants managed by admins; stored in memory when the
+	// file is empty
+	EnableRoleGrants bool
+	RoleGrantsFile   string
+
+	// Audit trail of security-relevant actions; kept in memory when empty
+	AuditLogFile string
+
+	// Break-glass emergency accounts, which log in without the identity
+	// provider. Their tokens are short-lived and carry the break_glass
+	// claim, and every login is posted to the alert webhook. Failures are
+	// posted at most once per BreakGlassAlertInterval. A username is locked
+	// for a client IP after BreakGlassMaxAttempts attempts from it, and a
+	// client IP after BreakGlassIPMaxFailures failures, within
+	// BreakGlassAttemptWindow.
+	EnableBreakGlass          bool
+	BreakGlassAccountsFile    string
+	BreakGlassTokenTTL        time.Duration
+	BreakGlassAlertWebhookURL string
+	BreakGlassAlertInterval   time.Duration
+	BreakGlassMaxAttempts     int
+	BreakGlassIPMaxFailures   int
+	BreakGlassAttemptWindow   time.Duration
+
+	// Admin impersonation. Impersonation tokens carry only these roles,
+	// whatever the user's own roles, and expire after the token TTL.
+	EnableImpersonation   bool
+	ImpersonationRoles    []string
+	ImpersonationTokenTTL time.Duration
+
+	// Server-side session store; sessions are kept in memory when empty
+	SessionStoreFile string
+
+	// Session lifetime policies, with per-role overrides that can only
+	// tighten them. The strictest limit of each kind across the defaults and
+	// the overrides of the user's roles applies.
+	SessionPolicy       SessionPolicy
+	SessionRolePolicies map[string]SessionPolicy
+
+	// OIDC provider settings (the gateway acting as an authorization server)
+	EnableOIDCProvider bool
+	OIDCIssuer         string
+	OIDCClientsFile    string
+	OIDCSigningKeyFile string
+
+	// Registered clients are kept in memory when empty, and lost on restart
+	OIDCClientStoreFile string
+
+	// Initial access token required for dynamic client registration;
+	// registration is disabled when empty
+	OIDCRegistrationToken string
 }
 
 // LoadConfig loads configuration from environment variables
@@ -39,6 +248,216 @@ func LoadConfig() (*Config, error) {
 		JWTSecret:         getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
 		JWTExpiration:     getEnvAsInt("JWT_EXPIRATION_HOURS", 24),
 		EnableRBAC:        getEnvAsBool("ENABLE_RBAC", true),
+		AdminRequiredACR:  getEnv("ADMIN_REQUIRED_ACR", ""),
+		TokenAudiences:    getEnvAsSlice("JWT_AUDIENCES", nil),
+		TokenScopes:       getEnvAsSlice("JWT_SCOPES", nil),
+
+		EnableSessionCookies:  getEnvAsBool("ENABLE_SESSION_COOKIES", false),
+		SessionCookieName:     getEnv("SESSION_COOKIE_NAME", "iag_session"),
+		CSRFCookieName:        getEnv("CSRF_COOKIE_NAME", "iag_csrf"),
+		CookieSecure:          getEnvAsBool("COOKIE_SECURE", true),
+		SessionStoreFile:      getEnv("SESSION_STORE_FILE", ""),
+		ReturnToAllowlist:     getEnvAsSlice("RETURN_TO_ALLOWLIST", nil),
+		PostLogoutRedirectURL: getEnv("POST_LOGOUT_REDIRECT_URL", ""),
+		MFARequiredRoles:      getEnvAsSlice("MFA_REQUIRED_ROLES", nil),
+		MFARequiredUsers:      getEnvAsSlice("MFA_REQUIRED_USERS", nil),
+		MFAStoreFile:          getEnv("MFA_STORE_FILE", ""),
+		MFAIssuer:             getEnv("MFA_ISSUER", "IAG"),
+		EnableWebAuthn:        getEnvAsBool("ENABLE_WEBAUTHN", false),
+		WebAuthnRPID:          getEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:        getEnv("WEBAUTHN_RP_NAME", "IAG"),
+		WebAuthnStoreFile:     getEnv("WEBAUTHN_STORE_FILE", ""),
+
+		EnableLocalAccounts:    getEnvAsBool("ENABLE_LOCAL_ACCOUNTS", false),
+		LocalAccountsFile:      getEnv("LOCAL_ACCOUNTS_FILE", ""),
+		LocalArgon2Memory:      getEnvAsInt("LOCAL_ARGON2_MEMORY_KIB", 64*1024),
+		LocalArgon2Iterations:  getEnvAsInt("LOCAL_ARGON2_ITERATIONS", 3),
+		LocalArgon2Parallelism: getEnvAsInt("LOCAL_ARGON2_PARALLELISM", 2),
+		LocalPasswordMinLength: getEnvAsInt("LOCAL_PASSWORD_MIN_LENGTH", 12),
+
+		LocalArgon2MaxConcurrency: getEnvAsInt("LOCAL_ARGON2_MAX_CONCURRENCY", 4),
+		PasswordMaxAttempts:       getEnvAsInt("PASSWORD_MAX_ATTEMPTS", 5),
+		PasswordIPMaxFailures:     getEnvAsInt("PASSWORD_IP_MAX_FAILURES", 20),
+
+		EnableLDAP:         getEnvAsBool("ENABLE_LDAP", false),
+		LDAPURL:            getEnv("LDAP_URL", ""),
+		LDAPStartTLS:       getEnvAsBool("LDAP_START_TLS", false),
+		LDAPBindDN:         getEnv("LDAP_BIND_DN", ""),
+		LDAPBindPassword:   getEnv("LDAP_BIND_PASSWORD", ""),
+		LDAPBaseDN:         getEnv("LDAP_BASE_DN", ""),
+		LDAPUserFilter:     getEnv("LDAP_USER_FILTER", "(&(objectClass=user)(sAMAccountName={username}))"),
+		LDAPIDAttribute:    getEnv("LDAP_ID_ATTRIBUTE", "objectGUID"),
+		LDAPEmailAttribute: getEnv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		LDAPNameAttribute:  getEnv("LDAP_NAME_ATTRIBUTE", "displayName"),
+		LDAPGroupAttribute: getEnv("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+
+		EnableSAML:          getEnvAsBool("ENABLE_SAML", false),
+		SAMLIDPMetadataFile: getEnv("SAML_IDP_METADATA_FILE", ""),
+		SAMLIDPMetadataURL:  getEnv("SAML_IDP_METADATA_URL", ""),
+		SAMLCertFile:        getEnv("SAML_CERT_FILE", ""),
+		SAMLKeyFile:         getEnv("SAML_KEY_FILE", ""),
+		SAMLIDAttribute:     getEnv("SAML_ID_ATTRIBUTE", ""),
+		SAMLEmailAttributes: getEnvAsSlice("SAML_EMAIL_ATTRIBUTES", []string{
+			"email", "mail", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+		}),
+		SAMLNameAttributes: getEnvAsSlice("SAML_NAME_ATTRIBUTES", []string{
+			"name", "displayName", "http://schemas.microsoft.com/identity/claims/displayname",
+		}),
+		SAMLGroupAttributes: getEnvAsSlice("SAML_GROUP_ATTRIBUTES", []string{
+			"groups", "roles",
+			"http://schemas.microsoft.com/ws/2008/06/identity/claims/groups",
+			"http://schemas.microsoft.com/ws/2008/06/identity/claims/role",
+		}),
+
+		EnableMagicLink:    getEnvAsBool("ENABLE_MAGIC_LINK", false),
+		MagicLinkRoles:     getEnvAsSlice("MAGIC_LINK_ROLES", []string{"viewer"}),
+		MagicLinkRateLimit: getEnvAsInt("MAGIC_LINK_RATE_LIMIT", 5),
+
+		MagicLinkAllowedEmails:  getEnvAsSlice("MAGIC_LINK_ALLOWED_EMAILS", nil),
+		MagicLinkAllowedDomains: getEnvAsSlice("MAGIC_LINK_ALLOWED_DOMAINS", nil),
+		MagicLinkIPRateLimit:    getEnvAsInt("MAGIC_LINK_IP_RATE_LIMIT", 20),
+		MailSender:              getEnv("MAIL_SENDER", "log"),
+		MailFrom:                getEnv("MAIL_FROM", "iag@localhost"),
+		MailFileDir:             getEnv("MAIL_FILE_DIR", ""),
+		SMTPHost:                getEnv("SMTP_HOST", ""),
+		SMTPPort:                getEnv("SMTP_PORT", "587"),
+		SMTPUsername:            getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:            getEnv("SMTP_PASSWORD", ""),
+
+		EnableAccountLinking:     getEnvAsBool("ENABLE_ACCOUNT_LINKING", false),
+		AccountsFile:             getEnv("ACCOUNTS_FILE", ""),
+		AccountAutoLinkProviders: getEnvAsSlice("ACCOUNT_AUTO_LINK_PROVIDERS", nil),
+
+		LoginAllowedEmails:       getEnvAsSlice("LOGIN_ALLOWED_EMAILS", nil),
+		LoginDeniedEmails:        getEnvAsSlice("LOGIN_DENIED_EMAILS", nil),
+		LoginAllowedDomains:      getEnvAsSlice("LOGIN_ALLOWED_DOMAINS", nil),
+		LoginAllowedGoogleHD:     getEnvAsSlice("LOGIN_ALLOWED_GOOGLE_HD", nil),
+		LoginAllowedAzureTenants: getEnvAsSlice("LOGIN_ALLOWED_AZURE_TENANTS", nil),
+		LoginAllowedProviders:    getEnvAsSlice("LOGIN_ALLOWED_PROVIDERS", nil),
+
+		EnableElevation:        getEnvAsBool("ENABLE_ELEVATION", false),
+		ElevationRoles:         getEnvAsSlice("ELEVATION_ROLES", []string{"admin"}),
+		ElevationApproverRoles: getEnvAsSlice("ELEVATION_APPROVER_ROLES", []string{"admin"}),
+		ElevationApprovers:     getEnvAsSlice("ELEVATION_APPROVERS", nil),
+		ElevationStoreFile:     getEnv("ELEVATION_STORE_FILE", ""),
+
+		EnableRoleGrants: getEnvAsBool("ENABLE_ROLE_GRANTS", false),
+		RoleGrantsFile:   getEnv("ROLE_GRANTS_FILE", ""),
+
+		AuditLogFile: getEnv("AUDIT_LOG_FILE", ""),
+
+		EnableBreakGlass:          getEnvAsBool("ENABLE_BREAK_GLASS", false),
+		BreakGlassAccountsFile:    getEnv("BREAK_GLASS_ACCOUNTS_FILE", ""),
+		BreakGlassAlertWebhookURL: getEnv("BREAK_GLASS_ALERT_WEBHOOK_URL", ""),
+		BreakGlassMaxAttempts:     getEnvAsInt("BREAK_GLASS_MAX_ATTEMPTS", 5),
+		BreakGlassIPMaxFailures:   getEnvAsInt("BREAK_GLASS_IP_MAX_FAILURES", 10),
+
+		EnableImpersonation: getEnvAsBool("ENABLE_IMPERSONATION", false),
+		ImpersonationRoles:  getEnvAsSlice("IMPERSONATION_ROLES", []string{"viewer"}),
+
+		EnableOIDCProvider: getEnvAsBool("ENABLE_OIDC_PROVIDER", false),
+		OIDCClientsFile:    getEnv("OIDC_CLIENTS_FILE", ""),
+		OIDCSigningKeyFile: getEnv("OIDC_SIGNING_KEY_FILE", ""),
+
+		OIDCClientStoreFile: getEnv("OIDC_CLIENT_STORE_FILE", ""),
+
+		OIDCRegistrationToken: getEnv("OIDC_REGISTRATION_TOKEN", ""),
+	}
+	config.OIDCIssuer = getEnv("OIDC_ISSUER", "http://localhost:"+config.ServerPort)
+	config.SAMLEntityID = getEnv("SAML_ENTITY_ID", "http://localhost:"+config.ServerPort+"/saml/metadata")
+	config.SAMLACSURL = getEnv("SAML_ACS_URL", "http://localhost:"+config.ServerPort+"/saml/acs")
+	config.MagicLinkURL = getEnv("MAGIC_LINK_URL", "http://localhost:"+config.ServerPort+"/auth/magic-link/verify")
+	config.WebAuthnOrigins = getEnvAsSlice("WEBAUTHN_ORIGINS", []string{"http://localhost:" + config.ServerPort})
+
+	// Session policies
+	var err error
+	if config.SessionPolicy.IdleTimeout, err = getEnvAsDuration("SESSION_IDLE_TIMEOUT", 30*time.Minute); err != nil {
+		return nil, err
+	}
+	if config.SessionPolicy.MaxLifetime, err = getEnvAsDuration("SESSION_MAX_LIFETIME", 0); err != nil {
+		return nil, err
+	}
+	config.SessionPolicy.MaxConcurrent = getEnvAsInt("SESSION_MAX_CONCURRENT", 0)
+	if config.SessionRolePolicies, err = parseRoleSessionPolicies(getEnv("SESSION_ROLE_POLICIES", "admin:max=8h")); err != nil {
+		return nil, err
+	}
+
+	// Local account token lifetimes
+	if config.LocalInviteTTL, err = getEnvAsDuration("LOCAL_INVITE_TTL", 72*time.Hour); err != nil {
+		return nil, err
+	}
+	if config.LocalResetTTL, err = getEnvAsDuration("LOCAL_RESET_TTL", time.Hour); err != nil {
+		return nil, err
+	}
+
+	// LDAP login
+	if config.LDAPGroupRoles, err = parseLDAPGroupRoles(getEnv("LDAP_GROUP_ROLES", "")); err != nil {
+		return nil, err
+	}
+	if config.LDAPTimeout, err = getEnvAsDuration("LDAP_TIMEOUT", 10*time.Second); err != nil {
+		return nil, err
+	}
+	if config.PasswordAttemptWindow, err = getEnvAsDuration("PASSWORD_ATTEMPT_WINDOW", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if config.EnableLDAP && (config.LDAPURL == "" || config.LDAPBaseDN == "") {
+		return nil, fmt.Errorf("LDAP_URL and LDAP_BASE_DN are required when ENABLE_LDAP is set")
+	}
+
+	// SAML login
+	if config.SAMLGroupRoles, err = parseSAMLGroupRoles(getEnv("SAML_GROUP_ROLES", "")); err != nil {
+		return nil, err
+	}
+	if config.EnableSAML && config.SAMLIDPMetadataFile == "" && config.SAMLIDPMetadataURL == "" {
+		return nil, fmt.Errorf("SAML_IDP_METADATA_FILE or SAML_IDP_METADATA_URL is required when ENABLE_SAML is set")
+	}
+
+	// Magic link login
+	if config.MagicLinkTTL, err = getEnvAsDuration("MAGIC_LINK_TTL", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if config.MagicLinkRateWindow, err = getEnvAsDuration("MAGIC_LINK_RATE_WINDOW", time.Hour); err != nil {
+		return nil, err
+	}
+	if config.EnableMagicLink && len(config.MagicLinkAllowedEmails) == 0 && len(config.MagicLinkAllowedDomains) == 0 {
+		return nil, fmt.Errorf("MAGIC_LINK_ALLOWED_EMAILS or MAGIC_LINK_ALLOWED_DOMAINS is required when ENABLE_MAGIC_LINK is set")
+	}
+
+	// Role elevation
+	if config.ElevationMaxDuration, err = getEnvAsDuration("ELEVATION_MAX_DURATION", 4*time.Hour); err != nil {
+		return nil, err
+	}
+	if config.ElevationRequestTTL, err = getEnvAsDuration("ELEVATION_REQUEST_TTL", 24*time.Hour); err != nil {
+		return nil, err
+	}
+
+	// Break-glass accounts
+	if config.BreakGlassTokenTTL, err = getEnvAsDuration("BREAK_GLASS_TOKEN_TTL", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if config.BreakGlassAlertInterval, err = getEnvAsDuration("BREAK_GLASS_ALERT_INTERVAL", time.Minute); err != nil {
+		return nil, err
+	}
+	if config.BreakGlassAttemptWindow, err = getEnvAsDuration("BREAK_GLASS_ATTEMPT_WINDOW", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	if config.EnableBreakGlass && config.BreakGlassAccountsFile == "" {
+		return nil, fmt.Errorf("BREAK_GLASS_ACCOUNTS_FILE is required when ENABLE_BREAK_GLASS is set")
+	}
+
+	// Impersonation
+	if config.ImpersonationTokenTTL, err = getEnvAsDuration("IMPERSONATION_TOKEN_TTL", 15*time.Minute); err != nil {
+		return nil, err
+	}
+	for _, role := range config.ImpersonationRoles {
+		if role == "admin" {
+			return nil, fmt.Errorf("IMPERSONATION_ROLES must not include admin")
+		}
+	}
+
+	// Step-up for admin endpoints
+	if config.AdminMaxAuthAge, err = getEnvAsDuration("ADMIN_MAX_AUTH_AGE", 0); err != nil {
+		return nil, err
 	}
 
 	// Set provider-specific OAuth endpoints
@@ -47,6 +466,8 @@ func LoadConfig() (*Config, error) {
 		config.OAuthAuthURL = "https://accounts.google.com/o/oauth2/v2/auth"
 		config.OAuthTokenURL = "https://oauth2.googleapis.com/token"
 		config.OAuthUserInfoURL = "https://www.googleapis.com/oauth2/v2/userinfo"
+		config.OAuthIssuer = "https://accounts.google.com"
+		config.OAuthJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
 		config.OAuthScopes = []string{"openid", "profile", "email"}
 	case "okta":
 		oktaDomain := getEnv("OKTA_DOMAIN", "")
@@ -56,16 +477,27 @@ func LoadConfig() (*Config, error) {
 		config.OAuthAuthURL = fmt.Sprintf("https://%s/oauth2/v1/authorize", oktaDomain)
 		config.OAuthTokenURL = fmt.Sprintf("https://%s/oauth2/v1/token", oktaDomain)
 		config.OAuthUserInfoURL = fmt.Sprintf("https://%s/oauth2/v1/userinfo", oktaDomain)
+		config.OAuthEndSessionURL = fmt.Sprintf("https://%s/oauth2/v1/logout", oktaDomain)
+		config.OAuthIssuer = fmt.Sprintf("https://%s", oktaDomain)
+		config.OAuthJWKSURL = fmt.Sprintf("https://%s/oauth2/v1/keys", oktaDomain)
 		config.OAuthScopes = []string{"openid", "profile", "email"}
 	case "azure":
 		tenantID := getEnv("AZURE_TENANT_ID", "common")
 		config.OAuthAuthURL = fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/authorize", tenantID)
 		config.OAuthTokenURL = fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", tenantID)
 		config.OAuthUserInfoURL = "https://graph.microsoft.com/v1.0/me"
+		config.OAuthEndSessionURL = fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/logout", tenantID)
+		config.OAuthIssuer = fmt.Sprintf("https://login.microsoftonline.com/%s/v2.0", tenantID)
+		config.OAuthJWKSURL = fmt.Sprintf("https://login.microsoftonline.com/%s/discovery/v2.0/keys", tenantID)
 		config.OAuthScopes = []string{"openid", "profile", "email"}
 	default:
 		return nil, fmt.Errorf("unsupported OAuth provider: %s", config.OAuthProvider)
 	}
+	// Google has no end_session_endpoint, so logout stays local unless one
+	// is configured
+	config.OAuthEndSessionURL = getEnv("OAUTH_END_SESSION_URL", config.OAuthEndSessionURL)
+	config.OAuthIssuer = getEnv("OAUTH_ISSUER", config.OAuthIssuer)
+	config.OAuthJWKSURL = getEnv("OAUTH_JWKS_URL", config.OAuthJWKSURL)
 
 	// Validate required config
 	if config.OAuthClientID == "" {
@@ -95,6 +527,20 @@ func getEnvAsInt(key string, defaultValue int) int {
 	return value
 }
 
+func getEnvAsSlice(key string, defaultValue []string) []string {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	var values []string
+	for _, v := range strings.Split(valueStr, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
 func getEnvAsBool(key string, defaultValue bool) bool {
 	valueStr := os.Getenv(key)
 	if valueStr == "" {
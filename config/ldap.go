@@ -0,0 +1,32 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LDAPGroupRole grants a role to members of an LDAP group
+type LDAPGroupRole struct {
+	Role    string
+	GroupDN string
+}
+
+// parseLDAPGroupRoles parses group-to-role mappings in the form
+// "admin=CN=IAG Admins,OU=Groups,DC=corp,DC=example,DC=com;viewer=CN=..."
+func parseLDAPGroupRoles(value string) ([]LDAPGroupRole, error) {
+	var mappings []LDAPGroupRole
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		role, groupDN, ok := strings.Cut(entry, "=")
+		role, groupDN = strings.TrimSpace(role), strings.TrimSpace(groupDN)
+		if !ok || role == "" || groupDN == "" {
+			return nil, fmt.Errorf("invalid LDAP group mapping %q: expected role=group DN", entry)
+		}
+		mappings = append(mappings, LDAPGroupRole{Role: role, GroupDN: groupDN})
+	}
+	return mappings, nil
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SAMLGroupRole grants a role to users whose assertion lists a group
+type SAMLGroupRole struct {
+	Role  string
+	Group string
+}
+
+// parseSAMLGroupRoles parses group-to-role mappings in the form
+// "admin=IAG Admins;viewer=Auditors"
+func parseSAMLGroupRoles(value string) ([]SAMLGroupRole, error) {
+	var mappings []SAMLGroupRole
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		role, group, ok := strings.Cut(entry, "=")
+		role, group = strings.TrimSpace(role), strings.TrimSpace(group)
+		if !ok || role == "" || group == "" {
+			return nil, fmt.Errorf("invalid SAML group mapping %q: expected role=group", entry)
+		}
+		mappings = append(mappings, SAMLGroupRole{Role: role, Group: group})
+	}
+	return mappings, nil
+}
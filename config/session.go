@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SessionPolicy limits how long and how many sessions a user may hold.
+// Zero values mean no limit.
+type SessionPolicy struct {
+	IdleTimeout   time.Duration
+	MaxLifetime   time.Duration
+	MaxConcurrent int
+}
+
+// parseRoleSessionPolicies parses per-role overrides in the form
+// "admin:idle=15m,max=8h,concurrent=2;viewer:idle=1h"
+func parseRoleSessionPolicies(value string) (map[string]SessionPolicy, error) {
+	policies := make(map[string]SessionPolicy)
+	if value == "" {
+		return policies, nil
+	}
+
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		role, settings, ok := strings.Cut(entry, ":")
+		if !ok || role == "" {
+			return nil, fmt.Errorf("invalid session policy %q: expected role:settings", entry)
+		}
+
+		var policy SessionPolicy
+		for _, setting := range strings.Split(settings, ",") {
+			key, val, ok := strings.Cut(strings.TrimSpace(setting), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid session policy setting %q for role %s", setting, role)
+			}
+
+			var err error
+			switch key {
+			case "idle":
+				policy.IdleTimeout, err = time.ParseDuration(val)
+			case "max":
+				policy.MaxLifetime, err = time.ParseDuration(val)
+			case "concurrent":
+				policy.MaxConcurrent, err = strconv.Atoi(val)
+			default:
+				err = fmt.Errorf("unknown setting %s", key)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid session policy for role %s: %w", role, err)
+			}
+		}
+		policies[strings.TrimSpace(role)] = policy
+	}
+	return policies, nil
+}
+
+func getEnvAsDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	valueStr := getEnv(key, "")
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return value, nil
+}
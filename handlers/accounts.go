@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/middleware"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// accountLinkCookieName holds the ID of a link request. The next login in
+// the browser links its identity to the requesting account instead of
+// resolving to an account of its own.
+const accountLinkCookieName = "account_link"
+
+// AccountHandler handles the logged-in user's canonical account and its
+// linked identities
+type AccountHandler struct {
+	auth     *AuthHandler
+	accounts *auth.AccountService
+}
+
+// NewAccountHandler creates a new account handler
+func NewAccountHandler(authHandler *AuthHandler, accounts *auth.AccountService) *AccountHandler {
+	return &AccountHandler{
+		auth:     authHandler,
+		accounts: accounts,
+	}
+}
+
+// Get returns the user's account with its linked identities
+func (h *AccountHandler) Get(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	account, err := h.accounts.Account(user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Account not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to load account: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(account)
+}
+
+// StartLink starts linking another identity to the user's account. The
+// user then logs in with that identity in the same browser.
+func (h *AccountHandler) StartLink(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	linkID, err := h.accounts.StartLink(user.ID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Account not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to start linking: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// SAML responses arrive as cross-site POSTs, which only carry
+	// SameSite=None cookies
+	sameSite := http.SameSiteLaxMode
+	if h.auth.config.CookieSecure {
+		sameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     accountLinkCookieName,
+		Value:    linkID,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.auth.config.CookieSecure,
+		SameSite: sameSite,
+		MaxAge:   600, // 10 minutes, matching the link request lifetime
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":    "Log in with the identity to link in this browser",
+		"expires_in": 600,
+	})
+}
+
+// Unlink removes a linked identity from the user's account
+func (h *AccountHandler) Unlink(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.accounts.Unlink(user.ID, r.PathValue("provider"), r.PathValue("subject"))
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Identity not found", http.StatusNotFound)
+		return
+	case errors.Is(err, auth.ErrLastIdentity):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, "Failed to unlink identity: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resolveAccount replaces the provider user ID with the account ID. A login
+// started by StartLink links its identity to the requesting account. It
+// reports false after writing an error response.
+func (h *AuthHandler) resolveAccount(w http.ResponseWriter, r *http.Request, user *models.User) (*models.User, bool) {
+	var resolved *models.User
+	var err error
+	if linkCookie, cookieErr := r.Cookie(accountLinkCookieName); cookieErr == nil {
+		clearCookie(w, accountLinkCookieName)
+		resolved, err = h.accounts.CompleteLink(linkCookie.Value, user)
+	} else {
+		resolved, err = h.accounts.Resolve(user)
+	}
+
+	switch {
+	case errors.Is(err, auth.ErrLinkRequestNotFound):
+		http.Error(w, "Link request expired; start linking again", http.StatusBadRequest)
+		return nil, false
+	case errors.Is(err, store.ErrIdentityLinked):
+		http.Error(w, "This identity is already linked to another account", http.StatusConflict)
+		return nil, false
+	case err != nil:
+		http.Error(w, "Failed to resolve account: "+err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return resolved, true
+}
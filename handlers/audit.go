@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+)
+
+// AuditHandler serves the audit trail to administrators
+type AuditHandler struct {
+	audit *auth.Audit
+}
+
+// NewAuditHandler creates a new audit handler
+func NewAuditHandler(audit *auth.Audit) *AuditHandler {
+	return &AuditHandler{audit: audit}
+}
+
+// List returns the audit trail, oldest first, optionally filtered by the
+// user query parameter, matching the actor or subject, and by action
+func (h *AuditHandler) List(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	events, err := h.audit.List(query.Get("user"), query.Get("action"))
+	if err != nil {
+		http.Error(w, "Failed to list audit events: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": events,
+	})
+}
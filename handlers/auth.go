@@ -4,75 +4,192 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"time"
 
 	"github.com/Hilina-t/microservice-authenticator/auth"
 	"github.com/Hilina-t/microservice-authenticator/config"
 	"github.com/Hilina-t/microservice-authenticator/middleware"
+	"github.com/Hilina-t/microservice-authenticator/models"
 	"github.com/Hilina-t/microservice-authenticator/utils"
+	"github.com/golang-jwt/jwt/v5"
 )
 
+// loginBindingCookieName holds a per-browser random value that login states
+// are bound to. It is shared by all of the browser's in-flight logins.
+const loginBindingCookieName = "oauth_binding"
+
+var loginDeniedPageTemplate = template.Must(template.New("login-denied").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Access denied</title></head>
+<body>
+  <h1>Access denied</h1>
+  <p>{{if .}}The account {{.}} is{{else}}Your account is{{end}} not allowed to sign in to this service.</p>
+  <p>Sign in with your organization account, or ask an administrator for access.</p>
+</body>
+</html>
+`))
+
+var logoutPageTemplate = template.Must(template.New("logout").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Sign out</title></head>
+<body>
+  <h1>Sign out</h1>
+  <form method="POST" action="/auth/logout">
+    <input type="hidden" name="csrf_token" value="{{.}}">
+    <button type="submit">Sign out</button>
+  </form>
+</body>
+</html>
+`))
+
 // AuthHandler handles authentication requests
 type AuthHandler struct {
 	config       *config.Config
 	oauthService *auth.OAuthService
+	authServer   *auth.AuthorizationServer
+	sessions     *auth.SessionManager
+	loginStates  *auth.LoginStateCodec
+	mfa          *auth.MFAService
+	webauthn     *auth.WebAuthnService
+	accounts     *auth.AccountService
+	elevations   *auth.ElevationService
+	roleGrants   *auth.RoleGrantService
+	directory    *auth.UserDirectory
 }
 
-// NewAuthHandler creates a new authentication handler
-func NewAuthHandler(cfg *config.Config, oauthService *auth.OAuthService) *AuthHandler {
+// NewAuthHandler creates a new authentication handler. authServer may be nil
+// when the gateway does not act as an OIDC provider, webauthn when WebAuthn
+// is disabled, accounts when account linking is disabled, elevations when
+// role elevation is disabled, and roleGrants when role grants are disabled.
+// The directory looks up passkey users.
+func NewAuthHandler(cfg *config.Config, oauthService *auth.OAuthService, authServer *auth.AuthorizationServer, sessions *auth.SessionManager, loginStates *auth.LoginStateCodec, mfa *auth.MFAService, webauthn *auth.WebAuthnService, accounts *auth.AccountService, elevations *auth.ElevationService, roleGrants *auth.RoleGrantService, directory *auth.UserDirectory) *AuthHandler {
 	return &AuthHandler{
 		config:       cfg,
 		oauthService: oauthService,
+		authServer:   authServer,
+		sessions:     sessions,
+		loginStates:  loginStates,
+		mfa:          mfa,
+		webauthn:     webauthn,
+		accounts:     accounts,
+		elevations:   elevations,
+		roleGrants:   roleGrants,
+		directory:    directory,
 	}
 }
 
 // Login initiates the OAuth flow
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
-	// Generate random state for CSRF protection
-	state, err := generateRandomState()
+	// Audience and scopes requested for the gateway token
+	params := auth.ParseTokenParams(r.URL.Query())
+	if err := params.Validate(h.config); err != nil {
+		http.Error(w, "Invalid token request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Where to send the browser after login
+	returnTo := r.URL.Query().Get("return_to")
+	if returnTo == "" {
+		returnTo = r.URL.Query().Get("redirect_uri")
+	}
+	if returnTo != "" {
+		if err := auth.ValidateReturnTo(h.config.ReturnToAllowlist, returnTo); err != nil {
+			http.Error(w, "Invalid return_to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// A step-up request from a client that got insufficient_user_authentication
+	maxAge, err := auth.ParseMaxAge(r.URL.Query().Get("max_age"))
+	if err != nil {
+		http.Error(w, "Invalid max_age: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Reuse the browser's binding value so logins in other tabs stay valid
+	binding := ""
+	if bindingCookie, err := r.Cookie(loginBindingCookieName); err == nil {
+		binding = bindingCookie.Value
+	}
+	if binding == "" {
+		if binding, err = generateRandomState(); err != nil {
+			http.Error(w, "Failed to generate state", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Everything needed to finish the login travels in the encrypted state
+	loginState, err := auth.NewLoginState(h.config.OAuthProvider, returnTo, params, binding)
+	if err != nil {
+		http.Error(w, "Failed to generate state", http.StatusInternalServerError)
+		return
+	}
+	loginState.ACR = auth.ParseACRValues(r.URL.Query().Get("acr_values"))
+	loginState.MaxAge = maxAge
+	state, err := h.loginStates.Encode(loginState)
 	if err != nil {
 		http.Error(w, "Failed to generate state", http.StatusInternalServerError)
 		return
 	}
 
-	// Store state in session/cookie for validation (simplified for demo)
 	http.SetCookie(w, &http.Cookie{
-		Name:     "oauth_state",
-		Value:    state,
-		Path:     "/",
+		Name:     loginBindingCookieName,
+		Value:    binding,
+		Path:     "/auth/callback",
 		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
+		Secure:   h.config.CookieSecure,
 		SameSite: http.SameSiteLaxMode,
-		MaxAge:   300, // 5 minutes
+		MaxAge:   600, // 10 minutes, matching the state lifetime
 	})
 
 	// Redirect to OAuth provider
-	authURL := h.oauthService.GetAuthURL(state)
+	authURL := h.oauthService.GetAuthURL(state, loginState)
 	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
 }
 
 // Callback handles the OAuth callback
 func (h *AuthHandler) Callback(w http.ResponseWriter, r *http.Request) {
 	// Verify state parameter
-	stateCookie, err := r.Cookie("oauth_state")
+	loginState, err := h.loginStates.Decode(r.URL.Query().Get("state"))
 	if err != nil {
-		http.Error(w, "State cookie not found", http.StatusBadRequest)
+		http.Error(w, "Invalid state parameter: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	state := r.URL.Query().Get("state")
-	if state != stateCookie.Value {
+	bindingCookie, err := r.Cookie(loginBindingCookieName)
+	if err != nil {
+		http.Error(w, "State cookie not found", http.StatusBadRequest)
+		return
+	}
+	if !loginState.BoundTo(bindingCookie.Value) {
 		http.Error(w, "Invalid state parameter", http.StatusBadRequest)
 		return
 	}
+	if loginState.Provider != h.config.OAuthProvider {
+		http.Error(w, "Invalid state parameter: provider mismatch", http.StatusBadRequest)
+		return
+	}
 
-	// Clear state cookie
-	http.SetCookie(w, &http.Cookie{
-		Name:   "oauth_state",
-		Value:  "",
-		Path:   "/",
-		MaxAge: -1,
-	})
+	// The allowlists may have changed since login, so check again
+	params := loginState.Params
+	if err := params.Validate(h.config); err != nil {
+		http.Error(w, "Invalid token request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	returnTo := loginState.ReturnTo
+	if returnTo != "" {
+		if err := auth.ValidateReturnTo(h.config.ReturnToAllowlist, returnTo); err != nil {
+			http.Error(w, "Invalid return_to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Exchange authorization code for token
 	code := r.URL.Query().Get("code")
@@ -81,26 +198,225 @@ func (h *AuthHandler) Callback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := h.oauthService.ExchangeCode(r.Context(), code)
+	token, err := h.oauthService.ExchangeCode(r.Context(), code, loginState.CodeVerifier)
 	if err != nil {
 		http.Error(w, "Failed to exchange code: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// The ID token must be signed by the provider and carry this login's
+	// nonce, so a token replayed or injected from another login is refused
+	upstreamIDToken := h.oauthService.IDToken(token)
+	idToken, err := h.oauthService.VerifyIDToken(upstreamIDToken, loginState.Nonce)
+	if err != nil {
+		log.Printf("Login rejected: %v", err)
+		http.Error(w, "Invalid ID token", http.StatusUnauthorized)
+		return
+	}
+	if err := loginState.SetAuthTime(idToken.AuthTime, time.Now()); err != nil {
+		log.Printf("Login rejected: %v", err)
+		http.Error(w, "Authentication is not recent enough", http.StatusUnauthorized)
+		return
+	}
+
 	// Get user information
 	user, err := h.oauthService.GetUserInfo(r.Context(), token)
 	if err != nil {
 		http.Error(w, "Failed to get user info: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if err := idToken.CheckUserInfo(h.config.OAuthProvider, user); err != nil {
+		log.Printf("Login rejected: %v", err)
+		http.Error(w, "Invalid user info", http.StatusUnauthorized)
+		return
+	}
+
+	h.completeFirstFactor(w, r, user, loginState, upstreamIDToken, []string{auth.AMRFederated})
+}
+
+// completeFirstFactor sends users who need a second factor, who registered
+// a security key, or whose client asked for more than the first factor to
+// /auth/mfa, and finishes the login for everyone else. Every login method
+// comes through here, so the login rules are applied to all of them.
+func (h *AuthHandler) completeFirstFactor(w http.ResponseWriter, r *http.Request, user *models.User, loginState *auth.LoginState, upstreamIDToken string, amr []string) {
+	if !h.loginAllowed(w, user, upstreamIDToken) {
+		return
+	}
+
+	// Everything after this point knows the user by their account ID
+	if h.accounts != nil {
+		var ok bool
+		if user, ok = h.resolveAccount(w, r, user); !ok {
+			return
+		}
+	}
+
+	// Keep the provider's profile and roles for passkey logins, which never
+	// reach the provider
+	if h.webauthn != nil {
+		if err := h.webauthn.RecordProfile(user); err != nil {
+			http.Error(w, "Failed to record profile: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// MFA is decided on the roles the token will carry, so a granted or
+	// elevated role on the MFA policy lists requires a second factor like a
+	// held one
+	user, ok := h.applyRoles(w, user)
+	if !ok {
+		return
+	}
+
+	mfaRequired, err := h.mfa.Required(user)
+	if err != nil {
+		http.Error(w, "Failed to check MFA: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !auth.ACRSatisfies(auth.ACRBasic, loginState.ACR) {
+		mfaRequired = true
+	}
+	hasSecurityKey := false
+	if h.webauthn != nil {
+		if hasSecurityKey, err = h.webauthn.HasCredentials(user.ID); err != nil {
+			http.Error(w, "Failed to check MFA: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	if mfaRequired || hasSecurityKey {
+		challengeID, err := h.mfa.StartChallenge(&auth.PendingLogin{
+			User:            user,
+			State:           loginState,
+			UpstreamIDToken: upstreamIDToken,
+			AMR:             amr,
+			WebAuthn:        hasSecurityKey,
+		})
+		if err != nil {
+			http.Error(w, "Failed to start MFA challenge: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     mfaChallengeCookieName,
+			Value:    challengeID,
+			Path:     "/auth/mfa",
+			HttpOnly: true,
+			Secure:   h.config.CookieSecure,
+			SameSite: http.SameSiteLaxMode,
+			MaxAge:   300, // 5 minutes
+		})
+		http.Redirect(w, r, "/auth/mfa", http.StatusFound)
+		return
+	}
+
+	h.finishLogin(w, r, user, loginState, upstreamIDToken, amr)
+}
+
+// loginAllowed applies the login rules to the user as the provider reported
+// them, and renders the denied page for users the rules reject
+func (h *AuthHandler) loginAllowed(w http.ResponseWriter, user *models.User, upstreamIDToken string) bool {
+	if err := auth.CheckLoginAllowed(h.config, user, auth.ParseUpstreamClaims(upstreamIDToken)); err != nil {
+		log.Printf("Login rejected: %v", err)
+		renderLoginDeniedPage(w, user.Email)
+		return false
+	}
+	return true
+}
+
+// applyRoles adds the user's granted and elevated roles, before any token
+// is issued, including by the OIDC provider; the tokens end when the first
+// granted or elevated role does. It renders the denied page or an error and reports false when
+// the login cannot go on.
+func (h *AuthHandler) applyRoles(w http.ResponseWriter, user *models.User) (*models.User, bool) {
+	if h.roleGrants != nil {
+		granted, err := h.roleGrants.Apply(user)
+		if errors.Is(err, auth.ErrNoActiveRoleGrant) {
+			log.Printf("Login rejected for user %s: %v", user.ID, err)
+			renderLoginDeniedPage(w, user.Email)
+			return nil, false
+		}
+		if err != nil {
+			http.Error(w, "Failed to apply role grants: "+err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+		user = granted
+	}
+
+	// Add the roles of approved elevations; the token ends with the first
+	// of them, so no elevated role outlives its approval
+	if h.elevations != nil {
+		var err error
+		if user, err = h.elevations.Elevate(user); err != nil {
+			http.Error(w, "Failed to apply role elevations: "+err.Error(), http.StatusInternalServerError)
+			return nil, false
+		}
+	}
+	return user, true
+}
+
+// finishLogin issues the gateway token once the user has passed every
+// login step, or hands the user back to a pending OIDC provider flow. The
+// user's roles must already have been applied with applyRoles.
+func (h *AuthHandler) finishLogin(w http.ResponseWriter, r *http.Request, user *models.User, loginState *auth.LoginState, upstreamIDToken string, amr []string) {
+	// Record the login as a server-side session the user can revoke. The
+	// session policy follows the roles the token will carry. Every token
+	// from this login is bound to it, including those issued to clients.
+	session, err := h.sessions.Create(user, clientIP(r), r.UserAgent(), upstreamIDToken)
+	if err != nil {
+		http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.setLogoutCookie(w, session)
+
+	// Resume a pending /oauth/authorize or device login
+	if h.resumePendingLogin(w, r, user, loginState.AuthenticatedAt(), session) {
+		return
+	}
+
+	params := loginState.Params
+	returnTo := loginState.ReturnTo
 
 	// Generate JWT token
-	jwtToken, err := utils.GenerateJWT(user, h.config.JWTSecret, h.config.JWTExpiration)
+	claims := utils.NewClaims(user, time.Hour*time.Duration(h.config.JWTExpiration))
+	claims.SessionID = session.ID
+	claims.AMR = amr
+	claims.ACR = auth.ACRForAMR(amr)
+	if authTime := loginState.AuthenticatedAt(); !authTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(authTime)
+	}
+	if !session.ExpiresAt.IsZero() && session.ExpiresAt.Before(claims.ExpiresAt.Time) {
+		claims.ExpiresAt = jwt.NewNumericDate(session.ExpiresAt)
+	}
+	params.Apply(claims)
+	jwtToken, err := utils.SignJWT(claims, h.config.JWTSecret)
 	if err != nil {
 		http.Error(w, "Failed to generate JWT: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// In session mode the browser keeps the token in a cookie
+	if h.config.EnableSessionCookies {
+		if returnTo == "" {
+			returnTo = "/"
+		}
+
+		h.setSessionCookies(w, jwtToken, claims.ExpiresAt.Time)
+		http.Redirect(w, r, returnTo, http.StatusFound)
+		return
+	}
+
+	// Otherwise hand the token to the page in the URL fragment, which
+	// browsers never send to servers
+	if returnTo != "" {
+		target, err := url.Parse(returnTo)
+		if err != nil {
+			http.Error(w, "Invalid return_to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		target.Fragment = "token=" + jwtToken
+		http.Redirect(w, r, target.String(), http.StatusFound)
+		return
+	}
+
 	// Return JWT token to client
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -121,11 +437,199 @@ func (h *AuthHandler) Profile(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
-// Logout handles user logout
+// LogoutPage asks the user to confirm signing out. Logout itself is a POST
+// with the CSRF token, so a cross-site link or image cannot end the
+// session.
+func (h *AuthHandler) LogoutPage(w http.ResponseWriter, r *http.Request) {
+	csrfToken := ""
+	if cookie, err := r.Cookie(h.config.CSRFCookieName); err == nil {
+		csrfToken = cookie.Value
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	logoutPageTemplate.Execute(w, csrfToken)
+}
+
+// Logout handles user logout. Besides ending the gateway session it ends
+// the provider session where the provider supports RP-initiated logout, so
+// the next login prompts for credentials again.
 func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
+	// End the server-side session so the token stops working everywhere
+	idTokenHint := ""
+	federated := true
+	if claims, ok := middleware.GetClaimsFromContext(r.Context()); ok {
+		if claims.SessionID != "" {
+			if session, err := h.sessions.Get(claims.UserID, claims.SessionID); err == nil {
+				idTokenHint = session.UpstreamIDToken
+			}
+			h.sessions.Revoke(claims.UserID, claims.SessionID)
+		}
+		// Local password and passkey logins have no IdP session to end, and
+		// SAML logins have none at the OAuth provider
+		federated = (len(claims.AMR) == 0 || slices.Contains(claims.AMR, auth.AMRFederated)) &&
+			claims.Provider != auth.SAMLProvider
+	}
+	endSessionURL := ""
+	if federated {
+		endSessionURL = h.oauthService.EndSessionURL(idTokenHint)
+	}
+
+	// In session mode the browser is sent through the provider logout
+	if h.config.EnableSessionCookies {
+		clearCookie(w, h.config.SessionCookieName)
+		clearCookie(w, h.config.CSRFCookieName)
+
+		target := endSessionURL
+		if target == "" {
+			target = h.config.PostLogoutRedirectURL
+		}
+		if target != "" {
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+	}
+
+	// API clients navigate to the provider logout themselves
+	response := map[string]string{
 		"message": "Logged out successfully",
+	}
+	if endSessionURL != "" {
+		response["end_session_url"] = endSessionURL
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// resumePendingLogin finishes an OIDC provider flow that sent the user
+// through the provider login, which the user completed at authTime. It
+// reports whether a response was written.
+func (h *AuthHandler) resumePendingLogin(w http.ResponseWriter, r *http.Request, user *models.User, authTime time.Time, session *models.Session) bool {
+	if h.authServer == nil {
+		return false
+	}
+
+	if authorizeCookie, err := r.Cookie(authorizeCookieName); err == nil {
+		clearCookie(w, authorizeCookieName)
+
+		redirectURL, err := h.authServer.CompleteAuthorization(authorizeCookie.Value, user, authTime, session)
+		if err != nil {
+			http.Error(w, "Failed to complete authorization: "+err.Error(), http.StatusBadRequest)
+			return true
+		}
+		http.Redirect(w, r, redirectURL, http.StatusFound)
+		return true
+	}
+
+	if deviceCookie, err := r.Cookie(deviceCookieName); err == nil {
+		clearCookie(w, deviceCookieName)
+
+		// The device is only approved once the user confirms the client
+		consent, err := h.authServer.RequestDeviceConsent(deviceCookie.Value, user, authTime, session)
+		if err != nil {
+			renderDevicePage(w, http.StatusBadRequest, devicePageData{
+				Error: "Failed to approve device: " + err.Error(),
+			})
+			return true
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     deviceConsentCookieName,
+			Value:    consent.Token,
+			Path:     "/oauth/device",
+			HttpOnly: true,
+			Secure:   h.config.CookieSecure,
+			SameSite: http.SameSiteStrictMode,
+			MaxAge:   300, // 5 minutes
+		})
+		renderDevicePage(w, http.StatusOK, devicePageData{Consent: consent})
+		return true
+	}
+
+	return false
+}
+
+// directLoginState validates the return_to, audience and scope of a login
+// that does not go through the IdP, so has no provider state to carry them
+func (h *AuthHandler) directLoginState(values url.Values) (*auth.LoginState, error) {
+	params := auth.ParseTokenParams(values)
+	if err := params.Validate(h.config); err != nil {
+		return nil, errors.New("Invalid token request: " + err.Error())
+	}
+	returnTo := values.Get("return_to")
+	if returnTo != "" {
+		if err := auth.ValidateReturnTo(h.config.ReturnToAllowlist, returnTo); err != nil {
+			return nil, errors.New("Invalid return_to: " + err.Error())
+		}
+	}
+	return &auth.LoginState{ReturnTo: returnTo, Params: params}, nil
+}
+
+// setSessionCookies stores the JWT in an HttpOnly session cookie and the
+// matching CSRF token in a cookie readable by page scripts, which must echo
+// it in the X-CSRF-Token header on state-changing requests
+func (h *AuthHandler) setSessionCookies(w http.ResponseWriter, jwtToken string, expiresAt time.Time) {
+	maxAge := int(time.Until(expiresAt).Seconds())
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.config.SessionCookieName,
+		Value:    jwtToken,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.config.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   maxAge,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.config.CSRFCookieName,
+		Value:    utils.CSRFToken(jwtToken, h.config.JWTSecret),
+		Path:     "/",
+		HttpOnly: false,
+		Secure:   h.config.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   maxAge,
+	})
+}
+
+// setLogoutCookie remembers the gateway session of a provider login in this
+// browser, so a front-channel logout loaded here can only end this
+// browser's session. The provider loads the logout page in a cross-site
+// iframe, so the cookie must be SameSite=None, which browsers only accept
+// on secure cookies.
+func (h *AuthHandler) setLogoutCookie(w http.ResponseWriter, session *models.Session) {
+	if session.UpstreamSessionID == "" {
+		return
+	}
+	cookie := &http.Cookie{
+		Name:     logoutCookieName,
+		Value:    session.ID,
+		Path:     logoutCookiePath,
+		HttpOnly: true,
+		Secure:   h.config.CookieSecure,
+		SameSite: http.SameSiteNoneMode,
+	}
+	if !session.ExpiresAt.IsZero() {
+		cookie.MaxAge = int(time.Until(session.ExpiresAt).Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
+
+// clientIP returns the address of the directly connected client
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func clearCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   name,
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
 	})
 }
 
@@ -136,3 +640,12 @@ func generateRandomState() (string, error) {
 	}
 	return base64.URLEncoding.EncodeToString(b), nil
 }
+
+// renderLoginDeniedPage tells users the login rules rejected them, without
+// saying which rule did
+func renderLoginDeniedPage(w http.ResponseWriter, email string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	loginDeniedPageTemplate.Execute(w, email)
+}
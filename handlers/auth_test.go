@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestConfig() *config.Config {
+	return &config.Config{
+		JWTSecret:         "test-secret",
+		JWTExpiration:     1,
+		OAuthProvider:     "google",
+		OAuthAuthURL:      "https://idp.example.com/authorize",
+		ReturnToAllowlist: []string{"https://app.example.com/portal"},
+	}
+}
+
+func newTestAuthHandler(t *testing.T, cfg *config.Config) *AuthHandler {
+	t.Helper()
+	loginStates, err := auth.NewLoginStateCodec(cfg.JWTSecret)
+	if err != nil {
+		t.Fatalf("NewLoginStateCodec() error = %v", err)
+	}
+	return NewAuthHandler(cfg, auth.NewOAuthService(cfg), nil,
+		auth.NewSessionManager(cfg, store.NewMemorySessionStore()), loginStates,
+		auth.NewMFAService(cfg, store.NewMemoryMFAStore()), nil, nil, nil, nil, auth.NewUserDirectory(cfg, nil, nil))
+}
+
+func TestAuthHandler_LoginReturnTo(t *testing.T) {
+	h := newTestAuthHandler(t, newTestConfig())
+
+	tests := []struct {
+		name     string
+		returnTo string
+		wantCode int
+	}{
+		{"Local path", "/dashboard", http.StatusTemporaryRedirect},
+		{"Allowed URL", "https://app.example.com/portal/me", http.StatusTemporaryRedirect},
+		{"Tab before slash", "/\t/evil.com", http.StatusBadRequest},
+		{"Backslash", "/\\evil.com", http.StatusBadRequest},
+		{"Invalid escape", "/%zz", http.StatusBadRequest},
+		{"Tab and invalid escape", "/\t/x%zz", http.StatusBadRequest},
+		{"Dot segments escape prefix", "https://app.example.com/portal/../admin", http.StatusBadRequest},
+		{"Scheme relative", "//evil.com/", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/auth/login?"+url.Values{"return_to": {tt.returnTo}}.Encode(), nil)
+			w := httptest.NewRecorder()
+			h.Login(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("Login(return_to=%q) status = %d, want %d", tt.returnTo, w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAuthHandler_FinishLoginReturnTo(t *testing.T) {
+	h := newTestAuthHandler(t, newTestConfig())
+	user := &models.User{ID: "123", Roles: []string{"user"}}
+
+	// A return_to that fails to parse is rejected, not dereferenced
+	for _, returnTo := range []string{"/%zz", "/\t/x"} {
+		w := httptest.NewRecorder()
+		h.finishLogin(w, httptest.NewRequest(http.MethodGet, "/auth/callback", nil), user,
+			&auth.LoginState{ReturnTo: returnTo}, "", []string{auth.AMRFederated})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("finishLogin(return_to=%q) status = %d, want %d", returnTo, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.finishLogin(w, httptest.NewRequest(http.MethodGet, "/auth/callback", nil), user,
+		&auth.LoginState{ReturnTo: "/dashboard"}, "", []string{auth.AMRFederated})
+	if location := w.Header().Get("Location"); w.Code != http.StatusFound || !strings.HasPrefix(location, "/dashboard#token=") {
+		t.Errorf("finishLogin() = %d to %q, want a redirect to /dashboard with the token", w.Code, location)
+	}
+}
+
+func TestAuthHandler_FinishLoginAuthTime(t *testing.T) {
+	cfg := newTestConfig()
+	h := newTestAuthHandler(t, cfg)
+	user := &models.User{ID: "123", Roles: []string{"user"}}
+	authTime := time.Now().Add(-20 * time.Minute).Unix()
+
+	tests := []struct {
+		name     string
+		authTime int64
+		want     *int64
+	}{
+		{"Reported by the IdP", authTime, &authTime},
+		{"Unknown", 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.finishLogin(w, httptest.NewRequest(http.MethodGet, "/auth/callback", nil), user,
+				&auth.LoginState{ReturnTo: "/dashboard", AuthTime: tt.authTime}, "", []string{auth.AMRFederated})
+			_, token, _ := strings.Cut(w.Header().Get("Location"), "#token=")
+			claims, err := utils.ValidateJWT(token, cfg.JWTSecret)
+			if err != nil {
+				t.Fatalf("ValidateJWT() error = %v", err)
+			}
+
+			// The token must carry the login's auth time, not its own issue time
+			switch {
+			case tt.want == nil && claims.AuthTime != nil:
+				t.Errorf("finishLogin() auth_time = %v, want none", claims.AuthTime)
+			case tt.want != nil && (claims.AuthTime == nil || claims.AuthTime.Unix() != *tt.want):
+				t.Errorf("finishLogin() auth_time = %v, want %d", claims.AuthTime, *tt.want)
+			}
+		})
+	}
+}
+
+// testIdP is an OAuth provider serving the token, userinfo and JWKS
+// endpoints. The ID token it returns echoes the nonce of the last login.
+type testIdP struct {
+	key      *utils.SigningKey
+	nonce    string
+	userInfo map[string]interface{}
+	claims   jwt.MapClaims // added to the ID token
+}
+
+// newTestIdP starts a provider and points cfg at it
+func newTestIdP(t *testing.T, cfg *config.Config) *testIdP {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	idp := &testIdP{
+		key:      utils.NewSigningKey(privateKey),
+		userInfo: map[string]interface{}{"id": "123", "email": "jane@example.com", "verified_email": true},
+	}
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		claims := jwt.MapClaims{
+			"iss":   server.URL,
+			"aud":   cfg.OAuthClientID,
+			"sub":   "123",
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(5 * time.Minute).Unix(),
+			"nonce": idp.nonce,
+		}
+		for name, value := range idp.claims {
+			claims[name] = value
+		}
+		idToken, err := idp.key.Sign(claims)
+		if err != nil {
+			t.Errorf("Sign() error = %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "upstream-access",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(idp.userInfo)
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []utils.JWK{idp.key.JWK()}})
+	})
+
+	cfg.OAuthClientID = "gateway"
+	cfg.OAuthIssuer = server.URL
+	cfg.OAuthTokenURL = server.URL + "/token"
+	cfg.OAuthUserInfoURL = server.URL + "/userinfo"
+	cfg.OAuthJWKSURL = server.URL + "/jwks"
+	return idp
+}
+
+// login starts a login at /auth/login with the query and returns the
+// callback request the provider would send the browser back with
+func (idp *testIdP) login(t *testing.T, h *AuthHandler, query string) *http.Request {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	h.Login(w, httptest.NewRequest(http.MethodGet, "/auth/login?"+query, nil))
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil || w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Login() = %d to %q, want a redirect to the provider", w.Code, w.Header().Get("Location"))
+	}
+	idp.nonce = location.Query().Get("nonce")
+
+	r := httptest.NewRequest(http.MethodGet, "/auth/callback?"+url.Values{
+		"code":  {"upstream-code"},
+		"state": {location.Query().Get("state")},
+	}.Encode(), nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func TestAuthHandler_CallbackIDToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		claims   jwt.MapClaims
+		nonce    string
+		wantCode int
+	}{
+		{"Valid", "", nil, "", http.StatusOK},
+		{"Userinfo for another subject", "", jwt.MapClaims{"sub": "456"}, "", http.StatusUnauthorized},
+		{"Wrong nonce", "", nil, "other-login", http.StatusUnauthorized},
+		{"Recent enough for max_age", "max_age=300", jwt.MapClaims{"auth_time": time.Now().Add(-time.Minute).Unix()}, "", http.StatusOK},
+		{"Older than max_age", "max_age=300", jwt.MapClaims{"auth_time": time.Now().Add(-time.Hour).Unix()}, "", http.StatusUnauthorized},
+		{"No auth_time for max_age", "max_age=300", nil, "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			idp := newTestIdP(t, cfg)
+			h := newTestAuthHandler(t, cfg)
+			idp.claims = tt.claims
+
+			r := idp.login(t, h, tt.query)
+			if tt.nonce != "" {
+				idp.nonce = tt.nonce
+			}
+			w := httptest.NewRecorder()
+			h.Callback(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("Callback() status = %d, want %d: %s", w.Code, tt.wantCode, w.Body)
+			}
+		})
+	}
+}
+
+func TestAuthHandler_CallbackLoginRules(t *testing.T) {
+	tests := []struct {
+		name     string
+		denied   []string
+		email    string
+		wantCode int
+	}{
+		{"Admitted", []string{"mallory@example.com"}, "jane@example.com", http.StatusOK},
+		{"Denied email", []string{"mallory@example.com"}, "mallory@example.com", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.LoginDeniedEmails = tt.denied
+			idp := newTestIdP(t, cfg)
+			idp.userInfo["email"] = tt.email
+			h := newTestAuthHandler(t, cfg)
+
+			w := httptest.NewRecorder()
+			h.Callback(w, idp.login(t, h, ""))
+			if w.Code != tt.wantCode {
+				t.Errorf("Callback() status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAuthHandler_CompleteFirstFactorLoginRules(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.LoginAllowedDomains = []string{"example.com"}
+	cfg.LoginAllowedProviders = []string{"ldap"}
+	h := newTestAuthHandler(t, cfg)
+
+	// SAML, like every other login method, finishes its first factor here
+	tests := []struct {
+		name     string
+		user     *models.User
+		wantCode int
+	}{
+		{"SAML user", &models.User{ID: "jane", Provider: auth.SAMLProvider, Email: "jane@example.com"}, http.StatusForbidden},
+		{"LDAP user", &models.User{ID: "jane", Provider: "ldap", Email: "jane@example.com"}, http.StatusFound},
+		{"Verified email", &models.User{ID: "jane", Provider: auth.MagicLinkProvider, Email: "jane@example.com", EmailVerified: true}, http.StatusFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.completeFirstFactor(w, httptest.NewRequest(http.MethodPost, "/saml/acs", nil), tt.user,
+				&auth.LoginState{ReturnTo: "/dashboard"}, "", []string{auth.AMRFederated})
+			if w.Code != tt.wantCode {
+				t.Errorf("completeFirstFactor() status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAuthHandler_CompleteFirstFactorGrantedRoleRequiresMFA(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.MFARequiredRoles = []string{"admin"}
+	h := newTestAuthHandler(t, cfg)
+	h.roleGrants = auth.NewRoleGrantService(cfg, store.NewMemoryRoleGrantStore(), auth.NewAudit(store.NewMemoryAuditStore()))
+
+	// The grant is the user's only source of admin
+	granter := &models.User{ID: "admin-1", Roles: []string{"admin"}}
+	if _, err := h.roleGrants.Grant(granter, &models.RoleGrant{UserID: "jane", Role: "admin", NotAfter: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("Grant() error = %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.completeFirstFactor(w, httptest.NewRequest(http.MethodPost, "/auth/ldap/login", nil),
+		&models.User{ID: "jane", Provider: "ldap", Roles: []string{"user"}},
+		&auth.LoginState{ReturnTo: "/dashboard"}, "", []string{auth.AMRPassword})
+	if location := w.Header().Get("Location"); w.Code != http.StatusFound || location != "/auth/mfa" {
+		t.Errorf("completeFirstFactor() = %d to %q, want a redirect to the MFA challenge", w.Code, location)
+	}
+}
+
+func TestAuthHandler_CompleteFirstFactorElevatedRoleRequiresMFA(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.MFARequiredRoles = []string{"admin"}
+	cfg.ElevationRoles = []string{"admin"}
+	cfg.ElevationMaxDuration = 4 * time.Hour
+	cfg.ElevationRequestTTL = 24 * time.Hour
+	cfg.ElevationApprovers = []string{"security-lead"}
+	h := newTestAuthHandler(t, cfg)
+	h.elevations = auth.NewElevationService(cfg, store.NewMemoryElevationStore(), auth.NewAudit(store.NewMemoryAuditStore()))
+
+	// The approved elevation is the user's only source of admin
+	jane := &models.User{ID: "jane", Provider: "ldap", Roles: []string{"user"}}
+	elevation, err := h.elevations.Request(jane, "admin", time.Hour, "INC-1234 database failover")
+	if err != nil {
+		t.Fatalf("Request() error = %v", err)
+	}
+	if _, err := h.elevations.Approve(&models.User{ID: "security-lead"}, elevation.ID, "approved"); err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	h.completeFirstFactor(w, httptest.NewRequest(http.MethodPost, "/auth/ldap/login", nil), jane,
+		&auth.LoginState{ReturnTo: "/dashboard"}, "", []string{auth.AMRPassword})
+	if location := w.Header().Get("Location"); w.Code != http.StatusFound || location != "/auth/mfa" {
+		t.Errorf("completeFirstFactor() = %d to %q, want a redirect to the MFA challenge", w.Code, location)
+	}
+}
@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// BreakGlassHandler handles emergency account logins. It issues tokens
+// directly and never calls the OAuth provider, so it works while the
+// provider is down.
+type BreakGlassHandler struct {
+	auth       *AuthHandler
+	breakGlass *auth.BreakGlassService
+}
+
+// NewBreakGlassHandler creates a new break-glass handler
+func NewBreakGlassHandler(authHandler *AuthHandler, breakGlass *auth.BreakGlassService) *BreakGlassHandler {
+	return &BreakGlassHandler{
+		auth:       authHandler,
+		breakGlass: breakGlass,
+	}
+}
+
+// breakGlassLoginRequest is the body of an emergency login
+type breakGlassLoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// Login checks an emergency account's credentials and returns a
+// short-lived token marked with the break_glass claim
+func (h *BreakGlassHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var req breakGlassLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, amr, err := h.breakGlass.Authenticate(req.Username, req.Password, req.Code, clientIP(r))
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, auth.ErrTooManyAttempts) {
+		http.Error(w, "Too many attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to sign in: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// A session lets admins revoke the token before it expires, through
+	// DELETE /admin/users/{id}/sessions/{sid}
+	session, err := h.auth.sessions.Create(user, clientIP(r), r.UserAgent(), "")
+	if err != nil {
+		http.Error(w, "Failed to create session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ttl := h.auth.config.BreakGlassTokenTTL
+	claims := utils.NewClaims(user, ttl)
+	claims.SessionID = session.ID
+	claims.AMR = amr
+	claims.ACR = auth.ACRForAMR(amr)
+	claims.AuthTime = claims.IssuedAt
+	claims.BreakGlass = true
+	if !session.ExpiresAt.IsZero() && session.ExpiresAt.Before(claims.ExpiresAt.Time) {
+		claims.ExpiresAt = jwt.NewNumericDate(session.ExpiresAt)
+	}
+	token, err := utils.SignJWT(claims, h.auth.config.JWTSecret)
+	if err != nil {
+		http.Error(w, "Failed to generate JWT: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"expires_in": int(time.Until(claims.ExpiresAt.Time).Seconds()),
+		"user":       user,
+	})
+}
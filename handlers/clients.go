@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// ClientHandler handles OAuth client registration and management
+type ClientHandler struct {
+	config   *config.Config
+	registry *auth.ClientRegistry
+}
+
+// NewClientHandler creates a new client handler
+func NewClientHandler(cfg *config.Config, registry *auth.ClientRegistry) *ClientHandler {
+	return &ClientHandler{
+		config:   cfg,
+		registry: registry,
+	}
+}
+
+// Register implements RFC 7591 dynamic client registration, gated by the
+// configured initial access token
+func (h *ClientHandler) Register(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if h.config.OIDCRegistrationToken == "" || !ok ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(h.config.OIDCRegistrationToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Valid initial access token required", http.StatusUnauthorized)
+		return
+	}
+
+	h.create(w, r)
+}
+
+// List returns all registered clients
+func (h *ClientHandler) List(w http.ResponseWriter, r *http.Request) {
+	clients, err := h.registry.List()
+	if err != nil {
+		http.Error(w, "Failed to list clients: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"clients": clients,
+	})
+}
+
+// Create registers a new client on behalf of an administrator
+func (h *ClientHandler) Create(w http.ResponseWriter, r *http.Request) {
+	h.create(w, r)
+}
+
+// Get returns a single client
+func (h *ClientHandler) Get(w http.ResponseWriter, r *http.Request) {
+	client, err := h.registry.Get(r.PathValue("id"))
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(client)
+}
+
+// RotateSecret issues a new secret for a client, invalidating the old one
+func (h *ClientHandler) RotateSecret(w http.ResponseWriter, r *http.Request) {
+	client, err := h.registry.RotateSecret(r.PathValue("id"))
+	if err != nil {
+		writeClientError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(client)
+}
+
+// Delete removes a client
+func (h *ClientHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.registry.Delete(r.PathValue("id")); err != nil {
+		writeClientError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ClientHandler) create(w http.ResponseWriter, r *http.Request) {
+	var meta auth.ClientMetadata
+	if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+		writeOAuthError(w, &auth.OAuthError{
+			Code:        "invalid_client_metadata",
+			Description: "request body must be JSON client metadata",
+			Status:      http.StatusBadRequest,
+		})
+		return
+	}
+
+	client, err := h.registry.Register(&meta)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(client)
+}
+
+func writeClientError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Client not found", http.StatusNotFound)
+		return
+	}
+	writeOAuthError(w, err)
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+)
+
+// deviceConsentCookieName holds the double-submit token of the consent form
+// shown after login, binding the approval to the browser that logged in
+const deviceConsentCookieName = "oauth_device_consent"
+
+var devicePageTemplate = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Device Login</title></head>
+<body>
+  <h1>Device Login</h1>
+  {{if .Approved}}
+  <p>Your device has been signed in. You can close this window and return to your terminal.</p>
+  {{else if .Denied}}
+  <p>The device was not signed in. You can close this window.</p>
+  {{else if .Consent}}
+  <p><strong>{{.Consent.ClientName}}</strong> wants to sign in to your account on the device showing the code <strong>{{.Consent.UserCode}}</strong>.</p>
+  {{if .Consent.Scopes}}<p>It will get access to: {{range $i, $s := .Consent.Scopes}}{{if $i}}, {{end}}{{$s}}{{end}}</p>{{end}}
+  <p>Only continue if you started this sign-in yourself and the code matches the one on your device.</p>
+  <form method="POST" action="/oauth/device/consent">
+    <input type="hidden" name="user_code" value="{{.Consent.UserCode}}">
+    <input type="hidden" name="csrf_token" value="{{.Consent.Token}}">
+    <button type="submit" name="action" value="approve">Approve</button>
+    <button type="submit" name="action" value="deny">Deny</button>
+  </form>
+  {{else}}
+  <p>Enter the code shown on your device.</p>
+  {{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+  <form method="POST" action="/oauth/device">
+    <input type="text" name="user_code" value="{{.UserCode}}" autocomplete="off" autofocus>
+    <button type="submit">Continue</button>
+  </form>
+  {{end}}
+</body>
+</html>
+`))
+
+type devicePageData struct {
+	UserCode string
+	Error    string
+	Consent  *auth.DeviceConsent
+	Approved bool
+	Denied   bool
+}
+
+// DeviceAuthorization implements the RFC 8628 device authorization endpoint
+// used by CLIs and other clients that cannot receive browser redirects
+func (h *OAuthServerHandler) DeviceAuthorization(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &auth.OAuthError{Code: "invalid_request", Status: http.StatusBadRequest})
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	clientSecret := r.PostForm.Get("client_secret")
+	if id, secret, ok := r.BasicAuth(); ok {
+		clientID = id
+		clientSecret = secret
+	}
+
+	resp, err := h.authServer.StartDeviceAuthorization(clientID, clientSecret, r.PostForm.Get("scope"))
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Device serves the page where users enter the code shown by their device.
+// A valid code sends the user through the provider login, after which
+// Callback asks them to approve the device.
+func (h *OAuthServerHandler) Device(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		renderDevicePage(w, http.StatusOK, devicePageData{
+			UserCode: r.URL.Query().Get("user_code"),
+		})
+	case http.MethodPost:
+		userCode, err := h.authServer.LookupUserCode(r.PostFormValue("user_code"), clientIP(r))
+		if errors.Is(err, auth.ErrTooManyAttempts) {
+			renderDevicePage(w, http.StatusTooManyRequests, devicePageData{
+				Error: "Too many wrong codes. Wait a few minutes and try again.",
+			})
+			return
+		}
+		if err != nil {
+			renderDevicePage(w, http.StatusBadRequest, devicePageData{
+				UserCode: r.PostFormValue("user_code"),
+				Error:    "Invalid code: " + err.Error(),
+			})
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     deviceCookieName,
+			Value:    userCode,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   h.config.CookieSecure,
+			SameSite: http.SameSiteLaxMode,
+			MaxAge:   600, // 10 minutes
+		})
+		http.Redirect(w, r, "/auth/login", http.StatusFound)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// DeviceConsent approves or denies a device after the user has logged in
+// and seen which client asked. The form is CSRF-protected, so only an
+// explicit choice on the consent page signs the device in.
+func (h *OAuthServerHandler) DeviceConsent(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form", http.StatusBadRequest)
+		return
+	}
+	consentCookie, err := r.Cookie(deviceConsentCookieName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(consentCookie.Value), []byte(r.PostForm.Get("csrf_token"))) != 1 {
+		http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   deviceConsentCookieName,
+		Value:  "",
+		Path:   "/oauth/device",
+		MaxAge: -1,
+	})
+
+	userCode := r.PostForm.Get("user_code")
+	token := r.PostForm.Get("csrf_token")
+	if r.PostForm.Get("action") != "approve" {
+		if err := h.authServer.DenyDevice(userCode, token); err != nil {
+			renderDevicePage(w, http.StatusBadRequest, devicePageData{Error: err.Error()})
+			return
+		}
+		renderDevicePage(w, http.StatusOK, devicePageData{Denied: true})
+		return
+	}
+
+	if err := h.authServer.ApproveDevice(userCode, token); err != nil {
+		renderDevicePage(w, http.StatusBadRequest, devicePageData{
+			Error: "Failed to approve device: " + err.Error(),
+		})
+		return
+	}
+	renderDevicePage(w, http.StatusOK, devicePageData{Approved: true})
+}
+
+func renderDevicePage(w http.ResponseWriter, status int, data devicePageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	devicePageTemplate.Execute(w, data)
+}
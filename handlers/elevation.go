@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/middleware"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// ElevationHandler handles just-in-time role elevation requests and their
+// approval
+type ElevationHandler struct {
+	elevations *auth.ElevationService
+}
+
+// NewElevationHandler creates a new elevation handler
+func NewElevationHandler(elevations *auth.ElevationService) *ElevationHandler {
+	return &ElevationHandler{elevations: elevations}
+}
+
+// elevationRequest is the body of a role elevation request
+type elevationRequest struct {
+	Role            string `json:"role"`
+	DurationSeconds int    `json:"duration_seconds"`
+	Justification   string `json:"justification"`
+}
+
+// decisionRequest is the optional body of an approval or denial
+type decisionRequest struct {
+	Reason string `json:"reason"`
+}
+
+// Request files a request by the caller to hold a role for a limited time
+func (h *ElevationHandler) Request(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req elevationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	duration := time.Duration(req.DurationSeconds) * time.Second
+	elevation, err := h.elevations.Request(user, req.Role, duration, req.Justification)
+	if err != nil {
+		status, message := elevationError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(elevation)
+}
+
+// List returns the caller's elevation requests, newest first
+func (h *ElevationHandler) List(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	elevations, err := h.elevations.ListByUser(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to list elevations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"elevations": elevations,
+	})
+}
+
+// ListPending returns the requests awaiting a decision to an approver
+func (h *ElevationHandler) ListPending(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	allowed, err := h.elevations.CanApprove(user)
+	if err == nil && !allowed {
+		err = auth.ErrNotApprover
+	}
+	if err != nil {
+		status, message := elevationError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	elevations, err := h.elevations.ListPending()
+	if err != nil {
+		http.Error(w, "Failed to list elevations: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"elevations": elevations,
+	})
+}
+
+// Approve grants a pending request
+func (h *ElevationHandler) Approve(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.elevations.Approve)
+}
+
+// Deny rejects a pending request
+func (h *ElevationHandler) Deny(w http.ResponseWriter, r *http.Request) {
+	h.decide(w, r, h.elevations.Deny)
+}
+
+func (h *ElevationHandler) decide(w http.ResponseWriter, r *http.Request, decide func(*models.User, string, string) (*models.Elevation, error)) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req decisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	elevation, err := decide(user, r.PathValue("id"), req.Reason)
+	if err != nil {
+		status, message := elevationError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(elevation)
+}
+
+func elevationError(err error) (int, string) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidElevation):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, auth.ErrNotApprover), errors.Is(err, auth.ErrSelfApproval):
+		return http.StatusForbidden, err.Error()
+	case errors.Is(err, auth.ErrElevationDecided):
+		return http.StatusConflict, err.Error()
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound, "Elevation request not found"
+	default:
+		return http.StatusInternalServerError, "Elevation operation failed: " + err.Error()
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/middleware"
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+// ImpersonationHandler lets administrators get a token to act as a user
+type ImpersonationHandler struct {
+	impersonation *auth.ImpersonationService
+	directory     *auth.UserDirectory
+}
+
+// NewImpersonationHandler creates a new impersonation handler
+func NewImpersonationHandler(impersonation *auth.ImpersonationService, directory *auth.UserDirectory) *ImpersonationHandler {
+	return &ImpersonationHandler{
+		impersonation: impersonation,
+		directory:     directory,
+	}
+}
+
+// impersonationRequest is the body of an impersonation request. The user's
+// email and name come from the gateway's own records.
+type impersonationRequest struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason"`
+}
+
+// Impersonate issues a short-lived token for the user that names the
+// calling admin in its act claim
+func (h *ImpersonationHandler) Impersonate(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Claims not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req impersonationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.UserID == "" {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+	stored, err := h.directory.Find(req.UserID)
+	if errors.Is(err, auth.ErrUnknownUser) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to look up user", http.StatusInternalServerError)
+		return
+	}
+
+	target := &models.User{ID: stored.ID, Email: stored.Email, Name: stored.Name, Provider: stored.Provider}
+	token, issued, err := h.impersonation.Impersonate(claims, target, req.Reason)
+	if errors.Is(err, auth.ErrInvalidImpersonation) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to impersonate: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	target.Roles = issued.Roles
+	target.Actor = issued.Act.Subject
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      token,
+		"expires_in": int(time.Until(issued.ExpiresAt.Time).Seconds()),
+		"user":       target,
+	})
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/middleware"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+func TestImpersonationHandler_Impersonate(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.ImpersonationRoles = []string{"viewer"}
+	cfg.ImpersonationTokenTTL = 15 * time.Minute
+	accounts := auth.NewAccountService(cfg, store.NewMemoryAccountStore())
+	jane, err := accounts.Resolve(&models.User{ID: "g-1", Email: "jane@example.com", EmailVerified: true, Provider: "google"})
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	h := NewImpersonationHandler(
+		auth.NewImpersonationService(cfg, auth.NewAudit(store.NewMemoryAuditStore())),
+		auth.NewUserDirectory(cfg, accounts, nil),
+	)
+	admin := utils.NewClaims(&models.User{ID: "admin-1", Roles: []string{"admin"}}, time.Hour)
+
+	impersonate := func(body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/admin/impersonate", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), middleware.ClaimsContextKey, admin))
+		w := httptest.NewRecorder()
+		h.Impersonate(w, r)
+		return w
+	}
+
+	// The email comes from the account, not the request
+	w := impersonate(`{"user_id": "` + jane.ID + `", "email": "admin@example.com", "reason": "SUP-42"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Impersonate() status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	claims, err := utils.ValidateJWT(resp.Token, cfg.JWTSecret)
+	if err != nil {
+		t.Fatalf("ValidateJWT() error = %v", err)
+	}
+	if claims.UserID != jane.ID || claims.Email != "jane@example.com" {
+		t.Errorf("token user = %s <%s>, want %s <jane@example.com>", claims.UserID, claims.Email, jane.ID)
+	}
+
+	if w := impersonate(`{"user_id": "no-such-user", "reason": "SUP-42"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Impersonate() of an unknown user status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+)
+
+// LDAPHandler handles sign-in with directory credentials
+type LDAPHandler struct {
+	login *passwordLoginForm
+}
+
+// NewLDAPHandler creates a new LDAP handler. Logins finish through the auth
+// handler, so they get the same MFA step and tokens as IdP logins.
+func NewLDAPHandler(authHandler *AuthHandler, ldap *auth.LDAPService) *LDAPHandler {
+	return &LDAPHandler{
+		login: &passwordLoginForm{
+			auth:         authHandler,
+			title:        "Sign in with your directory account",
+			path:         "/auth/ldap/login",
+			authenticate: ldap.Authenticate,
+		},
+	}
+}
+
+// LoginPage serves the directory sign-in form. It accepts the same
+// return_to, audience and scope parameters as Login.
+func (h *LDAPHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
+	h.login.Page(w, r)
+}
+
+// Login checks directory credentials and continues the login like an IdP
+// callback would
+func (h *LDAPHandler) Login(w http.ResponseWriter, r *http.Request) {
+	h.login.Submit(w, r)
+}
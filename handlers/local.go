@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/middleware"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+var localPasswordPageTemplate = template.Must(template.New("local-password").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{if .Register}}Create your account{{else}}Reset your password{{end}}</title></head>
+<body>
+  {{if .Done}}
+  <p>{{if .Register}}Your account has been created.{{else}}Your password has been changed.{{end}} <a href="/auth/local/login">Sign in</a></p>
+  {{else}}
+  <h1>{{if .Register}}Create your account{{else}}Reset your password{{end}}</h1>
+  {{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+  <form method="POST" action="{{if .Register}}/auth/local/register{{else}}/auth/local/reset{{end}}">
+    <input type="hidden" name="token" value="{{.Token}}">
+    {{if .Register}}
+    <label>Username <input type="text" name="username" value="{{.Username}}" autocomplete="username" autofocus></label>
+    <label>Name <input type="text" name="name" value="{{.Name}}" autocomplete="name"></label>
+    {{end}}
+    <label>Password <input type="password" name="password" autocomplete="new-password"></label>
+    <button type="submit">{{if .Register}}Create account{{else}}Change password{{end}}</button>
+  </form>
+  {{end}}
+</body>
+</html>
+`))
+
+type localPasswordPageData struct {
+	Register bool
+	Done     bool
+	Token    string
+	Username string
+	Name     string
+	Error    string
+}
+
+// LocalAccountHandler handles local username/password accounts
+type LocalAccountHandler struct {
+	local *auth.LocalAccountService
+	login *passwordLoginForm
+}
+
+// NewLocalAccountHandler creates a new local account handler. Logins finish
+// through the auth handler, so they get the same MFA step and tokens as
+// IdP logins.
+func NewLocalAccountHandler(authHandler *AuthHandler, local *auth.LocalAccountService) *LocalAccountHandler {
+	return &LocalAccountHandler{
+		local: local,
+		login: &passwordLoginForm{
+			auth:         authHandler,
+			title:        "Sign in",
+			path:         "/auth/local/login",
+			authenticate: local.Authenticate,
+		},
+	}
+}
+
+// LoginPage serves the local sign-in form. It accepts the same return_to,
+// audience and scope parameters as Login.
+func (h *LocalAccountHandler) LoginPage(w http.ResponseWriter, r *http.Request) {
+	h.login.Page(w, r)
+}
+
+// Login checks a username and password and continues the login like an
+// IdP callback would
+func (h *LocalAccountHandler) Login(w http.ResponseWriter, r *http.Request) {
+	h.login.Submit(w, r)
+}
+
+// RegisterPage serves the registration form for an invite
+func (h *LocalAccountHandler) RegisterPage(w http.ResponseWriter, r *http.Request) {
+	renderLocalPasswordPage(w, http.StatusOK, localPasswordPageData{
+		Register: true,
+		Token:    r.URL.Query().Get("invite"),
+	})
+}
+
+// Register creates an account from an invite
+func (h *LocalAccountHandler) Register(w http.ResponseWriter, r *http.Request) {
+	data := localPasswordPageData{
+		Register: true,
+		Token:    r.PostFormValue("token"),
+		Username: r.PostFormValue("username"),
+		Name:     r.PostFormValue("name"),
+	}
+
+	_, err := h.local.Register(data.Token, data.Username, data.Name, r.PostFormValue("password"))
+	if err != nil {
+		status, message := localAccountError(err)
+		data.Error = message
+		renderLocalPasswordPage(w, status, data)
+		return
+	}
+
+	renderLocalPasswordPage(w, http.StatusCreated, localPasswordPageData{Register: true, Done: true})
+}
+
+// ResetPage serves the password reset form for a reset token
+func (h *LocalAccountHandler) ResetPage(w http.ResponseWriter, r *http.Request) {
+	renderLocalPasswordPage(w, http.StatusOK, localPasswordPageData{
+		Token: r.URL.Query().Get("token"),
+	})
+}
+
+// Reset sets a new password with a reset token
+func (h *LocalAccountHandler) Reset(w http.ResponseWriter, r *http.Request) {
+	data := localPasswordPageData{Token: r.PostFormValue("token")}
+
+	if err := h.local.ResetPassword(data.Token, r.PostFormValue("password")); err != nil {
+		status, message := localAccountError(err)
+		data.Error = message
+		renderLocalPasswordPage(w, status, data)
+		return
+	}
+
+	renderLocalPasswordPage(w, http.StatusOK, localPasswordPageData{Done: true})
+}
+
+func renderLocalPasswordPage(w http.ResponseWriter, status int, data localPasswordPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer") // the URL carries the token
+	w.WriteHeader(status)
+	localPasswordPageTemplate.Execute(w, data)
+}
+
+// changePasswordRequest is the body of a password change
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePassword changes the caller's password and ends their other
+// sessions
+func (h *LocalAccountHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+	if user.Provider != "local" {
+		http.Error(w, "Not a local account", http.StatusBadRequest)
+		return
+	}
+
+	var req changePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var sessionID string
+	if claims, ok := middleware.GetClaimsFromContext(r.Context()); ok {
+		sessionID = claims.SessionID
+	}
+	if err := h.local.ChangePassword(user.ID, req.CurrentPassword, req.NewPassword, clientIP(r), sessionID); err != nil {
+		status, message := localAccountError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Password changed",
+	})
+}
+
+// inviteRequest is the body of an admin invite
+type inviteRequest struct {
+	Email string   `json:"email"`
+	Roles []string `json:"roles"`
+}
+
+// Invite creates a one-time registration invite
+func (h *LocalAccountHandler) Invite(w http.ResponseWriter, r *http.Request) {
+	admin, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req inviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	token, expiresAt, err := h.local.Invite(req.Email, req.Roles, admin.ID)
+	if err != nil {
+		http.Error(w, "Failed to create invite: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeLocalToken(w, "invite_token", token, "/auth/local/register?invite="+token, expiresAt)
+}
+
+// localAccountView is a local account without its password hash
+type localAccountView struct {
+	ID                string    `json:"id"`
+	Username          string    `json:"username"`
+	Email             string    `json:"email"`
+	Name              string    `json:"name"`
+	Roles             []string  `json:"roles"`
+	PasswordChangedAt time.Time `json:"password_changed_at"`
+	CreatedAt         time.Time `json:"created_at"`
+	CreatedBy         string    `json:"created_by,omitempty"`
+}
+
+func newLocalAccountView(account *models.LocalAccount) localAccountView {
+	return localAccountView{
+		ID:                account.ID,
+		Username:          account.Username,
+		Email:             account.Email,
+		Name:              account.Name,
+		Roles:             account.Roles,
+		PasswordChangedAt: account.PasswordChangedAt,
+		CreatedAt:         account.CreatedAt,
+		CreatedBy:         account.CreatedBy,
+	}
+}
+
+// ListAccounts returns all local accounts
+func (h *LocalAccountHandler) ListAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := h.local.Accounts()
+	if err != nil {
+		http.Error(w, "Failed to list accounts: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]localAccountView, 0, len(accounts))
+	for _, account := range accounts {
+		views = append(views, newLocalAccountView(account))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"accounts": views,
+	})
+}
+
+// IssuePasswordReset creates a one-time password reset token for an
+// account, for the admin to hand to its owner
+func (h *LocalAccountHandler) IssuePasswordReset(w http.ResponseWriter, r *http.Request) {
+	admin, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	token, expiresAt, err := h.local.IssuePasswordReset(r.PathValue("id"), admin.ID)
+	if err != nil {
+		status, message := localAccountError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	writeLocalToken(w, "reset_token", token, "/auth/local/reset?token="+token, expiresAt)
+}
+
+// DeleteAccount removes a local account and ends its sessions
+func (h *LocalAccountHandler) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	if err := h.local.DeleteAccount(r.PathValue("id")); err != nil {
+		status, message := localAccountError(err)
+		http.Error(w, message, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Account deleted",
+	})
+}
+
+func writeLocalToken(w http.ResponseWriter, name, token, path string, expiresAt time.Time) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		name:         token,
+		"path":       path,
+		"expires_at": expiresAt,
+	})
+}
+
+// localAccountError maps local account errors to a status and a message
+// safe to show to the user
+func localAccountError(err error) (int, string) {
+	switch {
+	case errors.Is(err, auth.ErrPasswordPolicy), errors.Is(err, auth.ErrInvalidUsername):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, auth.ErrInvalidLocalToken):
+		return http.StatusBadRequest, "This link is invalid or has expired."
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return http.StatusUnauthorized, err.Error()
+	case errors.Is(err, auth.ErrTooManyAttempts):
+		return http.StatusTooManyRequests, err.Error()
+	case errors.Is(err, store.ErrUsernameTaken):
+		return http.StatusConflict, err.Error()
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound, "Account not found"
+	default:
+		return http.StatusInternalServerError, "Local account operation failed: " + err.Error()
+	}
+}
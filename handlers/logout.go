@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+)
+
+const (
+	// logoutCookieName holds the gateway session of the browser's provider
+	// login, so front-channel logout can tell which session is this browser's
+	logoutCookieName = "gateway_session"
+	logoutCookiePath = "/auth/frontchannel-logout"
+)
+
+// LogoutNotificationHandler receives logout notifications from the upstream
+// provider, so logging out there also ends gateway sessions
+type LogoutNotificationHandler struct {
+	receiver *auth.LogoutReceiver
+}
+
+// NewLogoutNotificationHandler creates a new logout notification handler
+func NewLogoutNotificationHandler(receiver *auth.LogoutReceiver) *LogoutNotificationHandler {
+	return &LogoutNotificationHandler{
+		receiver: receiver,
+	}
+}
+
+// BackChannel accepts a logout token posted directly by the provider
+func (h *LogoutNotificationHandler) BackChannel(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &auth.OAuthError{Code: "invalid_request", Status: http.StatusBadRequest})
+		return
+	}
+
+	revoked, err := h.receiver.BackChannel(r.PostForm.Get("logout_token"))
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+	log.Printf("Back-channel logout revoked %d session(s)", revoked)
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// FrontChannel handles a logout request the provider loads in a hidden
+// iframe in the user's browser. It ends only the session in the browser's
+// logout cookie.
+func (h *LogoutNotificationHandler) FrontChannel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	var browserSessionID string
+	if cookie, err := r.Cookie(logoutCookieName); err == nil {
+		browserSessionID = cookie.Value
+	}
+	query := r.URL.Query()
+	revoked, err := h.receiver.FrontChannel(query.Get("iss"), query.Get("sid"), browserSessionID)
+	if err != nil {
+		http.Error(w, "Invalid logout request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Front-channel logout revoked %d session(s)", revoked)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   logoutCookieName,
+		Value:  "",
+		Path:   logoutCookiePath,
+		MaxAge: -1,
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+}
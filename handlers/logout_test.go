@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/middleware"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestLogoutNotificationHandler_FrontChannel(t *testing.T) {
+	cfg := newTestConfig()
+	idp := newTestIdP(t, cfg)
+	h := newTestAuthHandler(t, cfg)
+	logout := NewLogoutNotificationHandler(auth.NewLogoutReceiver(cfg, auth.NewProviderKeys(cfg.OAuthJWKSURL), h.sessions))
+	idp.claims = jwt.MapClaims{"sid": "idp-sid-1"}
+
+	w := httptest.NewRecorder()
+	h.Callback(w, idp.login(t, h, "return_to=/dashboard"))
+	var logoutCookie *http.Cookie
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == logoutCookieName {
+			logoutCookie = cookie
+		}
+	}
+	if logoutCookie == nil || logoutCookie.SameSite != http.SameSiteNoneMode {
+		t.Fatalf("Callback() logout cookie = %v, want a SameSite=None %s cookie", logoutCookie, logoutCookieName)
+	}
+	_, token, _ := strings.Cut(w.Header().Get("Location"), "#token=")
+	claims, err := utils.ValidateJWT(token, cfg.JWTSecret)
+	if err != nil {
+		t.Fatalf("ValidateJWT() error = %v", err)
+	}
+
+	frontChannel := func(cookies ...*http.Cookie) int {
+		r := httptest.NewRequest(http.MethodGet, logoutCookiePath+"?iss="+cfg.OAuthIssuer+"&sid=idp-sid-1", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		logout.FrontChannel(w, r)
+		return w.Code
+	}
+
+	// Without the browser's own session nothing is revoked
+	if code := frontChannel(); code != http.StatusBadRequest {
+		t.Errorf("FrontChannel() without the cookie status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if _, err := h.sessions.Check(claims.SessionID); err != nil {
+		t.Fatalf("Check() after a rejected logout error = %v", err)
+	}
+
+	if code := frontChannel(logoutCookie); code != http.StatusOK {
+		t.Errorf("FrontChannel() status = %d, want %d", code, http.StatusOK)
+	}
+	if _, err := h.sessions.Check(claims.SessionID); !errors.Is(err, auth.ErrSessionRevoked) {
+		t.Errorf("Check() after logout error = %v, want %v", err, auth.ErrSessionRevoked)
+	}
+}
+
+func TestAuthHandler_LogoutForm(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.EnableSessionCookies = true
+	cfg.SessionCookieName = "iag_session"
+	cfg.CSRFCookieName = "iag_csrf"
+	h := newTestAuthHandler(t, cfg)
+	session, err := h.sessions.Create(&models.User{ID: "123", Provider: "google", Roles: []string{"user"}}, "192.0.2.1", "test", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	claims := utils.NewClaims(&models.User{ID: "123", Provider: "google", Roles: []string{"user"}}, time.Hour)
+	claims.SessionID = session.ID
+	token, _ := utils.SignJWT(claims, cfg.JWTSecret)
+	csrfToken := utils.CSRFToken(token, cfg.JWTSecret)
+
+	// The confirmation page carries the CSRF token into the form
+	r := httptest.NewRequest(http.MethodGet, "/auth/logout", nil)
+	r.AddCookie(&http.Cookie{Name: cfg.CSRFCookieName, Value: csrfToken})
+	w := httptest.NewRecorder()
+	h.LogoutPage(w, r)
+	if !strings.Contains(w.Body.String(), `value="`+csrfToken+`"`) {
+		t.Fatalf("LogoutPage() body = %s, want the CSRF token in the form", w.Body)
+	}
+
+	logout := middleware.AuthMiddleware(cfg, h.sessions)(http.HandlerFunc(h.Logout))
+	post := func(csrf string) int {
+		r := httptest.NewRequest(http.MethodPost, "/auth/logout", strings.NewReader(url.Values{middleware.CSRFFormField: {csrf}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(&http.Cookie{Name: cfg.SessionCookieName, Value: token})
+		w := httptest.NewRecorder()
+		logout.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// A cross-site post has no token and leaves the session alone
+	if code := post(""); code != http.StatusForbidden {
+		t.Errorf("Logout() without a CSRF token status = %d, want %d", code, http.StatusForbidden)
+	}
+	if _, err := h.sessions.Check(session.ID); err != nil {
+		t.Fatalf("Check() after a rejected logout error = %v", err)
+	}
+
+	if code := post(csrfToken); code != http.StatusOK {
+		t.Errorf("Logout() status = %d, want %d", code, http.StatusOK)
+	}
+	if _, err := h.sessions.Check(session.ID); !errors.Is(err, auth.ErrSessionRevoked) {
+		t.Errorf("Check() after logout error = %v, want %v", err, auth.ErrSessionRevoked)
+	}
+}
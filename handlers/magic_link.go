@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+)
+
+const (
+	// magicLinkCookieName holds the login state of the browser's latest
+	// link request. A link only works in the browser that requested it, so
+	// a link sent to someone else's address cannot log them into it.
+	magicLinkCookieName = "magic_link"
+	// magicLinkCSRFCookieName holds the double-submit token of the request
+	// form
+	magicLinkCSRFCookieName = "magic_link_csrf"
+	magicLinkPath           = "/auth/magic-link"
+)
+
+var magicLinkPageTemplate = template.Must(template.New("magic-link").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Sign in with email</title></head>
+<body>
+  <h1>Sign in with email</h1>
+  {{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+  {{if .Sent}}
+  <p>We sent a sign-in link to {{.Email}}. Open it in this browser within {{.Minutes}} minutes.</p>
+  {{else if .Token}}
+  <form method="POST" action="/auth/magic-link/verify">
+    <input type="hidden" name="token" value="{{.Token}}">
+    <button type="submit">Sign in as {{.Email}}</button>
+  </form>
+  {{else if .CSRFToken}}
+  <form method="POST" action="/auth/magic-link">
+    <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+    <input type="hidden" name="return_to" value="{{.ReturnTo}}">
+    <input type="hidden" name="audience" value="{{.Audience}}">
+    <input type="hidden" name="scope" value="{{.Scope}}">
+    <label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="email" autofocus></label>
+    <button type="submit">Send sign-in link</button>
+  </form>
+  {{end}}
+</body>
+</html>
+`))
+
+type magicLinkPageData struct {
+	CSRFToken string
+	ReturnTo  string
+	Audience  string
+	Scope     string
+	Email     string
+	Sent      bool
+	Minutes   int
+	Token     string
+	Error     string
+}
+
+// MagicLinkHandler handles passwordless login with emailed links
+type MagicLinkHandler struct {
+	auth       *AuthHandler
+	magicLinks *auth.MagicLinkService
+}
+
+// NewMagicLinkHandler creates a new magic link handler. Logins finish
+// through the auth handler, so they get the same MFA step and tokens as IdP
+// logins.
+func NewMagicLinkHandler(authHandler *AuthHandler, magicLinks *auth.MagicLinkService) *MagicLinkHandler {
+	return &MagicLinkHandler{
+		auth:       authHandler,
+		magicLinks: magicLinks,
+	}
+}
+
+// RequestPage serves the form asking for an email address. It accepts the
+// same return_to, audience and scope parameters as /auth/login.
+func (h *MagicLinkHandler) RequestPage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if _, err := h.auth.directLoginState(query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.renderForm(w, http.StatusOK, magicLinkPageData{
+		ReturnTo: query.Get("return_to"),
+		Audience: query.Get("audience"),
+		Scope:    query.Get("scope"),
+	})
+}
+
+// Request emails a sign-in link and remembers the login in this browser
+func (h *MagicLinkHandler) Request(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	csrfCookie, err := r.Cookie(magicLinkCSRFCookieName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(csrfCookie.Value), []byte(r.PostForm.Get("csrf_token"))) != 1 {
+		http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+		return
+	}
+	direct, err := h.auth.directLoginState(r.PostForm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	form := magicLinkPageData{
+		ReturnTo: r.PostForm.Get("return_to"),
+		Audience: r.PostForm.Get("audience"),
+		Scope:    r.PostForm.Get("scope"),
+		Email:    r.PostForm.Get("email"),
+	}
+	linkID, expiresAt, err := h.magicLinks.Send(form.Email, clientIP(r))
+	switch {
+	case errors.Is(err, auth.ErrTooManyAttempts):
+		form.Error = "Too many sign-in links were requested from your network. Try again later."
+		h.renderForm(w, http.StatusTooManyRequests, form)
+		return
+	case errors.Is(err, auth.ErrInvalidEmail):
+		form.Error = "Enter a valid email address."
+		h.renderForm(w, http.StatusBadRequest, form)
+		return
+	case errors.Is(err, auth.ErrMagicLinkRateLimited):
+		form.Error = "Too many sign-in links were requested for this address. Try again later."
+		h.renderForm(w, http.StatusTooManyRequests, form)
+		return
+	case errors.Is(err, auth.ErrMagicLinkNotAllowed):
+		// Answer as if the link was sent, so the form does not reveal
+		// which addresses may sign in
+		log.Printf("Magic link for %s not sent: %v", form.Email, err)
+		h.render(w, http.StatusOK, magicLinkPageData{
+			Email:   form.Email,
+			Sent:    true,
+			Minutes: int(h.auth.config.MagicLinkTTL.Minutes()),
+		})
+		return
+	case err != nil:
+		log.Printf("Magic link for %s not sent: %v", form.Email, err)
+		http.Error(w, "Failed to send sign-in link", http.StatusInternalServerError)
+		return
+	}
+
+	// The link must come back to this browser, and only for this link
+	loginState, err := auth.NewLoginState(auth.MagicLinkProvider, direct.ReturnTo, direct.Params, linkID)
+	if err != nil {
+		http.Error(w, "Failed to generate state", http.StatusInternalServerError)
+		return
+	}
+	loginState.ExpiresAt = expiresAt.Unix()
+	state, err := h.auth.loginStates.Encode(loginState)
+	if err != nil {
+		http.Error(w, "Failed to generate state", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCookieName,
+		Value:    state,
+		Path:     magicLinkPath,
+		HttpOnly: true,
+		Secure:   h.auth.config.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:   magicLinkCSRFCookieName,
+		Value:  "",
+		Path:   magicLinkPath,
+		MaxAge: -1,
+	})
+
+	h.render(w, http.StatusOK, magicLinkPageData{
+		Email:   form.Email,
+		Sent:    true,
+		Minutes: int(h.auth.config.MagicLinkTTL.Minutes()),
+	})
+}
+
+// VerifyPage is where links point. It asks the user to confirm instead of
+// signing in right away, because mail scanners open links before users do
+// and would use them up.
+func (h *MagicLinkHandler) VerifyPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	email, _, err := h.magicLinks.Check(token)
+	if err != nil {
+		h.render(w, http.StatusBadRequest, magicLinkPageData{
+			Error: "This sign-in link is invalid, expired or already used.",
+		})
+		return
+	}
+
+	// Keep the token out of the Referer of anything the page loads
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	h.render(w, http.StatusOK, magicLinkPageData{Email: email, Token: token})
+}
+
+// Verify uses up a link and continues the login like an IdP callback would
+func (h *MagicLinkHandler) Verify(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	token := r.PostForm.Get("token")
+	_, linkID, err := h.magicLinks.Check(token)
+	if err != nil {
+		h.render(w, http.StatusBadRequest, magicLinkPageData{
+			Error: "This sign-in link is invalid, expired or already used.",
+		})
+		return
+	}
+
+	stateCookie, err := r.Cookie(magicLinkCookieName)
+	if err != nil {
+		h.render(w, http.StatusBadRequest, magicLinkPageData{
+			Error: "Open the sign-in link in the browser you requested it from.",
+		})
+		return
+	}
+	loginState, err := h.auth.loginStates.Decode(stateCookie.Value)
+	if err != nil || loginState.Provider != auth.MagicLinkProvider || !loginState.BoundTo(linkID) {
+		h.render(w, http.StatusBadRequest, magicLinkPageData{
+			Error: "Open the latest sign-in link in the browser you requested it from.",
+		})
+		return
+	}
+
+	user, err := h.magicLinks.Consume(token)
+	if err != nil {
+		h.render(w, http.StatusBadRequest, magicLinkPageData{
+			Error: "This sign-in link is invalid, expired or already used.",
+		})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   magicLinkCookieName,
+		Value:  "",
+		Path:   magicLinkPath,
+		MaxAge: -1,
+	})
+
+	loginState.AuthTime = time.Now().Unix()
+	h.auth.completeFirstFactor(w, r, user, loginState, "", []string{auth.AMREmail})
+}
+
+// renderForm renders the request form with a fresh CSRF token
+func (h *MagicLinkHandler) renderForm(w http.ResponseWriter, status int, data magicLinkPageData) {
+	csrfToken, err := generateRandomState()
+	if err != nil {
+		http.Error(w, "Failed to generate CSRF token", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     magicLinkCSRFCookieName,
+		Value:    csrfToken,
+		Path:     magicLinkPath,
+		HttpOnly: true,
+		Secure:   h.auth.config.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	data.CSRFToken = csrfToken
+	h.render(w, status, data)
+}
+
+func (h *MagicLinkHandler) render(w http.ResponseWriter, status int, data magicLinkPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	magicLinkPageTemplate.Execute(w, data)
+}
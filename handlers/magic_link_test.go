@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+)
+
+// testMailbox keeps the last message sent to each address
+type testMailbox map[string]string
+
+func (m testMailbox) Send(to, subject, body string) error {
+	m[to] = body
+	return nil
+}
+
+var magicLinkTokenPattern = regexp.MustCompile(`token=([^\s&]+)`)
+
+// postForm posts the form with the cookies to the handler
+func postForm(h http.HandlerFunc, path string, form url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	h(w, r)
+	return w
+}
+
+// newTestMagicLinkHandler returns a magic link handler sending to mailbox
+func newTestMagicLinkHandler(t *testing.T, mailbox testMailbox) *MagicLinkHandler {
+	t.Helper()
+	cfg := newTestConfig()
+	cfg.MagicLinkURL = "https://gateway.example.com/auth/magic-link/verify"
+	cfg.MagicLinkTTL = 15 * time.Minute
+	cfg.MagicLinkRateLimit = 5
+	cfg.MagicLinkRateWindow = time.Hour
+	cfg.MagicLinkIPRateLimit = 20
+	cfg.MagicLinkAllowedDomains = []string{"example.com"}
+	cfg.LoginDeniedEmails = []string{"mallory@example.com"}
+	return NewMagicLinkHandler(newTestAuthHandler(t, cfg), auth.NewMagicLinkService(cfg, mailbox))
+}
+
+// requestMagicLink submits the request form for the address
+func requestMagicLink(h *MagicLinkHandler, email string) *httptest.ResponseRecorder {
+	csrf := &http.Cookie{Name: magicLinkCSRFCookieName, Value: "csrf-1"}
+	return postForm(h.Request, magicLinkPath, url.Values{
+		"csrf_token": {"csrf-1"},
+		"email":      {email},
+		"return_to":  {"/dashboard"},
+	}, []*http.Cookie{csrf})
+}
+
+// signInWithMagicLink requests a link for the address and follows it
+func signInWithMagicLink(t *testing.T, h *MagicLinkHandler, mailbox testMailbox, email string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	w := requestMagicLink(h, email)
+	if w.Code != http.StatusOK {
+		return w
+	}
+	match := magicLinkTokenPattern.FindStringSubmatch(mailbox[email])
+	if match == nil {
+		t.Fatalf("Request() sent no link to %s", email)
+	}
+	token, _ := url.QueryUnescape(match[1])
+	return postForm(h.Verify, magicLinkPath+"/verify", url.Values{"token": {token}}, w.Result().Cookies())
+}
+
+func TestMagicLinkHandler_RequestNotAllowed(t *testing.T) {
+	mailbox := testMailbox{}
+	h := newTestMagicLinkHandler(t, mailbox)
+
+	tests := []struct {
+		name  string
+		email string
+	}{
+		{"Not on the allow list", "jane@example.org"},
+		{"Denied by the login rules", "mallory@example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The page must not tell these addresses apart from allowed ones
+			w := requestMagicLink(h, tt.email)
+			if w.Code != http.StatusOK {
+				t.Errorf("Request() status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if _, ok := mailbox[tt.email]; ok {
+				t.Errorf("Request() sent a link to %s", tt.email)
+			}
+			for _, cookie := range w.Result().Cookies() {
+				if cookie.Name == magicLinkCookieName {
+					t.Errorf("Request() set the %s cookie", magicLinkCookieName)
+				}
+			}
+		})
+	}
+}
+
+func TestMagicLinkHandler_RequestIPRateLimit(t *testing.T) {
+	h := newTestMagicLinkHandler(t, testMailbox{})
+
+	for i := 0; i < 20; i++ {
+		if w := requestMagicLink(h, fmt.Sprintf("user%d@example.com", i)); w.Code != http.StatusOK {
+			t.Fatalf("Request() #%d status = %d, want %d", i+1, w.Code, http.StatusOK)
+		}
+	}
+	if w := requestMagicLink(h, "user20@example.com"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Request() #21 status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestMagicLinkHandler_LoginRules(t *testing.T) {
+	mailbox := testMailbox{}
+	h := newTestMagicLinkHandler(t, mailbox)
+
+	if w := signInWithMagicLink(t, h, mailbox, "jane@example.com"); w.Code != http.StatusFound {
+		t.Errorf("sign-in status = %d, want %d", w.Code, http.StatusFound)
+	}
+
+	// The rules are checked again when the link is used
+	w := requestMagicLink(h, "john@example.com")
+	match := magicLinkTokenPattern.FindStringSubmatch(mailbox["john@example.com"])
+	if match == nil {
+		t.Fatalf("Request() sent no link to john@example.com")
+	}
+	h.auth.config.LoginDeniedEmails = append(h.auth.config.LoginDeniedEmails, "john@example.com")
+	token, _ := url.QueryUnescape(match[1])
+	w = postForm(h.Verify, magicLinkPath+"/verify", url.Values{"token": {token}}, w.Result().Cookies())
+	if w.Code != http.StatusForbidden {
+		t.Errorf("sign-in after the address was denied status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
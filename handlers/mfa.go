@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/middleware"
+)
+
+const mfaChallengeCookieName = "mfa_challenge"
+
+var mfaPageTemplate = template.Must(template.New("mfa").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Two-Factor Authentication</title></head>
+<body>
+  <h1>Two-Factor Authentication</h1>
+  {{if .RecoveryCodes}}
+  <p>Two-factor authentication is set up. Save these recovery codes somewhere safe. Each one signs you in once if you lose your authenticator, and they are not shown again.</p>
+  <ul>{{range .RecoveryCodes}}<li><code>{{.}}</code></li>{{end}}</ul>
+  <form method="POST" action="/auth/mfa/continue">
+    <button type="submit">Continue</button>
+  </form>
+  {{else}}
+  {{if .Enrollment}}
+  <p>Your account requires two-factor authentication. Add this account to your authenticator app, then enter the code it shows.</p>
+  <p>Provisioning URI (encode it as a QR code to scan): <code>{{.Enrollment.ProvisioningURI}}</code></p>
+  <p>Or enter this key manually: <code>{{.Enrollment.Secret}}</code></p>
+  {{else if .TOTP}}
+  <p>Enter the code from your authenticator app, or one of your recovery codes.</p>
+  {{end}}
+  {{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+  {{if or .Enrollment .TOTP}}
+  <form method="POST" action="/auth/mfa">
+    <input type="text" name="code" autocomplete="one-time-code" autofocus>
+    <button type="submit">Verify</button>
+  </form>
+  {{end}}
+  {{if .WebAuthn}}
+  <form id="webauthn" method="POST" action="/auth/mfa/webauthn/finish">
+    <input type="hidden" name="credential">
+    <button type="button" onclick="webauthnAssert('/auth/mfa/webauthn/begin', document.getElementById('webauthn'))">Use security key</button>
+  </form>
+` + webauthnScript + `
+  {{end}}
+  {{end}}
+</body>
+</html>
+`))
+
+type mfaPageData struct {
+	RecoveryCodes []string // issued by an enrollment confirmed at login
+	Enrollment    *auth.TOTPEnrollment
+	TOTP          bool // the user has a confirmed TOTP enrollment
+	WebAuthn      bool // the user has a registered security key
+	Error         string
+}
+
+// MFAChallenge serves the second-factor step of a login that Callback
+// parked, and finishes the login once a valid code is entered. A login that
+// confirmed a new enrollment shows its recovery codes first.
+func (h *AuthHandler) MFAChallenge(w http.ResponseWriter, r *http.Request) {
+	challengeCookie, err := r.Cookie(mfaChallengeCookieName)
+	if err != nil {
+		http.Error(w, "MFA challenge not found", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		pending, err := h.mfa.Challenge(challengeCookie.Value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		renderMFAPage(w, http.StatusOK, h.mfaPageData(pending, ""))
+	case http.MethodPost:
+		// Kept to restart the login if this was the last attempt
+		current, _ := h.mfa.Challenge(challengeCookie.Value)
+
+		pending, amr, err := h.mfa.CompleteChallenge(challengeCookie.Value, r.PostFormValue("code"))
+		if errors.Is(err, auth.ErrMFATooManyAttempts) && current != nil {
+			clearMFAChallengeCookie(w)
+			http.Redirect(w, r, restartLoginURL(current), http.StatusSeeOther)
+			return
+		}
+		if errors.Is(err, auth.ErrMFAInvalidCode) {
+			// The challenge stays open for another attempt
+			data := mfaPageData{TOTP: true, Error: "Invalid code, please try again."}
+			if pending, err := h.mfa.Challenge(challengeCookie.Value); err == nil {
+				data = h.mfaPageData(pending, data.Error)
+			}
+			renderMFAPage(w, http.StatusUnauthorized, data)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(pending.RecoveryCodes) > 0 {
+			renderMFAPage(w, http.StatusOK, h.mfaPageData(pending, ""))
+			return
+		}
+
+		clearMFAChallengeCookie(w)
+		h.finishLogin(w, r, pending.User, pending.State, pending.UpstreamIDToken, amr)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// MFAContinue finishes a login whose second factor was passed once the
+// user has seen the recovery codes of their new enrollment
+func (h *AuthHandler) MFAContinue(w http.ResponseWriter, r *http.Request) {
+	challengeCookie, err := r.Cookie(mfaChallengeCookieName)
+	if err != nil {
+		http.Error(w, "MFA challenge not found", http.StatusBadRequest)
+		return
+	}
+	pending, amr, err := h.mfa.FinishChallenge(challengeCookie.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	clearMFAChallengeCookie(w)
+	h.finishLogin(w, r, pending.User, pending.State, pending.UpstreamIDToken, amr)
+}
+
+// restartLoginURL is the start of the login a pending challenge came from,
+// with the same return_to, audience and scope
+func restartLoginURL(pending *auth.PendingLogin) string {
+	path := "/auth/login"
+	switch pending.User.Provider {
+	case "local":
+		path = "/auth/local/login"
+	case "ldap":
+		path = "/auth/ldap/login"
+	case auth.MagicLinkProvider:
+		path = "/auth/magic-link"
+	case auth.SAMLProvider:
+		path = "/saml/login"
+	}
+
+	query := url.Values{}
+	if state := pending.State; state != nil {
+		if state.ReturnTo != "" {
+			query.Set("return_to", state.ReturnTo)
+		}
+		if state.Params.Audience != "" {
+			query.Set("audience", state.Params.Audience)
+		}
+		if state.Params.Scope != "" {
+			query.Set("scope", state.Params.Scope)
+		}
+	}
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query.Encode()
+}
+
+// mfaPageData lists the second factors the pending login can use
+func (h *AuthHandler) mfaPageData(pending *auth.PendingLogin, message string) mfaPageData {
+	totp, _ := h.mfa.Enrolled(pending.User.ID)
+	return mfaPageData{
+		RecoveryCodes: pending.RecoveryCodes,
+		Enrollment:    pending.Enrollment,
+		TOTP:          totp,
+		WebAuthn:      pending.WebAuthn,
+		Error:         message,
+	}
+}
+
+func clearMFAChallengeCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:   mfaChallengeCookieName,
+		Value:  "",
+		Path:   "/auth/mfa",
+		MaxAge: -1,
+	})
+}
+
+func renderMFAPage(w http.ResponseWriter, status int, data mfaPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	mfaPageTemplate.Execute(w, data)
+}
+
+// MFAHandler lets users manage their TOTP enrollment
+type MFAHandler struct {
+	mfa *auth.MFAService
+}
+
+// NewMFAHandler creates a new MFA handler
+func NewMFAHandler(mfa *auth.MFAService) *MFAHandler {
+	return &MFAHandler{
+		mfa: mfa,
+	}
+}
+
+// mfaCodeRequest is the body of requests that must prove possession of
+// the second factor
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// Status reports whether the caller has MFA enrolled
+func (h *MFAHandler) Status(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	enrolled, err := h.mfa.Enrolled(user.ID)
+	if err != nil {
+		http.Error(w, "Failed to check MFA: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{
+		"enrolled": enrolled,
+	})
+}
+
+// Enroll starts a TOTP enrollment and returns the secret and provisioning URI
+func (h *MFAHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	enrollment, err := h.mfa.StartEnrollment(user)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(enrollment)
+}
+
+// Confirm activates the caller's enrollment and returns recovery codes
+func (h *MFAHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	h.withCode(w, r, func(userID, code string) (interface{}, error) {
+		codes, err := h.mfa.ConfirmEnrollment(userID, code)
+		return map[string][]string{"recovery_codes": codes}, err
+	})
+}
+
+// RecoveryCodes replaces the caller's recovery codes
+func (h *MFAHandler) RecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	h.withCode(w, r, func(userID, code string) (interface{}, error) {
+		codes, err := h.mfa.RegenerateRecoveryCodes(userID, code)
+		return map[string][]string{"recovery_codes": codes}, err
+	})
+}
+
+// Disable removes the caller's enrollment
+func (h *MFAHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	h.withCode(w, r, func(userID, code string) (interface{}, error) {
+		return map[string]string{"message": "MFA disabled"}, h.mfa.Disable(userID, code)
+	})
+}
+
+// withCode decodes a code request for the authenticated user and writes
+// the result of fn as JSON
+func (h *MFAHandler) withCode(w http.ResponseWriter, r *http.Request, fn func(userID, code string) (interface{}, error)) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req mfaCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	result, err := fn(user.ID, req.Code)
+	if err != nil {
+		writeMFAError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(result)
+}
+
+func writeMFAError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrMFAInvalidCode):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrMFANotEnrolled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, auth.ErrMFAAlreadyEnrolled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, "MFA operation failed: "+err.Error(), http.StatusInternalServerError)
+	}
+}
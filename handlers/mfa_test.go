@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+// startTestMFAChallenge parks an LDAP login of a user who must enroll in
+// MFA and returns the challenge cookie
+func startTestMFAChallenge(t *testing.T, h *AuthHandler) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.completeFirstFactor(w, httptest.NewRequest(http.MethodPost, "/auth/ldap/login", nil),
+		&models.User{ID: "jane", Provider: "ldap", Roles: []string{"admin"}},
+		&auth.LoginState{ReturnTo: "/dashboard", Params: auth.TokenParams{Scope: "read"}}, "", []string{auth.AMRPassword})
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == mfaChallengeCookieName {
+			return cookie
+		}
+	}
+	t.Fatalf("completeFirstFactor() = %d, want an MFA challenge cookie", w.Code)
+	return nil
+}
+
+func postMFA(h *AuthHandler, handler http.HandlerFunc, path string, cookie *http.Cookie, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+func TestAuthHandler_MFAEnrollmentShowsRecoveryCodes(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.MFARequiredRoles = []string{"admin"}
+	h := newTestAuthHandler(t, cfg)
+	cookie := startTestMFAChallenge(t, h)
+
+	pending, err := h.mfa.Challenge(cookie.Value)
+	if err != nil || pending.Enrollment == nil {
+		t.Fatalf("Challenge() = %v, want an enrollment", err)
+	}
+	code, err := utils.TOTPCode(pending.Enrollment.Secret, utils.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatalf("TOTPCode() error = %v", err)
+	}
+
+	// Confirming the enrollment shows the codes instead of finishing
+	w := postMFA(h, h.MFAChallenge, "/auth/mfa", cookie, url.Values{"code": {code}})
+	if w.Code != http.StatusOK || w.Header().Get("Location") != "" {
+		t.Fatalf("MFAChallenge() = %d to %q, want the recovery codes page", w.Code, w.Header().Get("Location"))
+	}
+	pending, _ = h.mfa.Challenge(cookie.Value)
+	if len(pending.RecoveryCodes) == 0 || !strings.Contains(w.Body.String(), pending.RecoveryCodes[0]) {
+		t.Fatal("MFAChallenge() page does not list the recovery codes")
+	}
+
+	w = postMFA(h, h.MFAContinue, "/auth/mfa/continue", cookie, nil)
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || location.Path != "/dashboard" || !strings.HasPrefix(location.Fragment, "token=") {
+		t.Errorf("MFAContinue() = %d to %q, want a redirect to /dashboard with a token", w.Code, location)
+	}
+	if w = postMFA(h, h.MFAContinue, "/auth/mfa/continue", cookie, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("MFAContinue() twice = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestAuthHandler_MFAAttemptsExhaustedRestartsLogin(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.MFARequiredRoles = []string{"admin"}
+	h := newTestAuthHandler(t, cfg)
+	cookie := startTestMFAChallenge(t, h)
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 5; i++ {
+		w = postMFA(h, h.MFAChallenge, "/auth/mfa", cookie, url.Values{"code": {"000000"}})
+	}
+	want := "/auth/ldap/login?return_to=%2Fdashboard&scope=read"
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != want {
+		t.Errorf("MFAChallenge() last attempt = %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), http.StatusSeeOther, want)
+	}
+}
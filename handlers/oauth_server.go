@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+const (
+	authorizeCookieName = "oauth_authorize"
+	deviceCookieName    = "oauth_device"
+)
+
+// OAuthServerHandler exposes the gateway's OAuth 2.0 / OIDC provider endpoints
+type OAuthServerHandler struct {
+	config     *config.Config
+	authServer *auth.AuthorizationServer
+}
+
+// NewOAuthServerHandler creates a new OAuth server handler
+func NewOAuthServerHandler(cfg *config.Config, authServer *auth.AuthorizationServer) *OAuthServerHandler {
+	return &OAuthServerHandler{
+		config:     cfg,
+		authServer: authServer,
+	}
+}
+
+// Authorize validates an authorization request and sends the user through
+// the upstream provider login; Callback resumes the request afterwards
+func (h *OAuthServerHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := &auth.AuthorizeRequest{
+		ClientID:            query.Get("client_id"),
+		RedirectURI:         query.Get("redirect_uri"),
+		ResponseType:        query.Get("response_type"),
+		Scope:               query.Get("scope"),
+		Audience:            query.Get("audience"),
+		State:               query.Get("state"),
+		Nonce:               query.Get("nonce"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+
+	client, err := h.authServer.ValidateClient(req)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	if err := h.authServer.ValidateAuthorizeRequest(client, req); err != nil {
+		var oauthErr *auth.OAuthError
+		errors.As(err, &oauthErr)
+		http.Redirect(w, r, h.authServer.ErrorRedirect(req, oauthErr), http.StatusFound)
+		return
+	}
+
+	id, err := h.authServer.StartAuthorization(req)
+	if err != nil {
+		http.Error(w, "Failed to start authorization", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     authorizeCookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.config.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   600, // 10 minutes
+	})
+
+	http.Redirect(w, r, "/auth/login", http.StatusFound)
+}
+
+// Token exchanges an authorization code for an access token and ID token
+func (h *OAuthServerHandler) Token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, &auth.OAuthError{Code: "invalid_request", Status: http.StatusBadRequest})
+		return
+	}
+
+	req := &auth.TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		ClientID:     r.PostForm.Get("client_id"),
+		ClientSecret: r.PostForm.Get("client_secret"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		DeviceCode:   r.PostForm.Get("device_code"),
+
+		SubjectToken:       r.PostForm.Get("subject_token"),
+		SubjectTokenType:   r.PostForm.Get("subject_token_type"),
+		ActorToken:         r.PostForm.Get("actor_token"),
+		RequestedTokenType: r.PostForm.Get("requested_token_type"),
+		Audience:           r.PostForm.Get("audience"),
+		Scope:              r.PostForm.Get("scope"),
+	}
+	if id, secret, ok := r.BasicAuth(); ok {
+		req.ClientID = id
+		req.ClientSecret = secret
+	}
+
+	resp, err := h.authServer.Exchange(req)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// UserInfo returns claims about the user the access token was issued for,
+// while the session the token is bound to is active
+func (h *OAuthServerHandler) UserInfo(w http.ResponseWriter, r *http.Request) {
+	tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Authorization header required", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := utils.ValidateJWT(tokenString, h.config.JWTSecret)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := h.authServer.CheckSession(claims); err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Invalid session: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.authServer.UserInfo(claims))
+}
+
+// Discovery serves the OIDC discovery document
+func (h *OAuthServerHandler) Discovery(w http.ResponseWriter, r *http.Request) {
+	issuer := h.authServer.Issuer()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/oauth/userinfo",
+		"jwks_uri":                              issuer + "/oauth/jwks",
+		"response_types_supported":              []string{"code"},
+		"device_authorization_endpoint":         issuer + "/oauth/device_authorization",
+		"grant_types_supported":                 []string{"authorization_code", auth.GrantTypeDeviceCode, auth.GrantTypeTokenExchange},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "profile", "email"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256", "plain"},
+	})
+}
+
+// JWKS serves the public keys used to verify ID tokens
+func (h *OAuthServerHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []utils.JWK{h.authServer.SigningKey().JWK()},
+	})
+}
+
+func writeOAuthError(w http.ResponseWriter, err error) {
+	var oauthErr *auth.OAuthError
+	if !errors.As(err, &oauthErr) {
+		oauthErr = &auth.OAuthError{Code: "server_error", Status: http.StatusInternalServerError}
+	}
+	if oauthErr.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(oauthErr.Status)
+	json.NewEncoder(w).Encode(oauthErr)
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+func TestOAuthServerHandler_UserInfoEndedSession(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.OIDCIssuer = "https://auth.example.com"
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	sessions := auth.NewSessionManager(cfg, store.NewMemorySessionStore())
+	h := NewOAuthServerHandler(cfg, auth.NewAuthorizationServer(cfg, store.NewMemoryClientStore(), utils.NewSigningKey(privateKey), sessions))
+
+	user := &models.User{ID: "123", Email: "test@example.com", Roles: []string{"user"}}
+	session, err := sessions.Create(user, "192.0.2.1", "test", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	claims := utils.NewClaims(user, time.Hour)
+	claims.SessionID = session.ID
+	token, err := utils.SignJWT(claims, cfg.JWTSecret)
+	if err != nil {
+		t.Fatalf("Failed to sign JWT: %v", err)
+	}
+
+	userInfo := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/oauth/userinfo", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		h.UserInfo(w, r)
+		return w.Code
+	}
+
+	if code := userInfo(); code != http.StatusOK {
+		t.Fatalf("UserInfo() with an active session status = %d, want %d", code, http.StatusOK)
+	}
+
+	// Logging out ends the profile access of every token of the session
+	if err := sessions.Revoke(user.ID, session.ID); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if code := userInfo(); code != http.StatusUnauthorized {
+		t.Errorf("UserInfo() with a revoked session status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
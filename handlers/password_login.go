@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+// passwordLoginCSRFCookieName holds the double-submit token of a
+// username/password sign-in form, so other sites cannot log a browser into
+// an account of theirs. The cookie is scoped to the form's path.
+const passwordLoginCSRFCookieName = "password_login_csrf"
+
+var passwordLoginPageTemplate = template.Must(template.New("password-login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Title}}</title></head>
+<body>
+  <h1>{{.Title}}</h1>
+  {{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+  <form method="POST" action="{{.Action}}">
+    <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+    <input type="hidden" name="return_to" value="{{.ReturnTo}}">
+    <input type="hidden" name="audience" value="{{.Audience}}">
+    <input type="hidden" name="scope" value="{{.Scope}}">
+    <label>Username <input type="text" name="username" value="{{.Username}}" autocomplete="username" autofocus></label>
+    <label>Password <input type="password" name="password" autocomplete="current-password"></label>
+    <button type="submit">Sign in</button>
+  </form>
+</body>
+</html>
+`))
+
+type passwordLoginPageData struct {
+	Title     string
+	Action    string
+	CSRFToken string
+	ReturnTo  string
+	Audience  string
+	Scope     string
+	Username  string
+	Error     string
+}
+
+// passwordLoginForm is a username/password sign-in form served at path.
+// Submissions are checked with authenticate, given the client IP for attempt
+// limiting, and continue like an IdP callback would.
+type passwordLoginForm struct {
+	auth         *AuthHandler
+	title        string
+	path         string
+	authenticate func(username, password, ip string) (*models.User, error)
+}
+
+// Page serves the sign-in form. It accepts the same return_to, audience and
+// scope parameters as /auth/login.
+func (f *passwordLoginForm) Page(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if _, err := f.auth.directLoginState(query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.render(w, http.StatusOK, passwordLoginPageData{
+		ReturnTo: query.Get("return_to"),
+		Audience: query.Get("audience"),
+		Scope:    query.Get("scope"),
+	})
+}
+
+// Submit checks a username and password and continues the login
+func (f *passwordLoginForm) Submit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	csrfCookie, err := r.Cookie(passwordLoginCSRFCookieName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(csrfCookie.Value), []byte(r.PostForm.Get("csrf_token"))) != 1 {
+		http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+		return
+	}
+	loginState, err := f.auth.directLoginState(r.PostForm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	form := passwordLoginPageData{
+		ReturnTo: r.PostForm.Get("return_to"),
+		Audience: r.PostForm.Get("audience"),
+		Scope:    r.PostForm.Get("scope"),
+		Username: r.PostForm.Get("username"),
+	}
+	user, err := f.authenticate(r.PostForm.Get("username"), r.PostForm.Get("password"), clientIP(r))
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		form.Error = "Invalid username or password."
+		f.render(w, http.StatusUnauthorized, form)
+		return
+	}
+	if errors.Is(err, auth.ErrTooManyAttempts) {
+		form.Error = "Too many sign-in attempts. Try again later."
+		f.render(w, http.StatusTooManyRequests, form)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to sign in: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:   passwordLoginCSRFCookieName,
+		Value:  "",
+		Path:   f.path,
+		MaxAge: -1,
+	})
+	loginState.AuthTime = time.Now().Unix()
+	f.auth.completeFirstFactor(w, r, user, loginState, "", []string{auth.AMRPassword})
+}
+
+// render renders the sign-in form with a fresh CSRF token
+func (f *passwordLoginForm) render(w http.ResponseWriter, status int, data passwordLoginPageData) {
+	csrfToken, err := generateRandomState()
+	if err != nil {
+		http.Error(w, "Failed to generate CSRF token", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     passwordLoginCSRFCookieName,
+		Value:    csrfToken,
+		Path:     f.path,
+		HttpOnly: true,
+		Secure:   f.auth.config.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	data.Title = f.title
+	data.Action = f.path
+	data.CSRFToken = csrfToken
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	passwordLoginPageTemplate.Execute(w, data)
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// submitPasswordLogin posts the sign-in form with a matching CSRF token
+func submitPasswordLogin(f *passwordLoginForm, username, password string) *httptest.ResponseRecorder {
+	form := url.Values{
+		"csrf_token": {"csrf-1"},
+		"username":   {username},
+		"password":   {password},
+		"return_to":  {"/dashboard"},
+	}
+	r := httptest.NewRequest(http.MethodPost, f.path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: passwordLoginCSRFCookieName, Value: "csrf-1"})
+	w := httptest.NewRecorder()
+	f.Submit(w, r)
+	return w
+}
+
+func TestPasswordLoginForm_LoginRules(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.LoginDeniedEmails = []string{"mallory@example.com"}
+	h := newTestAuthHandler(t, cfg)
+
+	// Local and LDAP sign-in share the form, differing only in the provider
+	for _, provider := range []string{"local", "ldap"} {
+		f := &passwordLoginForm{
+			auth: h,
+			path: "/auth/" + provider + "/login",
+			authenticate: func(username, password, ip string) (*models.User, error) {
+				if password != "correct" {
+					return nil, auth.ErrInvalidCredentials
+				}
+				return &models.User{ID: username, Email: username + "@example.com", Provider: provider, Roles: []string{"user"}}, nil
+			},
+		}
+
+		tests := []struct {
+			name     string
+			username string
+			password string
+			wantCode int
+		}{
+			{"Admitted", "jane", "correct", http.StatusFound},
+			{"Denied email", "mallory", "correct", http.StatusForbidden},
+			{"Wrong password", "jane", "wrong", http.StatusUnauthorized},
+		}
+
+		for _, tt := range tests {
+			t.Run(provider+"/"+tt.name, func(t *testing.T) {
+				if w := submitPasswordLogin(f, tt.username, tt.password); w.Code != tt.wantCode {
+					t.Errorf("Submit() status = %d, want %d", w.Code, tt.wantCode)
+				}
+			})
+		}
+	}
+}
+
+func TestPasswordLoginForm_AttemptLimit(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.LocalArgon2Memory = 1024
+	cfg.LocalArgon2Iterations = 1
+	cfg.LocalArgon2Parallelism = 1
+	cfg.LocalArgon2MaxConcurrency = 1
+	cfg.LocalPasswordMinLength = 12
+	cfg.LocalInviteTTL = time.Hour
+	cfg.PasswordMaxAttempts = 3
+	cfg.PasswordIPMaxFailures = 10
+	cfg.PasswordAttemptWindow = 15 * time.Minute
+	hasher, err := auth.NewPasswordHasher(cfg)
+	if err != nil {
+		t.Fatalf("NewPasswordHasher() error = %v", err)
+	}
+	local, err := auth.NewLocalAccountService(cfg, store.NewMemoryLocalAccountStore(), hasher,
+		auth.NewSessionManager(cfg, store.NewMemorySessionStore()), nil)
+	if err != nil {
+		t.Fatalf("NewLocalAccountService() error = %v", err)
+	}
+	invite, _, _ := local.Invite("vendor@example.com", nil, "admin-1")
+	if _, err := local.Register(invite, "vendor1", "", "correct horse battery staple"); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	f := &passwordLoginForm{
+		auth:         newTestAuthHandler(t, cfg),
+		path:         "/auth/local/login",
+		authenticate: local.Authenticate,
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := submitPasswordLogin(f, "vendor1", "wrong horse battery staple"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Submit() #%d status = %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+		}
+	}
+	if w := submitPasswordLogin(f, "vendor1", "correct horse battery staple"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Submit() after 3 failures status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
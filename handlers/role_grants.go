@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/middleware"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// RoleGrantHandler handles time-bound role grants
+type RoleGrantHandler struct {
+	grants *auth.RoleGrantService
+}
+
+// NewRoleGrantHandler creates a new role grant handler
+func NewRoleGrantHandler(grants *auth.RoleGrantService) *RoleGrantHandler {
+	return &RoleGrantHandler{grants: grants}
+}
+
+// Create grants a role to a user for a validity window
+func (h *RoleGrantHandler) Create(w http.ResponseWriter, r *http.Request) {
+	admin, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req models.RoleGrant
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	grant, err := h.grants.Grant(admin, &models.RoleGrant{
+		UserID:       req.UserID,
+		Role:         req.Role,
+		NotBefore:    req.NotBefore,
+		NotAfter:     req.NotAfter,
+		ReplaceRoles: req.ReplaceRoles,
+		Reason:       req.Reason,
+	})
+	if errors.Is(err, auth.ErrInvalidRoleGrant) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to grant role: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(grant)
+}
+
+// List returns the grants of all users, or of the user_id query parameter
+func (h *RoleGrantHandler) List(w http.ResponseWriter, r *http.Request) {
+	h.list(w, r.URL.Query().Get("user_id"))
+}
+
+// ListOwn returns the caller's grants, so users can see when their access
+// ends
+func (h *RoleGrantHandler) ListOwn(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+	h.list(w, user.ID)
+}
+
+func (h *RoleGrantHandler) list(w http.ResponseWriter, userID string) {
+	grants, err := h.grants.List(userID)
+	if err != nil {
+		http.Error(w, "Failed to list role grants: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"grants": grants,
+	})
+}
+
+// Revoke removes a grant before it expires
+func (h *RoleGrantHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	admin, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.grants.Revoke(admin, r.PathValue("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Role grant not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke role grant: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
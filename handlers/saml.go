@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+)
+
+// samlRequestCookiePrefix names the cookies holding the login state of
+// in-flight SAML logins. The rest of the name is the login's RelayState,
+// so parallel logins never collide.
+const samlRequestCookiePrefix = "saml_request_"
+
+// SAMLHandler handles login through a SAML 2.0 IdP
+type SAMLHandler struct {
+	auth *AuthHandler
+	saml *auth.SAMLService
+}
+
+// NewSAMLHandler creates a new SAML handler. Logins finish through the auth
+// handler, so they get the same MFA step and tokens as OAuth logins.
+func NewSAMLHandler(authHandler *AuthHandler, samlService *auth.SAMLService) *SAMLHandler {
+	return &SAMLHandler{
+		auth: authHandler,
+		saml: samlService,
+	}
+}
+
+// Metadata serves the service provider metadata to register with the IdP
+func (h *SAMLHandler) Metadata(w http.ResponseWriter, r *http.Request) {
+	metadata, err := h.saml.Metadata()
+	if err != nil {
+		http.Error(w, "Failed to generate metadata: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(metadata)
+}
+
+// Login sends the browser to the IdP with an AuthnRequest. It accepts the
+// same return_to, audience, scope, acr_values and max_age parameters as
+// /auth/login; max_age=0 forces the IdP to authenticate the user again.
+func (h *SAMLHandler) Login(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	direct, err := h.auth.directLoginState(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxAge, err := auth.ParseMaxAge(query.Get("max_age"))
+	if err != nil {
+		http.Error(w, "Invalid max_age: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	relayState, err := generateRandomState()
+	if err != nil {
+		http.Error(w, "Failed to generate state", http.StatusInternalServerError)
+		return
+	}
+	loginState, err := auth.NewLoginState(auth.SAMLProvider, direct.ReturnTo, direct.Params, relayState)
+	if err != nil {
+		http.Error(w, "Failed to generate state", http.StatusInternalServerError)
+		return
+	}
+	loginState.ACR = auth.ParseACRValues(query.Get("acr_values"))
+	loginState.MaxAge = maxAge
+
+	redirectURL, requestID, err := h.saml.AuthnRequestURL(relayState, maxAge == 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The response must answer this request
+	loginState.Nonce = requestID
+	state, err := h.auth.loginStates.Encode(loginState)
+	if err != nil {
+		http.Error(w, "Failed to generate state", http.StatusInternalServerError)
+		return
+	}
+
+	// The IdP posts the response from its own site, so the cookie must be
+	// SameSite=None to come along; browsers only accept that on Secure cookies
+	sameSite := http.SameSiteLaxMode
+	if h.auth.config.CookieSecure {
+		sameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     samlRequestCookiePrefix + relayState,
+		Value:    state,
+		Path:     "/saml/acs",
+		HttpOnly: true,
+		Secure:   h.auth.config.CookieSecure,
+		SameSite: sameSite,
+		MaxAge:   600, // 10 minutes, matching the state lifetime
+	})
+
+	http.Redirect(w, r, redirectURL, http.StatusFound)
+}
+
+// ACS is the assertion consumer service. It validates the IdP's response
+// to a request this browser started and continues the login.
+func (h *SAMLHandler) ACS(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	relayState := r.PostForm.Get("RelayState")
+	requestCookie, err := r.Cookie(samlRequestCookiePrefix + relayState)
+	if relayState == "" || err != nil {
+		http.Error(w, "SAML request cookie not found", http.StatusBadRequest)
+		return
+	}
+	loginState, err := h.auth.loginStates.Decode(requestCookie.Value)
+	if err != nil {
+		http.Error(w, "Invalid SAML request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !loginState.BoundTo(relayState) || loginState.Provider != auth.SAMLProvider {
+		http.Error(w, "Invalid SAML request", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   requestCookie.Name,
+		Value:  "",
+		Path:   "/saml/acs",
+		MaxAge: -1,
+	})
+
+	user, authnInstant, err := h.saml.ParseResponse(r.PostForm.Get("SAMLResponse"), loginState.Nonce)
+	if err != nil {
+		// The details help whoever debugs the IdP setup, not the browser
+		log.Printf("SAML login rejected: %v", err)
+		http.Error(w, "Invalid SAML response", http.StatusBadRequest)
+		return
+	}
+
+	var authTime int64
+	if !authnInstant.IsZero() {
+		authTime = authnInstant.Unix()
+	}
+	if err := loginState.SetAuthTime(authTime, time.Now()); err != nil {
+		log.Printf("SAML login rejected: %v", err)
+		http.Error(w, "Authentication is not recent enough", http.StatusUnauthorized)
+		return
+	}
+	h.auth.completeFirstFactor(w, r, user, loginState, "", []string{auth.AMRFederated})
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/middleware"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// SessionHandler lets users see and revoke their own sessions, and
+// admins those of any user
+type SessionHandler struct {
+	sessions *auth.SessionManager
+	audit    *auth.Audit
+}
+
+// NewSessionHandler creates a new session handler. Admin revocations are
+// recorded in the audit trail.
+func NewSessionHandler(sessions *auth.SessionManager, audit *auth.Audit) *SessionHandler {
+	return &SessionHandler{
+		sessions: sessions,
+		audit:    audit,
+	}
+}
+
+// sessionView is a session as shown to its owner
+type sessionView struct {
+	*models.Session
+	Current bool `json:"current"`
+
+	// Shadows the embedded field so the upstream token is never listed
+	UpstreamIDToken string `json:"upstream_id_token,omitempty"`
+}
+
+// List returns the caller's sessions, marking the one making the request
+func (h *SessionHandler) List(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := h.sessions.List(claims.UserID)
+	if err != nil {
+		http.Error(w, "Failed to list sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]sessionView, 0, len(sessions))
+	for _, session := range sessions {
+		views = append(views, sessionView{
+			Session: session,
+			Current: session.ID == claims.SessionID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": views,
+	})
+}
+
+// Revoke ends one of the caller's sessions
+func (h *SessionHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	claims, ok := middleware.GetClaimsFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.sessions.Revoke(claims.UserID, r.PathValue("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListForUser returns a user's sessions for an admin
+func (h *SessionHandler) ListForUser(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h.sessions.List(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Failed to list sessions: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]sessionView, 0, len(sessions))
+	for _, session := range sessions {
+		views = append(views, sessionView{Session: session})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": views,
+	})
+}
+
+// RevokeForUser ends one of a user's sessions for an admin, such as a
+// break-glass session that is no longer needed
+func (h *SessionHandler) RevokeForUser(w http.ResponseWriter, r *http.Request) {
+	admin, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	userID, sessionID := r.PathValue("id"), r.PathValue("sid")
+	err := h.sessions.Revoke(userID, sessionID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit.Record(models.AuditSessionRevoked, admin.ID, userID, map[string]string{"session_id": sessionID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
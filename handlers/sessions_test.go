@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/middleware"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+func TestSessionHandler_RevokeForUser(t *testing.T) {
+	cfg := newTestConfig()
+	sessions := auth.NewSessionManager(cfg, store.NewMemorySessionStore())
+	audit := auth.NewAudit(store.NewMemoryAuditStore())
+	h := NewSessionHandler(sessions, audit)
+
+	user := &models.User{ID: "break-glass:emergency-1", Provider: "break-glass", Roles: []string{"admin"}}
+	session, err := sessions.Create(user, "192.0.2.1", "test", "")
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	admin := &models.User{ID: "admin-1", Roles: []string{"admin"}}
+
+	revoke := func(userID, sessionID string) int {
+		r := httptest.NewRequest(http.MethodDelete, "/admin/users/"+userID+"/sessions/"+sessionID, nil)
+		r.SetPathValue("id", userID)
+		r.SetPathValue("sid", sessionID)
+		r = r.WithContext(context.WithValue(r.Context(), middleware.UserContextKey, admin))
+		w := httptest.NewRecorder()
+		h.RevokeForUser(w, r)
+		return w.Code
+	}
+
+	if code := revoke("someone-else", session.ID); code != http.StatusNotFound {
+		t.Errorf("RevokeForUser() of another user's session status = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := revoke(user.ID, session.ID); code != http.StatusNoContent {
+		t.Fatalf("RevokeForUser() status = %d, want %d", code, http.StatusNoContent)
+	}
+	if _, err := sessions.Check(session.ID); !errors.Is(err, auth.ErrSessionRevoked) {
+		t.Errorf("Check() after admin revocation error = %v, want %v", err, auth.ErrSessionRevoked)
+	}
+
+	events, _ := audit.List(user.ID, models.AuditSessionRevoked)
+	if len(events) != 1 || events[0].Actor != admin.ID {
+		t.Errorf("audit events = %+v, want one %s by %s", events, models.AuditSessionRevoked, admin.ID)
+	}
+}
@@ -0,0 +1,392 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/middleware"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/store"
+)
+
+// webauthnCeremonyCookieName ties a login ceremony to the browser that
+// started it, so an assertion cannot be replayed into another browser
+const webauthnCeremonyCookieName = "webauthn_ceremony"
+
+// webauthnScript fetches assertion options from a begin endpoint, asks the
+// browser for an assertion and posts it with the page's form
+const webauthnScript = `<script>
+function b64urlToBuf(s) {
+  s = s.replace(/-/g, "+").replace(/_/g, "/");
+  while (s.length % 4) s += "=";
+  return Uint8Array.from(atob(s), c => c.charCodeAt(0)).buffer;
+}
+function bufToB64url(b) {
+  return btoa(String.fromCharCode(...new Uint8Array(b))).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+async function webauthnAssert(beginURL, form) {
+  const resp = await fetch(beginURL, {method: "POST", credentials: "same-origin"});
+  if (!resp.ok) { alert(await resp.text()); return; }
+  const options = (await resp.json()).publicKey;
+  options.challenge = b64urlToBuf(options.challenge);
+  (options.allowCredentials || []).forEach(c => c.id = b64urlToBuf(c.id));
+  const cred = await navigator.credentials.get({publicKey: options});
+  form.credential.value = JSON.stringify({
+    id: cred.id,
+    rawId: bufToB64url(cred.rawId),
+    type: cred.type,
+    response: {
+      authenticatorData: bufToB64url(cred.response.authenticatorData),
+      clientDataJSON: bufToB64url(cred.response.clientDataJSON),
+      signature: bufToB64url(cred.response.signature),
+      userHandle: cred.response.userHandle ? bufToB64url(cred.response.userHandle) : null
+    }
+  });
+  form.submit();
+}
+</script>`
+
+var passkeyPageTemplate = template.Must(template.New("passkey").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Sign in with a passkey</title></head>
+<body>
+  <h1>Sign in with a passkey</h1>
+  {{if .Error}}<p style="color: #b00">{{.Error}}</p>{{end}}
+  <form id="passkey" method="POST" action="/auth/passkey/finish">
+    <input type="hidden" name="credential">
+    <input type="hidden" name="return_to" value="{{.ReturnTo}}">
+    <input type="hidden" name="audience" value="{{.Audience}}">
+    <input type="hidden" name="scope" value="{{.Scope}}">
+    <button type="button" onclick="webauthnAssert('/auth/passkey/begin', document.getElementById('passkey'))">Use a passkey</button>
+  </form>
+  <p><a href="/auth/login">Sign in with your identity provider instead</a></p>
+` + webauthnScript + `
+</body>
+</html>
+`))
+
+type passkeyPageData struct {
+	ReturnTo string
+	Audience string
+	Scope    string
+	Error    string
+}
+
+// MFAWebAuthnBegin starts a security-key assertion for a pending login
+func (h *AuthHandler) MFAWebAuthnBegin(w http.ResponseWriter, r *http.Request) {
+	challengeCookie, err := r.Cookie(mfaChallengeCookieName)
+	if err != nil {
+		http.Error(w, "MFA challenge not found", http.StatusBadRequest)
+		return
+	}
+	pending, err := h.mfa.Challenge(challengeCookie.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ceremonyID, assertion, err := h.webauthn.BeginLogin(pending.User.ID)
+	if err != nil {
+		writeWebAuthnError(w, err)
+		return
+	}
+	h.writeAssertionOptions(w, ceremonyID, assertion)
+}
+
+// MFAWebAuthnFinish checks the security-key assertion posted by the MFA
+// page and finishes the pending login
+func (h *AuthHandler) MFAWebAuthnFinish(w http.ResponseWriter, r *http.Request) {
+	challengeCookie, err := r.Cookie(mfaChallengeCookieName)
+	if err != nil {
+		http.Error(w, "MFA challenge not found", http.StatusBadRequest)
+		return
+	}
+	pending, err := h.mfa.Challenge(challengeCookie.Value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	amr, err := h.webauthn.FinishLogin(takeCeremonyCookie(w, r), pending.User, strings.NewReader(r.PostFormValue("credential")))
+	if err != nil {
+		renderMFAPage(w, http.StatusUnauthorized, h.mfaPageData(pending, "Security key verification failed, please try again."))
+		return
+	}
+
+	if _, err := h.mfa.TakeChallenge(challengeCookie.Value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clearMFAChallengeCookie(w)
+	h.finishLogin(w, r, pending.User, pending.State, pending.UpstreamIDToken, append(pending.AMR, amr...))
+}
+
+// PasskeyPage serves the passwordless login page. It accepts the same
+// return_to, audience and scope parameters as Login.
+func (h *AuthHandler) PasskeyPage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if _, err := h.directLoginState(query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderPasskeyPage(w, http.StatusOK, passkeyPageData{
+		ReturnTo: query.Get("return_to"),
+		Audience: query.Get("audience"),
+		Scope:    query.Get("scope"),
+	})
+}
+
+// PasskeyBegin starts a discoverable-credential assertion
+func (h *AuthHandler) PasskeyBegin(w http.ResponseWriter, r *http.Request) {
+	ceremonyID, assertion, err := h.webauthn.BeginPasskeyLogin()
+	if err != nil {
+		writeWebAuthnError(w, err)
+		return
+	}
+	h.writeAssertionOptions(w, ceremonyID, assertion)
+}
+
+// PasskeyFinish checks the passkey assertion and issues the gateway token
+// without a provider login
+func (h *AuthHandler) PasskeyFinish(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	loginState, err := h.directLoginState(r.PostForm)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	form := passkeyPageData{
+		ReturnTo: r.PostForm.Get("return_to"),
+		Audience: r.PostForm.Get("audience"),
+		Scope:    r.PostForm.Get("scope"),
+	}
+	account, amr, err := h.webauthn.FinishPasskeyLogin(takeCeremonyCookie(w, r), strings.NewReader(r.PostFormValue("credential")))
+	if errors.Is(err, auth.ErrWebAuthnCloned) {
+		log.Printf("Passkey login refused: %v", err)
+		form.Error = "This passkey may have been copied and can no longer be used. Sign in another way and remove it."
+		renderPasskeyPage(w, http.StatusUnauthorized, form)
+		return
+	}
+	if err != nil {
+		form.Error = "Passkey verification failed, please try again."
+		renderPasskeyPage(w, http.StatusUnauthorized, form)
+		return
+	}
+
+	// The user is looked up again, so removed accounts and unlinked
+	// identities cannot log in, with the profile and roles from their last
+	// provider login
+	user, err := h.directory.LookupProfile(account.UserID, account.Provider, account.Profile)
+	if errors.Is(err, auth.ErrUnknownUser) {
+		form.Error = "This passkey's account no longer exists. Sign in with your identity provider."
+		renderPasskeyPage(w, http.StatusUnauthorized, form)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to look up user: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !h.loginAllowed(w, user, "") {
+		return
+	}
+	user, ok := h.applyRoles(w, user)
+	if !ok {
+		return
+	}
+
+	loginState.AuthTime = time.Now().Unix()
+	h.finishLogin(w, r, user, loginState, "", amr)
+}
+
+// writeAssertionOptions sets the ceremony cookie and writes the options for
+// navigator.credentials.get
+func (h *AuthHandler) writeAssertionOptions(w http.ResponseWriter, ceremonyID string, assertion interface{}) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     webauthnCeremonyCookieName,
+		Value:    ceremonyID,
+		Path:     "/auth",
+		HttpOnly: true,
+		Secure:   h.config.CookieSecure,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   300, // 5 minutes
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(assertion)
+}
+
+// takeCeremonyCookie returns and clears the browser's ceremony ID
+func takeCeremonyCookie(w http.ResponseWriter, r *http.Request) string {
+	ceremonyCookie, err := r.Cookie(webauthnCeremonyCookieName)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   webauthnCeremonyCookieName,
+		Value:  "",
+		Path:   "/auth",
+		MaxAge: -1,
+	})
+	return ceremonyCookie.Value
+}
+
+func renderPasskeyPage(w http.ResponseWriter, status int, data passkeyPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	passkeyPageTemplate.Execute(w, data)
+}
+
+// WebAuthnHandler lets users register and manage security keys and passkeys
+type WebAuthnHandler struct {
+	webauthn *auth.WebAuthnService
+}
+
+// NewWebAuthnHandler creates a new WebAuthn handler
+func NewWebAuthnHandler(webauthn *auth.WebAuthnService) *WebAuthnHandler {
+	return &WebAuthnHandler{
+		webauthn: webauthn,
+	}
+}
+
+// webauthnCredentialView is a registered credential as shown to its owner
+type webauthnCredentialView struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	Transports     []string   `json:"transports,omitempty"`
+	BackupEligible bool       `json:"backup_eligible"`
+	BackupState    bool       `json:"backup_state"`
+	CloneWarning   bool       `json:"clone_warning,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+}
+
+func newWebAuthnCredentialView(credential *models.WebAuthnCredential) webauthnCredentialView {
+	view := webauthnCredentialView{
+		ID:             base64.RawURLEncoding.EncodeToString(credential.ID),
+		Name:           credential.Name,
+		Transports:     credential.Transports,
+		BackupEligible: credential.BackupEligible,
+		BackupState:    credential.BackupState,
+		CloneWarning:   credential.CloneWarning,
+		CreatedAt:      credential.CreatedAt,
+	}
+	if !credential.LastUsedAt.IsZero() {
+		view.LastUsedAt = &credential.LastUsedAt
+	}
+	return view
+}
+
+// BeginRegistration returns the ceremony ID and the options for
+// navigator.credentials.create
+func (h *WebAuthnHandler) BeginRegistration(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	ceremonyID, creation, err := h.webauthn.BeginRegistration(user)
+	if err != nil {
+		writeWebAuthnError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"publicKey":   creation.Response,
+	})
+}
+
+// FinishRegistration verifies the attestation in the request body and
+// stores the credential
+func (h *WebAuthnHandler) FinishRegistration(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	credential, err := h.webauthn.FinishRegistration(query.Get("ceremony_id"), user, query.Get("name"), r.Body)
+	if err != nil {
+		writeWebAuthnError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newWebAuthnCredentialView(credential))
+}
+
+// ListCredentials returns the caller's registered credentials
+func (h *WebAuthnHandler) ListCredentials(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	credentials, err := h.webauthn.Credentials(user.ID)
+	if err != nil {
+		writeWebAuthnError(w, err)
+		return
+	}
+
+	views := make([]webauthnCredentialView, 0, len(credentials))
+	for i := range credentials {
+		views = append(views, newWebAuthnCredentialView(&credentials[i]))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"credentials": views,
+	})
+}
+
+// DeleteCredential removes one of the caller's credentials
+func (h *WebAuthnHandler) DeleteCredential(w http.ResponseWriter, r *http.Request) {
+	user, ok := middleware.GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "User not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.webauthn.DeleteCredential(user.ID, r.PathValue("id")); err != nil {
+		writeWebAuthnError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Credential deleted",
+	})
+}
+
+func writeWebAuthnError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrWebAuthnVerificationFailed):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrWebAuthnCeremonyNotFound):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, auth.ErrWebAuthnNotRegistered), errors.Is(err, store.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, "WebAuthn operation failed: "+err.Error(), http.StatusInternalServerError)
+	}
+}
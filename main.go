@@ -9,6 +9,8 @@ import (
 	"github.com/Hilina-t/microservice-authenticator/config"
 	"github.com/Hilina-t/microservice-authenticator/handlers"
 	"github.com/Hilina-t/microservice-authenticator/middleware"
+	"github.com/Hilina-t/microservice-authenticator/store"
+	"github.com/Hilina-t/microservice-authenticator/utils"
 )
 
 func main() {
@@ -24,8 +26,199 @@ func main() {
 
 	// Initialize services
 	oauthService := auth.NewOAuthService(cfg)
-	authHandler := handlers.NewAuthHandler(cfg, oauthService)
+
+	var sessionStore store.SessionStore = store.NewMemorySessionStore()
+	if cfg.SessionStoreFile != "" {
+		sessionStore, err = store.NewFileSessionStore(cfg.SessionStoreFile)
+		if err != nil {
+			log.Fatalf("Failed to open session store: %v", err)
+		}
+	}
+	sessionManager := auth.NewSessionManager(cfg, sessionStore)
+
+	var mfaStore store.MFAStore = store.NewMemoryMFAStore()
+	if cfg.MFAStoreFile != "" {
+		mfaStore, err = store.NewFileMFAStore(cfg.MFAStoreFile)
+		if err != nil {
+			log.Fatalf("Failed to open MFA store: %v", err)
+		}
+	}
+	mfaService := auth.NewMFAService(cfg, mfaStore)
+
+	var accounts *auth.AccountService
+	if cfg.EnableAccountLinking {
+		var accountStore store.AccountStore = store.NewMemoryAccountStore()
+		if cfg.AccountsFile != "" {
+			accountStore, err = store.NewFileAccountStore(cfg.AccountsFile)
+			if err != nil {
+				log.Fatalf("Failed to open account store: %v", err)
+			}
+		}
+		accounts = auth.NewAccountService(cfg, accountStore)
+	}
+
+	var passwordHasher *auth.PasswordHasher
+	if cfg.EnableLocalAccounts || cfg.EnableBreakGlass {
+		passwordHasher, err = auth.NewPasswordHasher(cfg)
+		if err != nil {
+			log.Fatalf("Failed to initialize password hashing: %v", err)
+		}
+	}
+
+	var localAccounts *auth.LocalAccountService
+	if cfg.EnableLocalAccounts {
+		var localAccountStore store.LocalAccountStore = store.NewMemoryLocalAccountStore()
+		if cfg.LocalAccountsFile != "" {
+			localAccountStore, err = store.NewFileLocalAccountStore(cfg.LocalAccountsFile)
+			if err != nil {
+				log.Fatalf("Failed to open local account store: %v", err)
+			}
+		}
+		localAccounts, err = auth.NewLocalAccountService(cfg, localAccountStore, passwordHasher, sessionManager, accounts)
+		if err != nil {
+			log.Fatalf("Failed to initialize local accounts: %v", err)
+		}
+	}
+
+	var ldapService *auth.LDAPService
+	if cfg.EnableLDAP {
+		ldapService, err = auth.NewLDAPService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to initialize LDAP: %v", err)
+		}
+	}
+
+	var samlService *auth.SAMLService
+	if cfg.EnableSAML {
+		samlService, err = auth.NewSAMLService(cfg)
+		if err != nil {
+			log.Fatalf("Failed to initialize SAML: %v", err)
+		}
+	}
+
+	var magicLinks *auth.MagicLinkService
+	if cfg.EnableMagicLink {
+		mailSender, err := auth.NewMailSender(cfg)
+		if err != nil {
+			log.Fatalf("Failed to initialize mail: %v", err)
+		}
+		magicLinks = auth.NewMagicLinkService(cfg, mailSender)
+	}
+
+	var webauthnService *auth.WebAuthnService
+	if cfg.EnableWebAuthn {
+		var webauthnStore store.WebAuthnStore = store.NewMemoryWebAuthnStore()
+		if cfg.WebAuthnStoreFile != "" {
+			webauthnStore, err = store.NewFileWebAuthnStore(cfg.WebAuthnStoreFile)
+			if err != nil {
+				log.Fatalf("Failed to open WebAuthn store: %v", err)
+			}
+		}
+		webauthnService, err = auth.NewWebAuthnService(cfg, webauthnStore)
+		if err != nil {
+			log.Fatalf("Failed to initialize WebAuthn: %v", err)
+		}
+	}
+
+	var auditStore store.AuditStore = store.NewMemoryAuditStore()
+	if cfg.AuditLogFile != "" {
+		auditStore, err = store.NewFileAuditStore(cfg.AuditLogFile)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+	}
+	audit := auth.NewAudit(auditStore)
+
+	var elevations *auth.ElevationService
+	if cfg.EnableElevation {
+		var elevationStore store.ElevationStore = store.NewMemoryElevationStore()
+		if cfg.ElevationStoreFile != "" {
+			elevationStore, err = store.NewFileElevationStore(cfg.ElevationStoreFile)
+			if err != nil {
+				log.Fatalf("Failed to open elevation store: %v", err)
+			}
+		}
+		elevations = auth.NewElevationService(cfg, elevationStore, audit)
+	}
+
+	var roleGrants *auth.RoleGrantService
+	if cfg.EnableRoleGrants {
+		var roleGrantStore store.RoleGrantStore = store.NewMemoryRoleGrantStore()
+		if cfg.RoleGrantsFile != "" {
+			roleGrantStore, err = store.NewFileRoleGrantStore(cfg.RoleGrantsFile)
+			if err != nil {
+				log.Fatalf("Failed to open role grant store: %v", err)
+			}
+		}
+		roleGrants = auth.NewRoleGrantService(cfg, roleGrantStore, audit)
+	}
+
+	var breakGlass *auth.BreakGlassService
+	if cfg.EnableBreakGlass {
+		breakGlass, err = auth.NewBreakGlassService(cfg, passwordHasher, audit)
+		if err != nil {
+			log.Fatalf("Failed to initialize break-glass accounts: %v", err)
+		}
+		log.Printf("Break-glass accounts enabled; every use is audited and alerted")
+	}
+
+	var impersonation *auth.ImpersonationService
+	if cfg.EnableImpersonation {
+		impersonation = auth.NewImpersonationService(cfg, audit)
+	}
+
+	var authServer *auth.AuthorizationServer
+	var clientRegistry *auth.ClientRegistry
+	if cfg.EnableOIDCProvider {
+		var clientStore store.ClientStore = store.NewMemoryClientStore()
+		if cfg.OIDCClientStoreFile != "" {
+			clientStore, err = store.NewFileClientStore(cfg.OIDCClientStoreFile)
+			if err != nil {
+				log.Fatalf("Failed to open OIDC client store: %v", err)
+			}
+		}
+		if cfg.OIDCClientsFile != "" {
+			if err := store.LoadClients(clientStore, cfg.OIDCClientsFile); err != nil {
+				log.Fatalf("Failed to load OIDC clients: %v", err)
+			}
+		}
+		signingKey, err := utils.LoadOrGenerateSigningKey(cfg.OIDCSigningKeyFile)
+		if err != nil {
+			log.Fatalf("Failed to load OIDC signing key: %v", err)
+		}
+		authServer = auth.NewAuthorizationServer(cfg, clientStore, signingKey, sessionManager)
+		clientRegistry = auth.NewClientRegistry(cfg, clientStore)
+		log.Printf("OIDC Provider Issuer: %s", cfg.OIDCIssuer)
+	}
+
+	loginStates, err := auth.NewLoginStateCodec(cfg.JWTSecret)
+	if err != nil {
+		log.Fatalf("Failed to initialize login state: %v", err)
+	}
+
+	directory := auth.NewUserDirectory(cfg, accounts, localAccounts)
+	authHandler := handlers.NewAuthHandler(cfg, oauthService, authServer, sessionManager, loginStates, mfaService, webauthnService, accounts, elevations, roleGrants, directory)
+	sessionHandler := handlers.NewSessionHandler(sessionManager, audit)
+	mfaHandler := handlers.NewMFAHandler(mfaService)
+	logoutReceiver := auth.NewLogoutReceiver(cfg, oauthService.ProviderKeys(), sessionManager)
+	logoutNotificationHandler := handlers.NewLogoutNotificationHandler(logoutReceiver)
 	protectedHandler := handlers.NewProtectedHandler()
+	auditHandler := handlers.NewAuditHandler(audit)
+
+	// Tokens restricted to other services are refused by the gateway; it is
+	// the audience of tokens requested for its issuer
+	authenticate := middleware.AuthMiddleware(cfg, sessionManager)
+	restrictAudience := middleware.RestrictAudience(cfg.OIDCIssuer)
+	requireAuth := func(h http.Handler) http.Handler {
+		return authenticate(restrictAudience(middleware.RejectClientTokens(middleware.RejectActorWrites(h))))
+	}
+	if cfg.AdminRequiredACR != "" && auth.ParseACRValues(cfg.AdminRequiredACR) != cfg.AdminRequiredACR {
+		log.Fatalf("Unknown ADMIN_REQUIRED_ACR: %s", cfg.AdminRequiredACR)
+	}
+	requireAdminStepUp := middleware.RequireAuthLevel(cfg.AdminRequiredACR, cfg.AdminMaxAuthAge)
+	requireAdmin := func(h http.HandlerFunc) http.Handler {
+		return requireAuth(middleware.RequireRole("admin")(requireAdminStepUp(h)))
+	}
 
 	// Setup routes
 	mux := http.NewServeMux()
@@ -43,49 +236,194 @@ func main() {
 	// Authentication routes
 	mux.HandleFunc("/auth/login", authHandler.Login)
 	mux.HandleFunc("/auth/callback", authHandler.Callback)
+	mux.HandleFunc("/auth/mfa", authHandler.MFAChallenge)
+	mux.HandleFunc("POST /auth/mfa/continue", authHandler.MFAContinue)
+	mux.HandleFunc("POST /auth/backchannel-logout", logoutNotificationHandler.BackChannel)
+	mux.HandleFunc("GET /auth/frontchannel-logout", logoutNotificationHandler.FrontChannel)
+
+	// WebAuthn security keys and passkeys
+	if webauthnService != nil {
+		mux.HandleFunc("POST /auth/mfa/webauthn/begin", authHandler.MFAWebAuthnBegin)
+		mux.HandleFunc("POST /auth/mfa/webauthn/finish", authHandler.MFAWebAuthnFinish)
+		mux.HandleFunc("GET /auth/passkey", authHandler.PasskeyPage)
+		mux.HandleFunc("POST /auth/passkey/begin", authHandler.PasskeyBegin)
+		mux.HandleFunc("POST /auth/passkey/finish", authHandler.PasskeyFinish)
+
+		webauthnHandler := handlers.NewWebAuthnHandler(webauthnService)
+		mux.Handle("POST /auth/webauthn/register/begin", requireAuth(http.HandlerFunc(webauthnHandler.BeginRegistration)))
+		mux.Handle("POST /auth/webauthn/register/finish", requireAuth(http.HandlerFunc(webauthnHandler.FinishRegistration)))
+		mux.Handle("GET /auth/webauthn/credentials", requireAuth(http.HandlerFunc(webauthnHandler.ListCredentials)))
+		mux.Handle("DELETE /auth/webauthn/credentials/{id}", requireAuth(http.HandlerFunc(webauthnHandler.DeleteCredential)))
+	}
+
+	// OIDC provider routes for internal applications
+	if authServer != nil {
+		oauthServerHandler := handlers.NewOAuthServerHandler(cfg, authServer)
+		mux.HandleFunc("/.well-known/openid-configuration", oauthServerHandler.Discovery)
+		mux.HandleFunc("/oauth/authorize", oauthServerHandler.Authorize)
+		mux.HandleFunc("/oauth/token", oauthServerHandler.Token)
+		mux.HandleFunc("/oauth/userinfo", oauthServerHandler.UserInfo)
+		mux.HandleFunc("/oauth/jwks", oauthServerHandler.JWKS)
+		mux.HandleFunc("/oauth/device_authorization", oauthServerHandler.DeviceAuthorization)
+		mux.HandleFunc("/oauth/device", oauthServerHandler.Device)
+		mux.HandleFunc("POST /oauth/device/consent", oauthServerHandler.DeviceConsent)
+
+		// Client registration and management
+		clientHandler := handlers.NewClientHandler(cfg, clientRegistry)
+		mux.HandleFunc("/oauth/register", clientHandler.Register)
+		mux.Handle("GET /admin/clients", requireAdmin(clientHandler.List))
+		mux.Handle("POST /admin/clients", requireAdmin(clientHandler.Create))
+		mux.Handle("GET /admin/clients/{id}", requireAdmin(clientHandler.Get))
+		mux.Handle("DELETE /admin/clients/{id}", requireAdmin(clientHandler.Delete))
+		mux.Handle("POST /admin/clients/{id}/secret", requireAdmin(clientHandler.RotateSecret))
+	}
+
+	// Local username/password accounts
+	if localAccounts != nil {
+		localAccountHandler := handlers.NewLocalAccountHandler(authHandler, localAccounts)
+		mux.HandleFunc("GET /auth/local/login", localAccountHandler.LoginPage)
+		mux.HandleFunc("POST /auth/local/login", localAccountHandler.Login)
+		mux.HandleFunc("GET /auth/local/register", localAccountHandler.RegisterPage)
+		mux.HandleFunc("POST /auth/local/register", localAccountHandler.Register)
+		mux.HandleFunc("GET /auth/local/reset", localAccountHandler.ResetPage)
+		mux.HandleFunc("POST /auth/local/reset", localAccountHandler.Reset)
+		mux.Handle("POST /auth/local/password", requireAuth(http.HandlerFunc(localAccountHandler.ChangePassword)))
+
+		mux.Handle("POST /admin/local/invites", requireAdmin(localAccountHandler.Invite))
+		mux.Handle("GET /admin/local/accounts", requireAdmin(localAccountHandler.ListAccounts))
+		mux.Handle("DELETE /admin/local/accounts/{id}", requireAdmin(localAccountHandler.DeleteAccount))
+		mux.Handle("POST /admin/local/accounts/{id}/password-reset", requireAdmin(localAccountHandler.IssuePasswordReset))
+	}
+
+	// LDAP / Active Directory sign-in
+	if ldapService != nil {
+		ldapHandler := handlers.NewLDAPHandler(authHandler, ldapService)
+		mux.HandleFunc("GET /auth/ldap/login", ldapHandler.LoginPage)
+		mux.HandleFunc("POST /auth/ldap/login", ldapHandler.Login)
+	}
+
+	// SAML 2.0 service provider
+	if samlService != nil {
+		samlHandler := handlers.NewSAMLHandler(authHandler, samlService)
+		mux.HandleFunc("GET /saml/metadata", samlHandler.Metadata)
+		mux.HandleFunc("GET /saml/login", samlHandler.Login)
+		mux.HandleFunc("POST /saml/acs", samlHandler.ACS)
+	}
+
+	// Passwordless login with emailed links
+	if magicLinks != nil {
+		magicLinkHandler := handlers.NewMagicLinkHandler(authHandler, magicLinks)
+		mux.HandleFunc("GET /auth/magic-link", magicLinkHandler.RequestPage)
+		mux.HandleFunc("POST /auth/magic-link", magicLinkHandler.Request)
+		mux.HandleFunc("GET /auth/magic-link/verify", magicLinkHandler.VerifyPage)
+		mux.HandleFunc("POST /auth/magic-link/verify", magicLinkHandler.Verify)
+	}
+
+	// Canonical accounts and linked identities
+	if accounts != nil {
+		accountHandler := handlers.NewAccountHandler(authHandler, accounts)
+		mux.Handle("GET /auth/account", requireAuth(http.HandlerFunc(accountHandler.Get)))
+		mux.Handle("POST /auth/account/link", requireAuth(http.HandlerFunc(accountHandler.StartLink)))
+		mux.Handle("DELETE /auth/account/identities/{provider}/{subject...}", requireAuth(http.HandlerFunc(accountHandler.Unlink)))
+	}
+
+	// Break-glass emergency login, independent of the OAuth provider
+	if breakGlass != nil {
+		breakGlassHandler := handlers.NewBreakGlassHandler(authHandler, breakGlass)
+		mux.HandleFunc("POST /auth/break-glass/login", breakGlassHandler.Login)
+	}
+
+	// Just-in-time role elevation. Approvers need not be admins, so the
+	// service checks who may decide; deciding still requires step-up.
+	if elevations != nil {
+		elevationHandler := handlers.NewElevationHandler(elevations)
+		mux.Handle("POST /auth/elevations", requireAuth(http.HandlerFunc(elevationHandler.Request)))
+		mux.Handle("GET /auth/elevations", requireAuth(http.HandlerFunc(elevationHandler.List)))
+		mux.Handle("GET /auth/elevations/pending", requireAuth(http.HandlerFunc(elevationHandler.ListPending)))
+		mux.Handle("POST /auth/elevations/{id}/approve", requireAuth(requireAdminStepUp(http.HandlerFunc(elevationHandler.Approve))))
+		mux.Handle("POST /auth/elevations/{id}/deny", requireAuth(requireAdminStepUp(http.HandlerFunc(elevationHandler.Deny))))
+	}
+
+	// Time-bound role grants
+	if roleGrants != nil {
+		roleGrantHandler := handlers.NewRoleGrantHandler(roleGrants)
+		mux.Handle("GET /auth/role-grants", requireAuth(http.HandlerFunc(roleGrantHandler.ListOwn)))
+		mux.Handle("GET /admin/role-grants", requireAdmin(roleGrantHandler.List))
+		mux.Handle("POST /admin/role-grants", requireAdmin(roleGrantHandler.Create))
+		mux.Handle("DELETE /admin/role-grants/{id}", requireAdmin(roleGrantHandler.Revoke))
+	}
+
+	// Admin impersonation
+	if impersonation != nil {
+		impersonationHandler := handlers.NewImpersonationHandler(impersonation, directory)
+		mux.Handle("POST /admin/impersonate", requireAdmin(impersonationHandler.Impersonate))
+	}
+
+	// Audit trail
+	mux.Handle("GET /admin/audit", requireAdmin(auditHandler.List))
 
 	// Protected routes (require authentication)
-	mux.Handle("/auth/profile", middleware.AuthMiddleware(cfg)(http.HandlerFunc(authHandler.Profile)))
-	mux.Handle("/auth/logout", middleware.AuthMiddleware(cfg)(http.HandlerFunc(authHandler.Logout)))
+	mux.Handle("/auth/profile", requireAuth(http.HandlerFunc(authHandler.Profile)))
+	mux.HandleFunc("GET /auth/logout", authHandler.LogoutPage)
+	// Any token of the user may end its own session, whatever its audience
+	mux.Handle("POST /auth/logout", authenticate(middleware.RejectClientTokens(middleware.RejectActorWrites(http.HandlerFunc(authHandler.Logout)))))
+	mux.Handle("GET /auth/sessions", requireAuth(http.HandlerFunc(sessionHandler.List)))
+	mux.Handle("DELETE /auth/sessions/{id}", requireAuth(http.HandlerFunc(sessionHandler.Revoke)))
+	mux.Handle("GET /admin/users/{id}/sessions", requireAdmin(sessionHandler.ListForUser))
+	mux.Handle("DELETE /admin/users/{id}/sessions/{sid}", requireAdmin(sessionHandler.RevokeForUser))
+	mux.Handle("GET /auth/mfa/totp", requireAuth(http.HandlerFunc(mfaHandler.Status)))
+	mux.Handle("POST /auth/mfa/totp", requireAuth(http.HandlerFunc(mfaHandler.Enroll)))
+	mux.Handle("POST /auth/mfa/totp/confirm", requireAuth(http.HandlerFunc(mfaHandler.Confirm)))
+	mux.Handle("DELETE /auth/mfa/totp", requireAuth(http.HandlerFunc(mfaHandler.Disable)))
+	mux.Handle("POST /auth/mfa/recovery-codes", requireAuth(http.HandlerFunc(mfaHandler.RecoveryCodes)))
 
-	// RBAC protected routes
+	// RBAC protected routes. Scoped tokens must also carry the route's
+	// scope; unscoped tokens are limited by the user's roles alone.
 	if cfg.EnableRBAC {
 		// Admin-only endpoint
 		mux.Handle("/api/admin",
-			middleware.AuthMiddleware(cfg)(
+			requireAuth(
 				middleware.RequireRole("admin")(
-					http.HandlerFunc(protectedHandler.AdminOnly),
+					middleware.RestrictScope("admin")(
+						requireAdminStepUp(http.HandlerFunc(protectedHandler.AdminOnly)),
+					),
 				),
 			),
 		)
 
 		// User endpoint (requires user or admin role)
 		mux.Handle("/api/user/data",
-			middleware.AuthMiddleware(cfg)(
+			requireAuth(
 				middleware.RequireRole("user", "admin")(
-					http.HandlerFunc(protectedHandler.UserData),
+					middleware.RestrictScope("data:read")(
+						http.HandlerFunc(protectedHandler.UserData),
+					),
 				),
 			),
 		)
 
 		// Viewer endpoint (requires viewer, user, or admin role)
 		mux.Handle("/api/viewer/data",
-			middleware.AuthMiddleware(cfg)(
+			requireAuth(
 				middleware.RequireRole("viewer", "user", "admin")(
-					http.HandlerFunc(protectedHandler.ViewerData),
+					middleware.RestrictScope("data:read")(
+						http.HandlerFunc(protectedHandler.ViewerData),
+					),
 				),
 			),
 		)
 
 		// Permission-based endpoint example
 		mux.Handle("/api/data/create",
-			middleware.AuthMiddleware(cfg)(
+			requireAuth(
 				middleware.RequirePermission("data", "create")(
-					http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-						user, _ := middleware.GetUserFromContext(r.Context())
-						w.Header().Set("Content-Type", "application/json")
-						fmt.Fprintf(w, `{"message": "Data creation allowed", "user": "%s"}`, user.Email)
-					}),
+					middleware.RestrictScope("data:create")(
+						http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							user, _ := middleware.GetUserFromContext(r.Context())
+							w.Header().Set("Content-Type", "application/json")
+							fmt.Fprintf(w, `{"message": "Data creation allowed", "user": "%s"}`, user.Email)
+						}),
+					),
 				),
 			),
 		)
@@ -2,9 +2,12 @@ package middleware
 
 import (
 	"context"
+	"log"
+	"mime"
 	"net/http"
 	"strings"
 
+	"github.com/Hilina-t/microservice-authenticator/auth"
 	"github.com/Hilina-t/microservice-authenticator/config"
 	"github.com/Hilina-t/microservice-authenticator/models"
 	"github.com/Hilina-t/microservice-authenticator/utils"
@@ -12,28 +15,54 @@ import (
 
 type contextKey string
 
-const UserContextKey contextKey = "user"
+const (
+	UserContextKey   contextKey = "user"
+	ClaimsContextKey contextKey = "claims"
+)
+
+// CSRFHeaderName is the header carrying the double-submit CSRF token on
+// state-changing requests authenticated by the session cookie. HTML forms,
+// which cannot set headers, send it as the CSRFFormField field instead.
+const (
+	CSRFHeaderName = "X-CSRF-Token"
+	CSRFFormField  = "csrf_token"
+)
 
-// AuthMiddleware validates JWT tokens
-func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+// AuthMiddleware validates JWT tokens from the Authorization header or, when
+// session cookies are enabled, from the session cookie. Tokens bound to a
+// server-side session are rejected once the session is revoked; sessions
+// may be nil when session tracking is not used.
+func AuthMiddleware(cfg *config.Config, sessions *auth.SessionManager) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var tokenString string
+
 			// Extract token from Authorization header
 			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				http.Error(w, "Authorization header required", http.StatusUnauthorized)
-				return
-			}
+			if authHeader != "" {
+				// Check for Bearer token
+				parts := strings.Split(authHeader, " ")
+				if len(parts) != 2 || parts[0] != "Bearer" {
+					http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+					return
+				}
 
-			// Check for Bearer token
-			parts := strings.Split(authHeader, " ")
-			if len(parts) != 2 || parts[0] != "Bearer" {
-				http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+				tokenString = parts[1]
+			} else if cookie, err := sessionCookie(cfg, r); err == nil {
+				// Browsers attach cookies to cross-site requests, so
+				// state-changing requests must echo the CSRF token
+				if !isSafeMethod(r.Method) &&
+					!utils.VerifyCSRFToken(cookie.Value, csrfToken(r), cfg.JWTSecret) {
+					http.Error(w, "Invalid or missing CSRF token", http.StatusForbidden)
+					return
+				}
+
+				tokenString = cookie.Value
+			} else {
+				http.Error(w, "Authorization header required", http.StatusUnauthorized)
 				return
 			}
 
-			tokenString := parts[1]
-
 			// Validate token
 			claims, err := utils.ValidateJWT(tokenString, cfg.JWTSecret)
 			if err != nil {
@@ -41,6 +70,18 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 				return
 			}
 
+			if sessions != nil && claims.SessionID != "" {
+				if _, err := sessions.Check(claims.SessionID); err != nil {
+					http.Error(w, "Invalid session: "+err.Error(), http.StatusUnauthorized)
+					return
+				}
+			}
+
+			// Emergency tokens leave a trail of every request they make
+			if claims.BreakGlass {
+				log.Printf("BREAK-GLASS token of %s used: %s %s", claims.UserID, r.Method, r.URL.Path)
+			}
+
 			// Create user from claims
 			user := &models.User{
 				ID:       claims.UserID,
@@ -49,16 +90,106 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 				Roles:    claims.Roles,
 				Provider: claims.Provider,
 			}
+			if claims.Act != nil {
+				user.Actor = claims.Act.Subject
+			}
 
 			// Add user to context
 			ctx := context.WithValue(r.Context(), UserContextKey, user)
+			ctx = context.WithValue(ctx, ClaimsContextKey, claims)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
-// GetUserFromContext retrieves the user from the request context
+func sessionCookie(cfg *config.Config, r *http.Request) (*http.Cookie, error) {
+	if !cfg.EnableSessionCookies {
+		return nil, http.ErrNoCookie
+	}
+	return r.Cookie(cfg.SessionCookieName)
+}
+
+// csrfToken returns the CSRF token from the header or, for form posts, the
+// form field
+func csrfToken(r *http.Request) string {
+	if token := r.Header.Get(CSRFHeaderName); token != "" {
+		return token
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		return r.PostFormValue(CSRFFormField)
+	}
+	return ""
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// RejectActorWrites middleware lets tokens with an act claim, issued to an
+// impersonating admin or through token exchange, only read. Such tokens
+// cannot change the user's settings, such as enrolling MFA.
+func RejectActorWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := GetUserFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if user.Actor != "" && !isSafeMethod(r.Method) {
+			http.Error(w, "Tokens used on behalf of another user are read-only here", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RejectClientTokens middleware refuses access tokens the gateway's OIDC
+// provider issued to client applications. Those are for the client's own
+// APIs and the userinfo endpoint, so a client cannot replay its user's
+// token against the gateway.
+func RejectClientTokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := GetClaimsFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if claims.ClientID != "" {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="token was issued to a client application"`)
+			http.Error(w, "Tokens issued to client applications are not accepted here", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetUserFromContext retrieves the user from the request context. This is
+// the effective user; when someone acts as the user, user.Actor names them.
 func GetUserFromContext(ctx context.Context) (*models.User, bool) {
 	user, ok := ctx.Value(UserContextKey).(*models.User)
 	return user, ok
 }
+
+// GetActorFromContext returns the ID of whoever really makes the request:
+// the act claim subject when someone acts as the user, and otherwise the
+// user's own ID
+func GetActorFromContext(ctx context.Context) (string, bool) {
+	user, ok := GetUserFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	if user.Actor != "" {
+		return user.Actor, true
+	}
+	return user.ID, true
+}
+
+// GetClaimsFromContext retrieves the validated token claims from the request context
+func GetClaimsFromContext(ctx context.Context) (*utils.Claims, bool) {
+	claims, ok := ctx.Value(ClaimsContextKey).(*utils.Claims)
+	return claims, ok
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Hilina-t/microservice-authenticator/config"
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+)
+
+func TestAuthMiddleware_CSRF(t *testing.T) {
+	cfg := &config.Config{
+		JWTSecret:            "test-secret",
+		EnableSessionCookies: true,
+		SessionCookieName:    "iag_session",
+	}
+	token, err := utils.GenerateJWT(&models.User{ID: "123", Roles: []string{"user"}}, cfg.JWTSecret, 1)
+	if err != nil {
+		t.Fatalf("GenerateJWT() error = %v", err)
+	}
+	otherToken, _ := utils.GenerateJWT(&models.User{ID: "456", Roles: []string{"user"}}, cfg.JWTSecret, 1)
+	h := AuthMiddleware(cfg, nil)(okHandler)
+
+	tests := []struct {
+		name     string
+		method   string
+		cookie   bool
+		bearer   bool
+		csrf     string
+		form     string
+		wantCode int
+	}{
+		{"Cookie GET needs no token", http.MethodGet, true, false, "", "", http.StatusOK},
+		{"Cookie POST without token", http.MethodPost, true, false, "", "", http.StatusForbidden},
+		{"Cookie POST with wrong token", http.MethodPost, true, false, "forged", "", http.StatusForbidden},
+		{"Cookie DELETE with another session's token", http.MethodDelete, true, false, utils.CSRFToken(otherToken, cfg.JWTSecret), "", http.StatusForbidden},
+		{"Cookie POST with valid token", http.MethodPost, true, false, utils.CSRFToken(token, cfg.JWTSecret), "", http.StatusOK},
+		{"Cookie form POST with valid token field", http.MethodPost, true, false, "", utils.CSRFToken(token, cfg.JWTSecret), http.StatusOK},
+		{"Cookie form POST with wrong token field", http.MethodPost, true, false, "", "forged", http.StatusForbidden},
+		{"Bearer POST is exempt", http.MethodPost, false, true, "", "", http.StatusOK},
+		{"Bearer POST with a cookie is exempt", http.MethodPost, true, true, "", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/auth/sessions", nil)
+			if tt.form != "" {
+				r = httptest.NewRequest(tt.method, "/auth/logout", strings.NewReader(url.Values{CSRFFormField: {tt.form}}.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if tt.cookie {
+				r.AddCookie(&http.Cookie{Name: cfg.SessionCookieName, Value: token})
+			}
+			if tt.bearer {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			if tt.csrf != "" {
+				r.Header.Set(CSRFHeaderName, tt.csrf)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Errorf("AuthMiddleware() status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRejectClientTokens(t *testing.T) {
+	h := RejectClientTokens(okHandler)
+
+	tests := []struct {
+		name     string
+		claims   *utils.Claims
+		wantCode int
+	}{
+		{"No claims", nil, http.StatusUnauthorized},
+		{"Gateway token", &utils.Claims{UserID: "123"}, http.StatusOK},
+		{"Issued to a client", &utils.Claims{UserID: "123", ClientID: "app"}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serveWithClaims(h, tt.claims); w.Code != tt.wantCode {
+				t.Errorf("RejectClientTokens() status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
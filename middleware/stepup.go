@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+)
+
+// RequireAuthLevel middleware checks that the token's login reached the
+// given acr class and, when maxAge is positive, happened within maxAge.
+// Otherwise it rejects with the RFC 9470 insufficient_user_authentication
+// error, telling the client which acr_values and max_age to log in again
+// with.
+func RequireAuthLevel(acr string, maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaimsFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			if !auth.ACRSatisfies(claims.ACR, acr) {
+				writeInsufficientUserAuthentication(w, "A stronger authentication level is required", acr, maxAge)
+				return
+			}
+			if maxAge > 0 && (claims.AuthTime == nil || time.Since(claims.AuthTime.Time) > maxAge) {
+				writeInsufficientUserAuthentication(w, "More recent authentication is required", acr, maxAge)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func writeInsufficientUserAuthentication(w http.ResponseWriter, description, acr string, maxAge time.Duration) {
+	challenge := []string{
+		`Bearer error="insufficient_user_authentication"`,
+		fmt.Sprintf(`error_description="%s"`, description),
+	}
+	body := map[string]interface{}{
+		"error":             "insufficient_user_authentication",
+		"error_description": description,
+	}
+	if acr != "" {
+		challenge = append(challenge, fmt.Sprintf(`acr_values="%s"`, acr))
+		body["acr_values"] = acr
+	}
+	if maxAge > 0 {
+		seconds := int(maxAge.Seconds())
+		challenge = append(challenge, fmt.Sprintf("max_age=%d", seconds))
+		body["max_age"] = seconds
+	}
+
+	w.Header().Set("WWW-Authenticate", strings.Join(challenge, ", "))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/auth"
+	"github.com/Hilina-t/microservice-authenticator/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRequireAuthLevel(t *testing.T) {
+	recent := jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	stale := jwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	tests := []struct {
+		name      string
+		acr       string
+		maxAge    time.Duration
+		claims    *utils.Claims
+		wantCode  int
+		wantError string
+	}{
+		{"No claims", "", 0, nil, http.StatusUnauthorized, ""},
+		{"ACR met", auth.ACRMFA, 0, &utils.Claims{ACR: auth.ACRMFA}, http.StatusOK, ""},
+		{"ACR too weak", auth.ACRMFA, 0, &utils.Claims{ACR: auth.ACRBasic}, http.StatusUnauthorized, "insufficient_user_authentication"},
+		{"No ACR required", "", 0, &utils.Claims{}, http.StatusOK, ""},
+		{"Recent login", "", 15 * time.Minute, &utils.Claims{AuthTime: recent}, http.StatusOK, ""},
+		{"Stale login", "", 15 * time.Minute, &utils.Claims{AuthTime: stale}, http.StatusUnauthorized, "insufficient_user_authentication"},
+		{"Unknown auth_time", "", 15 * time.Minute, &utils.Claims{}, http.StatusUnauthorized, "insufficient_user_authentication"},
+		{"No max_age ignores auth_time", "", 0, &utils.Claims{AuthTime: stale}, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveWithClaims(RequireAuthLevel(tt.acr, tt.maxAge)(okHandler), tt.claims)
+			if w.Code != tt.wantCode {
+				t.Fatalf("RequireAuthLevel() status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantError == "" {
+				return
+			}
+
+			var body map[string]interface{}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if body["error"] != tt.wantError {
+				t.Errorf("RequireAuthLevel() error = %v, want %s", body["error"], tt.wantError)
+			}
+			challenge := w.Header().Get("WWW-Authenticate")
+			if !strings.Contains(challenge, `error="`+tt.wantError+`"`) {
+				t.Errorf("RequireAuthLevel() WWW-Authenticate = %q, want the %s error", challenge, tt.wantError)
+			}
+			if tt.acr != "" && !strings.Contains(challenge, `acr_values="`+tt.acr+`"`) {
+				t.Errorf("RequireAuthLevel() WWW-Authenticate = %q, want acr_values=%q", challenge, tt.acr)
+			}
+			if tt.maxAge > 0 && !strings.Contains(challenge, "max_age=900") {
+				t.Errorf("RequireAuthLevel() WWW-Authenticate = %q, want max_age=900", challenge)
+			}
+		})
+	}
+}
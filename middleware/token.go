@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RequireAudience middleware checks that the token was issued for any of
+// the given audiences, so tokens minted for one service are rejected by
+// another
+func RequireAudience(audiences ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaimsFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			for _, audience := range audiences {
+				if claims.HasAudience(audience) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="token audience not accepted"`)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":     "Invalid token audience",
+				"audiences": audiences,
+			})
+		})
+	}
+}
+
+// RestrictAudience middleware lets through unrestricted tokens, which have
+// no aud claim, and tokens issued for any of the given audiences. Services
+// that also accept their users' unrestricted tokens use it instead of
+// RequireAudience.
+func RestrictAudience(audiences ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		restricted := RequireAudience(audiences...)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if claims, ok := GetClaimsFromContext(r.Context()); ok && len(claims.Audience) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			restricted.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope middleware checks that the token was granted all of the
+// given scopes. Tokens without a scope claim are rejected.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	required := strings.Join(scopes, " ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetClaimsFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			for _, scope := range scopes {
+				if !claims.HasScope(scope) {
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, required))
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusForbidden)
+					json.NewEncoder(w).Encode(map[string]string{
+						"error": "Insufficient scope",
+						"scope": required,
+					})
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RestrictScope middleware lets through unscoped tokens, whose access
+// follows the user's roles alone, and scoped tokens granted all of the
+// given scopes
+func RestrictScope(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		restricted := RequireScope(scopes...)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if claims, ok := GetClaimsFromContext(r.Context()); ok && claims.Scope == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			restricted.ServeHTTP(w, r)
+		})
+	}
+}
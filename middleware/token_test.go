@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Hilina-t/microservice-authenticator/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// serveWithClaims runs the handler for a request authenticated with claims
+func serveWithClaims(h http.Handler, claims *utils.Claims) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if claims != nil {
+		r = r.WithContext(context.WithValue(r.Context(), ClaimsContextKey, claims))
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestRequireAudience(t *testing.T) {
+	h := RequireAudience("orders", "billing")(okHandler)
+
+	tests := []struct {
+		name     string
+		claims   *utils.Claims
+		wantCode int
+	}{
+		{"No claims", nil, http.StatusUnauthorized},
+		{"Missing aud", &utils.Claims{}, http.StatusUnauthorized},
+		{"Wrong aud", &utils.Claims{RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"inventory"}}}, http.StatusUnauthorized},
+		{"Accepted aud", &utils.Claims{RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"billing"}}}, http.StatusOK},
+		{"One of several", &utils.Claims{RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"inventory", "orders"}}}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveWithClaims(h, tt.claims)
+			if w.Code != tt.wantCode {
+				t.Errorf("RequireAudience() status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusUnauthorized && tt.claims != nil && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("RequireAudience() rejected without a WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name     string
+		required []string
+		scope    string
+		wantCode int
+	}{
+		{"All scopes", []string{"orders:read", "orders:write"}, "orders:read orders:write", http.StatusOK},
+		{"Superset", []string{"orders:read"}, "openid orders:read orders:write", http.StatusOK},
+		{"Subset", []string{"orders:read", "orders:write"}, "orders:read", http.StatusForbidden},
+		{"Empty scope", []string{"orders:read"}, "", http.StatusForbidden},
+		{"Prefix is not a match", []string{"orders"}, "orders:read", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := RequireScope(tt.required...)(okHandler)
+			w := serveWithClaims(h, &utils.Claims{Scope: tt.scope})
+			if w.Code != tt.wantCode {
+				t.Errorf("RequireScope() status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusForbidden && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("RequireScope() rejected without a WWW-Authenticate header")
+			}
+		})
+	}
+
+	if w := serveWithClaims(RequireScope("orders:read")(okHandler), nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("RequireScope() without claims status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRestrictAudience(t *testing.T) {
+	h := RestrictAudience("https://auth.example.com")(okHandler)
+
+	tests := []struct {
+		name     string
+		claims   *utils.Claims
+		wantCode int
+	}{
+		{"No claims", nil, http.StatusUnauthorized},
+		{"Unrestricted", &utils.Claims{}, http.StatusOK},
+		{"Accepted aud", &utils.Claims{RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"https://auth.example.com"}}}, http.StatusOK},
+		{"Other service", &utils.Claims{RegisteredClaims: jwt.RegisteredClaims{Audience: jwt.ClaimStrings{"orders"}}}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serveWithClaims(h, tt.claims); w.Code != tt.wantCode {
+				t.Errorf("RestrictAudience() status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRestrictScope(t *testing.T) {
+	h := RestrictScope("data:read")(okHandler)
+
+	tests := []struct {
+		name     string
+		claims   *utils.Claims
+		wantCode int
+	}{
+		{"No claims", nil, http.StatusUnauthorized},
+		{"Unscoped", &utils.Claims{}, http.StatusOK},
+		{"Granted", &utils.Claims{Scope: "openid data:read"}, http.StatusOK},
+		{"Scoped elsewhere", &utils.Claims{Scope: "orders:read"}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serveWithClaims(h, tt.claims); w.Code != tt.wantCode {
+				t.Errorf("RestrictScope() status = %d, want %d", w.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Account is the gateway's canonical record of a person. Every provider
+// identity the person logs in with is linked to one account, and the
+// account ID is the subject of their tokens.
+type Account struct {
+	ID         string           `json:"id"`
+	Identities []LinkedIdentity `json:"identities"`
+	CreatedAt  time.Time        `json:"created_at"`
+}
+
+// LinkedIdentity is a provider identity linked to an account
+type LinkedIdentity struct {
+	Provider      string    `json:"provider"`
+	Subject       string    `json:"subject"` // the user ID at the provider
+	Email         string    `json:"email,omitempty"`
+	EmailVerified bool      `json:"email_verified"`
+	LinkedAt      time.Time `json:"linked_at"`
+	LastLoginAt   time.Time `json:"last_login_at"`
+}
+
+// Identity returns the index of the linked identity, or -1 if the identity
+// is not linked to the account
+func (a *Account) Identity(provider, subject string) int {
+	for i, identity := range a.Identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return i
+		}
+	}
+	return -1
+}
+
+// HasVerifiedEmail reports whether one of the identities from the given
+// providers has the email address, verified by its provider
+func (a *Account) HasVerifiedEmail(email string, providers []string) bool {
+	for _, identity := range a.Identities {
+		if !identity.EmailVerified || !strings.EqualFold(identity.Email, email) {
+			continue
+		}
+		for _, provider := range providers {
+			if identity.Provider == provider {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Audit actions
+const (
+	AuditElevationRequested = "elevation.requested"
+	AuditElevationApproved  = "elevation.approved"
+	AuditElevationDenied    = "elevation.denied"
+	AuditElevationUsed      = "elevation.used"
+
+	AuditBreakGlassLogin  = "break_glass.login"
+	AuditBreakGlassFailed = "break_glass.failed"
+
+	AuditImpersonationStarted = "impersonation.started"
+
+	AuditRoleGrantCreated = "role_grant.created"
+	AuditRoleGrantRevoked = "role_grant.revoked"
+	AuditRoleGrantExpired = "role_grant.expired"
+
+	AuditSessionRevoked = "session.revoked"
+)
+
+// AuditEvent is an entry in the audit trail of security-relevant actions
+type AuditEvent struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	// Actor is the user who performed the action, and Subject the user it
+	// affected when that is someone else
+	Actor   string            `json:"actor"`
+	Subject string            `json:"subject,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Involves reports whether the user performed or was affected by the action
+func (e *AuditEvent) Involves(userID string) bool {
+	return e.Actor == userID || e.Subject == userID
+}
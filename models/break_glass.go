@@ -0,0 +1,28 @@
+package models
+
+// BreakGlassProvider is the provider of users logged in with an emergency
+// account
+const BreakGlassProvider = "break-glass"
+
+// BreakGlassAccount is a pre-provisioned emergency account for logging in
+// when the identity provider is unavailable
+type BreakGlassAccount struct {
+	Username string   `json:"username"`
+	Name     string   `json:"name,omitempty"`
+	Roles    []string `json:"roles"`
+
+	// PasswordHash is an Argon2id hash in the PHC string format
+	PasswordHash string `json:"password_hash"`
+	// TOTPSecret, when set, requires a one-time code with the password
+	TOTPSecret string `json:"totp_secret,omitempty"`
+}
+
+// User returns the account as an authenticated user
+func (a *BreakGlassAccount) User() *User {
+	return &User{
+		ID:       BreakGlassProvider + ":" + a.Username,
+		Name:     a.Name,
+		Provider: BreakGlassProvider,
+		Roles:    append([]string(nil), a.Roles...),
+	}
+}
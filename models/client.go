@@ -0,0 +1,126 @@
+package models
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// Client represents an application registered with the gateway's
+// authorization server
+type Client struct {
+	ID           string   `json:"client_id"`
+	SecretHash   string   `json:"client_secret_hash,omitempty"`
+	Name         string   `json:"client_name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	GrantTypes   []string `json:"grant_types"`
+	Scopes       []string `json:"scopes"`
+
+	// Audiences the client may request tokens for through token exchange
+	Audiences []string `json:"audiences,omitempty"`
+	// Roles the client may pass on in exchanged tokens; the user's other
+	// roles are dropped
+	DelegatedRoles []string `json:"delegated_roles,omitempty"`
+
+	// Token lifetimes in seconds; zero means the gateway default
+	AccessTokenLifetime int `json:"access_token_lifetime,omitempty"`
+	IDTokenLifetime     int `json:"id_token_lifetime,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Public reports whether the client has no secret and must use PKCE
+func (c *Client) Public() bool {
+	return c.SecretHash == ""
+}
+
+// SetSecret stores a hash of the client secret
+func (c *Client) SetSecret(secret string) {
+	if secret == "" {
+		c.SecretHash = ""
+		return
+	}
+	sum := sha256.Sum256([]byte(secret))
+	c.SecretHash = hex.EncodeToString(sum[:])
+}
+
+// VerifySecret checks a presented secret against the stored hash
+func (c *Client) VerifySecret(secret string) bool {
+	if c.Public() {
+		return false
+	}
+	sum := sha256.Sum256([]byte(secret))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(c.SecretHash)) == 1
+}
+
+// HasRedirectURI checks if the redirect URI exactly matches a registered one
+func (c *Client) HasRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsGrantType checks if the client may use the given grant type.
+// An empty grant type list allows the authorization code grant only.
+func (c *Client) AllowsGrantType(grantType string) bool {
+	allowed := c.GrantTypes
+	if len(allowed) == 0 {
+		allowed = []string{"authorization_code"}
+	}
+	for _, g := range allowed {
+		if g == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAudience checks if the client may obtain tokens for the audience
+func (c *Client) AllowsAudience(audience string) bool {
+	for _, a := range c.Audiences {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// DelegatableRoles returns the roles that the client may pass on in
+// exchanged tokens
+func (c *Client) DelegatableRoles(roles []string) []string {
+	var allowed []string
+	for _, role := range roles {
+		for _, d := range c.DelegatedRoles {
+			if d == role {
+				allowed = append(allowed, role)
+				break
+			}
+		}
+	}
+	return allowed
+}
+
+// AllowsScope checks if the client may request the given scope.
+// An empty scope list allows the standard OIDC scopes only.
+func (c *Client) AllowsScope(scope string) bool {
+	allowed := c.Scopes
+	if len(allowed) == 0 {
+		allowed = []string{"openid", "profile", "email"}
+	}
+	for _, s := range allowed {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScopes splits a space-delimited OAuth scope string
+func ParseScopes(scope string) []string {
+	return strings.Fields(scope)
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// ElevationStatus is the state of a role elevation request
+type ElevationStatus string
+
+const (
+	ElevationPending  ElevationStatus = "pending"
+	ElevationApproved ElevationStatus = "approved"
+	ElevationDenied   ElevationStatus = "denied"
+)
+
+// Elevation is a user's request to hold a role for a limited time. Once
+// approved, the role is added to tokens issued to the user until ExpiresAt.
+type Elevation struct {
+	ID              string          `json:"id"`
+	UserID          string          `json:"user_id"`
+	Email           string          `json:"email,omitempty"`
+	Role            string          `json:"role"`
+	DurationSeconds int             `json:"duration_seconds"`
+	Justification   string          `json:"justification"`
+	Status          ElevationStatus `json:"status"`
+	RequestedAt     time.Time       `json:"requested_at"`
+
+	// Set by the approver's decision; the elevated role is held from the
+	// approval for the requested duration
+	DecidedBy      string    `json:"decided_by,omitempty"`
+	DecidedAt      time.Time `json:"decided_at,omitempty"`
+	DecisionReason string    `json:"decision_reason,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at,omitempty"`
+}
+
+// Active reports whether the elevation is approved and not yet expired
+func (e *Elevation) Active(now time.Time) bool {
+	return e.Status == ElevationApproved && now.Before(e.ExpiresAt)
+}
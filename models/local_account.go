@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// LocalAccount is a username/password account kept by the gateway for
+// users without an IdP account
+type LocalAccount struct {
+	ID       string   `json:"id"`
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+	Roles    []string `json:"roles"`
+
+	// PasswordHash is an Argon2id hash in the PHC string format
+	PasswordHash      string    `json:"password_hash"`
+	PasswordChangedAt time.Time `json:"password_changed_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by,omitempty"` // the admin who sent the invite
+}
+
+// User returns the account as an authenticated user
+func (a *LocalAccount) User() *User {
+	return &User{
+		ID:       a.ID,
+		Email:    a.Email,
+		Name:     a.Name,
+		Provider: "local",
+		Roles:    append([]string(nil), a.Roles...),
+		Created:  a.CreatedAt,
+	}
+}
+
+// Kinds of one-time local account tokens
+const (
+	LocalTokenInvite        = "invite"
+	LocalTokenPasswordReset = "password_reset"
+)
+
+// LocalToken is a single-use invite or password reset token. Only the
+// SHA-256 hash of the token is stored.
+type LocalToken struct {
+	Hash string `json:"hash"`
+	Kind string `json:"kind"`
+
+	// AccountID is the account a password reset is for
+	AccountID string `json:"account_id,omitempty"`
+
+	// Email and Roles are what an invite grants
+	Email string   `json:"email,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+
+	CreatedBy string    `json:"created_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
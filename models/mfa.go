@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// MFAEnrollment is a user's TOTP second factor
+type MFAEnrollment struct {
+	UserID string `json:"user_id"`
+	Secret string `json:"secret"`
+
+	// Confirmed is set once the user proves their authenticator app works;
+	// unconfirmed enrollments are not challenged at login
+	Confirmed bool `json:"confirmed"`
+
+	// SHA-256 hashes of unused recovery codes
+	RecoveryCodeHashes []string `json:"recovery_code_hashes,omitempty"`
+
+	// LastUsedStep is the TOTP time step of the last accepted code, so a
+	// code cannot be replayed
+	LastUsedStep int64 `json:"last_used_step,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
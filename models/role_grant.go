@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// RoleGrant gives a user a role for a validity window. A zero NotBefore or
+// NotAfter leaves that side of the window open. ReplaceRoles makes the
+// user's grants their only roles, instead of adding to the login's roles.
+type RoleGrant struct {
+	ID           string    `json:"id"`
+	UserID       string    `json:"user_id"`
+	Role         string    `json:"role"`
+	NotBefore    time.Time `json:"not_before,omitempty"`
+	NotAfter     time.Time `json:"not_after,omitempty"`
+	ReplaceRoles bool      `json:"replace_roles,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	GrantedBy    string    `json:"granted_by"`
+	GrantedAt    time.Time `json:"granted_at"`
+}
+
+// ActiveAt reports whether the grant is in force at t
+func (g *RoleGrant) ActiveAt(t time.Time) bool {
+	return (g.NotBefore.IsZero() || !t.Before(g.NotBefore)) && !g.ExpiredAt(t)
+}
+
+// ExpiredAt reports whether the grant has ended by t
+func (g *RoleGrant) ExpiredAt(t time.Time) bool {
+	return !g.NotAfter.IsZero() && !t.Before(g.NotAfter)
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Session represents a login of a user on one device or browser
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	Provider   string    `json:"provider"`
+	IPAddress  string    `json:"ip_address"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+
+	// Limits fixed when the session is created; zero means no limit
+	ExpiresAt          time.Time `json:"expires_at,omitempty"`
+	IdleTimeoutSeconds int       `json:"idle_timeout_seconds,omitempty"`
+
+	// ID token from the upstream provider login, sent as id_token_hint
+	// when ending the provider session on logout
+	UpstreamIDToken string `json:"upstream_id_token,omitempty"`
+
+	// Provider subject and session ID from that ID token, matched against
+	// provider logout notifications
+	UpstreamSubject   string `json:"upstream_sub,omitempty"`
+	UpstreamSessionID string `json:"upstream_sid,omitempty"`
+}
+
+// Expired reports whether the session passed its absolute lifetime or has
+// been idle for longer than its idle timeout
+func (s *Session) Expired(now time.Time) bool {
+	if !s.ExpiresAt.IsZero() && now.After(s.ExpiresAt) {
+		return true
+	}
+	idle := time.Duration(s.IdleTimeoutSeconds) * time.Second
+	return idle > 0 && now.Sub(s.LastSeenAt) > idle
+}
@@ -11,6 +11,20 @@ type User struct {
 	Provider string    `json:"provider"`
 	Roles    []string  `json:"roles"`
 	Created  time.Time `json:"created"`
+
+	// EmailVerified is set when the provider vouches that the user controls
+	// the email address
+	EmailVerified bool `json:"email_verified,omitempty"`
+
+	// Actor is who really makes requests with a token issued to someone
+	// acting as this user, such as an impersonating admin or a service that
+	// exchanged the user's token. It is the subject of the act claim.
+	Actor string `json:"actor,omitempty"`
+
+	// RolesExpireAt is when the first time-bound role in Roles ends, such
+	// as a role grant or elevation. Tokens for the user must not outlive
+	// it. Zero means every role is permanent.
+	RolesExpireAt time.Time `json:"-"`
 }
 
 // Role represents a role in the RBAC system
@@ -72,6 +86,22 @@ func (u *User) HasPermission(resource, action string) bool {
 	return false
 }
 
+// AddTimedRole adds a role the user holds until expiresAt, which may be zero
+// for no end. A role the user already has is not added again and leaves
+// RolesExpireAt alone, so it does not cut tokens short. It reports whether
+// the role was added.
+func (u *User) AddTimedRole(role string, expiresAt time.Time) bool {
+	if u.HasRole(role) {
+		return false
+	}
+	// Clip so the caller's Roles slice is never written to
+	u.Roles = append(u.Roles[:len(u.Roles):len(u.Roles)], role)
+	if !expiresAt.IsZero() && (u.RolesExpireAt.IsZero() || expiresAt.Before(u.RolesExpireAt)) {
+		u.RolesExpireAt = expiresAt
+	}
+	return true
+}
+
 // HasRole checks if a user has a specific role
 func (u *User) HasRole(role string) bool {
 	for _, r := range u.Roles {
@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// WebAuthnAccount holds a user's WebAuthn credentials (security keys and
+// passkeys) along with what is needed to log the user in with a passkey
+type WebAuthnAccount struct {
+	UserID string `json:"user_id"`
+
+	// Handle is the random, opaque user handle given to authenticators;
+	// passkeys return it to identify the account at login
+	Handle []byte `json:"handle"`
+
+	// Provider is the provider of the user's last login before registering
+	// or using a credential. Passkey logins never reach the provider, so
+	// they look the user up by it.
+	Provider string `json:"provider"`
+
+	// Profile is the user as their provider returned them at their last
+	// provider login, before role grants and elevations are added. Passkey
+	// logins of IdP, LDAP and SAML users take their email, name and roles
+	// from it, since the gateway keeps no other record of them.
+	Profile *User `json:"profile,omitempty"`
+
+	Credentials []WebAuthnCredential `json:"credentials"`
+}
+
+// WebAuthnCredential is a registered public key credential
+type WebAuthnCredential struct {
+	ID              []byte   `json:"id"`
+	Name            string   `json:"name"`
+	PublicKey       []byte   `json:"public_key"`
+	AttestationType string   `json:"attestation_type"`
+	Transports      []string `json:"transports,omitempty"`
+	AAGUID          []byte   `json:"aaguid,omitempty"`
+	SignCount       uint32   `json:"sign_count"`
+	CloneWarning    bool     `json:"clone_warning,omitempty"`
+
+	UserPresent    bool `json:"user_present"`
+	UserVerified   bool `json:"user_verified"`
+	BackupEligible bool `json:"backup_eligible"`
+	BackupState    bool `json:"backup_state"`
+
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at,omitempty"`
+}
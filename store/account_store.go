@@ -0,0 +1,188 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+// ErrIdentityLinked is returned when saving an account with an identity
+// that is linked to another account
+var ErrIdentityLinked = errors.New("identity is linked to another account")
+
+// AccountStore persists canonical accounts and their linked identities
+type AccountStore interface {
+	Get(id string) (*models.Account, error)
+	GetByIdentity(provider, subject string) (*models.Account, error)
+	// ListByEmail returns the accounts with an identity having the email
+	// address, compared case-insensitively
+	ListByEmail(email string) ([]*models.Account, error)
+	// Save creates or replaces an account. An identity may be linked to
+	// only one account.
+	Save(account *models.Account) error
+}
+
+// MemoryAccountStore is an in-memory AccountStore
+type MemoryAccountStore struct {
+	mu       sync.RWMutex
+	accounts map[string]*models.Account
+}
+
+// NewMemoryAccountStore creates an empty in-memory account store
+func NewMemoryAccountStore() *MemoryAccountStore {
+	return &MemoryAccountStore{
+		accounts: make(map[string]*models.Account),
+	}
+}
+
+// Get returns an account by ID
+func (s *MemoryAccountStore) Get(id string) (*models.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, ok := s.accounts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyAccount(account), nil
+}
+
+// GetByIdentity returns the account a provider identity is linked to
+func (s *MemoryAccountStore) GetByIdentity(provider, subject string) (*models.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, account := range s.accounts {
+		if account.Identity(provider, subject) >= 0 {
+			return copyAccount(account), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ListByEmail returns the accounts with an identity having the email address
+func (s *MemoryAccountStore) ListByEmail(email string) ([]*models.Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var accounts []*models.Account
+	for _, account := range s.accounts {
+		for _, identity := range account.Identities {
+			if strings.EqualFold(identity.Email, email) {
+				accounts = append(accounts, copyAccount(account))
+				break
+			}
+		}
+	}
+	return accounts, nil
+}
+
+// Save creates or replaces an account
+func (s *MemoryAccountStore) Save(account *models.Account) error {
+	if account.ID == "" {
+		return fmt.Errorf("account ID is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.accounts {
+		if existing.ID == account.ID {
+			continue
+		}
+		for _, identity := range account.Identities {
+			if existing.Identity(identity.Provider, identity.Subject) >= 0 {
+				return ErrIdentityLinked
+			}
+		}
+	}
+	s.accounts[account.ID] = copyAccount(account)
+	return nil
+}
+
+func copyAccount(account *models.Account) *models.Account {
+	copied := *account
+	copied.Identities = append([]models.LinkedIdentity(nil), account.Identities...)
+	return &copied
+}
+
+// FileAccountStore is an AccountStore persisted as a JSON file
+type FileAccountStore struct {
+	path string
+
+	mu     sync.Mutex
+	memory *MemoryAccountStore
+}
+
+// NewFileAccountStore opens the account file at path, creating it on the
+// first write if it does not exist
+func NewFileAccountStore(path string) (*FileAccountStore, error) {
+	s := &FileAccountStore{
+		path:   path,
+		memory: NewMemoryAccountStore(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account file: %w", err)
+	}
+
+	var accounts []*models.Account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("failed to decode account file: %w", err)
+	}
+	for _, account := range accounts {
+		s.memory.accounts[account.ID] = account
+	}
+	return s, nil
+}
+
+// Get returns an account by ID
+func (s *FileAccountStore) Get(id string) (*models.Account, error) {
+	return s.memory.Get(id)
+}
+
+// GetByIdentity returns the account a provider identity is linked to
+func (s *FileAccountStore) GetByIdentity(provider, subject string) (*models.Account, error) {
+	return s.memory.GetByIdentity(provider, subject)
+}
+
+// ListByEmail returns the accounts with an identity having the email address
+func (s *FileAccountStore) ListByEmail(email string) ([]*models.Account, error) {
+	return s.memory.ListByEmail(email)
+}
+
+// Save creates or replaces an account
+func (s *FileAccountStore) Save(account *models.Account) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Save(account); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// flushLocked atomically rewrites the account file; s.mu must be held
+func (s *FileAccountStore) flushLocked() error {
+	s.memory.mu.RLock()
+	accounts := make([]*models.Account, 0, len(s.memory.accounts))
+	for _, account := range s.memory.accounts {
+		accounts = append(accounts, account)
+	}
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	s.memory.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode accounts: %w", err)
+	}
+
+	return writeFileAtomic(s.path, data)
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+// AuditStore persists the audit trail. Events are only ever appended.
+type AuditStore interface {
+	Append(event *models.AuditEvent) error
+	// List returns all events, oldest first
+	List() ([]*models.AuditEvent, error)
+}
+
+// MemoryAuditStore is an in-memory AuditStore
+type MemoryAuditStore struct {
+	mu     sync.RWMutex
+	events []*models.AuditEvent
+}
+
+// NewMemoryAuditStore creates an empty in-memory audit store
+func NewMemoryAuditStore() *MemoryAuditStore {
+	return &MemoryAuditStore{}
+}
+
+// Append adds an event to the end of the trail
+func (s *MemoryAuditStore) Append(event *models.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, copyAuditEvent(event))
+	return nil
+}
+
+// List returns all events, oldest first
+func (s *MemoryAuditStore) List() ([]*models.AuditEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]*models.AuditEvent, 0, len(s.events))
+	for _, event := range s.events {
+		events = append(events, copyAuditEvent(event))
+	}
+	return events, nil
+}
+
+func copyAuditEvent(event *models.AuditEvent) *models.AuditEvent {
+	copied := *event
+	if event.Details != nil {
+		copied.Details = make(map[string]string, len(event.Details))
+		for k, v := range event.Details {
+			copied.Details[k] = v
+		}
+	}
+	return &copied
+}
+
+// FileAuditStore is an AuditStore persisted as a file of JSON lines. Unlike
+// the other file stores it is appended to rather than rewritten, so earlier
+// events are never lost to a failed write.
+type FileAuditStore struct {
+	mu     sync.Mutex
+	file   *os.File
+	memory *MemoryAuditStore
+}
+
+// NewFileAuditStore opens the audit file at path, creating it if it does
+// not exist
+func NewFileAuditStore(path string) (*FileAuditStore, error) {
+	s := &FileAuditStore{memory: NewMemoryAuditStore()}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read audit file: %w", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var event models.AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return nil, fmt.Errorf("failed to decode audit file line %d: %w", line, err)
+		}
+		s.memory.events = append(s.memory.events, &event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit file: %w", err)
+	}
+
+	s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	return s, nil
+}
+
+// Append writes an event to the end of the audit file
+func (s *FileAuditStore) Append(event *models.AuditEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit file: %w", err)
+	}
+	return s.memory.Append(event)
+}
+
+// List returns all events, oldest first
+func (s *FileAuditStore) List() ([]*models.AuditEvent, error) {
+	return s.memory.List()
+}
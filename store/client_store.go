@@ -0,0 +1,203 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+// ErrNotFound is returned when a record does not exist in a store
+var ErrNotFound = errors.New("not found")
+
+// ClientStore persists OAuth clients registered with the gateway
+type ClientStore interface {
+	Get(id string) (*models.Client, error)
+	List() ([]*models.Client, error)
+	Save(client *models.Client) error
+	Delete(id string) error
+}
+
+// MemoryClientStore is an in-memory ClientStore
+type MemoryClientStore struct {
+	mu      sync.RWMutex
+	clients map[string]*models.Client
+}
+
+// NewMemoryClientStore creates an empty in-memory client store
+func NewMemoryClientStore() *MemoryClientStore {
+	return &MemoryClientStore{
+		clients: make(map[string]*models.Client),
+	}
+}
+
+// Get returns the client with the given ID
+func (s *MemoryClientStore) Get(id string) (*models.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	client, ok := s.clients[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *client
+	return &copied, nil
+}
+
+// List returns all clients ordered by ID
+func (s *MemoryClientStore) List() ([]*models.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	clients := make([]*models.Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		copied := *client
+		clients = append(clients, &copied)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID < clients[j].ID
+	})
+	return clients, nil
+}
+
+// Save creates or replaces a client
+func (s *MemoryClientStore) Save(client *models.Client) error {
+	if client.ID == "" {
+		return fmt.Errorf("client ID is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *client
+	s.clients[client.ID] = &copied
+	return nil
+}
+
+// Delete removes a client
+func (s *MemoryClientStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.clients[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.clients, id)
+	return nil
+}
+
+// FileClientStore is a ClientStore persisted as a JSON file, so registered
+// clients and their secret hashes survive restarts
+type FileClientStore struct {
+	path string
+
+	mu     sync.Mutex
+	memory *MemoryClientStore
+}
+
+// NewFileClientStore opens the client store file at path, creating it on
+// the first write if it does not exist
+func NewFileClientStore(path string) (*FileClientStore, error) {
+	s := &FileClientStore{
+		path:   path,
+		memory: NewMemoryClientStore(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client store file: %w", err)
+	}
+
+	var clients []*models.Client
+	if err := json.Unmarshal(data, &clients); err != nil {
+		return nil, fmt.Errorf("failed to decode client store file: %w", err)
+	}
+	for _, client := range clients {
+		s.memory.clients[client.ID] = client
+	}
+	return s, nil
+}
+
+// Get returns the client with the given ID
+func (s *FileClientStore) Get(id string) (*models.Client, error) {
+	return s.memory.Get(id)
+}
+
+// List returns all clients ordered by ID
+func (s *FileClientStore) List() ([]*models.Client, error) {
+	return s.memory.List()
+}
+
+// Save creates or replaces a client
+func (s *FileClientStore) Save(client *models.Client) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Save(client); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// Delete removes a client
+func (s *FileClientStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Delete(id); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// flushLocked atomically rewrites the client store file; s.mu must be held
+func (s *FileClientStore) flushLocked() error {
+	clients, _ := s.memory.List()
+	data, err := json.MarshalIndent(clients, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode clients: %w", err)
+	}
+
+	return writeFileAtomic(s.path, data)
+}
+
+// clientFileEntry is a client as written in the clients file, where the
+// secret is given in plain text and hashed on load
+type clientFileEntry struct {
+	models.Client
+	Secret string `json:"client_secret"`
+}
+
+// LoadClients reads a JSON array of clients from path into the store
+func LoadClients(s ClientStore, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read clients file: %w", err)
+	}
+
+	var entries []*clientFileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to decode clients file: %w", err)
+	}
+
+	for _, entry := range entries {
+		client := entry.Client
+		if client.AllowsGrantType("authorization_code") && len(client.RedirectURIs) == 0 {
+			return fmt.Errorf("client %s has no redirect URIs", client.ID)
+		}
+		if entry.Secret != "" {
+			client.SetSecret(entry.Secret)
+		}
+		if err := s.Save(&client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+func TestFileClientStore_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients.json")
+
+	s, err := NewFileClientStore(path)
+	if err != nil {
+		t.Fatalf("NewFileClientStore() error = %v", err)
+	}
+
+	registered := &models.Client{ID: "registered", Name: "Registered", RedirectURIs: []string{"https://app.example.com/cb"}}
+	registered.SetSecret("s3cret")
+	for _, client := range []*models.Client{registered, {ID: "deleted"}} {
+		if err := s.Save(client); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	if err := s.Delete("deleted"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	// Reopen the file to check what was persisted
+	reopened, err := NewFileClientStore(path)
+	if err != nil {
+		t.Fatalf("NewFileClientStore() reopen error = %v", err)
+	}
+
+	client, err := reopened.Get("registered")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if !client.VerifySecret("s3cret") || !client.HasRedirectURI("https://app.example.com/cb") {
+		t.Errorf("Get() = %+v, want the registered client with its secret", client)
+	}
+	if _, err := reopened.Get("deleted"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() deleted client error = %v, want ErrNotFound", err)
+	}
+}
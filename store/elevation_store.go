@@ -0,0 +1,171 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+// ElevationStore persists role elevation requests
+type ElevationStore interface {
+	Get(id string) (*models.Elevation, error)
+	// ListByUser returns the user's requests, newest first
+	ListByUser(userID string) ([]*models.Elevation, error)
+	// ListByStatus returns the requests with the status, oldest first
+	ListByStatus(status models.ElevationStatus) ([]*models.Elevation, error)
+	// Save creates or replaces a request
+	Save(elevation *models.Elevation) error
+}
+
+// MemoryElevationStore is an in-memory ElevationStore
+type MemoryElevationStore struct {
+	mu         sync.RWMutex
+	elevations map[string]*models.Elevation
+}
+
+// NewMemoryElevationStore creates an empty in-memory elevation store
+func NewMemoryElevationStore() *MemoryElevationStore {
+	return &MemoryElevationStore{
+		elevations: make(map[string]*models.Elevation),
+	}
+}
+
+// Get returns a request by ID
+func (s *MemoryElevationStore) Get(id string) (*models.Elevation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elevation, ok := s.elevations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *elevation
+	return &copied, nil
+}
+
+// ListByUser returns the user's requests, newest first
+func (s *MemoryElevationStore) ListByUser(userID string) ([]*models.Elevation, error) {
+	elevations := s.list(func(e *models.Elevation) bool { return e.UserID == userID })
+	sort.Slice(elevations, func(i, j int) bool {
+		return elevations[i].RequestedAt.After(elevations[j].RequestedAt)
+	})
+	return elevations, nil
+}
+
+// ListByStatus returns the requests with the status, oldest first
+func (s *MemoryElevationStore) ListByStatus(status models.ElevationStatus) ([]*models.Elevation, error) {
+	elevations := s.list(func(e *models.Elevation) bool { return e.Status == status })
+	sort.Slice(elevations, func(i, j int) bool {
+		return elevations[i].RequestedAt.Before(elevations[j].RequestedAt)
+	})
+	return elevations, nil
+}
+
+func (s *MemoryElevationStore) list(match func(*models.Elevation) bool) []*models.Elevation {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var elevations []*models.Elevation
+	for _, elevation := range s.elevations {
+		if match(elevation) {
+			copied := *elevation
+			elevations = append(elevations, &copied)
+		}
+	}
+	return elevations
+}
+
+// Save creates or replaces a request
+func (s *MemoryElevationStore) Save(elevation *models.Elevation) error {
+	if elevation.ID == "" {
+		return fmt.Errorf("elevation ID is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *elevation
+	s.elevations[elevation.ID] = &copied
+	return nil
+}
+
+// FileElevationStore is an ElevationStore persisted as a JSON file
+type FileElevationStore struct {
+	path string
+
+	mu     sync.Mutex
+	memory *MemoryElevationStore
+}
+
+// NewFileElevationStore opens the elevation file at path, creating it on
+// the first write if it does not exist
+func NewFileElevationStore(path string) (*FileElevationStore, error) {
+	s := &FileElevationStore{
+		path:   path,
+		memory: NewMemoryElevationStore(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read elevation file: %w", err)
+	}
+
+	var elevations []*models.Elevation
+	if err := json.Unmarshal(data, &elevations); err != nil {
+		return nil, fmt.Errorf("failed to decode elevation file: %w", err)
+	}
+	for _, elevation := range elevations {
+		s.memory.elevations[elevation.ID] = elevation
+	}
+	return s, nil
+}
+
+// Get returns a request by ID
+func (s *FileElevationStore) Get(id string) (*models.Elevation, error) {
+	return s.memory.Get(id)
+}
+
+// ListByUser returns the user's requests, newest first
+func (s *FileElevationStore) ListByUser(userID string) ([]*models.Elevation, error) {
+	return s.memory.ListByUser(userID)
+}
+
+// ListByStatus returns the requests with the status, oldest first
+func (s *FileElevationStore) ListByStatus(status models.ElevationStatus) ([]*models.Elevation, error) {
+	return s.memory.ListByStatus(status)
+}
+
+// Save creates or replaces a request
+func (s *FileElevationStore) Save(elevation *models.Elevation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Save(elevation); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// flushLocked atomically rewrites the elevation file; s.mu must be held
+func (s *FileElevationStore) flushLocked() error {
+	s.memory.mu.RLock()
+	elevations := make([]*models.Elevation, 0, len(s.memory.elevations))
+	for _, elevation := range s.memory.elevations {
+		elevations = append(elevations, elevation)
+	}
+	data, err := json.MarshalIndent(elevations, "", "  ")
+	s.memory.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode elevations: %w", err)
+	}
+
+	return writeFileAtomic(s.path, data)
+}
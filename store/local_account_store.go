@@ -0,0 +1,274 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+// ErrUsernameTaken is returned when saving an account whose username
+// belongs to another account
+var ErrUsernameTaken = errors.New("username already taken")
+
+// LocalAccountStore persists local accounts and their one-time tokens
+type LocalAccountStore interface {
+	Get(id string) (*models.LocalAccount, error)
+	GetByUsername(username string) (*models.LocalAccount, error)
+	List() ([]*models.LocalAccount, error)
+	Save(account *models.LocalAccount) error
+	Delete(id string) error
+
+	SaveToken(token *models.LocalToken) error
+	// TakeToken returns and removes a token, so it can be used only once
+	TakeToken(hash string) (*models.LocalToken, error)
+}
+
+// MemoryLocalAccountStore is an in-memory LocalAccountStore
+type MemoryLocalAccountStore struct {
+	mu       sync.RWMutex
+	accounts map[string]*models.LocalAccount
+	tokens   map[string]*models.LocalToken
+}
+
+// NewMemoryLocalAccountStore creates an empty in-memory local account store
+func NewMemoryLocalAccountStore() *MemoryLocalAccountStore {
+	return &MemoryLocalAccountStore{
+		accounts: make(map[string]*models.LocalAccount),
+		tokens:   make(map[string]*models.LocalToken),
+	}
+}
+
+// Get returns an account by ID
+func (s *MemoryLocalAccountStore) Get(id string) (*models.LocalAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, ok := s.accounts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyLocalAccount(account), nil
+}
+
+// GetByUsername returns an account by username
+func (s *MemoryLocalAccountStore) GetByUsername(username string) (*models.LocalAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, account := range s.accounts {
+		if account.Username == username {
+			return copyLocalAccount(account), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// List returns all accounts
+func (s *MemoryLocalAccountStore) List() ([]*models.LocalAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := make([]*models.LocalAccount, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		accounts = append(accounts, copyLocalAccount(account))
+	}
+	return accounts, nil
+}
+
+// Save creates or replaces an account. Usernames are unique.
+func (s *MemoryLocalAccountStore) Save(account *models.LocalAccount) error {
+	if account.ID == "" || account.Username == "" {
+		return fmt.Errorf("account ID and username are required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.accounts {
+		if existing.Username == account.Username && existing.ID != account.ID {
+			return ErrUsernameTaken
+		}
+	}
+	s.accounts[account.ID] = copyLocalAccount(account)
+	return nil
+}
+
+// Delete removes an account and its password reset tokens
+func (s *MemoryLocalAccountStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.accounts, id)
+	for hash, token := range s.tokens {
+		if token.AccountID == id {
+			delete(s.tokens, hash)
+		}
+	}
+	return nil
+}
+
+// SaveToken stores a one-time token
+func (s *MemoryLocalAccountStore) SaveToken(token *models.LocalToken) error {
+	if token.Hash == "" {
+		return fmt.Errorf("token hash is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *token
+	copied.Roles = append([]string(nil), token.Roles...)
+	s.tokens[token.Hash] = &copied
+	return nil
+}
+
+// TakeToken returns and removes a one-time token
+func (s *MemoryLocalAccountStore) TakeToken(hash string) (*models.LocalToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(s.tokens, hash)
+	return token, nil
+}
+
+func copyLocalAccount(account *models.LocalAccount) *models.LocalAccount {
+	copied := *account
+	copied.Roles = append([]string(nil), account.Roles...)
+	return &copied
+}
+
+// FileLocalAccountStore is a LocalAccountStore persisted as a JSON file
+type FileLocalAccountStore struct {
+	path string
+
+	mu     sync.Mutex
+	memory *MemoryLocalAccountStore
+}
+
+// localAccountFile is the on-disk layout of a FileLocalAccountStore
+type localAccountFile struct {
+	Accounts []*models.LocalAccount `json:"accounts"`
+	Tokens   []*models.LocalToken   `json:"tokens"`
+}
+
+// NewFileLocalAccountStore opens the account file at path, creating it on
+// the first write if it does not exist
+func NewFileLocalAccountStore(path string) (*FileLocalAccountStore, error) {
+	s := &FileLocalAccountStore{
+		path:   path,
+		memory: NewMemoryLocalAccountStore(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local account file: %w", err)
+	}
+
+	var file localAccountFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to decode local account file: %w", err)
+	}
+	for _, account := range file.Accounts {
+		s.memory.accounts[account.ID] = account
+	}
+	for _, token := range file.Tokens {
+		s.memory.tokens[token.Hash] = token
+	}
+	return s, nil
+}
+
+// Get returns an account by ID
+func (s *FileLocalAccountStore) Get(id string) (*models.LocalAccount, error) {
+	return s.memory.Get(id)
+}
+
+// GetByUsername returns an account by username
+func (s *FileLocalAccountStore) GetByUsername(username string) (*models.LocalAccount, error) {
+	return s.memory.GetByUsername(username)
+}
+
+// List returns all accounts
+func (s *FileLocalAccountStore) List() ([]*models.LocalAccount, error) {
+	return s.memory.List()
+}
+
+// Save creates or replaces an account
+func (s *FileLocalAccountStore) Save(account *models.LocalAccount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Save(account); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// Delete removes an account and its password reset tokens
+func (s *FileLocalAccountStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Delete(id); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// SaveToken stores a one-time token
+func (s *FileLocalAccountStore) SaveToken(token *models.LocalToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.SaveToken(token); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// TakeToken returns and removes a one-time token
+func (s *FileLocalAccountStore) TakeToken(hash string) (*models.LocalToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.memory.TakeToken(hash)
+	if err != nil {
+		return nil, err
+	}
+	return token, s.flushLocked()
+}
+
+// flushLocked atomically rewrites the account file; s.mu must be held
+func (s *FileLocalAccountStore) flushLocked() error {
+	s.memory.mu.RLock()
+	file := localAccountFile{
+		Accounts: make([]*models.LocalAccount, 0, len(s.memory.accounts)),
+		Tokens:   make([]*models.LocalToken, 0, len(s.memory.tokens)),
+	}
+	for _, account := range s.memory.accounts {
+		file.Accounts = append(file.Accounts, account)
+	}
+	for _, token := range s.memory.tokens {
+		file.Tokens = append(file.Tokens, token)
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	s.memory.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode local accounts: %w", err)
+	}
+
+	return writeFileAtomic(s.path, data)
+}
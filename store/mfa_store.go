@@ -0,0 +1,151 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+// MFAStore persists users' second-factor enrollments
+type MFAStore interface {
+	Get(userID string) (*models.MFAEnrollment, error)
+	Save(enrollment *models.MFAEnrollment) error
+	Delete(userID string) error
+}
+
+// MemoryMFAStore is an in-memory MFAStore
+type MemoryMFAStore struct {
+	mu          sync.RWMutex
+	enrollments map[string]*models.MFAEnrollment
+}
+
+// NewMemoryMFAStore creates an empty in-memory MFA store
+func NewMemoryMFAStore() *MemoryMFAStore {
+	return &MemoryMFAStore{
+		enrollments: make(map[string]*models.MFAEnrollment),
+	}
+}
+
+// Get returns the enrollment of a user
+func (s *MemoryMFAStore) Get(userID string) (*models.MFAEnrollment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	enrollment, ok := s.enrollments[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyEnrollment(enrollment), nil
+}
+
+// Save creates or replaces the enrollment of a user
+func (s *MemoryMFAStore) Save(enrollment *models.MFAEnrollment) error {
+	if enrollment.UserID == "" {
+		return fmt.Errorf("user ID is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.enrollments[enrollment.UserID] = copyEnrollment(enrollment)
+	return nil
+}
+
+// Delete removes the enrollment of a user
+func (s *MemoryMFAStore) Delete(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.enrollments[userID]; !ok {
+		return ErrNotFound
+	}
+	delete(s.enrollments, userID)
+	return nil
+}
+
+func copyEnrollment(enrollment *models.MFAEnrollment) *models.MFAEnrollment {
+	copied := *enrollment
+	copied.RecoveryCodeHashes = append([]string(nil), enrollment.RecoveryCodeHashes...)
+	return &copied
+}
+
+// FileMFAStore is an MFAStore persisted as a JSON file
+type FileMFAStore struct {
+	path string
+
+	mu     sync.Mutex
+	memory *MemoryMFAStore
+}
+
+// NewFileMFAStore opens the enrollment file at path, creating it on the
+// first write if it does not exist
+func NewFileMFAStore(path string) (*FileMFAStore, error) {
+	s := &FileMFAStore{
+		path:   path,
+		memory: NewMemoryMFAStore(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MFA file: %w", err)
+	}
+
+	var enrollments []*models.MFAEnrollment
+	if err := json.Unmarshal(data, &enrollments); err != nil {
+		return nil, fmt.Errorf("failed to decode MFA file: %w", err)
+	}
+	for _, enrollment := range enrollments {
+		s.memory.enrollments[enrollment.UserID] = enrollment
+	}
+	return s, nil
+}
+
+// Get returns the enrollment of a user
+func (s *FileMFAStore) Get(userID string) (*models.MFAEnrollment, error) {
+	return s.memory.Get(userID)
+}
+
+// Save creates or replaces the enrollment of a user
+func (s *FileMFAStore) Save(enrollment *models.MFAEnrollment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Save(enrollment); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// Delete removes the enrollment of a user
+func (s *FileMFAStore) Delete(userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Delete(userID); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// flushLocked atomically rewrites the enrollment file; s.mu must be held
+func (s *FileMFAStore) flushLocked() error {
+	s.memory.mu.RLock()
+	enrollments := make([]*models.MFAEnrollment, 0, len(s.memory.enrollments))
+	for _, enrollment := range s.memory.enrollments {
+		enrollments = append(enrollments, enrollment)
+	}
+	data, err := json.MarshalIndent(enrollments, "", "  ")
+	s.memory.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode MFA enrollments: %w", err)
+	}
+
+	return writeFileAtomic(s.path, data)
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+// RoleGrantStore persists time-bound role grants
+type RoleGrantStore interface {
+	Get(id string) (*models.RoleGrant, error)
+	// List returns all grants, or the user's grants when userID is not
+	// empty, oldest first
+	List(userID string) ([]*models.RoleGrant, error)
+	Save(grant *models.RoleGrant) error
+	Delete(id string) error
+}
+
+// MemoryRoleGrantStore is an in-memory RoleGrantStore
+type MemoryRoleGrantStore struct {
+	mu     sync.RWMutex
+	grants map[string]*models.RoleGrant
+}
+
+// NewMemoryRoleGrantStore creates an empty in-memory role grant store
+func NewMemoryRoleGrantStore() *MemoryRoleGrantStore {
+	return &MemoryRoleGrantStore{
+		grants: make(map[string]*models.RoleGrant),
+	}
+}
+
+// Get returns a grant by ID
+func (s *MemoryRoleGrantStore) Get(id string) (*models.RoleGrant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	grant, ok := s.grants[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *grant
+	return &copied, nil
+}
+
+// List returns all grants, or the user's grants, oldest first
+func (s *MemoryRoleGrantStore) List(userID string) ([]*models.RoleGrant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var grants []*models.RoleGrant
+	for _, grant := range s.grants {
+		if userID == "" || grant.UserID == userID {
+			copied := *grant
+			grants = append(grants, &copied)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].GrantedAt.Before(grants[j].GrantedAt)
+	})
+	return grants, nil
+}
+
+// Save creates or replaces a grant
+func (s *MemoryRoleGrantStore) Save(grant *models.RoleGrant) error {
+	if grant.ID == "" {
+		return fmt.Errorf("role grant ID is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *grant
+	s.grants[grant.ID] = &copied
+	return nil
+}
+
+// Delete removes a grant
+func (s *MemoryRoleGrantStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.grants[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.grants, id)
+	return nil
+}
+
+// FileRoleGrantStore is a RoleGrantStore persisted as a JSON file
+type FileRoleGrantStore struct {
+	path string
+
+	mu     sync.Mutex
+	memory *MemoryRoleGrantStore
+}
+
+// NewFileRoleGrantStore opens the role grant file at path, creating it on
+// the first write if it does not exist
+func NewFileRoleGrantStore(path string) (*FileRoleGrantStore, error) {
+	s := &FileRoleGrantStore{
+		path:   path,
+		memory: NewMemoryRoleGrantStore(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read role grant file: %w", err)
+	}
+
+	var grants []*models.RoleGrant
+	if err := json.Unmarshal(data, &grants); err != nil {
+		return nil, fmt.Errorf("failed to decode role grant file: %w", err)
+	}
+	for _, grant := range grants {
+		s.memory.grants[grant.ID] = grant
+	}
+	return s, nil
+}
+
+// Get returns a grant by ID
+func (s *FileRoleGrantStore) Get(id string) (*models.RoleGrant, error) {
+	return s.memory.Get(id)
+}
+
+// List returns all grants, or the user's grants, oldest first
+func (s *FileRoleGrantStore) List(userID string) ([]*models.RoleGrant, error) {
+	return s.memory.List(userID)
+}
+
+// Save creates or replaces a grant
+func (s *FileRoleGrantStore) Save(grant *models.RoleGrant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Save(grant); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// Delete removes a grant
+func (s *FileRoleGrantStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Delete(id); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// flushLocked atomically rewrites the role grant file; s.mu must be held
+func (s *FileRoleGrantStore) flushLocked() error {
+	grants, _ := s.memory.List("")
+	data, err := json.MarshalIndent(grants, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode role grants: %w", err)
+	}
+
+	return writeFileAtomic(s.path, data)
+}
@@ -0,0 +1,271 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+// SessionStore persists user sessions
+type SessionStore interface {
+	Create(session *models.Session) error
+	Get(id string) (*models.Session, error)
+	ListByUser(userID string) ([]*models.Session, error)
+	ListByUpstream(subject, sessionID string) ([]*models.Session, error)
+	Touch(id string, lastSeen time.Time) error
+	Delete(id string) error
+}
+
+// MemorySessionStore is an in-memory SessionStore
+type MemorySessionStore struct {
+	mu       sync.RWMutex
+	sessions map[string]*models.Session
+}
+
+// NewMemorySessionStore creates an empty in-memory session store
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		sessions: make(map[string]*models.Session),
+	}
+}
+
+// Create adds a new session
+func (s *MemorySessionStore) Create(session *models.Session) error {
+	if session.ID == "" {
+		return fmt.Errorf("session ID is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *session
+	s.sessions[session.ID] = &copied
+	return nil
+}
+
+// Get returns the session with the given ID
+func (s *MemorySessionStore) Get(id string) (*models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *session
+	return &copied, nil
+}
+
+// ListByUser returns a user's sessions, most recently created first
+func (s *MemorySessionStore) ListByUser(userID string) ([]*models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []*models.Session
+	for _, session := range s.sessions {
+		if session.UserID == userID {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// ListByUpstream returns the sessions started from a provider login,
+// matched by provider session ID and subject. An empty argument matches any
+// value, but at least one must be given.
+func (s *MemorySessionStore) ListByUpstream(subject, sessionID string) ([]*models.Session, error) {
+	if subject == "" && sessionID == "" {
+		return nil, fmt.Errorf("subject or session ID is required")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []*models.Session
+	for _, session := range s.sessions {
+		if subject != "" && session.UpstreamSubject != subject {
+			continue
+		}
+		if sessionID != "" && session.UpstreamSessionID != sessionID {
+			continue
+		}
+		copied := *session
+		sessions = append(sessions, &copied)
+	}
+	return sessions, nil
+}
+
+// Touch updates the last-seen time of a session
+func (s *MemorySessionStore) Touch(id string, lastSeen time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	session.LastSeenAt = lastSeen
+	return nil
+}
+
+// Delete removes a session, revoking it
+func (s *MemorySessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.sessions[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+// touchFlushInterval limits how often touches alone rewrite the session
+// file. Touches in between are written with the next flush, so a restart
+// loses at most this much last-seen time.
+const touchFlushInterval = time.Minute
+
+// FileSessionStore is a SessionStore persisted as a JSON file, suitable for
+// single-instance deployments that must keep sessions across restarts
+type FileSessionStore struct {
+	path string
+
+	mu        sync.Mutex
+	memory    *MemorySessionStore
+	flushedAt time.Time
+}
+
+// NewFileSessionStore opens the session file at path, creating it on the
+// first write if it does not exist
+func NewFileSessionStore(path string) (*FileSessionStore, error) {
+	s := &FileSessionStore{
+		path:   path,
+		memory: NewMemorySessionStore(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read session file: %w", err)
+	}
+
+	var sessions []*models.Session
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to decode session file: %w", err)
+	}
+	for _, session := range sessions {
+		s.memory.sessions[session.ID] = session
+	}
+	return s, nil
+}
+
+// Create adds a new session
+func (s *FileSessionStore) Create(session *models.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Create(session); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// Get returns the session with the given ID
+func (s *FileSessionStore) Get(id string) (*models.Session, error) {
+	return s.memory.Get(id)
+}
+
+// ListByUser returns a user's sessions, most recently created first
+func (s *FileSessionStore) ListByUser(userID string) ([]*models.Session, error) {
+	return s.memory.ListByUser(userID)
+}
+
+// ListByUpstream returns the sessions started from a provider login
+func (s *FileSessionStore) ListByUpstream(subject, sessionID string) ([]*models.Session, error) {
+	return s.memory.ListByUpstream(subject, sessionID)
+}
+
+// Touch updates the last-seen time of a session. The file is rewritten at
+// most once per touchFlushInterval for touches.
+func (s *FileSessionStore) Touch(id string, lastSeen time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Touch(id, lastSeen); err != nil {
+		return err
+	}
+	if time.Since(s.flushedAt) < touchFlushInterval {
+		return nil
+	}
+	return s.flushLocked()
+}
+
+// Delete removes a session, revoking it
+func (s *FileSessionStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Delete(id); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// flushLocked atomically rewrites the session file; s.mu must be held
+func (s *FileSessionStore) flushLocked() error {
+	s.memory.mu.RLock()
+	sessions := make([]*models.Session, 0, len(s.memory.sessions))
+	for _, session := range s.memory.sessions {
+		sessions = append(sessions, session)
+	}
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	s.memory.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode sessions: %w", err)
+	}
+
+	if err := writeFileAtomic(s.path, data); err != nil {
+		return err
+	}
+	s.flushedAt = time.Now()
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path,
+// so readers never see a partially written file
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	return nil
+}
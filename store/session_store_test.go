@@ -0,0 +1,99 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+func TestFileSessionStore_TouchThrottle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	s, err := NewFileSessionStore(path)
+	if err != nil {
+		t.Fatalf("NewFileSessionStore() error = %v", err)
+	}
+	if err := s.Create(&models.Session{ID: "s1", UserID: "u1", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+
+	// A touch right after a write stays in memory
+	lastSeen := time.Now().Add(time.Minute).Truncate(time.Second)
+	if err := s.Touch("s1", lastSeen); err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+	if session, _ := s.Get("s1"); !session.LastSeenAt.Equal(lastSeen) {
+		t.Errorf("Get() LastSeenAt = %v, want %v", session.LastSeenAt, lastSeen)
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(written) {
+		t.Errorf("Touch() rewrote the file within %v", touchFlushInterval)
+	}
+
+	// and is written with the next change
+	if err := s.Create(&models.Session{ID: "s2", UserID: "u1", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	reopened, err := NewFileSessionStore(path)
+	if err != nil {
+		t.Fatalf("NewFileSessionStore() reopen error = %v", err)
+	}
+	if session, _ := reopened.Get("s1"); session == nil || !session.LastSeenAt.Equal(lastSeen) {
+		t.Errorf("reopened LastSeenAt = %v, want %v", session, lastSeen)
+	}
+}
+
+func TestFileSessionStore_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+
+	s, err := NewFileSessionStore(path)
+	if err != nil {
+		t.Fatalf("NewFileSessionStore() error = %v", err)
+	}
+
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, session := range []*models.Session{
+		{ID: "s1", UserID: "u1", CreatedAt: created},
+		{ID: "s2", UserID: "u1", CreatedAt: created.Add(time.Minute)},
+		{ID: "s3", UserID: "u2", CreatedAt: created},
+	} {
+		if err := s.Create(session); err != nil {
+			t.Fatalf("Create() error = %v", err)
+		}
+	}
+	if err := s.Delete("s1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	lastSeen := time.Now().Truncate(time.Second)
+	s.flushedAt = time.Time{} // as if the last write was long ago
+	if err := s.Touch("s2", lastSeen); err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+
+	// Reopen the file to check what was persisted
+	reopened, err := NewFileSessionStore(path)
+	if err != nil {
+		t.Fatalf("NewFileSessionStore() reopen error = %v", err)
+	}
+
+	sessions, err := reopened.ListByUser("u1")
+	if err != nil {
+		t.Fatalf("ListByUser() error = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != "s2" {
+		t.Fatalf("ListByUser() = %v, want only s2", sessions)
+	}
+	if !sessions[0].LastSeenAt.Equal(lastSeen) {
+		t.Errorf("LastSeenAt = %v, want %v", sessions[0].LastSeenAt, lastSeen)
+	}
+
+	if _, err := reopened.Get("s1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() deleted session error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+// WebAuthnStore persists users' WebAuthn credentials
+type WebAuthnStore interface {
+	Get(userID string) (*models.WebAuthnAccount, error)
+	GetByHandle(handle []byte) (*models.WebAuthnAccount, error)
+	Save(account *models.WebAuthnAccount) error
+}
+
+// MemoryWebAuthnStore is an in-memory WebAuthnStore
+type MemoryWebAuthnStore struct {
+	mu       sync.RWMutex
+	accounts map[string]*models.WebAuthnAccount
+}
+
+// NewMemoryWebAuthnStore creates an empty in-memory WebAuthn store
+func NewMemoryWebAuthnStore() *MemoryWebAuthnStore {
+	return &MemoryWebAuthnStore{
+		accounts: make(map[string]*models.WebAuthnAccount),
+	}
+}
+
+// Get returns the account of a user
+func (s *MemoryWebAuthnStore) Get(userID string) (*models.WebAuthnAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, ok := s.accounts[userID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyWebAuthnAccount(account), nil
+}
+
+// GetByHandle returns the account with the given user handle
+func (s *MemoryWebAuthnStore) GetByHandle(handle []byte) (*models.WebAuthnAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, account := range s.accounts {
+		if bytes.Equal(account.Handle, handle) {
+			return copyWebAuthnAccount(account), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Save creates or replaces the account of a user
+func (s *MemoryWebAuthnStore) Save(account *models.WebAuthnAccount) error {
+	if account.UserID == "" {
+		return fmt.Errorf("user ID is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accounts[account.UserID] = copyWebAuthnAccount(account)
+	return nil
+}
+
+func copyWebAuthnAccount(account *models.WebAuthnAccount) *models.WebAuthnAccount {
+	copied := *account
+	copied.Credentials = append([]models.WebAuthnCredential(nil), account.Credentials...)
+	return &copied
+}
+
+// FileWebAuthnStore is a WebAuthnStore persisted as a JSON file
+type FileWebAuthnStore struct {
+	path string
+
+	mu     sync.Mutex
+	memory *MemoryWebAuthnStore
+}
+
+// NewFileWebAuthnStore opens the credential file at path, creating it on
+// the first write if it does not exist
+func NewFileWebAuthnStore(path string) (*FileWebAuthnStore, error) {
+	s := &FileWebAuthnStore{
+		path:   path,
+		memory: NewMemoryWebAuthnStore(),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WebAuthn file: %w", err)
+	}
+
+	var accounts []*models.WebAuthnAccount
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("failed to decode WebAuthn file: %w", err)
+	}
+	for _, account := range accounts {
+		s.memory.accounts[account.UserID] = account
+	}
+	return s, nil
+}
+
+// Get returns the account of a user
+func (s *FileWebAuthnStore) Get(userID string) (*models.WebAuthnAccount, error) {
+	return s.memory.Get(userID)
+}
+
+// GetByHandle returns the account with the given user handle
+func (s *FileWebAuthnStore) GetByHandle(handle []byte) (*models.WebAuthnAccount, error) {
+	return s.memory.GetByHandle(handle)
+}
+
+// Save creates or replaces the account of a user
+func (s *FileWebAuthnStore) Save(account *models.WebAuthnAccount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.memory.Save(account); err != nil {
+		return err
+	}
+	return s.flushLocked()
+}
+
+// flushLocked atomically rewrites the credential file; s.mu must be held
+func (s *FileWebAuthnStore) flushLocked() error {
+	s.memory.mu.RLock()
+	accounts := make([]*models.WebAuthnAccount, 0, len(s.memory.accounts))
+	for _, account := range s.memory.accounts {
+		accounts = append(accounts, account)
+	}
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	s.memory.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode WebAuthn accounts: %w", err)
+	}
+
+	return writeFileAtomic(s.path, data)
+}
@@ -0,0 +1,28 @@
+package utils
+
+import "testing"
+
+func TestVerifyCSRFToken(t *testing.T) {
+	secret := "test-secret-key"
+	token := CSRFToken("session-a", secret)
+
+	tests := []struct {
+		name     string
+		session  string
+		token    string
+		expected bool
+	}{
+		{"Matching session", "session-a", token, true},
+		{"Other session", "session-b", token, false},
+		{"Empty token", "session-a", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := VerifyCSRFToken(tt.session, tt.token, secret)
+			if result != tt.expected {
+				t.Errorf("VerifyCSRFToken() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
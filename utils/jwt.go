@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Hilina-t/microservice-authenticator/models"
@@ -15,26 +16,90 @@ type Claims struct {
 	Name     string   `json:"name"`
 	Roles    []string `json:"roles"`
 	Provider string   `json:"provider"`
+	Scope    string   `json:"scope,omitempty"`
+	Act      *Actor   `json:"act,omitempty"`
+	// SessionID links the token to a server-side session that can be revoked
+	SessionID string `json:"sid,omitempty"`
+	// AMR lists the authentication methods used at login (RFC 8176), ACR
+	// the authentication context class they add up to, and AuthTime when
+	// the user last actively authenticated
+	AMR      []string         `json:"amr,omitempty"`
+	ACR      string           `json:"acr,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	// BreakGlass marks tokens from an emergency account login
+	BreakGlass bool `json:"break_glass,omitempty"`
+	// ClientID names the OAuth client application the gateway's OIDC
+	// provider issued the token to (RFC 9068)
+	ClientID string `json:"client_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT generates a JWT token for a user
-func GenerateJWT(user *models.User, secret string, expirationHours int) (string, error) {
-	claims := Claims{
+// Actor identifies the party acting on behalf of the token subject
+// (RFC 8693 section 4.1). Nested actors record a delegation chain, with the
+// most recent actor outermost.
+type Actor struct {
+	Subject string `json:"sub"`
+	Act     *Actor `json:"act,omitempty"`
+}
+
+// HasAudience checks if the token is intended for the given audience
+func (c *Claims) HasAudience(audience string) bool {
+	for _, aud := range c.Audience {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope checks if the token was granted the given scope
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range strings.Fields(c.Scope) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// NewClaims builds the standard gateway claims for a user, expiring after
+// the given duration or when the user's first time-bound role ends,
+// whichever is sooner. Callers may adjust the claims before SignJWT.
+func NewClaims(user *models.User, expiration time.Duration) *Claims {
+	now := time.Now()
+	expiresAt := now.Add(expiration)
+	if !user.RolesExpireAt.IsZero() && user.RolesExpireAt.Before(expiresAt) {
+		expiresAt = user.RolesExpireAt
+	}
+	return &Claims{
 		UserID:   user.ID,
 		Email:    user.Email,
 		Name:     user.Name,
 		Roles:    user.Roles,
 		Provider: user.Provider,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour * time.Duration(expirationHours))),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 			Issuer:    "microservice-authenticator",
 			Subject:   user.ID,
 		},
 	}
+}
+
+// GenerateJWT generates a JWT token for a user
+func GenerateJWT(user *models.User, secret string, expirationHours int) (string, error) {
+	return GenerateJWTWithExpiration(user, secret, time.Hour*time.Duration(expirationHours))
+}
+
+// GenerateJWTWithExpiration generates a JWT token for a user that expires
+// after the given duration
+func GenerateJWTWithExpiration(user *models.User, secret string, expiration time.Duration) (string, error) {
+	return SignJWT(NewClaims(user, expiration), secret)
+}
 
+// SignJWT signs the claims with HS256
+func SignJWT(claims *Claims, secret string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenString, err := token.SignedString([]byte(secret))
 	if err != nil {
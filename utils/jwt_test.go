@@ -128,3 +128,60 @@ func TestGenerateJWT_ExpirationTime(t *testing.T) {
 		t.Errorf("Token expiration time differs by %v, expected within 1 minute", diff)
 	}
 }
+
+func TestNewClaims_RolesExpireAt(t *testing.T) {
+	rolesExpireAt := time.Now().Add(10 * time.Minute)
+	user := &models.User{
+		ID:            "123",
+		Roles:         []string{"user", "contractor"},
+		RolesExpireAt: rolesExpireAt,
+	}
+
+	// The token ends with the first time-bound role
+	claims := NewClaims(user, time.Hour)
+	if !claims.ExpiresAt.Time.Equal(rolesExpireAt.Truncate(time.Second)) {
+		t.Errorf("ExpiresAt = %v, want %v", claims.ExpiresAt.Time, rolesExpireAt)
+	}
+
+	// A shorter lifetime is kept
+	claims = NewClaims(user, time.Minute)
+	if claims.ExpiresAt.Time.After(time.Now().Add(time.Minute)) {
+		t.Errorf("ExpiresAt = %v, want within 1 minute", claims.ExpiresAt.Time)
+	}
+}
+
+func TestClaims_AudienceAndScope(t *testing.T) {
+	secret := "test-secret-key"
+	user := &models.User{
+		ID:    "123",
+		Email: "test@example.com",
+		Roles: []string{"user"},
+	}
+
+	claims := NewClaims(user, time.Hour)
+	claims.Audience = []string{"orders-service"}
+	claims.Scope = "orders:read orders:write"
+
+	token, err := SignJWT(claims, secret)
+	if err != nil {
+		t.Fatalf("Failed to sign JWT: %v", err)
+	}
+
+	parsed, err := ValidateJWT(token, secret)
+	if err != nil {
+		t.Fatalf("Failed to validate JWT: %v", err)
+	}
+
+	if !parsed.HasAudience("orders-service") {
+		t.Error("Expected orders-service audience")
+	}
+	if parsed.HasAudience("billing-service") {
+		t.Error("Unexpected billing-service audience")
+	}
+	if !parsed.HasScope("orders:write") {
+		t.Error("Expected orders:write scope")
+	}
+	if parsed.HasScope("orders") {
+		t.Error("Scope matching must not use prefixes")
+	}
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// IDTokenClaims represents the claims of an OIDC ID token issued to a client
+type IDTokenClaims struct {
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time,omitempty"`
+	Email    string `json:"email,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Picture  string `json:"picture,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateIDToken generates a signed OIDC ID token for a user and client
+func GenerateIDToken(key *SigningKey, issuer string, user *models.User, clientID, nonce string, authTime time.Time, expiration time.Duration) (string, error) {
+	now := time.Now()
+	claims := IDTokenClaims{
+		Nonce:   nonce,
+		Email:   user.Email,
+		Name:    user.Name,
+		Picture: user.Picture,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiration)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    issuer,
+			Subject:   user.ID,
+			Audience:  jwt.ClaimStrings{clientID},
+		},
+	}
+	if !authTime.IsZero() {
+		claims.AuthTime = authTime.Unix()
+	}
+	return key.Sign(claims)
+}
+
+// VerifyPKCE checks a PKCE code verifier against the challenge sent in the
+// authorization request. Only the S256 and plain methods are supported.
+func VerifyPKCE(verifier, challenge, method string) bool {
+	switch method {
+	case "", "plain":
+		return verifier != "" && subtle.ConstantTimeCompare([]byte(verifier), []byte(challenge)) == 1
+	case "S256":
+		sum := sha256.Sum256([]byte(verifier))
+		computed := base64.RawURLEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+	}
+	return false
+}
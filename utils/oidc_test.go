@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/Hilina-t/microservice-authenticator/models"
+)
+
+func TestGenerateIDToken(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	key := NewSigningKey(privateKey)
+
+	user := &models.User{
+		ID:    "123",
+		Email: "test@example.com",
+		Name:  "Test User",
+	}
+
+	token, err := GenerateIDToken(key, "https://auth.example.com", user, "client-1", "nonce-1", time.Now(), time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to generate ID token: %v", err)
+	}
+
+	var claims IDTokenClaims
+	if err := key.Parse(token, &claims); err != nil {
+		t.Fatalf("Failed to parse ID token: %v", err)
+	}
+
+	if claims.Subject != user.ID {
+		t.Errorf("Subject = %s, want %s", claims.Subject, user.ID)
+	}
+	if claims.Nonce != "nonce-1" {
+		t.Errorf("Nonce = %s, want nonce-1", claims.Nonce)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != "client-1" {
+		t.Errorf("Audience = %v, want [client-1]", claims.Audience)
+	}
+
+	// A different key must not verify the token
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	if err := NewSigningKey(otherKey).Parse(token, &IDTokenClaims{}); err == nil {
+		t.Error("Expected error when verifying with a different key, got nil")
+	}
+}
+
+func TestVerifyPKCE(t *testing.T) {
+	// Test vector from RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		method    string
+		expected  bool
+	}{
+		{"S256 matches", verifier, challenge, "S256", true},
+		{"S256 wrong verifier", "wrong", challenge, "S256", false},
+		{"Plain matches", "abc", "abc", "plain", true},
+		{"Plain empty verifier", "", "", "plain", false},
+		{"Unknown method", verifier, challenge, "S512", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := VerifyPKCE(tt.verifier, tt.challenge, tt.method)
+			if result != tt.expected {
+				t.Errorf("VerifyPKCE() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testArgon2Params keep the tests fast
+var testArgon2Params = Argon2Params{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse battery staple", testArgon2Params)
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("HashPassword() = %s, want the PHC argon2id format", hash)
+	}
+
+	other, _ := HashPassword("correct horse battery staple", testArgon2Params)
+	if other == hash {
+		t.Error("HashPassword() returned the same hash twice, want a random salt")
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		expected bool
+	}{
+		{"Correct password", "correct horse battery staple", true},
+		{"Wrong password", "correct horse battery stapler", false},
+		{"Empty password", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := VerifyPassword(tt.password, hash)
+			if err != nil {
+				t.Fatalf("VerifyPassword() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("VerifyPassword() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestVerifyPassword_InvalidHash(t *testing.T) {
+	tests := []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=19$m=0,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=19$m=1024,t=1,p=1$!!!$aGFzaGhhc2g",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$",
+	}
+
+	for _, encoded := range tests {
+		if _, err := VerifyPassword("password", encoded); !errors.Is(err, ErrInvalidPasswordHash) {
+			t.Errorf("VerifyPassword(%q) error = %v, want %v", encoded, err, ErrInvalidPasswordHash)
+		}
+	}
+}
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	hash, _ := HashPassword("password", testArgon2Params)
+
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+
+	if PasswordNeedsRehash(hash, testArgon2Params) {
+		t.Error("PasswordNeedsRehash() with the same parameters = true, want false")
+	}
+	if !PasswordNeedsRehash(hash, stronger) {
+		t.Error("PasswordNeedsRehash() with more iterations = false, want true")
+	}
+	if !PasswordNeedsRehash("garbage", testArgon2Params) {
+		t.Error("PasswordNeedsRehash() with an invalid hash = false, want true")
+	}
+}
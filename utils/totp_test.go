@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B test vectors for SHA1, truncated to 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode() error = %v", err)
+		}
+		if code != tt.expected {
+			t.Errorf("TOTPCode(%d) = %s, want %s", tt.unix, code, tt.expected)
+		}
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret() error = %v", err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	step := TOTPStep(now)
+
+	codeAt := func(s int64) string {
+		code, _ := TOTPCode(secret, s)
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantOK   bool
+		wantStep int64
+	}{
+		{"Current step", codeAt(step), true, step},
+		{"Previous step", codeAt(step - 1), true, step - 1},
+		{"Next step", codeAt(step + 1), true, step + 1},
+		{"Too old", codeAt(step - 2), false, 0},
+		{"Wrong length", "12345", false, 0},
+		{"Empty", "", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, ok := VerifyTOTP(secret, tt.code, now)
+			if ok != tt.wantOK || matched != tt.wantStep {
+				t.Errorf("VerifyTOTP() = %d, %v, want %d, %v", matched, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("IAG", "user@example.com", "ABCDEF")
+	if !strings.HasPrefix(uri, "otpauth://totp/IAG:user@example.com?") {
+		t.Errorf("TOTPProvisioningURI() = %s, want otpauth://totp/IAG:user@example.com?...", uri)
+	}
+	for _, param := range []string{"secret=ABCDEF", "issuer=IAG", "digits=6", "period=30"} {
+		if !strings.Contains(uri, param) {
+			t.Errorf("TOTPProvisioningURI() = %s, missing %s", uri, param)
+		}
+	}
+}